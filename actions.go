@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Действия (trigger actions) ----------------
+// Действия из ACTION_NAMES отслеживаются так же, как медиа: выключенное действие записывается
+// в состояние и через MEDIA_OFF_DURATION включается через action.update. Состояние хранится
+// в отдельном файле в формате состояния медиа, уведомления идут через общие notify и кулдауны.
+
+const actionStateFilename = "action_state.json"
+
+type Action struct {
+	ActionID string `json:"actionid"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+}
+
+// actionEventID — id действия в событиях: кулдауны и треды Mattermost ведутся по MediaID,
+// и у действий он не должен совпадать с id медиа
+func actionEventID(id string) string {
+	return "action-" + id
+}
+
+func getActions(ctx context.Context, cfg *Config, logger *logrus.Logger) ([]Action, error) {
+	requestBody := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "action.get",
+		Params: map[string]interface{}{
+			"output": []string{"actionid", "name", "status"},
+			"filter": map[string]interface{}{
+				"name": cfg.ActionNames,
+			},
+		},
+		Auth: zabbixSession(cfg),
+		ID:   1,
+	}
+	var result []Action
+	if err := callZabbix(ctx, cfg, requestBody, &result, logger); err != nil {
+		return nil, err
+	}
+	logger.Infof("Получено %d действий", len(result))
+	return result, nil
+}
+
+func enableAction(ctx context.Context, cfg *Config, action Action, logger *logrus.Logger) error {
+	if cfg.Simulate {
+		logger.Infof("[SIMULATE] action.update для %s не отправлен", action.ActionID)
+		return nil
+	}
+	if cfg.DryRun {
+		logger.Infof("[DRY-RUN] Действие %s было бы включено", action.Name)
+		return nil
+	}
+	requestBody := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "action.update",
+		Params: map[string]interface{}{
+			"actionid": action.ActionID,
+			"status":   "0",
+		},
+		Auth: zabbixSession(cfg),
+		ID:   2,
+	}
+	var result struct {
+		ActionIDs []string `json:"actionids"`
+	}
+	if err := callZabbix(ctx, cfg, requestBody, &result, logger); err != nil {
+		return err
+	}
+	// как и у медиа: без id действия в ответе update ничего не изменил, действие остаётся в состоянии
+	for _, id := range result.ActionIDs {
+		if id == action.ActionID {
+			return nil
+		}
+	}
+	return fmt.Errorf("action.update завершился успешно, но не затронул действие %s (id %s): actionids=%v", action.Name, action.ActionID, result.ActionIDs)
+}
+
+// processActions проверяет действия из ACTION_NAMES и включает выключенные дольше MEDIA_OFF_DURATION.
+// Возвращает ошибку, если список действий получить не удалось.
+func processActions(ctx context.Context, cfg *Config, state MediaState, failures EnableFailures, commit *cycleCommit, logger *logrus.Logger, sysLogger *syslog.Writer) error {
+	actions, err := getActions(ctx, cfg, logger)
+	if err != nil {
+		logger.Errorf("Ошибка получения действий: %v", err)
+		return err
+	}
+	if len(actions) == 0 {
+		// пустой ответ скорее сбой фильтра или прав, чем удаление всех действий — состояние не трогаем
+		logger.Warning("Не получено ни одного действия для обработки — состояние действий не изменено")
+		return nil
+	}
+	now := cfg.Clock.Now()
+	changed := false
+	seen := make(map[string]bool, len(actions))
+	for _, action := range actions {
+		seen[action.ActionID] = true
+		eventID := actionEventID(action.ActionID)
+		logEntry := logger.WithFields(logrus.Fields{
+			"action_id":   action.ActionID,
+			"action_name": action.Name,
+			"status":      action.Status,
+		})
+		rec, tracked := state[action.ActionID]
+
+		if action.Status != "1" {
+			if tracked {
+				delete(state, action.ActionID)
+				delete(failures, eventID)
+				cfg.throttle.Forget(eventID)
+				changed = true
+				logEntry.Info("Действие включено - удалено из состояния")
+				notify(ctx, cfg, Event{
+					Type:      EventActionRestored,
+					MediaID:   eventID,
+					MediaName: action.Name,
+					Message:   fmt.Sprintf("Действие восстановлено: %s", action.Name),
+				}, logger)
+			}
+			continue
+		}
+
+		if !tracked {
+			rec = &MediaRecord{FirstSeen: now, Name: action.Name}
+			state[action.ActionID] = rec
+			changed = true
+			logEntry.WithField("action", "state_recorded").Warn("Обнаружено отключённое действие")
+			if sysLogger != nil {
+				_ = sysLogger.Warning(fmt.Sprintf("Обнаружено выключенное action: id=%s name=%s", action.ActionID, action.Name))
+			}
+			if notify(ctx, cfg, Event{
+				Type:      EventActionDisabled,
+				MediaID:   eventID,
+				MediaName: action.Name,
+				Threshold: cfg.OffDuration,
+				Message: fmt.Sprintf("Обнаружено отключенное действие: %s\nБудет автоматически включено через: %s",
+					action.Name, cfg.OffDuration.Round(time.Minute)),
+			}, logger) {
+				rec.notified(now)
+			}
+			// первое напоминание — не раньше чем через кулдаун после обнаружения
+			cfg.throttle.Mark(eventID, EventActionStillDisabled, now)
+			continue
+		}
+
+		if rec.Name != action.Name {
+			rec.Name = action.Name
+			changed = true
+		}
+		if rec.FirstSeen.IsZero() || rec.FirstSeen.After(now) {
+			logEntry.WithField("first_seen", rec.FirstSeen).Warn("Некорректное время обнаружения отключения — отсчёт начат заново")
+			rec.FirstSeen = now
+			changed = true
+		}
+		disabledFor := now.Sub(rec.FirstSeen)
+		logEntry = logEntry.WithFields(logrus.Fields{
+			"disabled_duration": disabledFor.Round(time.Second),
+			"threshold":         cfg.OffDuration,
+		})
+
+		if disabledFor < cfg.OffDuration || cfg.DryRun {
+			msg := fmt.Sprintf("Действие отключено: %s\nОтключено: %s назад\nАвтоматическое включение через: %s",
+				action.Name, disabledFor.Round(time.Minute), (cfg.OffDuration - disabledFor).Round(time.Minute))
+			if disabledFor >= cfg.OffDuration {
+				logEntry.Infof("[DRY-RUN] Действие %s было бы включено", action.Name)
+				msg = fmt.Sprintf("Действие отключено: %s\nОтключено: %s назад\nБыло бы включено автоматически (пробный режим)",
+					action.Name, disabledFor.Round(time.Minute))
+			} else {
+				logEntry.Info("Действие отключено, но ещё не превышен лимит времени")
+			}
+			if notify(ctx, cfg, Event{
+				Type:        EventActionStillDisabled,
+				MediaID:     eventID,
+				MediaName:   action.Name,
+				DisabledFor: disabledFor,
+				Threshold:   cfg.OffDuration,
+				Message:     msg,
+			}, logger) {
+				rec.notified(now)
+				changed = true
+			}
+			continue
+		}
+
+		logEntry.Warn("Действие отключено дольше разрешённого времени")
+		if sysLogger != nil {
+			_ = sysLogger.Warning(fmt.Sprintf("Action id=%s name=%s отключено %v — превышен порог %v", action.ActionID, action.Name, disabledFor.Round(time.Second), cfg.OffDuration))
+		}
+		if err := enableAction(ctx, cfg, action, logger); err != nil {
+			logEntry.WithError(err).Error("Ошибка включения действия")
+			if !failures.shouldNotify(cfg, eventID, err, now) {
+				logEntry.Info("Повторная ошибка включения — уведомление подавлено")
+				continue
+			}
+			notify(ctx, cfg, Event{
+				Type:        EventActionEnableFailed,
+				MediaID:     eventID,
+				MediaName:   action.Name,
+				DisabledFor: disabledFor,
+				Threshold:   cfg.OffDuration,
+				Error:       err.Error(),
+				Message:     fmt.Sprintf("Ошибка включения действия: %s\nОшибка: %v", action.Name, err),
+			}, logger)
+			continue
+		}
+		delete(failures, eventID)
+		logEntry.Info("Действие успешно включено")
+		cfg.auditLog.ActionEnabled(cfg, action, disabledFor, logger)
+		if sysLogger != nil {
+			_ = sysLogger.Info(fmt.Sprintf("Скрипт включил action id=%s name=%s", action.ActionID, action.Name))
+		}
+		notify(ctx, cfg, Event{
+			Type:        EventActionAutoEnabled,
+			MediaID:     eventID,
+			MediaName:   action.Name,
+			DisabledFor: disabledFor,
+			Threshold:   cfg.OffDuration,
+			Message:     fmt.Sprintf("Действие %s было автоматически включено скриптом.", action.Name),
+		}, logger)
+		delete(state, action.ActionID)
+		cfg.throttle.Forget(eventID)
+		changed = true
+	}
+
+	for id, rec := range state {
+		if !seen[id] {
+			// действие удалено или убрано из ACTION_NAMES
+			logger.WithFields(logrus.Fields{"action_id": id, "action_name": rec.Name}).Info("Действия больше нет в ответе Zabbix — удалено из состояния")
+			delete(state, id)
+			delete(failures, actionEventID(id))
+			cfg.throttle.Forget(actionEventID(id))
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := saveState(commit, cfg.stateFile(actionStateFilename), state, cfg.StateCompact); err != nil {
+			logger.Errorf("Ошибка сохранения состояния действий: %v", err)
+		}
+	}
+	return nil
+}
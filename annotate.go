@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// annotationPrefix отмечает строки, которые watcher дописывает в описание медиа
+const annotationPrefix = "auto-enabled by watcher at "
+
+// annotateDescription дописывает в описание отметку об автовключении.
+// Если итог длиннее max, удаляются самые старые отметки watcher-а; текст, написанный людьми, не трогается.
+// Если и после этого не помещается — отметка не добавляется, возвращается ok=false.
+func annotateDescription(desc string, at time.Time, max int) (string, bool) {
+	note := annotationPrefix + at.UTC().Format(time.RFC3339)
+	lines := strings.Split(desc, "\n")
+	if desc == "" {
+		lines = nil
+	}
+	lines = append(lines, note)
+
+	for max > 0 && len(strings.Join(lines, "\n")) > max {
+		idx := -1
+		for i, l := range lines[:len(lines)-1] {
+			if strings.HasPrefix(l, annotationPrefix) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return desc, false
+		}
+		lines = append(lines[:idx], lines[idx+1:]...)
+	}
+	return strings.Join(lines, "\n"), true
+}
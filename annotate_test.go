@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnotateDescription(t *testing.T) {
+	at := time.Date(2024, 3, 1, 15, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	note := "auto-enabled by watcher at 2024-03-01T12:00:00Z"
+	old1 := "auto-enabled by watcher at 2024-02-01T08:00:00Z"
+	old2 := "auto-enabled by watcher at 2024-02-15T08:00:00Z"
+	tests := []struct {
+		name   string
+		desc   string
+		max    int
+		want   string
+		wantOK bool
+	}{
+		{name: "пустое описание", want: note, wantOK: true},
+		{name: "текст людей сохраняется", desc: "Основной канал\nдежурные", want: "Основной канал\nдежурные\n" + note, wantOK: true},
+		{name: "без ограничения длины", desc: old1 + "\n" + old2, want: old1 + "\n" + old2 + "\n" + note, wantOK: true},
+		{
+			name: "старые отметки вытесняются",
+			desc: "Основной канал\n" + old1 + "\n" + old2, max: len("Основной канал\n" + old2 + "\n" + note),
+			want: "Основной канал\n" + old2 + "\n" + note, wantOK: true,
+		},
+		{name: "не помещается из-за текста людей", desc: strings.Repeat("x", 40), max: 60, want: strings.Repeat("x", 40)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := annotateDescription(tt.desc, at, tt.max)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("описание %q (%v), ожидалось %q (%v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAutoEnableDescriptionPayload(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		desc     string
+		wantDesc string // пусто — описание не передаётся
+	}{
+		{name: "выключено", env: map[string]string{"ANNOTATE_ENABLE": "0"}, desc: "Основной канал"},
+		{
+			name:     "включено",
+			env:      map[string]string{"ANNOTATE_ENABLE": "1"},
+			desc:     "Основной канал",
+			wantDesc: "Основной канал\nauto-enabled by watcher at 2024-03-01T12:00:00Z",
+		},
+		{
+			name: "не помещается в ANNOTATE_ENABLE_MAX_LENGTH",
+			env:  map[string]string{"ANNOTATE_ENABLE": "1", "ANNOTATE_ENABLE_MAX_LENGTH": "20"},
+			desc: "Основной канал",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			var params map[string]interface{}
+			zabbix.Handle("mediatype.update", func(raw json.RawMessage) (interface{}, *fakeError) {
+				if err := json.Unmarshal(raw, &params); err != nil {
+					return nil, &fakeError{Code: -32602, Message: err.Error()}
+				}
+				return map[string][]string{"mediatypeids": {"1"}}, nil
+			})
+			env := map[string]string{"ZABBIX_API_URL": zabbix.URL}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			cfg, clock := newTestConfig(t, env)
+			media := []MediaType{{MediaTypeID: "1", Name: "Email", Status: "1", Description: tt.desc}}
+			state := MediaState{"1": {FirstSeen: clock.Now().Add(-2 * time.Hour)}}
+
+			commit := newCycleCommit()
+			handleMediaTypes(context.Background(), cfg, media, newMemoryStore(), state, make(EnableFailures), commit, testLogger(t), nil)
+			if _, ok := state["1"]; ok {
+				t.Fatal("медиа не включено")
+			}
+
+			if params["mediatypeid"] != "1" || params["status"] != "0" {
+				t.Errorf("параметры mediatype.update: %v", params)
+			}
+			desc, ok := params["description"]
+			switch {
+			case tt.wantDesc == "" && ok:
+				t.Errorf("передано описание %q", desc)
+			case tt.wantDesc != "" && desc != tt.wantDesc:
+				t.Errorf("описание %q, ожидалось %q", desc, tt.wantDesc)
+			}
+		})
+	}
+}
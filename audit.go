@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Периодическая сводка изменений для аудита ----------------
+// Раз в AUDIT_SUMMARY_INTERVAL часов собирает изменения из журнала событий (HISTORY_FILE)
+// и отчёта по группам (GROUP_REPORT_FILE) с прошлой сводки и отправляет одним сообщением.
+// Граница уже отправленного периода хранится в файле, поэтому после перезапуска
+// следующая сводка продолжает с того же места.
+
+const auditStateFilename = "audit_summary_state.json"
+
+// auditSummaryMaxLines — сколько изменений перечислять в сводке, остальные только считаются
+const auditSummaryMaxLines = 50
+
+// auditSkipEvents — события, которые изменениями не являются и в сводку не входят
+var auditSkipEvents = map[string]bool{
+	EventMediaStillDisabled:  true,
+	EventActionStillDisabled: true,
+	EventHeartbeat:           true,
+	EventCycleSkipped:        true,
+	EventCycleTimeout:        true,
+	EventZabbixUnreachable:   true,
+	EventZabbixRecovered:     true,
+	EventQuietDigest:         true,
+	EventAuditSummary:        true,
+}
+
+type AuditState struct {
+	Until time.Time `json:"until"`
+}
+
+type auditSummary struct {
+	state AuditState
+}
+
+func loadAuditSummary(filename string) (*auditSummary, error) {
+	a := &auditSummary{}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return a, nil
+	}
+	if err != nil {
+		return a, err
+	}
+	return a, json.Unmarshal(data, &a.state)
+}
+
+// auditEntry — одно изменение в сводке
+type auditEntry struct {
+	Time time.Time
+	Type string
+	Text string
+}
+
+// MaybeSend отправляет сводку, если с прошлой прошло AUDIT_SUMMARY_INTERVAL. Первый запуск
+// только запоминает точку отсчёта.
+func (a *auditSummary) MaybeSend(ctx context.Context, cfg *Config, report *groupReport, commit *cycleCommit, logger *logrus.Logger) {
+	now := cfg.Clock.Now()
+	if a.state.Until.IsZero() {
+		a.advance(cfg, now, commit, logger)
+		logger.Infof("Сводка изменений: отсчёт периода начат с %s", now.Format(time.RFC3339))
+		return
+	}
+	if now.Sub(a.state.Until) < cfg.AuditSummaryInterval {
+		return
+	}
+
+	from := a.state.Until
+	entries, err := collectAuditEntries(cfg, report, from, now)
+	if err != nil {
+		// без журнала сводка была бы неполной — граница не сдвигается, попробуем на следующем цикле
+		logger.WithError(err).Error("Ошибка чтения журнала для сводки изменений")
+		return
+	}
+	msg := formatAuditSummary(from, now, entries)
+	logger.WithFields(logrus.Fields{"from": from.Format(time.RFC3339), "to": now.Format(time.RFC3339), "changes": len(entries)}).Info("Отправка сводки изменений")
+	notify(ctx, cfg, Event{Type: EventAuditSummary, Message: msg, Time: now}, logger)
+	a.advance(cfg, now, commit, logger)
+}
+
+func (a *auditSummary) advance(cfg *Config, until time.Time, commit *cycleCommit, logger *logrus.Logger) {
+	a.state.Until = until
+	data, err := marshalState(a.state, cfg.StateCompact)
+	if err != nil {
+		logger.Errorf("Ошибка сохранения границы сводки изменений: %v", err)
+		return
+	}
+	commit.Stage(cfg.stateFile(auditStateFilename), data)
+}
+
+// collectAuditEntries — изменения в интервале (from, to] по времени. Изменения групп берутся
+// из отчёта по группам, если он ведётся, иначе — из журнала событий.
+func collectAuditEntries(cfg *Config, report *groupReport, from, to time.Time) ([]auditEntry, error) {
+	var entries []auditEntry
+	if path := cfg.history.Path(); path != "" {
+		records, err := readHistory(path, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range records {
+			if rec.Simulated || auditSkipEvents[rec.Type] || (report != nil && rec.Type == EventGroupChanged) {
+				continue
+			}
+			entries = append(entries, auditEntry{Time: rec.Time, Type: rec.Type, Text: strings.TrimSpace(firstLine(rec.Message))})
+		}
+	}
+	if report != nil {
+		for _, rec := range report.Between(from, to) {
+			if !rec.Time.After(from) {
+				continue
+			}
+			entries = append(entries, auditEntry{Time: rec.Time, Type: EventGroupChanged, Text: strings.TrimSpace(rec.GroupChange.String())})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
+
+func formatAuditSummary(from, to time.Time, entries []auditEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Сводка изменений за период %s — %s\n", from.Format("2006-01-02 15:04"), to.Format("2006-01-02 15:04"))
+	if len(entries) == 0 {
+		b.WriteString("Изменений не было")
+		return b.String()
+	}
+
+	counts := map[string]int{}
+	for _, e := range entries {
+		counts[e.Type]++
+	}
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	parts := make([]string, 0, len(types))
+	for _, t := range types {
+		parts = append(parts, fmt.Sprintf("%s: %d", t, counts[t]))
+	}
+	fmt.Fprintf(&b, "Всего изменений: %d (%s)\n", len(entries), strings.Join(parts, ", "))
+
+	for i, e := range entries {
+		if i == auditSummaryMaxLines {
+			fmt.Fprintf(&b, "... и ещё %d", len(entries)-auditSummaryMaxLines)
+			break
+		}
+		fmt.Fprintf(&b, "%s %s\n", e.Time.Local().Format("2006-01-02 15:04"), e.Text)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// readHistory читает из журнала событий записи в интервале (from, to]
+func readHistory(path string, from, to time.Time) ([]HistoryRecord, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var out []HistoryRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec HistoryRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			// недописанная строка при аварийной остановке не должна ломать сводку
+			continue
+		}
+		if rec.Time.After(from) && !rec.Time.After(to) {
+			out = append(out, rec)
+		}
+	}
+	return out, scanner.Err()
+}
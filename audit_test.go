@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditSummaryWindowAndWatermark(t *testing.T) {
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{
+		"MM_WEBHOOK_URL":         mm.URL,
+		"HISTORY_FILE":           "history.jsonl",
+		"HISTORY_EVENTS":         "all",
+		"AUDIT_SUMMARY_INTERVAL": "1",
+	})
+	logger := testLogger(t)
+	start := clock.Now()
+	record := func(offset time.Duration, typ, msg string) {
+		cfg.history.Record(cfg, Event{Type: typ, MediaID: "1", MediaName: "Email", Message: msg, Time: start.Add(offset)}, logger)
+	}
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		events  func()
+		// пусто — сводка не отправляется
+		wantIn  []string
+		wantOut []string
+	}{
+		{
+			name: "первый запуск — только точка отсчёта",
+			events: func() {
+				record(-10*time.Minute, EventMediaDisabled, "до начала отсчёта")
+				record(0, EventMediaDisabled, "на границе периода")
+			},
+		},
+		{
+			name:    "период не истёк",
+			advance: 30 * time.Minute,
+			events: func() {
+				record(10*time.Minute, EventMediaDisabled, "Медиа Email выключено")
+				record(20*time.Minute, EventMediaStillDisabled, "Медиа Email всё ещё выключено")
+			},
+		},
+		{
+			name:    "первая сводка",
+			advance: 30 * time.Minute,
+			events: func() {
+				record(50*time.Minute, EventMediaAutoEnabled, "Медиа Email включено автоматически")
+			},
+			wantIn: []string{
+				"Всего изменений: 2 (media_auto_enabled: 1, media_disabled: 1)",
+				"Медиа Email выключено", "Медиа Email включено автоматически",
+			},
+			// напоминания — не изменения, а записи до начала периода уже не входят
+			wantOut: []string{"всё ещё выключено", "до начала отсчёта", "на границе периода"},
+		},
+		{
+			name:    "следующая сводка продолжает с границы",
+			advance: time.Hour,
+			events:  func() { record(65*time.Minute, EventGroupChanged, "Admins: +intruder") },
+			wantIn:  []string{"Всего изменений: 1 (group_changed: 1)", "Admins: +intruder"},
+			wantOut: []string{"Медиа Email выключено", "включено автоматически"},
+		},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		step.events()
+		// граница читается из файла, как после перезапуска
+		audit, err := loadAuditSummary(auditStateFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit := newCycleCommit()
+		audit.MaybeSend(context.Background(), cfg, nil, commit, logger)
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+
+		texts := mm.Texts()
+		if len(step.wantIn) == 0 {
+			if len(texts) != 0 {
+				t.Errorf("%s: отправлено %q", step.name, texts)
+			}
+			continue
+		}
+		if len(texts) != 1 {
+			t.Fatalf("%s: сообщения %q, ожидалась одна сводка", step.name, texts)
+		}
+		for _, s := range step.wantIn {
+			if !strings.Contains(texts[0], s) {
+				t.Errorf("%s: в сводке нет %q:\n%s", step.name, s, texts[0])
+			}
+		}
+		for _, s := range step.wantOut {
+			if strings.Contains(texts[0], s) {
+				t.Errorf("%s: в сводке лишнее %q:\n%s", step.name, s, texts[0])
+			}
+		}
+		saved, err := loadAuditSummary(auditStateFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !saved.state.Until.Equal(clock.Now()) {
+			t.Errorf("%s: граница %v, ожидалась %v", step.name, saved.state.Until, clock.Now())
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Журнал изменений (AUDIT_LOG_FILE) ----------------
+// Каждое включение медиа или действия вотчером и каждое изменение пользовательских групп
+// дописывается JSON-строкой в AUDIT_LOG_FILE. В отличие от HISTORY_FILE, журнал не зависит
+// от фильтров и кулдаунов уведомлений, а файл только дописывается.
+
+// Типы записей журнала изменений
+const (
+	auditMediaEnabled  = "media_enabled"
+	auditActionEnabled = "action_enabled"
+	auditGroupChanged  = "group_changed"
+)
+
+type AuditRecord struct {
+	Time        time.Time `json:"time"`
+	Server      string    `json:"server,omitempty"`
+	Type        string    `json:"type"`
+	MediaID     string    `json:"media_id,omitempty"`
+	MediaName   string    `json:"media_name,omitempty"`
+	ActionID    string    `json:"action_id,omitempty"`
+	ActionName  string    `json:"action_name,omitempty"`
+	DisabledFor float64   `json:"disabled_for_seconds,omitempty"`
+	// указатель — нулевой порог тоже записывается
+	Threshold       *float64     `json:"threshold_seconds,omitempty"`
+	ThresholdSource string       `json:"threshold_source,omitempty"`
+	Group           *GroupChange `json:"group,omitempty"`
+}
+
+// seconds — длительность в секундах для полей журнала
+func seconds(d time.Duration) *float64 {
+	s := d.Seconds()
+	return &s
+}
+
+type auditLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newAuditLog(path string) *auditLog {
+	if path == "" {
+		return nil
+	}
+	return &auditLog{path: path}
+}
+
+// MediaEnabled записывает включение медиа вотчером
+func (a *auditLog) MediaEnabled(cfg *Config, media MediaType, policy MediaPolicy, disabledFor time.Duration, logger *logrus.Logger) {
+	a.write(cfg, AuditRecord{
+		Type:            auditMediaEnabled,
+		MediaID:         media.MediaTypeID,
+		MediaName:       media.Name,
+		DisabledFor:     disabledFor.Seconds(),
+		Threshold:       seconds(policy.OffDuration),
+		ThresholdSource: policy.offDurationSource(),
+	}, logger)
+}
+
+// ActionEnabled записывает включение действия вотчером
+func (a *auditLog) ActionEnabled(cfg *Config, action Action, disabledFor time.Duration, logger *logrus.Logger) {
+	a.write(cfg, AuditRecord{
+		Type:            auditActionEnabled,
+		ActionID:        action.ActionID,
+		ActionName:      action.Name,
+		DisabledFor:     disabledFor.Seconds(),
+		Threshold:       seconds(cfg.OffDuration),
+		ThresholdSource: "MEDIA_OFF_DURATION",
+	}, logger)
+}
+
+// GroupChanged записывает изменение пользовательской группы
+func (a *auditLog) GroupChanged(cfg *Config, change GroupChange, logger *logrus.Logger) {
+	a.write(cfg, AuditRecord{Type: auditGroupChanged, Group: &change}, logger)
+}
+
+// write дописывает запись в журнал; ошибки записи только логируются. В режиме --simulate
+// в Zabbix ничего не меняется, поэтому и записей нет.
+func (a *auditLog) write(cfg *Config, rec AuditRecord, logger *logrus.Logger) {
+	if a == nil || cfg.Simulate {
+		return
+	}
+	rec.Time = cfg.Clock.Now().UTC()
+	rec.Server = cfg.ServerName
+	data, err := json.Marshal(rec)
+	if err != nil {
+		logger.WithError(err).Error("Ошибка формирования записи журнала изменений")
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.WithError(err).Errorf("Ошибка записи журнала изменений %s", a.path)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		logger.WithError(err).Errorf("Ошибка записи журнала изменений %s", a.path)
+	}
+}
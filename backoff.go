@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/syslog"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Недоступность API Zabbix (ZABBIX_BACKOFF_*) ----------------
+// После ZABBIX_BACKOFF_AFTER циклов подряд, в которых API сервера был недоступен (сетевая ошибка,
+// таймаут, HTTP 5xx), уходит одно уведомление о недоступности, а не по одному за цикл; первый цикл,
+// в котором API ответил, сообщает о восстановлении.
+// Если недоступны все серверы, интервал до следующего цикла умножается на ZABBIX_BACKOFF_FACTOR
+// за каждый следующий неудачный цикл, но не больше ZABBIX_BACKOFF_MAX.
+
+// errZabbixUnreachable — в цикле не ответил API ни одного сервера
+var errZabbixUnreachable = errors.New("API Zabbix недоступен")
+
+// zabbixUnreachable сообщает, что err — недоступность API: сетевая ошибка, таймаут или HTTP 5xx/429.
+// Ошибка JSON-RPC (*zabbixError), HTTP 4xx или неразборчивый ответ значат, что API ответил, —
+// это обычная ошибка цикла без уведомления о недоступности и увеличения интервала.
+func zabbixUnreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var serverErr zabbixServerError
+	var netErr net.Error
+	return errors.As(err, &serverErr) || errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// apiWatch считает подряд идущие циклы, в которых API сервера был недоступен
+type apiWatch struct {
+	failures int
+	since    time.Time
+	alerted  bool
+}
+
+func (w *apiWatch) Track(ctx context.Context, cfg *Config, err error, logger *logrus.Logger, sysLogger *syslog.Writer) {
+	if err == nil {
+		if w.alerted {
+			msg := fmt.Sprintf("API Zabbix снова доступен (был недоступен %s, циклов с ошибкой: %d)",
+				cfg.Clock.Now().Sub(w.since).Round(time.Second), w.failures)
+			logger.Info(msg)
+			if sysLogger != nil {
+				_ = sysLogger.Info(msg)
+			}
+			notify(ctx, cfg, Event{Type: EventZabbixRecovered, Message: msg, DisabledFor: cfg.Clock.Now().Sub(w.since)}, logger)
+		}
+		*w = apiWatch{}
+		return
+	}
+
+	if w.failures == 0 {
+		w.since = cfg.Clock.Now()
+	}
+	w.failures++
+	if cfg.BackoffAfter <= 0 || w.alerted || w.failures < cfg.BackoffAfter {
+		return
+	}
+	w.alerted = true
+	msg := fmt.Sprintf("API Zabbix недоступен %d циклов подряд. Последняя ошибка: %v", w.failures, err)
+	logger.WithField("api_failures", w.failures).Error(msg)
+	if sysLogger != nil {
+		_ = sysLogger.Err(msg)
+	}
+	notify(ctx, cfg, Event{Type: EventZabbixUnreachable, Message: msg, Error: err.Error(), DisabledFor: cfg.Clock.Now().Sub(w.since)}, logger)
+}
+
+// backoffInterval — интервал до следующего цикла после failures неудачных циклов подряд
+func (cfg *Config) backoffInterval(failures int) time.Duration {
+	if cfg.BackoffAfter <= 0 || failures < cfg.BackoffAfter {
+		return cfg.CheckInterval
+	}
+	d := cfg.CheckInterval
+	for i := cfg.BackoffAfter; i <= failures && d < cfg.BackoffMax; i++ {
+		d = time.Duration(float64(d) * cfg.BackoffFactor)
+	}
+	if d > cfg.BackoffMax {
+		d = cfg.BackoffMax
+	}
+	return d
+}
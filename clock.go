@@ -0,0 +1,58 @@
+package main
+
+import "time"
+
+// Clock — источник времени для логики порогов; в тестах подменяется управляемыми часами
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock — системные часы
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// clockStepTolerance — на сколько настенные часы могут разойтись с монотонными между циклами,
+// прежде чем считать, что системное время перевели (NTP step, ручная установка)
+const clockStepTolerance = time.Minute
+
+// clockWatch замечает перевод системных часов между циклами. Интервал циклов отсчитывает
+// time.Ticker по монотонным часам, а время отключения медиа хранится в настенном — его и
+// приходится поправлять после перевода.
+type clockWatch struct {
+	wall time.Time     // настенное время прошлого вызова
+	mono time.Duration // монотонное время прошлого вызова (от clockEpoch)
+}
+
+// clockEpoch — точка отсчёта монотонных показаний
+var clockEpoch = time.Now()
+
+// Step принимает time.Now() (с монотонными показаниями) и возвращает, на сколько перевели
+// настенные часы с прошлого вызова; 0 — не переводили
+func (w *clockWatch) Step(now time.Time) time.Duration {
+	if w == nil {
+		return 0
+	}
+	return w.step(now.Round(0), now.Sub(clockEpoch))
+}
+
+func (w *clockWatch) step(wall time.Time, mono time.Duration) time.Duration {
+	prevWall, prevMono := w.wall, w.mono
+	w.wall, w.mono = wall, mono
+	if prevWall.IsZero() {
+		return 0
+	}
+	step := wall.Sub(prevWall) - (mono - prevMono)
+	if step > clockStepTolerance || step < -clockStepTolerance {
+		return step
+	}
+	return 0
+}
+
+// shiftState сдвигает время первого обнаружения на перевод часов, чтобы длительность отключения
+// считалась по реально прошедшему времени
+func shiftState(state MediaState, step time.Duration) {
+	for _, rec := range state {
+		rec.FirstSeen = rec.FirstSeen.Add(step)
+	}
+}
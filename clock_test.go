@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClockWatchStep(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		wall, mono time.Duration // сдвиг настенных и монотонных часов со второго вызова
+		want       time.Duration
+	}{
+		{name: "часы идут ровно", wall: 5 * time.Minute, mono: 5 * time.Minute},
+		{name: "расхождение в пределах допуска", wall: 5*time.Minute + 30*time.Second, mono: 5 * time.Minute},
+		{name: "перевод вперёд", wall: 3 * time.Hour, mono: 5 * time.Minute, want: 3*time.Hour - 5*time.Minute},
+		{name: "перевод назад", wall: -2 * time.Hour, mono: 5 * time.Minute, want: -2*time.Hour - 5*time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w clockWatch
+			if step := w.step(base, time.Hour); step != 0 {
+				t.Fatalf("первый вызов: перевод %v", step)
+			}
+			if step := w.step(base.Add(tt.wall), time.Hour+tt.mono); step != tt.want {
+				t.Errorf("перевод %v, ожидалось %v", step, tt.want)
+			}
+		})
+	}
+	var w *clockWatch
+	if step := w.Step(time.Now()); step != 0 {
+		t.Errorf("без clockWatch: перевод %v", step)
+	}
+}
+
+func TestProcessMediaTypesClockStep(t *testing.T) {
+	// медиа на самом деле выключено 30 минут при пороге в час
+	const disabledFor = 30 * time.Minute
+	tests := []struct {
+		name string
+		step time.Duration
+	}{
+		// без поправки отключение выглядело бы длиннее порога, и медиа включилось бы раньше срока
+		{name: "вперёд", step: 3 * time.Hour},
+		// без поправки время обнаружения оказалось бы в будущем, и отсчёт начался бы заново
+		{name: "назад", step: -2 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			zabbix.Result("mediatype.update", map[string][]string{"mediatypeids": {"1"}})
+			cfg, clock := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL})
+			logger := testLogger(t)
+			// прошлый цикл видел настенное время до перевода
+			now := time.Now()
+			cfg.clockSteps = &clockWatch{wall: now.Round(0).Add(-tt.step), mono: now.Sub(clockEpoch)}
+			// время обнаружения записано по часам до перевода
+			state := MediaState{"1": {FirstSeen: clock.Now().Add(-tt.step - disabledFor)}}
+			media := []MediaType{{MediaTypeID: "1", Name: "Email", Status: "1"}}
+
+			commit := newCycleCommit()
+			handleMediaTypes(context.Background(), cfg, media, newMemoryStore(), state, make(EnableFailures), commit, logger, nil)
+			if err := commit.Commit(logger); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := len(zabbix.Calls("mediatype.update")); n != 0 {
+				t.Errorf("mediatype.update вызван %d раз до истечения порога", n)
+			}
+			firstSeen, ok := state["1"]
+			if !ok {
+				t.Fatal("медиа пропало из состояния")
+			}
+			// между засечкой в тесте и проверкой в цикле проходят доли секунды
+			if got := clock.Now().Sub(firstSeen.FirstSeen); got < disabledFor-time.Second || got > disabledFor+time.Second {
+				t.Errorf("после перевода отключено %v, ожидалось %v", got, disabledFor)
+			}
+		})
+	}
+}
+
+func TestOffDurationBoundaryWithFakeClock(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		off  time.Duration
+	}{
+		{name: "минуты числом", env: map[string]string{"MEDIA_OFF_DURATION": "60"}, off: time.Hour},
+		{name: "секунды", env: map[string]string{"MEDIA_OFF_DURATION": "90s"}, off: 90 * time.Second},
+		{name: "порог медиа", env: map[string]string{"MEDIA_OFF_DURATION_OVERRIDES": "Email:10"}, off: 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			zabbix.Result("mediatype.update", map[string][]string{"mediatypeids": {"1"}})
+			env := map[string]string{"ZABBIX_API_URL": zabbix.URL}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			cfg, clock := newTestConfig(t, env)
+			logger := testLogger(t)
+			media := []MediaType{{MediaTypeID: "1", Name: "Email", Status: "1"}}
+			state := make(MediaState)
+			failures := make(EnableFailures)
+
+			steps := []struct {
+				advance time.Duration
+				outcome string
+			}{
+				{outcome: outcomeDetected},
+				{advance: tt.off - time.Second, outcome: outcomeWaiting},
+				// ровно на пороге — без ожидания в реальном времени
+				{advance: time.Second, outcome: outcomeAutoEnabled},
+			}
+			for i, step := range steps {
+				clock.Advance(step.advance)
+				commit := newCycleCommit()
+				result := processMediaTypes(context.Background(), cfg, media, nil, newMemoryStore(), state, failures, make(VanishState), commit, logger, nil)
+				if err := commit.Commit(logger); err != nil {
+					t.Fatal(err)
+				}
+				if len(result.Outcomes) != 1 || result.Outcomes[0].Outcome != step.outcome {
+					t.Fatalf("шаг %d: исходы %+v, ожидалось %s", i+1, result.Outcomes, step.outcome)
+				}
+			}
+		})
+	}
+}
+
+func TestMattermostRateLimitFollowsClock(t *testing.T) {
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{"MM_WEBHOOK_URL": mm.URL, "MM_MAX_PER_MINUTE": "2"})
+	logger := testLogger(t)
+	send := func(n int) int {
+		for i := 0; i < n; i++ {
+			notify(context.Background(), cfg, Event{Type: EventGroupChanged, Message: "Admins: +intruder"}, logger)
+		}
+		return len(mm.Payloads())
+	}
+
+	steps := []struct {
+		advance time.Duration
+		send    int
+		want    int
+	}{
+		{send: 3, want: 2},
+		// жетон пополняется раз в 30 секунд по часам конфигурации
+		{advance: 29 * time.Second, send: 1},
+		{advance: time.Second, send: 2, want: 1},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		if got := send(step.send); got != step.want {
+			t.Errorf("шаг %d: доставлено %d, ожидалось %d", i+1, got, step.want)
+		}
+	}
+	if n := cfg.mmBucket.TakeDropped(); n != 3 {
+		t.Errorf("подавлено %d, ожидалось 3", n)
+	}
+}
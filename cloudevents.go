@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Уведомления в формате CloudEvents 1.0 ----------------
+
+const cloudEventsContentType = "application/cloudevents+json"
+
+// cloudEventTypes — соответствие внутренних типов событий атрибуту type CloudEvent
+var cloudEventTypes = map[string]string{
+	EventMediaDisabled:        "zabbix.media-watcher.media.disabled",
+	EventMediaStillDisabled:   "zabbix.media-watcher.media.still_disabled",
+	EventMediaAutoEnabled:     "zabbix.media-watcher.media.auto_enabled",
+	EventMediaEnableFailed:    "zabbix.media-watcher.media.enable_failed",
+	EventMediaRestored:        "zabbix.media-watcher.media.restored",
+	EventGroupChanged:         "zabbix.media-watcher.usergroup.changed",
+	EventUserChanged:          "zabbix.media-watcher.user.changed",
+	EventTemplateChanged:      "zabbix.media-watcher.media.template_changed",
+	EventMediaFieldsChanged:   "zabbix.media-watcher.media.fields_changed",
+	EventMaintenanceOverrun:   "zabbix.media-watcher.maintenance.overrun",
+	EventMaintenanceExpired:   "zabbix.media-watcher.maintenance.expired",
+	EventMaintenanceDeleted:   "zabbix.media-watcher.maintenance.deleted",
+	EventStatePersistFailed:   "zabbix.media-watcher.state.persist_failed",
+	EventStatePersistOK:       "zabbix.media-watcher.state.persist_restored",
+	EventHeartbeat:            "zabbix.media-watcher.heartbeat",
+	EventCycleSkipped:         "zabbix.media-watcher.cycle.skipped",
+	EventCycleTimeout:         "zabbix.media-watcher.cycle.timeout",
+	EventQuietDigest:          "zabbix.media-watcher.quiet.digest",
+	EventMediaMisconfigured:   "zabbix.media-watcher.media.misconfigured",
+	EventMediaConfigFixed:     "zabbix.media-watcher.media.config_fixed",
+	EventMediaReconciled:      "zabbix.media-watcher.media.reconciled",
+	EventMediaReconcileFailed: "zabbix.media-watcher.media.reconcile_failed",
+	EventMediaDrift:           "zabbix.media-watcher.media.drift",
+	EventMediaScheduledOff:    "zabbix.media-watcher.media.scheduled_off",
+	EventMediaScheduledOn:     "zabbix.media-watcher.media.scheduled_on",
+	EventMediaSuppressed:      "zabbix.media-watcher.media.suppressed",
+	EventUserMediaChanged:     "zabbix.media-watcher.user.media_changed",
+	EventMediaVanished:        "zabbix.media-watcher.media.vanished",
+	EventMediaReappeared:      "zabbix.media-watcher.media.reappeared",
+	EventAuditSummary:         "zabbix.media-watcher.audit.summary",
+	EventNotifySuppressed:     "zabbix.media-watcher.notify.suppressed",
+	EventActionDisabled:       "zabbix.media-watcher.action.disabled",
+	EventActionStillDisabled:  "zabbix.media-watcher.action.still_disabled",
+	EventActionAutoEnabled:    "zabbix.media-watcher.action.auto_enabled",
+	EventActionEnableFailed:   "zabbix.media-watcher.action.enable_failed",
+	EventActionRestored:       "zabbix.media-watcher.action.restored",
+	EventZabbixUnreachable:    "zabbix.media-watcher.api.unreachable",
+	EventZabbixRecovered:      "zabbix.media-watcher.api.recovered",
+}
+
+// CloudEvent — структурированное представление события (spec 1.0)
+type CloudEvent struct {
+	SpecVersion     string `json:"specversion"`
+	Type            string `json:"type"`
+	Source          string `json:"source"`
+	ID              string `json:"id"`
+	Time            string `json:"time"`
+	DataContentType string `json:"datacontenttype"`
+	// Атрибут-расширение с меткой окружения (ENVIRONMENT)
+	Environment string         `json:"environment,omitempty"`
+	Data        CloudEventData `json:"data"`
+}
+
+type CloudEventData struct {
+	Message            string  `json:"message"`
+	MediaID            string  `json:"media_id,omitempty"`
+	MediaName          string  `json:"media_name,omitempty"`
+	DisabledForSeconds float64 `json:"disabled_for_seconds,omitempty"`
+	ThresholdSeconds   float64 `json:"threshold_seconds,omitempty"`
+	Error              string  `json:"error,omitempty"`
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+func buildCloudEvent(cfg *Config, ev Event) CloudEvent {
+	ceType, ok := cloudEventTypes[ev.Type]
+	if !ok {
+		ceType = "zabbix.media-watcher." + ev.Type
+	}
+	return CloudEvent{
+		SpecVersion:     "1.0",
+		Type:            ceType,
+		Source:          cfg.CloudEventsSource,
+		ID:              newEventID(),
+		Time:            ev.Time.UTC().Format(time.RFC3339),
+		DataContentType: "application/json",
+		Environment:     cfg.Environment,
+		Data: CloudEventData{
+			Message:            ev.Message,
+			MediaID:            ev.MediaID,
+			MediaName:          ev.MediaName,
+			DisabledForSeconds: ev.DisabledFor.Seconds(),
+			ThresholdSeconds:   ev.Threshold.Seconds(),
+			Error:              ev.Error,
+		},
+	}
+}
+
+func sendCloudEvent(ctx context.Context, cfg *Config, ev Event, logger *logrus.Logger) {
+	data, err := json.Marshal(buildCloudEvent(cfg, ev))
+	if err != nil {
+		logger.WithError(err).Error("Ошибка формирования CloudEvent")
+		return
+	}
+	if _, err := cfg.delivery.Deliver(ctx, cfg, deliveryCloudEvents, ev.Type, data, logger); err != nil && !errors.Is(err, errSpooled) {
+		logger.WithError(err).Error("Ошибка отправки CloudEvent")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestBuildCloudEvent(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	tests := []struct {
+		name     string
+		env      map[string]string
+		ev       Event
+		wantType string
+		want     CloudEventData
+		wantEnv  string
+	}{
+		{
+			name:     "медиа отключено",
+			ev:       Event{Type: EventMediaDisabled, Message: "Email отключён", MediaID: "1", MediaName: "Email", Time: at},
+			wantType: "zabbix.media-watcher.media.disabled",
+			want:     CloudEventData{Message: "Email отключён", MediaID: "1", MediaName: "Email"},
+		},
+		{
+			name: "ошибка включения с длительностями",
+			ev: Event{Type: EventMediaEnableFailed, Message: "не удалось", MediaID: "2", MediaName: "SMS",
+				DisabledFor: 90 * time.Minute, Threshold: time.Hour, Error: "permission denied", Time: at},
+			wantType: "zabbix.media-watcher.media.enable_failed",
+			want: CloudEventData{Message: "не удалось", MediaID: "2", MediaName: "SMS",
+				DisabledForSeconds: 5400, ThresholdSeconds: 3600, Error: "permission denied"},
+		},
+		{
+			name:     "неизвестный тип получает префикс",
+			ev:       Event{Type: "something_new", Message: "x", Time: at},
+			wantType: "zabbix.media-watcher.something_new",
+			want:     CloudEventData{Message: "x"},
+		},
+		{
+			name:     "источник и окружение из конфигурации",
+			env:      map[string]string{"CLOUDEVENTS_SOURCE": "/zbx/prod", "ENVIRONMENT": "prod"},
+			ev:       Event{Type: EventStatePersistOK, Message: "восстановлено", Time: at},
+			wantType: "zabbix.media-watcher.state.persist_restored",
+			want:     CloudEventData{Message: "восстановлено"},
+			wantEnv:  "prod",
+		},
+	}
+	idPattern := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := newTestConfig(t, tt.env)
+			ce := buildCloudEvent(cfg, tt.ev)
+			if ce.SpecVersion != "1.0" {
+				t.Errorf("specversion = %q", ce.SpecVersion)
+			}
+			if ce.Type != tt.wantType {
+				t.Errorf("type = %q, ожидалось %q", ce.Type, tt.wantType)
+			}
+			if ce.Source != cfg.CloudEventsSource || ce.Source == "" {
+				t.Errorf("source = %q, ожидалось %q", ce.Source, cfg.CloudEventsSource)
+			}
+			if !idPattern.MatchString(ce.ID) {
+				t.Errorf("id = %q", ce.ID)
+			}
+			if ce.Time != "2024-03-01T09:00:00Z" {
+				t.Errorf("time = %q, ожидалось время в UTC", ce.Time)
+			}
+			if ce.DataContentType != "application/json" {
+				t.Errorf("datacontenttype = %q", ce.DataContentType)
+			}
+			if ce.Environment != tt.wantEnv {
+				t.Errorf("environment = %q, ожидалось %q", ce.Environment, tt.wantEnv)
+			}
+			if ce.Data != tt.want {
+				t.Errorf("data = %+v, ожидалось %+v", ce.Data, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildCloudEventUniqueIDs(t *testing.T) {
+	cfg, clock := newTestConfig(t, nil)
+	ev := Event{Type: EventMediaDisabled, Message: "x", Time: clock.Now()}
+	if a, b := buildCloudEvent(cfg, ev).ID, buildCloudEvent(cfg, ev).ID; a == b {
+		t.Errorf("два события с одинаковым id %q", a)
+	}
+}
+
+func TestSendCloudEvent(t *testing.T) {
+	var (
+		contentType string
+		envelope    map[string]json.RawMessage
+	)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer sink.Close()
+	cfg, clock := newTestConfig(t, map[string]string{"CLOUDEVENTS_URL": sink.URL})
+
+	notify(context.Background(), cfg, Event{Type: EventMediaRestored, Message: "Email снова включён", MediaID: "1", MediaName: "Email", Time: clock.Now()}, testLogger(t))
+
+	if contentType != cloudEventsContentType {
+		t.Errorf("Content-Type = %q, ожидалось %q", contentType, cloudEventsContentType)
+	}
+	// обязательные атрибуты CloudEvents 1.0 присутствуют в структурированном виде
+	for _, attr := range []string{"specversion", "type", "source", "id", "time", "datacontenttype", "data"} {
+		if _, ok := envelope[attr]; !ok {
+			t.Errorf("в конверте нет атрибута %q: %v", attr, envelope)
+		}
+	}
+	var data CloudEventData
+	if err := json.Unmarshal(envelope["data"], &data); err != nil || data.MediaName != "Email" || data.Message != "Email снова включён" {
+		t.Errorf("data = %s", envelope["data"])
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+)
+
+// cycleCommit собирает все файлы, изменённые за цикл, и записывает их одной группой:
+// сначала каждый файл пишется во временный рядом с целевым, и только когда
+// подготовлены все — временные файлы переименовываются поверх целевых.
+// Если процесс упадёт до переименования, на диске останется согласованное состояние прошлого цикла.
+// Записи в базу (STATE_BACKEND=sqlite) выполняются в открытой транзакции до переименования,
+// а фиксируются последним шагом: если переименовать файл не удалось, транзакция откатывается.
+type cycleCommit struct {
+	files []stagedFile
+	txs   []stagedTx
+}
+
+type stagedFile struct {
+	target string
+	data   []byte
+}
+
+type stagedTx struct {
+	key   string
+	begin func() (pendingTx, error)
+}
+
+// pendingTx — запись, выполненная, но ещё не зафиксированная (транзакция базы)
+type pendingTx interface {
+	Commit() error
+	Rollback() error
+}
+
+func newCycleCommit() *cycleCommit {
+	return &cycleCommit{}
+}
+
+// Stage добавляет файл в коммит; повторный Stage того же файла заменяет данные
+func (c *cycleCommit) Stage(filename string, data []byte) {
+	for i := range c.files {
+		if c.files[i].target == filename {
+			c.files[i].data = data
+			return
+		}
+	}
+	c.files = append(c.files, stagedFile{target: filename, data: data})
+}
+
+// StageTx добавляет в коммит запись в базу: begin выполняет её в транзакции, не фиксируя.
+// Повторный StageTx с тем же ключом заменяет прежнюю.
+func (c *cycleCommit) StageTx(key string, begin func() (pendingTx, error)) {
+	for i := range c.txs {
+		if c.txs[i].key == key {
+			c.txs[i].begin = begin
+			return
+		}
+	}
+	c.txs = append(c.txs, stagedTx{key: key, begin: begin})
+}
+
+// Commit записывает все подготовленные файлы и записи в базу. При ошибке до переименования
+// ни один целевой файл не изменяется, транзакции откатываются, а всё подготовленное остаётся
+// в очереди до следующего цикла.
+func (c *cycleCommit) Commit(logger *logrus.Logger) error {
+	if len(c.files) == 0 && len(c.txs) == 0 {
+		return nil
+	}
+
+	temps := make([]string, 0, len(c.files))
+	cleanup := func() {
+		for _, t := range temps {
+			_ = os.Remove(t)
+		}
+	}
+	open := make([]pendingTx, 0, len(c.txs))
+	rollback := func() {
+		for _, tx := range open {
+			_ = tx.Rollback()
+		}
+	}
+
+	for _, f := range c.files {
+		tmp, err := writeTempFile(f.target, f.data)
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("подготовка %s: %v", f.target, err)
+		}
+		temps = append(temps, tmp)
+	}
+
+	for _, t := range c.txs {
+		tx, err := t.begin()
+		if err != nil {
+			rollback()
+			cleanup()
+			return fmt.Errorf("запись %s: %v", t.key, err)
+		}
+		open = append(open, tx)
+	}
+
+	dirs := map[string]bool{}
+	for i, f := range c.files {
+		if err := os.Rename(temps[i], f.target); err != nil {
+			rollback()
+			cleanup()
+			// уже переименованные файлы записаны, в следующем цикле они запишутся повторно
+			return fmt.Errorf("запись %s: %v", f.target, err)
+		}
+		dirs[filepath.Dir(f.target)] = true
+		logger.Infof("Состояние сохранено в %s", f.target)
+	}
+	c.files = nil
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			logger.WithError(err).Warnf("Не удалось сбросить на диск каталог %s", dir)
+		}
+	}
+
+	for i, tx := range open {
+		if err := tx.Commit(); err != nil {
+			open = open[i+1:]
+			rollback()
+			return fmt.Errorf("запись %s: %v", c.txs[i].key, err)
+		}
+		logger.Infof("Состояние сохранено в %s", c.txs[i].key)
+	}
+	c.txs = nil
+	return nil
+}
+
+// syncDir сбрасывает на диск запись каталога: без этого после сбоя питания переименование
+// может не сохраниться и на месте файла окажется прежняя версия
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// writeTempFile пишет данные во временный файл в каталоге целевого и возвращает его путь
+func writeTempFile(target string, data []byte) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	name := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(name)
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(name)
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	if err := os.Chmod(name, 0644); err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return name, nil
+}
+
+// persistWatch считает подряд идущие ошибки сохранения состояния.
+// Это отдельный сигнал: API может быть доступен, а состояние при этом не пишется на диск.
+type persistWatch struct {
+	failures int
+	alerted  bool
+}
+
+func (w *persistWatch) Track(ctx context.Context, cfg *Config, err error, logger *logrus.Logger, sysLogger *syslog.Writer) {
+	if err == nil {
+		if w.alerted {
+			logger.Info("Сохранение состояния восстановлено")
+			if sysLogger != nil {
+				_ = sysLogger.Info("Сохранение состояния восстановлено")
+			}
+			notify(ctx, cfg, Event{Type: EventStatePersistOK, Message: "Сохранение состояния восстановлено"}, logger)
+		}
+		w.failures = 0
+		w.alerted = false
+		return
+	}
+
+	w.failures++
+	if cfg.StateSaveFailThreshold <= 0 || w.alerted || w.failures < cfg.StateSaveFailThreshold {
+		return
+	}
+	w.alerted = true
+	msg := fmt.Sprintf("КРИТИЧНО: состояние не сохраняется %d циклов подряд, отслеживание медиа может расходиться с реальностью. Последняя ошибка: %v", w.failures, err)
+	logger.WithField("save_failures", w.failures).Error(msg)
+	if sysLogger != nil {
+		_ = sysLogger.Crit(msg)
+	}
+	notify(ctx, cfg, Event{Type: EventStatePersistFailed, Message: msg, Error: err.Error()}, logger)
+}
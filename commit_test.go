@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, data string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// tempLeftovers — временные файлы коммита, оставшиеся в каталоге
+func tempLeftovers(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tmp-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestCycleCommitWritesAllFiles(t *testing.T) {
+	dir := t.TempDir()
+	media := filepath.Join(dir, "media_state.json")
+	groups := filepath.Join(dir, "usergroup_state.json")
+	writeFile(t, media, "old")
+
+	c := newCycleCommit()
+	c.Stage(media, []byte("first"))
+	c.Stage(groups, []byte("groups"))
+	// повторный Stage того же файла заменяет данные
+	c.Stage(media, []byte("new"))
+	if got := readFile(t, media); got != "old" {
+		t.Fatalf("файл изменён до Commit: %q", got)
+	}
+	if err := c.Commit(testLogger(t)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, media); got != "new" {
+		t.Errorf("файл состояния медиа = %q, ожидалось new", got)
+	}
+	if got := readFile(t, groups); got != "groups" {
+		t.Errorf("файл состояния групп = %q, ожидалось groups", got)
+	}
+	if left := tempLeftovers(t, dir); len(left) > 0 {
+		t.Errorf("остались временные файлы: %v", left)
+	}
+}
+
+// failingTx — запись в базу, которая не выполняется или не фиксируется
+type failingTx struct {
+	rolledBack *bool
+	commitErr  error
+}
+
+func (tx failingTx) Commit() error { return tx.commitErr }
+
+func (tx failingTx) Rollback() error {
+	*tx.rolledBack = true
+	return nil
+}
+
+func TestCycleCommitFailureLeavesPreviousState(t *testing.T) {
+	tests := []struct {
+		name string
+		// stage подготавливает коммит так, чтобы один из этапов сорвался
+		stage func(t *testing.T, dir string, c *cycleCommit)
+		// fix устраняет причину, после чего повторный Commit должен пройти
+		fix func(t *testing.T, dir string)
+	}{
+		{
+			name: "не удалось подготовить временный файл",
+			stage: func(t *testing.T, dir string, c *cycleCommit) {
+				c.Stage(filepath.Join(dir, "missing", "export.json"), []byte("new"))
+			},
+			fix: func(t *testing.T, dir string) {
+				if err := os.Mkdir(filepath.Join(dir, "missing"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "не удалось переименовать временный файл",
+			stage: func(t *testing.T, dir string, c *cycleCommit) {
+				// поверх непустого каталога файл не переименовать
+				blocked := filepath.Join(dir, "blocked.json")
+				if err := os.MkdirAll(filepath.Join(blocked, "child"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				c.Stage(blocked, []byte("new"))
+			},
+			fix: func(t *testing.T, dir string) {
+				if err := os.RemoveAll(filepath.Join(dir, "blocked.json")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			state := filepath.Join(dir, "media_state.json")
+			writeFile(t, state, "old")
+			store := newMemoryStore()
+
+			c := newCycleCommit()
+			tt.stage(t, dir, c)
+			c.Stage(state, []byte("new"))
+			if err := store.SaveMedia(c, MediaState{"1": {FirstSeen: time.Unix(100, 0), Name: "Email"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.Commit(testLogger(t)); err == nil {
+				t.Fatal("Commit должен вернуть ошибку")
+			}
+			if got := readFile(t, state); got != "old" {
+				t.Errorf("файл состояния изменён при сорвавшемся коммите: %q", got)
+			}
+			if loaded, _, _ := store.LoadMedia(); len(loaded) != 0 {
+				t.Errorf("запись в хранилище применена при сорвавшемся коммите: %v", loaded)
+			}
+			if left := tempLeftovers(t, dir); len(left) > 0 {
+				t.Errorf("остались временные файлы: %v", left)
+			}
+
+			// подготовленное остаётся в очереди и записывается следующим коммитом
+			tt.fix(t, dir)
+			if err := c.Commit(testLogger(t)); err != nil {
+				t.Fatalf("повторный Commit: %v", err)
+			}
+			if got := readFile(t, state); got != "new" {
+				t.Errorf("файл состояния после повторного коммита = %q, ожидалось new", got)
+			}
+			if loaded, _, _ := store.LoadMedia(); loaded["1"] == nil {
+				t.Errorf("запись в хранилище не применена после повторного коммита")
+			}
+		})
+	}
+}
+
+func TestPersistWatchAlertsAfterFailureStreak(t *testing.T) {
+	mm := newMattermostRecorder(t)
+	cfg, err := loadTestConfig(t, map[string]string{"MM_WEBHOOK_URL": mm.URL, "STATE_SAVE_FAIL_THRESHOLD": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := testLogger(t)
+	dir := t.TempDir()
+	w := &persistWatch{}
+
+	// cycle сохраняет состояние так, как это делает цикл вотчера; ok=false — каталога нет
+	cycle := func(ok bool) {
+		target := filepath.Join(dir, "missing", "media_state.json")
+		if ok {
+			target = filepath.Join(dir, "media_state.json")
+		}
+		c := newCycleCommit()
+		c.Stage(target, []byte("{}"))
+		w.Track(context.Background(), cfg, c.Commit(logger), logger, nil)
+	}
+
+	steps := []struct {
+		ok   bool
+		want string
+	}{
+		{ok: false},
+		{ok: false},
+		{ok: false, want: "не сохраняется 3 циклов подряд"},
+		// повторно о той же серии не сообщается
+		{ok: false},
+		{ok: true, want: "Сохранение состояния восстановлено"},
+		// после восстановления серия считается заново
+		{ok: false},
+		{ok: false},
+		{ok: true},
+		{ok: false},
+		{ok: false},
+		{ok: false, want: "не сохраняется 3 циклов подряд"},
+	}
+	for i, step := range steps {
+		cycle(step.ok)
+		got := mm.Texts()
+		if step.want == "" {
+			if len(got) != 0 {
+				t.Fatalf("цикл %d: лишние уведомления %q", i+1, got)
+			}
+			continue
+		}
+		if len(got) != 1 || !strings.Contains(got[0], step.want) {
+			t.Fatalf("цикл %d: уведомления %q, ожидалось одно с %q", i+1, got, step.want)
+		}
+	}
+}
+
+func TestPersistWatchDisabled(t *testing.T) {
+	mm := newMattermostRecorder(t)
+	cfg, err := loadTestConfig(t, map[string]string{"MM_WEBHOOK_URL": mm.URL, "STATE_SAVE_FAIL_THRESHOLD": "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &persistWatch{}
+	for i := 0; i < 5; i++ {
+		w.Track(context.Background(), cfg, errors.New("disk full"), testLogger(t), nil)
+	}
+	if got := mm.Texts(); len(got) != 0 {
+		t.Fatalf("при STATE_SAVE_FAIL_THRESHOLD=0 отправлены уведомления %q", got)
+	}
+}
+
+func TestStatePartialWriteKeepsPreviousState(t *testing.T) {
+	since := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		file string
+		save func(c *cycleCommit, file string) error
+		// check сверяет загруженное состояние с сохранённым до сбоя
+		check func(t *testing.T, file string)
+	}{
+		{
+			name: "медиа",
+			file: "media_state.json",
+			save: func(c *cycleCommit, file string) error {
+				return saveState(c, file, MediaState{"1": {FirstSeen: since}}, false)
+			},
+			check: func(t *testing.T, file string) {
+				state, _, err := loadState(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !state["1"].FirstSeen.Equal(since) {
+					t.Errorf("состояние медиа %v", state)
+				}
+			},
+		},
+		{
+			name: "группы",
+			file: "usergroup_state.json",
+			save: func(c *cycleCommit, file string) error {
+				return saveGroupState(c, file, GroupState{"7": {ID: "7", Name: "Admins", Users: []string{"1"}}}, false)
+			},
+			check: func(t *testing.T, file string) {
+				state, existed, err := loadGroupState(file)
+				if err != nil || !existed {
+					t.Fatalf("состояние групп: %v, %v", existed, err)
+				}
+				if g := state["7"]; g.Name != "Admins" || !reflect.DeepEqual(g.Users, []string{"1"}) {
+					t.Errorf("состояние групп %v", state)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := filepath.Join(dir, tt.file)
+			c := newCycleCommit()
+			if err := tt.save(c, target); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Commit(testLogger(t)); err != nil {
+				t.Fatal(err)
+			}
+			saved := readFile(t, target)
+			if !strings.Contains(saved, "\n  ") {
+				t.Errorf("состояние записано без отступов: %q", saved)
+			}
+			if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0644 {
+				t.Errorf("права файла состояния: %v, %v", info.Mode().Perm(), err)
+			}
+
+			// процесс убит посреди записи: во временном файле рядом оборванный JSON, до переименования дело не дошло
+			writeFile(t, target+".tmp-crash", saved[:len(saved)/2])
+
+			if got := readFile(t, target); got != saved {
+				t.Errorf("файл состояния изменён: %q", got)
+			}
+			tt.check(t, target)
+
+			// следующий цикл пишет состояние как обычно, оборванный файл ему не мешает
+			c = newCycleCommit()
+			if err := tt.save(c, target); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Commit(testLogger(t)); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, target)
+		})
+	}
+}
+
+func TestCycleCommitTxBeginFailureKeepsFiles(t *testing.T) {
+	dir := t.TempDir()
+	state := filepath.Join(dir, "media_state.json")
+	writeFile(t, state, "old")
+
+	c := newCycleCommit()
+	c.Stage(state, []byte("new"))
+	c.StageTx("база", func() (pendingTx, error) { return nil, errors.New("database is locked") })
+
+	err := c.Commit(testLogger(t))
+	if err == nil || !strings.Contains(err.Error(), "database is locked") {
+		t.Fatalf("Commit = %v, ожидалась ошибка базы", err)
+	}
+	if got := readFile(t, state); got != "old" {
+		t.Errorf("файл состояния изменён, хотя запись в базу не выполнилась: %q", got)
+	}
+	if left := tempLeftovers(t, dir); len(left) > 0 {
+		t.Errorf("остались временные файлы: %v", left)
+	}
+}
+
+func TestCycleCommitRenameFailureRollsBackTx(t *testing.T) {
+	dir := t.TempDir()
+	blocked := filepath.Join(dir, "blocked.json")
+	if err := os.MkdirAll(filepath.Join(blocked, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var rolledBack bool
+	c := newCycleCommit()
+	c.Stage(blocked, []byte("new"))
+	c.StageTx("база", func() (pendingTx, error) {
+		return failingTx{rolledBack: &rolledBack, commitErr: errors.New("транзакция не должна фиксироваться")}, nil
+	})
+	if err := c.Commit(testLogger(t)); err == nil {
+		t.Fatal("Commit должен вернуть ошибку")
+	}
+	if !rolledBack {
+		t.Error("транзакция не откачена после ошибки переименования")
+	}
+}
+
+func TestCycleCommitSQLiteCommittedLast(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{StateBackend: stateBackendSQLite, StateDSN: filepath.Join(dir, "state.db"), Clock: realClock{}}
+	db, err := openStateDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	blocked := filepath.Join(dir, "blocked.json")
+	if err := os.MkdirAll(filepath.Join(blocked, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	store := newStateStore(cfg, db)
+	c := newCycleCommit()
+	c.Stage(blocked, []byte("new"))
+	if err := store.SaveMedia(c, MediaState{"1": {FirstSeen: time.Unix(100, 0).UTC(), Name: "Email"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveGroups(c, GroupState{"7": {ID: "7", Name: "Ops"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Commit(testLogger(t)); err == nil {
+		t.Fatal("Commit должен вернуть ошибку")
+	}
+
+	// свежее хранилище читает то, что реально в базе
+	fresh := newStateStore(cfg, db)
+	if media, _, err := fresh.LoadMedia(); err != nil || len(media) != 0 {
+		t.Errorf("медиа в базе после отката = %v, %v; ожидалось пусто", media, err)
+	}
+	if _, existed, err := fresh.LoadGroups(); err != nil || existed {
+		t.Errorf("группы в базе после отката: existed=%v, %v", existed, err)
+	}
+
+	if err := os.RemoveAll(blocked); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Commit(testLogger(t)); err != nil {
+		t.Fatalf("повторный Commit: %v", err)
+	}
+	media, _, err := fresh.LoadMedia()
+	if err != nil || media["1"] == nil || media["1"].Name != "Email" {
+		t.Errorf("медиа в базе после коммита = %v, %v", media, err)
+	}
+	groups, existed, err := fresh.LoadGroups()
+	if err != nil || !existed || groups["7"].Name != "Ops" {
+		t.Errorf("группы в базе после коммита = %v (existed=%v), %v", groups, existed, err)
+	}
+}
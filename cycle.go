@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Расписание циклов ----------------
+
+// runOnce выполняет один цикл без расписания (-once, RUN_ONCE) — для запуска из cron и systemd-таймеров.
+// Ошибка возвращается, если API Zabbix какого-либо сервера был недоступен.
+func runOnce(cfg *Config, watchers []*watcher, logger *logrus.Logger) error {
+	if maintenanceModeActive(cfg) {
+		logger.Warnf("Режим обслуживания активен (найден %s) — проверка пропущена", cfg.MaintenanceFile)
+		return nil
+	}
+	ctx, cancel := cfg.newCycleContext(context.Background())
+	defer cancel()
+	return runWatchers(ctx, cfg, watchers, logger)
+}
+
+// runScheduled запускает cycle сразу и затем каждые CheckInterval.
+// Если к очередному запуску предыдущий цикл ещё не завершился, запуск пропускается —
+// два цикла одновременно с общим состоянием не работают. Пропуск сразу пишется в лог,
+// а уведомление уходит после завершения затянувшегося цикла, чтобы не отправлять его параллельно с ним.
+// cycle получает контекст цикла: при CYCLE_TIMEOUT у него есть дедлайн, все HTTP-запросы цикла
+// идут с этим контекстом и после дедлайна обрываются, а цикл дорабатывает с ошибками.
+// После отмены ctx (SIGINT/SIGTERM) новые циклы не запускаются, а текущий дорабатывает до конца,
+// чтобы не оборвать его между изменением в Zabbix и записью состояния. Повторный сигнал
+// во время ожидания отменяет контекст цикла — его запросы обрываются, и цикл завершается с ошибками.
+// Пока API Zabbix недоступен (cycle возвращает errZabbixUnreachable), интервал увеличивается по ZABBIX_BACKOFF_*.
+func runScheduled(ctx context.Context, cfg *Config, cycle func(ctx context.Context) error, logger *logrus.Logger) {
+	done := make(chan struct{})
+	var (
+		started  time.Time
+		cycleErr error
+	)
+	abortCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	start := func() {
+		started = time.Now()
+		cfg.schedule.Started(started)
+		go func() {
+			defer func() { done <- struct{}{} }()
+			ctx, cancel := cfg.newCycleContext(abortCtx)
+			defer cancel()
+			cycleErr = cycle(ctx)
+			if ctx.Err() == context.DeadlineExceeded {
+				reportCycleTimeout(cfg, time.Since(started), logger)
+			}
+		}()
+	}
+
+	interval := cfg.CheckInterval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	cfg.schedule.Planned(time.Now().Add(interval))
+	running := true
+	skipped := 0
+	apiFailures := 0
+	start()
+	for {
+		select {
+		case <-ctx.Done():
+			if running {
+				logger.Info("Получен сигнал остановки — ожидание завершения текущего цикла (повторный сигнал прервёт его запросы)")
+				again := make(chan os.Signal, 1)
+				signal.Notify(again, os.Interrupt, syscall.SIGTERM)
+				go func() {
+					if _, ok := <-again; ok {
+						logger.Warn("Повторный сигнал остановки — запросы текущего цикла прерваны")
+						abort()
+					}
+				}()
+				<-done
+				signal.Stop(again)
+				close(again)
+				cfg.schedule.Finished(time.Now())
+				if skipped > 0 {
+					reportCycleSkipped(cfg, skipped, time.Since(started), interval, logger)
+				}
+			}
+			return
+		case <-done:
+			running = false
+			cfg.schedule.Finished(time.Now())
+			checkCycleDuration.Observe(time.Since(started).Seconds())
+			if skipped > 0 {
+				reportCycleSkipped(cfg, skipped, time.Since(started), interval, logger)
+				skipped = 0
+			}
+			if errors.Is(cycleErr, errZabbixUnreachable) {
+				apiFailures++
+			} else {
+				apiFailures = 0
+			}
+			if next := cfg.backoffInterval(apiFailures); next != interval {
+				if next > interval {
+					logger.WithField("api_failures", apiFailures).Warnf("API Zabbix недоступен — интервал проверки увеличен до %v", next)
+				} else {
+					logger.Infof("API Zabbix доступен — интервал проверки снова %v", next)
+				}
+				interval = next
+				ticker.Reset(interval)
+				cfg.schedule.Planned(time.Now().Add(interval))
+			}
+		case tick := <-ticker.C:
+			// тикер не сдвигается от длительности цикла: следующий запуск — ровно через интервал от этого тика
+			cfg.schedule.Planned(tick.Add(interval))
+			if running {
+				cfg.schedule.Skipped()
+				skipped++
+				logger.WithFields(logrus.Fields{"running_for": time.Since(started), "interval": interval}).Warn("Предыдущий цикл ещё выполняется — запуск пропущен")
+				continue
+			}
+			running = true
+			start()
+		}
+	}
+}
+
+// cycleSchedule — время следующего и последнего запусков для /schedule и логов
+type cycleSchedule struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	next       time.Time
+	lastStart  time.Time
+	lastFinish time.Time
+	running    bool
+	skipped    int
+}
+
+func newCycleSchedule(interval time.Duration) *cycleSchedule {
+	return &cycleSchedule{interval: interval}
+}
+
+func (s *cycleSchedule) Planned(next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next = next
+}
+
+func (s *cycleSchedule) Started(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastStart = at
+	s.running = true
+}
+
+func (s *cycleSchedule) Finished(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastFinish = at
+	s.running = false
+}
+
+func (s *cycleSchedule) Skipped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped++
+}
+
+// Next — время следующего запуска по тикеру
+func (s *cycleSchedule) Next() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.next
+}
+
+// ServeHTTP отдаёт GET /schedule: интервал, следующий запуск и сведения о последнем цикле
+func (s *cycleSchedule) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.Lock()
+	resp := struct {
+		IntervalSeconds     float64    `json:"interval_seconds"`
+		NextRun             *time.Time `json:"next_run,omitempty"`
+		NextRunInSeconds    float64    `json:"next_run_in_seconds"`
+		LastStart           *time.Time `json:"last_start,omitempty"`
+		LastFinish          *time.Time `json:"last_finish,omitempty"`
+		LastDurationSeconds float64    `json:"last_duration_seconds,omitempty"`
+		Running             bool       `json:"running"`
+		Skipped             int        `json:"skipped_total"`
+	}{IntervalSeconds: s.interval.Seconds(), Running: s.running, Skipped: s.skipped}
+	if !s.next.IsZero() {
+		next := s.next.UTC()
+		resp.NextRun = &next
+		if d := time.Until(s.next); d > 0 {
+			resp.NextRunInSeconds = d.Round(time.Second).Seconds()
+		}
+	}
+	if !s.lastStart.IsZero() {
+		start := s.lastStart.UTC()
+		resp.LastStart = &start
+	}
+	if !s.lastFinish.IsZero() {
+		finish := s.lastFinish.UTC()
+		resp.LastFinish = &finish
+		if !s.lastFinish.Before(s.lastStart) {
+			resp.LastDurationSeconds = s.lastFinish.Sub(s.lastStart).Seconds()
+		}
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// newCycleContext — контекст цикла: отменяется вместе с parent и по CYCLE_TIMEOUT
+func (cfg *Config) newCycleContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if cfg.CycleTimeout > 0 {
+		return context.WithTimeout(parent, cfg.CycleTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// reportCycleSkipped сообщает о пропущенных запусках; interval — действовавший интервал, который
+// при недоступности API бывает больше MEDIA_CHECK_INTERVAL
+func reportCycleSkipped(cfg *Config, skipped int, ran, interval time.Duration, logger *logrus.Logger) {
+	msg := fmt.Sprintf("Пропущено запусков проверки: %d — предыдущий цикл выполнялся %v при интервале %v",
+		skipped, ran.Round(time.Second), interval)
+	logger.WithFields(logrus.Fields{"skipped": skipped, "ran_for": ran, "interval": interval}).Warn(msg)
+	// уведомления вне цикла отправляются без его дедлайна
+	notify(context.Background(), cfg, Event{Type: EventCycleSkipped, Message: msg, DisabledFor: ran, Threshold: interval}, logger)
+}
+
+func reportCycleTimeout(cfg *Config, ran time.Duration, logger *logrus.Logger) {
+	msg := fmt.Sprintf("Цикл проверки прерван по таймауту %v (выполнялся %v) — часть проверок могла не пройти", cfg.CycleTimeout, ran.Round(time.Second))
+	logger.WithField("ran_for", ran).Error(msg)
+	notify(context.Background(), cfg, Event{Type: EventCycleTimeout, Message: msg, DisabledFor: ran, Threshold: cfg.CycleTimeout}, logger)
+}
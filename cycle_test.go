@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCycleReports(t *testing.T) {
+	mm := newMattermostRecorder(t)
+	var (
+		mu    sync.Mutex
+		hooks []string
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		hooks = append(hooks, string(body))
+		mu.Unlock()
+	}))
+	defer hook.Close()
+	cfg, _ := newTestConfig(t, map[string]string{
+		"MM_WEBHOOK_URL":           mm.URL,
+		"MEDIA_CHECK_INTERVAL":     "5",
+		"CYCLE_TIMEOUT":            "30",
+		"GENERIC_WEBHOOK_URL":      hook.URL,
+		"GENERIC_WEBHOOK_TEMPLATE": "{{.Type}} {{.ThresholdSeconds}}",
+	})
+	logger := testLogger(t)
+
+	// при недоступности API интервал был увеличен с 5m до 20m — в отчёте действовавший интервал
+	reportCycleSkipped(cfg, 2, 12*time.Minute, 20*time.Minute, logger)
+	reportCycleTimeout(cfg, 31*time.Second, logger)
+
+	want := []string{
+		"Пропущено запусков проверки: 2 — предыдущий цикл выполнялся 12m0s при интервале 20m0s",
+		"Цикл проверки прерван по таймауту 30s (выполнялся 31s)",
+	}
+	texts := mm.Texts()
+	if len(texts) != len(want) {
+		t.Fatalf("сообщения %q, ожидалось %d", texts, len(want))
+	}
+	for i := range want {
+		if !strings.HasPrefix(texts[i], want[i]) {
+			t.Errorf("сообщение %q, ожидалось начало %q", texts[i], want[i])
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if wantHooks := []string{EventCycleSkipped + " 1200", EventCycleTimeout + " 30"}; !reflect.DeepEqual(hooks, wantHooks) {
+		t.Errorf("события webhook %q, ожидалось %q", hooks, wantHooks)
+	}
+}
+
+func TestCycleTimeoutAbortsRequests(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// тело дочитано — сервер замечает обрыв соединения клиентом
+		_, _ = io.Copy(io.Discard, r.Body)
+		select {
+		case <-time.After(time.Minute):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	cfg, _ := newTestConfig(t, nil)
+	cfg.CycleTimeout = 30 * time.Millisecond
+
+	ctx, cancel := cfg.newCycleContext(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	_, err := doHTTP(ctx, cfg, http.MethodPost, slow.URL, nil, []byte("{}"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ошибка %v, ожидался дедлайн цикла", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("запрос оборван через %v", elapsed)
+	}
+}
+
+func TestCheckIntervalMustBePositive(t *testing.T) {
+	if _, err := loadTestConfig(t, map[string]string{"MEDIA_CHECK_INTERVAL": "0"}); err == nil || !strings.Contains(err.Error(), "MEDIA_CHECK_INTERVAL") {
+		t.Errorf("MEDIA_CHECK_INTERVAL=0: ошибка %v", err)
+	}
+}
+
+func TestRunScheduledNextRun(t *testing.T) {
+	const step = 40 * time.Millisecond
+	tests := []struct {
+		name string
+		err  error
+		// ожидаемая пауза перед каждым циклом: первый планируется при старте, следующие — после результата предыдущего
+		want []time.Duration
+	}{
+		{name: "ровный интервал", want: []time.Duration{step, step, step}},
+		// после каждой неудачи интервал удваивается до ZABBIX_BACKOFF_MAX
+		{name: "API недоступен", err: errZabbixUnreachable, want: []time.Duration{step, 2 * step, 4 * step, 4 * step}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := newTestConfig(t, nil)
+			cfg.CheckInterval = step
+			cfg.BackoffAfter = 1
+			cfg.BackoffFactor = 2
+			cfg.BackoffMax = 4 * step
+			ctx, stop := context.WithCancel(context.Background())
+			defer stop()
+			var starts, nexts []time.Time
+			cycle := func(context.Context) error {
+				starts = append(starts, time.Now())
+				nexts = append(nexts, cfg.schedule.Next())
+				if len(starts) == len(tt.want) {
+					stop()
+				}
+				return tt.err
+			}
+
+			runScheduled(ctx, cfg, cycle, testLogger(t))
+
+			if len(starts) != len(tt.want) {
+				t.Fatalf("выполнено циклов: %d", len(starts))
+			}
+			const tolerance = 20 * time.Millisecond
+			for i, want := range tt.want {
+				// сообщаемый следующий запуск — момент запуска плюс текущий интервал
+				if got := nexts[i].Sub(starts[i]); got < want-tolerance || got > want+tolerance {
+					t.Errorf("цикл %d: следующий запуск через %v, ожидалось %v", i+1, got, want)
+				}
+				// и цикл действительно запускается после такой паузы
+				if i > 0 {
+					if got := starts[i].Sub(starts[i-1]); got < want-tolerance || got > want+tolerance {
+						t.Errorf("цикл %d: запущен через %v после предыдущего, ожидалось %v", i+1, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestOnlyTransportErrorsAreUnreachable: ошибка JSON-RPC значит, что API ответил, — это обычная
+// ошибка цикла; недоступностью считаются только сетевые ошибки, таймауты и HTTP 5xx
+func TestOnlyTransportErrorsAreUnreachable(t *testing.T) {
+	tests := []struct {
+		name        string
+		serve       http.HandlerFunc
+		unreachable bool
+	}{
+		{name: "ошибка JSON-RPC", serve: func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				ID int `json:"id"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      req.ID,
+				"error":   fakeError{Code: -32500, Message: "Application error.", Data: json.RawMessage(`"No permissions to referred object or it does not exist!"`)},
+			})
+		}},
+		{name: "HTTP 502", serve: func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		}, unreachable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := httptest.NewServer(tt.serve)
+			defer zabbix.Close()
+			mm := newMattermostRecorder(t)
+			cfg, _ := newTestConfig(t, map[string]string{
+				"ZABBIX_API_URL":       zabbix.URL,
+				"MM_WEBHOOK_URL":       mm.URL,
+				"ZABBIX_BACKOFF_AFTER": "1",
+				"ZABBIX_MAX_RETRIES":   "0",
+			})
+			logger := testLogger(t)
+			w := newWatcher(cfg, newMemoryStore(), logger, &syslogRouter{})
+
+			err := runWatchers(context.Background(), cfg, []*watcher{w}, logger)
+			// ошибка цикла увеличивает интервал и завершает RUN_ONCE с ненулевым кодом
+			if tt.unreachable && !errors.Is(err, errZabbixUnreachable) {
+				t.Errorf("ошибка цикла %v, ожидалась недоступность API", err)
+			}
+			if !tt.unreachable && err != nil {
+				t.Errorf("ошибка цикла %v, ожидалось nil", err)
+			}
+			alerted := false
+			for _, text := range mm.Texts() {
+				if strings.HasPrefix(text, "API Zabbix недоступен") {
+					alerted = true
+				}
+			}
+			if alerted != tt.unreachable {
+				t.Errorf("уведомления %q, ожидалось уведомление о недоступности: %v", mm.Texts(), tt.unreachable)
+			}
+		})
+	}
+}
+
+func TestCycleScheduleServeHTTP(t *testing.T) {
+	s := newCycleSchedule(5 * time.Minute)
+	started := time.Now().Add(-10 * time.Second)
+	s.Started(started)
+	s.Finished(started.Add(4 * time.Second))
+	next := time.Now().Add(90 * time.Second)
+	s.Planned(next)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/schedule", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("код %d", rec.Code)
+	}
+	var got struct {
+		IntervalSeconds     float64   `json:"interval_seconds"`
+		NextRun             time.Time `json:"next_run"`
+		NextRunInSeconds    float64   `json:"next_run_in_seconds"`
+		LastDurationSeconds float64   `json:"last_duration_seconds"`
+		Running             bool      `json:"running"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.NextRun.Equal(next) || got.NextRunInSeconds != 90 {
+		t.Errorf("следующий запуск %v через %vс, ожидалось %v через 90с", got.NextRun, got.NextRunInSeconds, next)
+	}
+	if got.IntervalSeconds != 300 || got.LastDurationSeconds != 4 || got.Running {
+		t.Errorf("ответ %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/schedule", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: код %d", rec.Code)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Доставка уведомлений ----------------
+// Сообщение сначала отправляется сразу, с NOTIFY_RETRIES повторами и растущей паузой.
+// Не доставленное уходит в очередь (при NOTIFY_SPOOL_FILE она переживает перезапуск),
+// откуда каждый цикл повторяется по порядку с экспоненциальной паузой до NOTIFY_BACKOFF_MAX.
+// Прямые отправки и повторы из очереди идут через общий лимитер NOTIFY_RATE.
+
+const (
+	deliveryMattermost  = "mattermost"
+	deliveryCloudEvents = "cloudevents"
+	deliveryTelegram    = "telegram"
+	deliveryWebhook     = "webhook"
+)
+
+// errSpooled — сообщение не доставлено сразу и поставлено в очередь
+var errSpooled = errors.New("уведомление поставлено в очередь")
+
+// permanentError — отказ, который повтором не исправить (4xx кроме 429, ошибка в теле ответа)
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+type spoolItem struct {
+	Kind     string          `json:"kind"`
+	Event    string          `json:"event"`
+	Body     json.RawMessage `json:"body"`
+	Attempts int             `json:"attempts"`
+	Queued   time.Time       `json:"queued"`
+	NextAt   time.Time       `json:"next_at"`
+}
+
+type deliveryPipeline struct {
+	mu         sync.Mutex
+	flushMu    sync.Mutex // два Flush одновременно отправили бы одно сообщение дважды
+	file       string
+	items      []spoolItem
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	maxAge     time.Duration
+	limiter    *rateLimiter
+}
+
+func newDeliveryPipeline(file string, retries int, backoff, maxBackoff, maxAge time.Duration, rate float64) (*deliveryPipeline, error) {
+	p := &deliveryPipeline{
+		file:       file,
+		retries:    retries,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		maxAge:     maxAge,
+		limiter:    newRateLimiter(rate),
+	}
+	if file == "" {
+		return p, nil
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &p.items); err != nil {
+			return nil, fmt.Errorf("разбор %s: %v", file, err)
+		}
+	}
+	return p, nil
+}
+
+// delay — пауза перед повтором номер attempt (с нуля), не больше maxBackoff
+func (p *deliveryPipeline) delay(attempt int) time.Duration {
+	d := p.backoff
+	for i := 0; i < attempt && d < p.maxBackoff; i++ {
+		d *= 2
+	}
+	if p.maxBackoff > 0 && d > p.maxBackoff {
+		d = p.maxBackoff
+	}
+	return d
+}
+
+// Deliver отправляет тело в канал kind и возвращает ID поста, если канал его вернул.
+// Пока в очереди есть сообщения того же канала, новое встаёт за ними, чтобы не нарушать порядок.
+func (p *deliveryPipeline) Deliver(ctx context.Context, cfg *Config, kind, event string, body []byte, logger *logrus.Logger) (string, error) {
+	if p == nil {
+		return sendDelivery(ctx, cfg, kind, body)
+	}
+	if p.pending(kind) > 0 {
+		p.enqueue(cfg, kind, event, body, 0, logger)
+		return "", errSpooled
+	}
+
+	// при отмене ctx повторы прекращаются, а сообщение уходит в очередь
+	var lastErr error
+	attempts := 0
+	for attempts <= p.retries {
+		if _, err := p.limiter.Wait(ctx); err != nil {
+			lastErr = err
+			break
+		}
+		postID, err := sendDelivery(ctx, cfg, kind, body)
+		attempts++
+		if err == nil {
+			return postID, nil
+		}
+		var perm permanentError
+		if errors.As(err, &perm) {
+			return "", err
+		}
+		lastErr = err
+		if attempts <= p.retries {
+			d := p.delay(attempts - 1)
+			logger.WithError(err).WithFields(logrus.Fields{"channel": kind, "event": event, "attempt": attempts, "delay": d.String()}).Warn("Уведомление не доставлено, повтор")
+			if sleepContext(ctx, d) != nil {
+				break
+			}
+		}
+	}
+	logger.WithError(lastErr).WithFields(logrus.Fields{"channel": kind, "event": event}).Warn("Уведомление не доставлено, ставим в очередь")
+	p.enqueue(cfg, kind, event, body, attempts, logger)
+	return "", errSpooled
+}
+
+func (p *deliveryPipeline) pending(kind string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, it := range p.items {
+		if it.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *deliveryPipeline) enqueue(cfg *Config, kind, event string, body []byte, attempts int, logger *logrus.Logger) {
+	now := cfg.Clock.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.items = append(p.items, spoolItem{Kind: kind, Event: event, Body: body, Attempts: attempts, Queued: now, NextAt: now})
+	p.save(logger)
+}
+
+// Flush повторяет отправку сообщений из очереди, у которых подошло время. По каждому каналу
+// сообщения идут строго по порядку: после первой неудачи канал ждёт следующего цикла.
+// Отправка идёт без p.mu, чтобы Deliver и enqueue не ждали сети и лимитера; пока сообщение
+// не доставлено, оно остаётся в очереди, и новые сообщения канала встают за ним.
+func (p *deliveryPipeline) Flush(ctx context.Context, cfg *Config, logger *logrus.Logger) {
+	if p == nil {
+		return
+	}
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+	p.mu.Lock()
+	items := append([]spoolItem(nil), p.items...)
+	p.mu.Unlock()
+	if len(items) == 0 {
+		return
+	}
+
+	now := cfg.Clock.Now()
+	blocked := map[string]bool{}
+	kept := make([]spoolItem, 0, len(items))
+	changed := false
+	for _, it := range items {
+		if blocked[it.Kind] || ctx.Err() != nil {
+			kept = append(kept, it)
+			continue
+		}
+		fields := logrus.Fields{"channel": it.Kind, "event": it.Event, "attempts": it.Attempts}
+		if p.maxAge > 0 && now.Sub(it.Queued) > p.maxAge {
+			logger.WithFields(fields).Errorf("Уведомление удалено из очереди: не доставлено за %v", p.maxAge)
+			changed = true
+			continue
+		}
+		if it.NextAt.After(now) {
+			blocked[it.Kind] = true
+			kept = append(kept, it)
+			continue
+		}
+
+		if _, err := p.limiter.Wait(ctx); err != nil {
+			kept = append(kept, it)
+			continue
+		}
+		_, err := sendDelivery(ctx, cfg, it.Kind, it.Body)
+		changed = true
+		var perm permanentError
+		switch {
+		case err == nil:
+			logger.WithFields(fields).Info("Уведомление из очереди доставлено")
+		case errors.As(err, &perm):
+			logger.WithError(err).WithFields(fields).Error("Уведомление из очереди отклонено получателем и удалено")
+		default:
+			blocked[it.Kind] = true
+			it.NextAt = now.Add(p.delay(it.Attempts))
+			it.Attempts++
+			logger.WithError(err).WithFields(fields).Warnf("Уведомление из очереди не доставлено, следующая попытка в %s", it.NextAt.Format(time.RFC3339))
+			kept = append(kept, it)
+		}
+	}
+	if !changed {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// пока шла отправка, enqueue мог только дописать сообщения в конец очереди
+	p.items = append(kept, p.items[len(items):]...)
+	p.save(logger)
+}
+
+// save записывает очередь на диск; вызывается под мьютексом
+func (p *deliveryPipeline) save(logger *logrus.Logger) {
+	if p.file == "" {
+		return
+	}
+	data, err := json.MarshalIndent(p.items, "", "  ")
+	if err != nil {
+		logger.WithError(err).Error("Ошибка сериализации очереди уведомлений")
+		return
+	}
+	tmp, err := writeTempFile(p.file, data)
+	if err == nil {
+		if err = os.Rename(tmp, p.file); err != nil {
+			_ = os.Remove(tmp)
+		} else {
+			err = syncDir(filepath.Dir(p.file))
+		}
+	}
+	if err != nil {
+		logger.WithError(err).Errorf("Ошибка сохранения очереди уведомлений в %s", p.file)
+	}
+}
+
+// sendDelivery — одна попытка отправки в канал без повторов
+func sendDelivery(ctx context.Context, cfg *Config, kind string, body []byte) (string, error) {
+	var (
+		resp *http.Response
+		err  error
+	)
+	switch kind {
+	case deliveryMattermost:
+		resp, err = postJSON(ctx, cfg, mattermostWebhook(cfg), body)
+	case deliveryCloudEvents:
+		resp, err = postWebhook(ctx, cfg, cfg.CloudEventsURL, cloudEventsContentType, cfg.CloudEventsSecret, body)
+	case deliveryTelegram:
+		// у Bot API свой формат ответа и ошибок
+		return "", sendTelegram(ctx, cfg, body)
+	case deliveryWebhook:
+		resp, err = postWebhook(ctx, cfg, cfg.WebhookURL, cfg.WebhookContentType, cfg.WebhookSecret, body)
+	default:
+		return "", permanentError{fmt.Errorf("неизвестный канал доставки %q", kind)}
+	}
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(respBody))
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return "", permanentError{err}
+		}
+		return "", err
+	}
+	if kind != deliveryMattermost {
+		return "", nil
+	}
+	postID, err := parseMattermostResponse(respBody)
+	if err != nil {
+		return "", permanentError{err}
+	}
+	return postID, nil
+}
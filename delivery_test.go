@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyReceiver отвечает заданными кодами по очереди (дальше — 200) и запоминает принятые тела
+type flakyReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	codes    []int
+	attempts []time.Time
+	received []string
+}
+
+func newFlakyReceiver(t *testing.T, codes ...int) *flakyReceiver {
+	t.Helper()
+	f := &flakyReceiver{codes: codes}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.attempts = append(f.attempts, time.Now())
+		if len(f.codes) > 0 {
+			code := f.codes[0]
+			f.codes = f.codes[1:]
+			if code != http.StatusOK {
+				w.WriteHeader(code)
+				return
+			}
+		}
+		f.received = append(f.received, string(body))
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *flakyReceiver) Stats() (attempts int, received []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.attempts), append([]string(nil), f.received...)
+}
+
+// newDeliveryTestConfig — конфигурация с CLOUDEVENTS_URL на приёмник и короткими паузами повторов
+func newDeliveryTestConfig(t *testing.T, url string, env map[string]string) (*Config, *fakeClock) {
+	t.Helper()
+	all := map[string]string{"CLOUDEVENTS_URL": url, "NOTIFY_RETRIES": "1", "NOTIFY_SPOOL_FILE": "spool.json"}
+	for k, v := range env {
+		all[k] = v
+	}
+	cfg, clock := newTestConfig(t, all)
+	// паузы в секундах из окружения для теста слишком длинные
+	cfg.delivery.backoff = 10 * time.Millisecond
+	cfg.delivery.maxBackoff = 40 * time.Millisecond
+	return cfg, clock
+}
+
+func TestDeliveryInlineRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		codes        []int
+		wantErr      error // errSpooled или nil; отказ получателя проверяется по wantPerm
+		wantPerm     bool
+		wantAttempts int
+		wantSpooled  int
+	}{
+		{name: "сразу", wantAttempts: 1},
+		{name: "со второй попытки", codes: []int{http.StatusBadGateway}, wantAttempts: 2},
+		{name: "все попытки неудачны", codes: []int{http.StatusBadGateway, http.StatusServiceUnavailable}, wantErr: errSpooled, wantAttempts: 2, wantSpooled: 1},
+		{name: "429 повторяется", codes: []int{http.StatusTooManyRequests, http.StatusTooManyRequests}, wantErr: errSpooled, wantAttempts: 2, wantSpooled: 1},
+		// отказ получателя повтором не исправить — ни повторов, ни очереди
+		{name: "отказ 400", codes: []int{http.StatusBadRequest}, wantPerm: true, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recv := newFlakyReceiver(t, tt.codes...)
+			cfg, _ := newDeliveryTestConfig(t, recv.URL, nil)
+			_, err := cfg.delivery.Deliver(context.Background(), cfg, deliveryCloudEvents, EventMediaDisabled, []byte(`{"n":1}`), testLogger(t))
+			switch {
+			case tt.wantPerm:
+				if _, ok := err.(permanentError); !ok {
+					t.Errorf("ошибка %v, ожидался отказ получателя", err)
+				}
+			case err != tt.wantErr:
+				t.Errorf("ошибка %v, ожидалась %v", err, tt.wantErr)
+			}
+			if attempts, _ := recv.Stats(); attempts != tt.wantAttempts {
+				t.Errorf("попыток %d, ожидалось %d", attempts, tt.wantAttempts)
+			}
+			if n := cfg.delivery.pending(deliveryCloudEvents); n != tt.wantSpooled {
+				t.Errorf("в очереди %d, ожидалось %d", n, tt.wantSpooled)
+			}
+		})
+	}
+}
+
+func TestDeliverySpoolThenPacedRetry(t *testing.T) {
+	// обе попытки первого сообщения и первый повтор из очереди неудачны
+	recv := newFlakyReceiver(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+	cfg, clock := newDeliveryTestConfig(t, recv.URL, nil)
+	logger := testLogger(t)
+
+	if _, err := cfg.delivery.Deliver(context.Background(), cfg, deliveryCloudEvents, EventMediaDisabled, []byte(`{"n":1}`), logger); err != errSpooled {
+		t.Fatalf("первое сообщение: %v", err)
+	}
+	// пока очередь канала не пуста, новое сообщение встаёт за ней без попытки отправки
+	if _, err := cfg.delivery.Deliver(context.Background(), cfg, deliveryCloudEvents, EventMediaAutoEnabled, []byte(`{"n":2}`), logger); err != errSpooled {
+		t.Fatalf("второе сообщение: %v", err)
+	}
+	if attempts, _ := recv.Stats(); attempts != 2 {
+		t.Fatalf("попыток %d, ожидалось 2", attempts)
+	}
+
+	// очередь переживает перезапуск
+	restored, err := newDeliveryPipeline("spool.json", 1, time.Second, time.Minute, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := restored.pending(deliveryCloudEvents); n != 2 {
+		t.Fatalf("после перезапуска в очереди %d, ожидалось 2", n)
+	}
+
+	steps := []struct {
+		name         string
+		advance      time.Duration
+		wantAttempts int // новых попыток за шаг
+		wantReceived []string
+		wantPending  int
+	}{
+		// повтор неудачен — канал ждёт паузы, второе сообщение не обгоняет первое
+		{name: "первый повтор", wantAttempts: 1, wantPending: 2},
+		{name: "пауза не прошла", advance: 10 * time.Millisecond, wantPending: 2},
+		// пауза растёт с числом прошлых попыток: backoff·2², но не больше maxBackoff
+		{name: "после паузы", advance: 40 * time.Millisecond, wantAttempts: 2, wantReceived: []string{`{"n":1}`, `{"n":2}`}},
+		{name: "очередь пуста", advance: time.Hour, wantReceived: []string{`{"n":1}`, `{"n":2}`}},
+	}
+	attempts := 2
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		cfg.delivery.Flush(context.Background(), cfg, logger)
+		got, received := recv.Stats()
+		if got-attempts != step.wantAttempts {
+			t.Errorf("%s: попыток %d, ожидалось %d", step.name, got-attempts, step.wantAttempts)
+		}
+		attempts = got
+		if !reflect.DeepEqual(received, step.wantReceived) {
+			t.Errorf("%s: доставлено %v, ожидалось %v", step.name, received, step.wantReceived)
+		}
+		if n := cfg.delivery.pending(deliveryCloudEvents); n != step.wantPending {
+			t.Errorf("%s: в очереди %d, ожидалось %d", step.name, n, step.wantPending)
+		}
+	}
+
+	// доставленное удалено и из файла очереди
+	restored, err = newDeliveryPipeline("spool.json", 1, time.Second, time.Minute, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := restored.pending(deliveryCloudEvents); n != 0 {
+		t.Errorf("в файле очереди осталось %d", n)
+	}
+}
+
+func TestDeliverySpoolMaxAge(t *testing.T) {
+	recv := newFlakyReceiver(t, http.StatusBadGateway, http.StatusBadGateway)
+	cfg, clock := newDeliveryTestConfig(t, recv.URL, map[string]string{"NOTIFY_SPOOL_MAX_AGE": "1"})
+	logger := testLogger(t)
+	if _, err := cfg.delivery.Deliver(context.Background(), cfg, deliveryCloudEvents, EventMediaDisabled, []byte(`{"n":1}`), logger); err != errSpooled {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour + time.Minute)
+	cfg.delivery.Flush(context.Background(), cfg, logger)
+	if attempts, received := recv.Stats(); attempts != 2 || len(received) != 0 {
+		t.Errorf("попыток %d, доставлено %v — устаревшее сообщение не должно отправляться", attempts, received)
+	}
+	if n := cfg.delivery.pending(deliveryCloudEvents); n != 0 {
+		t.Errorf("в очереди осталось %d", n)
+	}
+}
+
+func TestDeliverySharedRateLimiter(t *testing.T) {
+	const rate = 20 // не чаще раза в 50ms
+	recv := newFlakyReceiver(t, http.StatusBadGateway, http.StatusBadGateway)
+	cfg, _ := newDeliveryTestConfig(t, recv.URL, map[string]string{"NOTIFY_RATE": "20"})
+	cfg.delivery.backoff = time.Millisecond
+	cfg.delivery.maxBackoff = time.Millisecond
+	logger := testLogger(t)
+
+	// две неудачные попытки в строке, затем повтор из очереди и прямая отправка
+	if _, err := cfg.delivery.Deliver(context.Background(), cfg, deliveryCloudEvents, EventMediaDisabled, []byte(`{"n":1}`), logger); err != errSpooled {
+		t.Fatal(err)
+	}
+	cfg.delivery.Flush(context.Background(), cfg, logger)
+	if _, err := cfg.delivery.Deliver(context.Background(), cfg, deliveryCloudEvents, EventMediaAutoEnabled, []byte(`{"n":2}`), logger); err != nil {
+		t.Fatal(err)
+	}
+
+	recv.mu.Lock()
+	times := append([]time.Time(nil), recv.attempts...)
+	recv.mu.Unlock()
+	if len(times) != 4 {
+		t.Fatalf("попыток %d, ожидалось 4", len(times))
+	}
+	// лимитер общий для попыток в строке и повторов из очереди: попытка i — не раньше i интервалов от первой
+	for i := 1; i < len(times); i++ {
+		if elapsed, min := times[i].Sub(times[0]), time.Duration(i)*time.Second/rate; elapsed < min-5*time.Millisecond {
+			t.Errorf("попытка %d через %v после первой, ожидалось не меньше %v", i+1, elapsed, min)
+		}
+	}
+}
+
+func TestDeliveryRetryStopsOnCancel(t *testing.T) {
+	recv := newFlakyReceiver(t, http.StatusBadGateway, http.StatusBadGateway)
+	cfg, _ := newDeliveryTestConfig(t, recv.URL, nil)
+	cfg.delivery.backoff = time.Minute
+	cfg.delivery.maxBackoff = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := cfg.delivery.Deliver(ctx, cfg, deliveryCloudEvents, EventMediaDisabled, []byte(`{"n":1}`), testLogger(t)); err != errSpooled {
+		t.Fatalf("ошибка %v, ожидалась постановка в очередь", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("пауза перед повтором не прервана отменой: %v", elapsed)
+	}
+	if attempts, _ := recv.Stats(); attempts != 1 {
+		t.Errorf("попыток %d, ожидалась 1", attempts)
+	}
+	if n := cfg.delivery.pending(deliveryCloudEvents); n != 1 {
+		t.Errorf("в очереди %d, ожидалось 1", n)
+	}
+}
+
+// TestDeliveryFlushDoesNotBlockDeliver: пока Flush ждёт ответа получателя, новое сообщение
+// встаёт в очередь сразу, а не после окончания отправки
+func TestDeliveryFlushDoesNotBlockDeliver(t *testing.T) {
+	release := make(chan struct{})
+	sending := make(chan struct{}, 1)
+	var mu sync.Mutex
+	var received []string
+	recv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		select {
+		case sending <- struct{}{}:
+			<-release
+		default:
+		}
+		mu.Lock()
+		received = append(received, string(body))
+		mu.Unlock()
+	}))
+	defer recv.Close()
+	cfg, _ := newDeliveryTestConfig(t, recv.URL, nil)
+	logger := testLogger(t)
+	cfg.delivery.enqueue(cfg, deliveryCloudEvents, EventMediaDisabled, []byte(`{"n":1}`), 1, logger)
+
+	flushed := make(chan struct{})
+	go func() {
+		cfg.delivery.Flush(context.Background(), cfg, logger)
+		close(flushed)
+	}()
+	<-sending
+
+	delivered := make(chan error, 1)
+	go func() {
+		_, err := cfg.delivery.Deliver(context.Background(), cfg, deliveryCloudEvents, EventMediaAutoEnabled, []byte(`{"n":2}`), logger)
+		delivered <- err
+	}()
+	select {
+	case err := <-delivered:
+		// первое сообщение ещё не доставлено — второе встаёт за ним
+		if err != errSpooled {
+			t.Errorf("ошибка %v, ожидалась постановка в очередь", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Deliver ждал окончания Flush")
+	}
+	close(release)
+	<-flushed
+
+	// доставленное сообщение удалено, добавленное во время Flush осталось в очереди
+	if n := cfg.delivery.pending(deliveryCloudEvents); n != 1 {
+		t.Fatalf("в очереди %d, ожидалось 1", n)
+	}
+	cfg.delivery.Flush(context.Background(), cfg, logger)
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{`{"n":1}`, `{"n":2}`}; !reflect.DeepEqual(received, want) {
+		t.Errorf("доставлено %v, ожидалось %v", received, want)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/syslog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Желаемое состояние медиа (DESIRED_STATE_FILE) ----------------
+// Файл — простой YAML-словарь «имя медиа: enabled|disabled»:
+//
+//	# рабочие каналы
+//	Email: enabled
+//	"SMS (резерв)": disabled
+//
+// Медиа из файла приводятся к объявленному состоянию каждый цикл и в эвристике
+// «отключено слишком долго» не участвуют. Выключение включённых медиа — только при DESIRED_STATE_DISABLE.
+// Файл перечитывается при изменении, перезапуск не нужен.
+
+type desiredState struct {
+	file  string
+	mtime time.Time
+	want  map[string]bool // имя -> должно быть включено
+	// медиа, о расхождении которых уже сообщили (чтобы не повторять каждый цикл)
+	drift map[string]bool
+}
+
+func newDesiredState(file string) (*desiredState, error) {
+	d := &desiredState{file: file, drift: map[string]bool{}}
+	if _, err := d.reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// reload перечитывает файл, если он изменился; возвращает true, если содержимое обновлено
+func (d *desiredState) reload() (bool, error) {
+	info, err := os.Stat(d.file)
+	if err != nil {
+		return false, err
+	}
+	if d.want != nil && info.ModTime().Equal(d.mtime) {
+		return false, nil
+	}
+	data, err := os.ReadFile(d.file)
+	if err != nil {
+		return false, err
+	}
+	want, err := parseDesiredState(data)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", d.file, err)
+	}
+	d.want = want
+	d.mtime = info.ModTime()
+	return true, nil
+}
+
+// parseDesiredState разбирает плоский YAML-словарь; вложенные структуры не поддерживаются
+func parseDesiredState(data []byte) (map[string]bool, error) {
+	want := map[string]bool{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		name, value, ok := cutYAMLKey(line)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("строка %d: ожидается «имя: enabled|disabled»", n)
+		}
+		if _, dup := want[name]; dup {
+			return nil, fmt.Errorf("строка %d: медиа %s указано повторно", n, name)
+		}
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		switch strings.ToLower(strings.Trim(strings.TrimSpace(value), `"'`)) {
+		case "enabled", "enable", "on", "true":
+			want[name] = true
+		case "disabled", "disable", "off", "false":
+			want[name] = false
+		default:
+			return nil, fmt.Errorf("строка %d: неверное состояние %q для %s", n, value, name)
+		}
+	}
+	return want, sc.Err()
+}
+
+// cutYAMLKey отделяет ключ (возможно, в кавычках) от значения
+func cutYAMLKey(line string) (key, value string, ok bool) {
+	if q := line[0]; q == '"' || q == '\'' {
+		end := strings.IndexByte(line[1:], q)
+		if end < 0 {
+			return "", "", false
+		}
+		key = line[1 : end+1]
+		rest := strings.TrimSpace(line[end+2:])
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		return key, rest[1:], true
+	}
+	key, value, ok = strings.Cut(line, ":")
+	return strings.TrimSpace(key), value, ok
+}
+
+// Manages сообщает, управляется ли медиа файлом желаемого состояния
+func (d *desiredState) Manages(name string) bool {
+	if d == nil {
+		return false
+	}
+	_, ok := d.want[name]
+	return ok
+}
+
+func (d *desiredState) names() []string {
+	names := make([]string, 0, len(d.want))
+	for n := range d.want {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reconcile приводит медиа из файла к объявленному состоянию
+func (d *desiredState) Reconcile(ctx context.Context, cfg *Config, logger *logrus.Logger, sysLogger *syslog.Writer) {
+	if d == nil {
+		return
+	}
+	if changed, err := d.reload(); err != nil {
+		logger.WithError(err).Error("Ошибка чтения файла желаемого состояния, используется прежний")
+	} else if changed {
+		logger.WithField("media", d.names()).Info("Файл желаемого состояния перечитан")
+	}
+	if len(d.want) == 0 {
+		return
+	}
+
+	mediaTypes, err := getMediaTypesByName(ctx, cfg, d.names(), logger)
+	if err != nil {
+		logger.Errorf("Ошибка получения медиа для сверки с желаемым состоянием: %v", err)
+		return
+	}
+	found := map[string]bool{}
+	now := cfg.Clock.Now()
+	for _, media := range mediaTypes {
+		found[media.Name] = true
+		wantEnabled := d.want[media.Name]
+		enabled := media.Status == "0"
+		logEntry := logger.WithFields(logrus.Fields{"media_id": media.MediaTypeID, "media_name": media.Name, "desired_enabled": wantEnabled})
+		if enabled == wantEnabled {
+			if d.drift[media.Name] {
+				delete(d.drift, media.Name)
+				logEntry.Info("Медиа вернулось к желаемому состоянию")
+			}
+			continue
+		}
+
+		if !wantEnabled && !cfg.DesiredStateDisable {
+			if !d.drift[media.Name] {
+				d.drift[media.Name] = true
+				msg := fmt.Sprintf("Медиа %s включено, хотя по %s должно быть выключено (выключение не разрешено DESIRED_STATE_DISABLE)", media.Name, d.file)
+				logEntry.Warn(msg)
+				notify(ctx, cfg, Event{Type: EventMediaDrift, MediaID: media.MediaTypeID, MediaName: media.Name, Channel: cfg.policyFor(media.Name).Channel, Message: msg}, logger)
+			}
+			continue
+		}
+
+		action := "выключено"
+		if wantEnabled {
+			action = "включено"
+		}
+		if cfg.DryRun {
+			// медиа в пробном режиме не меняется — расхождение сообщается один раз, а не каждый цикл
+			if !d.drift[media.Name] {
+				d.drift[media.Name] = true
+				logEntry.Infof("[DRY-RUN] Медиа %s было бы %s согласно желаемому состоянию (%s)", media.Name, action, d.file)
+			}
+			continue
+		}
+		if wantEnabled {
+			err = enableMediaType(ctx, cfg, media, now, logger)
+		} else {
+			err = disableMediaType(ctx, cfg, media.MediaTypeID, logger)
+		}
+		if err != nil {
+			logEntry.WithError(err).Error("Не удалось привести медиа к желаемому состоянию")
+			notify(ctx, cfg, Event{
+				Type:      EventMediaReconcileFailed,
+				MediaID:   media.MediaTypeID,
+				MediaName: media.Name,
+				Channel:   cfg.policyFor(media.Name).Channel,
+				Error:     err.Error(),
+				Message:   fmt.Sprintf("Не удалось привести медиа %s к желаемому состоянию: %v", media.Name, err),
+			}, logger)
+			continue
+		}
+		delete(d.drift, media.Name)
+		msg := fmt.Sprintf("Медиа %s %s согласно желаемому состоянию (%s)", media.Name, action, d.file)
+		logEntry.Warn(msg)
+		if sysLogger != nil {
+			_ = sysLogger.Warning(msg)
+		}
+		notify(ctx, cfg, Event{Type: EventMediaReconciled, MediaID: media.MediaTypeID, MediaName: media.Name, Channel: cfg.policyFor(media.Name).Channel, Message: msg}, logger)
+	}
+	for _, name := range d.names() {
+		if !found[name] {
+			logger.WithField("media_name", name).Warn("Медиа из файла желаемого состояния не найдено в Zabbix")
+		}
+	}
+}
+
+// getMediaTypesByName получает медиа по списку имён независимо от MEDIA_NAMES и MEDIA_WATCH_TAG
+func getMediaTypesByName(ctx context.Context, cfg *Config, names []string, logger *logrus.Logger) ([]MediaType, error) {
+	output := []string{"mediatypeid", "name", "status"}
+	if cfg.AnnotateEnable {
+		output = append(output, "description")
+	}
+	req := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "mediatype.get",
+		Params:  MediaTypeGetParams{Output: output, Filter: &MediaTypeFilter{Name: names}},
+		Auth:    zabbixSession(cfg),
+		ID:      4,
+	}
+	var result []MediaType
+	err := callZabbix(ctx, cfg, req, &result, logger)
+	return result, err
+}
+
+func disableMediaType(ctx context.Context, cfg *Config, mediaTypeID string, logger *logrus.Logger) error {
+	if cfg.Simulate {
+		logger.Infof("[SIMULATE] mediatype.update (выключение) для %s не отправлен", mediaTypeID)
+		return nil
+	}
+	if cfg.DryRun {
+		logger.Infof("[DRY-RUN] Медиа %s было бы выключено", mediaTypeID)
+		return nil
+	}
+	req := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "mediatype.update",
+		Params:  MediaTypeUpdateParams{MediaTypeID: mediaTypeID, Status: mediaStatusDisabled},
+		Auth:    zabbixSession(cfg),
+		ID:      2,
+	}
+	var result struct {
+		MediaTypeIDs []string `json:"mediatypeids"`
+	}
+	if err := callZabbix(ctx, cfg, req, &result, logger); err != nil {
+		return err
+	}
+	// как и при включении: без id медиа в ответе update ничего не изменил
+	for _, id := range result.MediaTypeIDs {
+		if id == mediaTypeID {
+			return nil
+		}
+	}
+	return fmt.Errorf("mediatype.update завершился успешно, но не выключил медиа %s: mediatypeids=%v", mediaTypeID, result.MediaTypeIDs)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDesiredState(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]bool
+		wantErr string
+	}{
+		{
+			name: "словарь с комментариями",
+			data: "---\n# рабочие каналы\nEmail: enabled\n\n\"SMS (резерв)\": disabled # до замены модема\n'Fax: old': off\nTelegram: \"on\"\n",
+			want: map[string]bool{"Email": true, "SMS (резерв)": false, "Fax: old": false, "Telegram": true},
+		},
+		{name: "синонимы", data: "A: enable\nB: TRUE\nC: disable\nD: false", want: map[string]bool{"A": true, "B": true, "C": false, "D": false}},
+		{name: "пустой файл", data: "# пусто\n", want: map[string]bool{}},
+		{name: "без двоеточия", data: "Email enabled", wantErr: "строка 1"},
+		{name: "без имени", data: ": enabled", wantErr: "строка 1"},
+		{name: "повтор", data: "Email: enabled\nEmail: disabled", wantErr: "строка 2: медиа Email указано повторно"},
+		{name: "неверное состояние", data: "Email: maybe", wantErr: "неверное состояние"},
+		{name: "незакрытая кавычка", data: "\"Email: enabled", wantErr: "строка 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDesiredState([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("состояние %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func writeDesiredState(t *testing.T, data string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "desired.yaml")
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// desiredEvents переводит сообщения сверки в "<тип события>:<медиа>"
+func desiredEvents(texts []string) []string {
+	var out []string
+	for _, text := range texts {
+		var ev string
+		switch {
+		case strings.Contains(text, "согласно желаемому состоянию"):
+			ev = EventMediaReconciled
+		case strings.Contains(text, "хотя по"):
+			ev = EventMediaDrift
+		case strings.HasPrefix(text, "Не удалось привести"):
+			ev = EventMediaReconcileFailed
+		default:
+			ev = "?"
+		}
+		for _, name := range []string{"Email", "SMS", "Fax"} {
+			if strings.Contains(text, "медиа "+name+" ") || strings.HasPrefix(text, "Медиа "+name+" ") {
+				ev += ":" + name
+			}
+		}
+		out = append(out, ev)
+	}
+	return out
+}
+
+func TestDesiredStateReconcile(t *testing.T) {
+	media := func() []MediaType {
+		return []MediaType{
+			{MediaTypeID: "1", Name: "Email", Status: "1"},
+			{MediaTypeID: "2", Name: "SMS", Status: "0"},
+			{MediaTypeID: "3", Name: "Fax", Status: "1"},
+		}
+	}
+	tests := []struct {
+		name       string
+		desired    string
+		disable    bool
+		dryRun     bool
+		failUpdate bool
+		// mediatype.update отвечает без ошибки, но с пустым mediatypeids
+		emptyUpdate bool
+		wantEvents  []string // "<тип>:<медиа>"
+		wantLive    map[string]string
+	}{
+		{
+			name:       "включает выключенное",
+			desired:    "Email: enabled",
+			wantEvents: []string{EventMediaReconciled + ":Email"},
+			wantLive:   map[string]string{"Email": "0", "SMS": "0", "Fax": "1"},
+		},
+		{
+			name:       "без DESIRED_STATE_DISABLE только сообщает",
+			desired:    "SMS: disabled",
+			wantEvents: []string{EventMediaDrift + ":SMS"},
+			wantLive:   map[string]string{"Email": "1", "SMS": "0", "Fax": "1"},
+		},
+		{
+			name:       "с DESIRED_STATE_DISABLE выключает",
+			desired:    "Email: enabled\nSMS: disabled\nFax: disabled",
+			disable:    true,
+			wantEvents: []string{EventMediaReconciled + ":Email", EventMediaReconciled + ":SMS"},
+			wantLive:   map[string]string{"Email": "0", "SMS": "1", "Fax": "1"},
+		},
+		{
+			name:       "ошибка Zabbix",
+			desired:    "Email: enabled",
+			failUpdate: true,
+			wantEvents: []string{EventMediaReconcileFailed + ":Email"},
+			wantLive:   map[string]string{"Email": "1", "SMS": "0", "Fax": "1"},
+		},
+		{
+			name:        "выключение не подтверждено",
+			desired:     "SMS: disabled",
+			disable:     true,
+			emptyUpdate: true,
+			wantEvents:  []string{EventMediaReconcileFailed + ":SMS"},
+			wantLive:    map[string]string{"Email": "1", "SMS": "0", "Fax": "1"},
+		},
+		{
+			// пробный режим ничего не меняет и не шлёт «включено согласно желаемому состоянию»
+			name:     "DRY_RUN",
+			desired:  "Email: enabled\nSMS: disabled",
+			disable:  true,
+			dryRun:   true,
+			wantLive: map[string]string{"Email": "1", "SMS": "0", "Fax": "1"},
+		},
+		{
+			name:     "медиа нет в Zabbix",
+			desired:  "Telegram: enabled",
+			wantLive: map[string]string{"Email": "1", "SMS": "0", "Fax": "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			live := serveLiveMedia(zabbix, media()...)
+			if tt.failUpdate {
+				zabbix.Handle("mediatype.update", func(json.RawMessage) (interface{}, *fakeError) {
+					return nil, &fakeError{Code: -32500, Message: "Application error.", Data: json.RawMessage(`"No permissions to referred object or it does not exist!"`)}
+				})
+			}
+			if tt.emptyUpdate {
+				zabbix.Result("mediatype.update", map[string][]string{"mediatypeids": {}})
+			}
+			mm := newMattermostRecorder(t)
+			env := map[string]string{
+				"ZABBIX_API_URL":     zabbix.URL,
+				"MM_WEBHOOK_URL":     mm.URL,
+				"DESIRED_STATE_FILE": writeDesiredState(t, tt.desired),
+			}
+			if tt.disable {
+				env["DESIRED_STATE_DISABLE"] = "true"
+			}
+			if tt.dryRun {
+				env["DRY_RUN"] = "true"
+			}
+			cfg, _ := newTestConfig(t, env)
+			logger := testLogger(t)
+
+			cfg.desired.Reconcile(context.Background(), cfg, logger, nil)
+			if got := desiredEvents(mm.Texts()); !reflect.DeepEqual(got, tt.wantEvents) {
+				t.Errorf("события %v, ожидалось %v", got, tt.wantEvents)
+			}
+			if statuses := live.Statuses(); !reflect.DeepEqual(statuses, tt.wantLive) {
+				t.Errorf("состояние в Zabbix %v, ожидалось %v", statuses, tt.wantLive)
+			}
+			if tt.failUpdate || tt.emptyUpdate {
+				return
+			}
+
+			// после сверки состояние сходится: повторный цикл ничего не меняет и не сообщает
+			updates := len(zabbix.Calls("mediatype.update"))
+			cfg.desired.Reconcile(context.Background(), cfg, logger, nil)
+			if got := mm.Texts(); got != nil {
+				t.Errorf("повторная сверка: сообщения %q", got)
+			}
+			if n := len(zabbix.Calls("mediatype.update")); n != updates {
+				t.Errorf("повторная сверка: mediatype.update вызван ещё %d раз", n-updates)
+			}
+		})
+	}
+}
+
+func TestDesiredStateDriftAfterManualChange(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	live := serveLiveMedia(zabbix, MediaType{MediaTypeID: "1", Name: "Email", Status: "0"})
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{
+		"ZABBIX_API_URL":     zabbix.URL,
+		"MM_WEBHOOK_URL":     mm.URL,
+		"DESIRED_STATE_FILE": writeDesiredState(t, "Email: enabled"),
+	})
+	logger := testLogger(t)
+
+	steps := []struct {
+		name   string
+		manual string // status, выставленный вручную перед циклом
+		want   []string
+	}{
+		{name: "совпадает", want: nil},
+		{name: "выключили вручную", manual: "1", want: []string{EventMediaReconciled + ":Email"}},
+		{name: "снова совпадает", want: nil},
+	}
+	for _, step := range steps {
+		if step.manual != "" {
+			live.Set("1", step.manual)
+		}
+		clock.Advance(time.Minute)
+		cfg.desired.Reconcile(context.Background(), cfg, logger, nil)
+		if got := desiredEvents(mm.Texts()); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: события %v, ожидалось %v", step.name, got, step.want)
+		}
+		if s := live.Statuses()["Email"]; s != "0" {
+			t.Errorf("%s: Email в состоянии %s", step.name, s)
+		}
+	}
+
+	// медиа из файла не участвует в эвристике «отключено слишком долго»
+	live.Set("1", "1")
+	updates := len(zabbix.Calls("mediatype.update"))
+	state := MediaState{"1": {FirstSeen: clock.Now().Add(-24 * time.Hour)}}
+	handleMediaTypes(context.Background(), cfg, []MediaType{{MediaTypeID: "1", Name: "Email", Status: "1"}}, newMemoryStore(), state, make(EnableFailures), newCycleCommit(), logger, nil)
+	if n := len(zabbix.Calls("mediatype.update")); n != updates {
+		t.Errorf("управляемое медиа включено эвристикой: mediatype.update вызван ещё %d раз", n-updates)
+	}
+	if len(state) != 0 {
+		t.Errorf("управляемое медиа осталось в состоянии: %v", state)
+	}
+	if got := mm.Texts(); got != nil {
+		t.Errorf("по управляемому медиа отправлено %q", got)
+	}
+}
+
+func TestDesiredStateReload(t *testing.T) {
+	file := writeDesiredState(t, "Email: enabled")
+	d, err := newDesiredState(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := d.reload(); changed || err != nil {
+		t.Errorf("без изменений файла: %v, %v", changed, err)
+	}
+
+	mtime := time.Now().Add(time.Minute)
+	rewrite := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime = mtime.Add(time.Minute)
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rewrite("Email: disabled\nSMS: enabled")
+	if changed, err := d.reload(); !changed || err != nil {
+		t.Fatalf("после изменения файла: %v, %v", changed, err)
+	}
+	if want := map[string]bool{"Email": false, "SMS": true}; !reflect.DeepEqual(d.want, want) {
+		t.Errorf("состояние %v, ожидалось %v", d.want, want)
+	}
+
+	// ошибка в файле не сбрасывает прежнее состояние
+	rewrite("Email: maybe")
+	if _, err := d.reload(); err == nil {
+		t.Fatal("ошибка разбора не возвращена")
+	}
+	if !d.Manages("SMS") || d.Manages("Fax") {
+		t.Errorf("после ошибки состояние %v", d.want)
+	}
+}
+
+func TestDesiredStateFileConfigError(t *testing.T) {
+	_, err := loadTestConfig(t, map[string]string{"DESIRED_STATE_FILE": writeDesiredState(t, "Email: maybe")})
+	if err == nil || !strings.Contains(err.Error(), "DESIRED_STATE_FILE") {
+		t.Errorf("ошибка %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ---------------- Проверка готовности (GET /healthz) ----------------
+// Вотчер готов, когда у каждого сервера Zabbix хотя бы раз успешно прошло получение медиа
+// и последние HEALTH_FAIL_CYCLES циклов подряд не закончились ошибкой обращения к API.
+
+type serverHealth struct {
+	lastSuccess time.Time
+	failures    int
+	lastError   string
+}
+
+type healthState struct {
+	mu        sync.Mutex
+	failAfter int
+	servers   map[string]*serverHealth
+}
+
+func newHealthState(failAfter int) *healthState {
+	return &healthState{failAfter: failAfter, servers: map[string]*serverHealth{}}
+}
+
+// Register добавляет сервер, без успешного цикла которого вотчер не готов
+func (h *healthState) Register(server string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.servers[server]; !ok {
+		h.servers[server] = &serverHealth{}
+	}
+}
+
+// Success отмечает успешное обращение к API в цикле
+func (h *healthState) Success(server string, now time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.servers[server] = &serverHealth{lastSuccess: now}
+}
+
+// Failure отмечает цикл, в котором API Zabbix был недоступен
+func (h *healthState) Failure(server string, err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.servers[server]
+	if !ok {
+		s = &serverHealth{}
+		h.servers[server] = s
+	}
+	s.failures++
+	s.lastError = err.Error()
+}
+
+func (h *healthState) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	type serverStatus struct {
+		Name        string     `json:"name"`
+		Ready       bool       `json:"ready"`
+		LastSuccess *time.Time `json:"last_success,omitempty"`
+		Failures    int        `json:"consecutive_failures"`
+		LastError   string     `json:"last_error,omitempty"`
+	}
+	resp := struct {
+		Status      string         `json:"status"`
+		LastSuccess *time.Time     `json:"last_success,omitempty"`
+		Failures    int            `json:"consecutive_failures"`
+		LastError   string         `json:"last_error,omitempty"`
+		Servers     []serverStatus `json:"servers,omitempty"`
+	}{Status: "ok"}
+
+	h.mu.Lock()
+	names := make([]string, 0, len(h.servers))
+	for name := range h.servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := h.servers[name]
+		st := serverStatus{Name: name, Ready: !s.lastSuccess.IsZero() && s.failures < h.failAfter, Failures: s.failures, LastError: s.lastError}
+		if !s.lastSuccess.IsZero() {
+			t := s.lastSuccess.UTC()
+			st.LastSuccess = &t
+			// общее время — самая старая из последних успешных проверок
+			if resp.LastSuccess == nil || t.Before(*resp.LastSuccess) {
+				resp.LastSuccess = &t
+			}
+		}
+		if !st.Ready {
+			resp.Status = "unavailable"
+		}
+		if s.failures > resp.Failures {
+			resp.Failures, resp.LastError = s.failures, s.lastError
+		}
+		resp.Servers = append(resp.Servers, st)
+	}
+	h.mu.Unlock()
+	if len(names) == 0 {
+		resp.Status = "unavailable"
+	}
+	if len(resp.Servers) == 1 && resp.Servers[0].Name == "" {
+		resp.Servers = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if resp.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
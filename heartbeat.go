@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Heartbeat ----------------
+
+var severityNames = map[string]string{
+	"0": "не классифицировано",
+	"1": "информация",
+	"2": "предупреждение",
+	"3": "средняя",
+	"4": "высокая",
+	"5": "чрезвычайная",
+}
+
+// heartbeat периодически сообщает, что вотчер жив
+type heartbeat struct {
+	last time.Time
+}
+
+// getProblemCounts возвращает число открытых проблем по severity (problem.get)
+func getProblemCounts(ctx context.Context, cfg *Config, logger *logrus.Logger) (map[string]int, error) {
+	req := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "problem.get",
+		Params: map[string]interface{}{
+			"output": []string{"eventid", "severity"},
+		},
+		Auth: zabbixSession(cfg),
+		ID:   40,
+	}
+	var result []struct {
+		EventID  string `json:"eventid"`
+		Severity string `json:"severity"`
+	}
+	if err := callZabbix(ctx, cfg, req, &result, logger); err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, p := range result {
+		counts[p.Severity]++
+	}
+	return counts, nil
+}
+
+// formatProblemCounts — "высокая: 2, средняя: 1" от самой серьёзной к наименее
+func formatProblemCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "нет"
+	}
+	sevs := make([]string, 0, len(counts))
+	for s := range counts {
+		sevs = append(sevs, s)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(sevs)))
+	parts := make([]string, 0, len(sevs))
+	for _, s := range sevs {
+		name, ok := severityNames[s]
+		if !ok {
+			name = "severity " + s
+		}
+		parts = append(parts, fmt.Sprintf("%s: %d", name, counts[s]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// buildHeartbeatMessage собирает текст heartbeat. Ошибка problem.get не мешает отправке — просто без счётчиков.
+func buildHeartbeatMessage(ctx context.Context, cfg *Config, state MediaState, logger *logrus.Logger) string {
+	msg := fmt.Sprintf("Zabbix Media Watcher работает. Отслеживается медиа: %d, сейчас отключено: %d",
+		cfg.watchedCount(), len(state))
+	if !cfg.HeartbeatIncludeProblems {
+		return msg
+	}
+	counts, err := getProblemCounts(ctx, cfg, logger)
+	if err != nil {
+		logger.WithError(err).Warn("Не удалось получить проблемы для heartbeat")
+		return msg + "\nОткрытые проблемы: нет данных"
+	}
+	return msg + "\nОткрытые проблемы: " + formatProblemCounts(counts)
+}
+
+// MaybeSend отправляет heartbeat, если с прошлого прошло не меньше HEARTBEAT_INTERVAL
+func (h *heartbeat) MaybeSend(ctx context.Context, cfg *Config, state MediaState, logger *logrus.Logger) {
+	if cfg.HeartbeatInterval <= 0 {
+		return
+	}
+	now := cfg.Clock.Now()
+	if !h.last.IsZero() && now.Sub(h.last) < cfg.HeartbeatInterval {
+		return
+	}
+	h.last = now
+	notify(ctx, cfg, Event{Type: EventHeartbeat, Message: buildHeartbeatMessage(ctx, cfg, state, logger)}, logger)
+}
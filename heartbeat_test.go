@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type problemRow struct {
+	EventID  string `json:"eventid"`
+	Severity string `json:"severity"`
+}
+
+func TestHeartbeatProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  string
+		problems []problemRow // nil — problem.get отвечает ошибкой
+		want     string
+		queried  bool
+	}{
+		{
+			name:    "без HEARTBEAT_INCLUDE_PROBLEMS",
+			include: "0",
+			want:    "Zabbix Media Watcher работает. Отслеживается медиа: 2, сейчас отключено: 1",
+		},
+		{
+			name:     "проблемы по severity",
+			include:  "1",
+			problems: []problemRow{{"11", "4"}, {"12", "3"}, {"13", "4"}, {"14", "5"}, {"15", "9"}},
+			want: "Zabbix Media Watcher работает. Отслеживается медиа: 2, сейчас отключено: 1\n" +
+				"Открытые проблемы: severity 9: 1, чрезвычайная: 1, высокая: 2, средняя: 1",
+			queried: true,
+		},
+		{
+			name:     "проблем нет",
+			include:  "1",
+			problems: []problemRow{},
+			want:     "Zabbix Media Watcher работает. Отслеживается медиа: 2, сейчас отключено: 1\nОткрытые проблемы: нет",
+			queried:  true,
+		},
+		{
+			name:    "problem.get с ошибкой",
+			include: "1",
+			want:    "Zabbix Media Watcher работает. Отслеживается медиа: 2, сейчас отключено: 1\nОткрытые проблемы: нет данных",
+			queried: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			if tt.problems != nil {
+				zabbix.Result("problem.get", tt.problems)
+			}
+			mm := newMattermostRecorder(t)
+			cfg, clock := newTestConfig(t, map[string]string{
+				"ZABBIX_API_URL":             zabbix.URL,
+				"MM_WEBHOOK_URL":             mm.URL,
+				"MEDIA_NAMES":                "Email,SMS",
+				"HEARTBEAT_INTERVAL":         "60",
+				"HEARTBEAT_INCLUDE_PROBLEMS": tt.include,
+			})
+			state := MediaState{"1": {FirstSeen: clock.Now()}}
+
+			(&heartbeat{}).MaybeSend(context.Background(), cfg, state, testLogger(t))
+
+			texts := mm.Texts()
+			if len(texts) != 1 {
+				t.Fatalf("сообщения %q, ожидался один heartbeat", texts)
+			}
+			if texts[0] != tt.want {
+				t.Errorf("heartbeat %q, ожидалось %q", texts[0], tt.want)
+			}
+			if got := len(zabbix.Calls("problem.get")) > 0; got != tt.queried {
+				t.Errorf("problem.get запрошен: %v, ожидалось %v", got, tt.queried)
+			}
+		})
+	}
+}
+
+func TestHeartbeatInterval(t *testing.T) {
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{"MM_WEBHOOK_URL": mm.URL, "HEARTBEAT_INTERVAL": "60"})
+	h := &heartbeat{}
+	steps := []struct {
+		advance time.Duration
+		sent    bool
+	}{
+		{sent: true},
+		{advance: 59 * time.Minute},
+		{advance: time.Minute, sent: true},
+		{advance: 30 * time.Minute},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		h.MaybeSend(context.Background(), cfg, MediaState{}, testLogger(t))
+		if got := len(mm.Texts()) == 1; got != step.sent {
+			t.Errorf("шаг %d: heartbeat отправлен: %v, ожидалось %v", i+1, got, step.sent)
+		}
+	}
+}
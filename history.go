@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Журнал событий (HISTORY_FILE) ----------------
+// Каждое событие вотчера дописывается в JSONL-файл. При --simulate записи тоже пишутся,
+// но помечаются simulated:true, чтобы при разборе их можно было отличить от реальных действий.
+
+type HistoryRecord struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	MediaID     string    `json:"media_id,omitempty"`
+	MediaName   string    `json:"media_name,omitempty"`
+	Message     string    `json:"message"`
+	Error       string    `json:"error,omitempty"`
+	DisabledFor float64   `json:"disabled_for_seconds,omitempty"`
+	Simulated   bool      `json:"simulated"`
+}
+
+// defaultHistoryEvents — по умолчанию в журнал попадают только смены состояния:
+// напоминания, heartbeat и служебные события цикла журнал бы только раздували
+var defaultHistoryEvents = []string{
+	EventMediaDisabled, EventMediaAutoEnabled, EventMediaEnableFailed, EventMediaRestored,
+	EventGroupChanged, EventUserChanged, EventTemplateChanged, EventMediaFieldsChanged, EventMaintenanceOverrun,
+	EventMaintenanceExpired, EventMaintenanceDeleted,
+	EventStatePersistFailed, EventStatePersistOK, EventMediaMisconfigured, EventMediaConfigFixed,
+	EventMediaReconciled, EventMediaReconcileFailed, EventMediaDrift,
+	EventMediaScheduledOff, EventMediaScheduledOn, EventMediaSuppressed, EventUserMediaChanged,
+	EventMediaVanished, EventMediaReappeared,
+	EventActionDisabled, EventActionAutoEnabled, EventActionEnableFailed, EventActionRestored,
+	EventZabbixUnreachable, EventZabbixRecovered,
+}
+
+// historyEventAliases — короткие имена для HISTORY_EVENTS помимо полных типов и типов без префикса media_
+var historyEventAliases = map[string][]string{
+	"enabled": {EventMediaAutoEnabled, EventMediaRestored},
+}
+
+// parseHistoryEvents разбирает HISTORY_EVENTS: список типов событий или all (nil — писать всё)
+func parseHistoryEvents(spec string) (map[string]bool, error) {
+	names := splitList(spec)
+	if len(names) == 0 {
+		names = defaultHistoryEvents
+	}
+	events := map[string]bool{}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if name == "all" {
+			return nil, nil
+		}
+		if types, ok := historyEventAliases[name]; ok {
+			for _, t := range types {
+				events[t] = true
+			}
+			continue
+		}
+		if _, ok := cloudEventTypes[name]; ok {
+			events[name] = true
+			continue
+		}
+		if _, ok := cloudEventTypes["media_"+name]; ok {
+			events["media_"+name] = true
+			continue
+		}
+		return nil, fmt.Errorf("неизвестный тип события в HISTORY_EVENTS: %q", name)
+	}
+	return events, nil
+}
+
+type historyLog struct {
+	mu   sync.Mutex
+	path string
+	// типы событий для записи, nil — все
+	events map[string]bool
+}
+
+func newHistoryLog(path string, events map[string]bool) *historyLog {
+	if path == "" {
+		return nil
+	}
+	return &historyLog{path: path, events: events}
+}
+
+// Path — путь журнала, пусто — журнал не ведётся
+func (h *historyLog) Path() string {
+	if h == nil {
+		return ""
+	}
+	return h.path
+}
+
+// Record дописывает событие в журнал; ошибки записи только логируются
+func (h *historyLog) Record(cfg *Config, ev Event, logger *logrus.Logger) {
+	if h == nil || (h.events != nil && !h.events[ev.Type]) {
+		return
+	}
+	data, err := json.Marshal(HistoryRecord{
+		Time:        ev.Time.UTC(),
+		Type:        ev.Type,
+		MediaID:     ev.MediaID,
+		MediaName:   ev.MediaName,
+		Message:     ev.Message,
+		Error:       ev.Error,
+		DisabledFor: ev.DisabledFor.Seconds(),
+		Simulated:   cfg.Simulate || cfg.DryRun,
+	})
+	if err != nil {
+		logger.WithError(err).Error("Ошибка формирования записи журнала событий")
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		logger.WithError(err).Errorf("Ошибка записи журнала событий %s", h.path)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		logger.WithError(err).Errorf("Ошибка записи журнала событий %s", h.path)
+	}
+}
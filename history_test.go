@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readHistoryLines читает журнал событий построчно, проверяя, что каждая строка — отдельный JSON
+func readHistoryLines(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var out []map[string]interface{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("строка журнала %q: %v", sc.Text(), err)
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+func TestHistorySimulatedFlag(t *testing.T) {
+	tests := []struct {
+		name          string
+		dryRun        bool
+		simulate      bool
+		wantSimulated bool
+		wantUpdates   int
+	}{
+		{name: "реальное включение", wantUpdates: 1},
+		{name: "DRY_RUN", dryRun: true, wantSimulated: true},
+		{name: "--simulate", simulate: true, wantSimulated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			zabbix.Result("mediatype.update", map[string][]string{"mediatypeids": {"1"}})
+			env := map[string]string{"ZABBIX_API_URL": zabbix.URL, "HISTORY_FILE": "history.jsonl"}
+			if tt.dryRun {
+				env["DRY_RUN"] = "1"
+			}
+			cfg, clock := newTestConfig(t, env)
+			cfg.Simulate = tt.simulate
+			logger := testLogger(t)
+			media := []MediaType{{MediaTypeID: "1", Name: "Email", Status: "1"}}
+			state := MediaState{"1": {FirstSeen: clock.Now().Add(-2 * time.Hour)}}
+
+			handleMediaTypes(context.Background(), cfg, media, newMemoryStore(), state, make(EnableFailures), newCycleCommit(), logger, nil)
+			// флаг зависит от режима, а не от типа события
+			notify(context.Background(), cfg, Event{Type: EventGroupChanged, Message: "Admins: +intruder"}, logger)
+
+			if n := len(zabbix.Calls("mediatype.update")); n != tt.wantUpdates {
+				t.Errorf("mediatype.update вызван %d раз, ожидалось %d", n, tt.wantUpdates)
+			}
+			records := readHistoryLines(t, "history.jsonl")
+			var types []string
+			for _, rec := range records {
+				types = append(types, rec["type"].(string))
+				simulated, ok := rec["simulated"].(bool)
+				if !ok {
+					t.Errorf("%s: поле simulated отсутствует: %v", rec["type"], rec)
+				}
+				if simulated != tt.wantSimulated {
+					t.Errorf("%s: simulated=%v, ожидалось %v", rec["type"], simulated, tt.wantSimulated)
+				}
+			}
+			// то, что произошло бы, попадает в журнал и в пробных режимах
+			if len(types) != 2 || types[0] != EventMediaAutoEnabled || types[1] != EventGroupChanged {
+				t.Errorf("в журнале %v", types)
+			}
+		})
+	}
+}
+
+func TestHistoryRecordFields(t *testing.T) {
+	cfg, clock := newTestConfig(t, map[string]string{"HISTORY_FILE": "history.jsonl"})
+	logger := testLogger(t)
+	notify(context.Background(), cfg, Event{
+		Type:        EventMediaEnableFailed,
+		MediaID:     "1",
+		MediaName:   "Email",
+		Error:       "No permissions",
+		DisabledFor: 90 * time.Minute,
+		Message:     "Не удалось включить Email",
+	}, logger)
+
+	records := readHistoryLines(t, "history.jsonl")
+	if len(records) != 1 {
+		t.Fatalf("записей %d", len(records))
+	}
+	rec := records[0]
+	want := map[string]interface{}{
+		"time":                 clock.Now().UTC().Format(time.RFC3339),
+		"type":                 EventMediaEnableFailed,
+		"media_id":             "1",
+		"media_name":           "Email",
+		"message":              "Не удалось включить Email",
+		"error":                "No permissions",
+		"disabled_for_seconds": float64(5400),
+		"simulated":            false,
+	}
+	for k, v := range want {
+		if rec[k] != v {
+			t.Errorf("%s = %v, ожидалось %v", k, rec[k], v)
+		}
+	}
+}
+
+func TestParseHistoryEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []string // должны быть выбраны
+		wantNot []string // не должны
+		wantAll bool
+		wantErr string
+	}{
+		{
+			name:    "по умолчанию — смены состояния",
+			want:    []string{EventMediaDisabled, EventMediaAutoEnabled, EventMediaEnableFailed, EventGroupChanged},
+			wantNot: []string{EventMediaStillDisabled, EventHeartbeat},
+		},
+		{
+			name:    "короткие имена и псевдоним enabled",
+			spec:    "disabled, Enabled ,enable_failed",
+			want:    []string{EventMediaDisabled, EventMediaAutoEnabled, EventMediaRestored, EventMediaEnableFailed},
+			wantNot: []string{EventGroupChanged, EventMediaStillDisabled},
+		},
+		{name: "полное имя", spec: "media_still_disabled", want: []string{EventMediaStillDisabled}, wantNot: []string{EventMediaDisabled}},
+		{name: "all", spec: "disabled,all", wantAll: true},
+		{name: "неизвестный тип", spec: "disabled,reboot", wantErr: `"reboot"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHistoryEvents(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantAll {
+				if got != nil {
+					t.Errorf("выбраны %v, ожидались все", got)
+				}
+				return
+			}
+			for _, ev := range tt.want {
+				if !got[ev] {
+					t.Errorf("%s не выбран", ev)
+				}
+			}
+			for _, ev := range tt.wantNot {
+				if got[ev] {
+					t.Errorf("%s выбран", ev)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryEventsFilter(t *testing.T) {
+	events := []string{EventMediaDisabled, EventMediaStillDisabled, EventMediaAutoEnabled, EventMediaEnableFailed, EventGroupChanged, EventHeartbeat}
+	tests := []struct {
+		name string
+		spec string
+		want []string
+	}{
+		{name: "по умолчанию", want: []string{EventMediaDisabled, EventMediaAutoEnabled, EventMediaEnableFailed, EventGroupChanged}},
+		{name: "выбранные", spec: "disabled,enabled,enable_failed", want: []string{EventMediaDisabled, EventMediaAutoEnabled, EventMediaEnableFailed}},
+		{name: "все", spec: "all", want: events},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := newTestConfig(t, map[string]string{"HISTORY_FILE": "history.jsonl", "HISTORY_EVENTS": tt.spec})
+			logger := testLogger(t)
+			for _, ev := range events {
+				notify(context.Background(), cfg, Event{Type: ev, MediaID: "1", MediaName: "Email", Message: ev}, logger)
+			}
+			var got []string
+			for _, rec := range readHistoryLines(t, "history.jsonl") {
+				got = append(got, rec["type"].(string))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("в журнале %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// ---------------- Общий HTTP-клиент ----------------
+
+// Режимы обработки редиректов (HTTP_REDIRECTS)
+const (
+	// preserve — следовать редиректам, повторяя исходный метод и тело
+	redirectPreserve = "preserve"
+	// same-host — как preserve, но редирект на другой хост считается ошибкой
+	redirectSameHost = "same-host"
+)
+
+const maxRedirects = 5
+
+// newHTTPClient создаёт клиент для всех исходящих запросов. Сам клиент редиректам не следует:
+// стандартный http.Client превращает POST в GET на 301/302 и теряет тело, из-за чего
+// запрос молча не доходит. Редиректы обрабатывает doHTTP.
+// timeout ограничивает весь запрос, включая соединение и чтение тела ответа;
+// tlsConfig (может быть nil) задаёт свои CA и клиентский сертификат.
+// Прокси берётся из HTTP_PROXY/HTTPS_PROXY/NO_PROXY, а proxy (может быть nil) задаёт его явно.
+func newHTTPClient(timeout time.Duration, tlsConfig *tls.Config, proxy *url.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = tlsConfig
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// parseProxyURL разбирает адрес прокси вида http://host:port (пусто — nil)
+func parseProxyURL(key, raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+		return nil, fmt.Errorf("неверный %s %q: ожидается адрес вида http://proxy:3128", key, raw)
+	}
+	return u, nil
+}
+
+// zabbixTLSConfig собирает TLS для API Zabbix из ZABBIX_CA_CERT, ZABBIX_CLIENT_CERT/ZABBIX_CLIENT_KEY
+// и ZABBIX_INSECURE_SKIP_VERIFY. Если ничего не задано, возвращает nil — действуют системные настройки.
+func zabbixTLSConfig(caFile, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" && !insecure {
+		return nil, nil
+	}
+	conf := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("ZABBIX_CA_CERT: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ZABBIX_CA_CERT: в %s нет сертификатов PEM", caFile)
+		}
+		conf.RootCAs = pool
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("для клиентского сертификата нужны и ZABBIX_CLIENT_CERT, и ZABBIX_CLIENT_KEY")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("ZABBIX_CLIENT_CERT/ZABBIX_CLIENT_KEY: %v", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+func isRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// doHTTP выполняет запрос через общий клиент и сам проходит редиректы согласно HTTP_REDIRECTS;
+// отмена ctx (дедлайн цикла, остановка) обрывает запрос
+func doHTTP(ctx context.Context, cfg *Config, method, url string, header http.Header, body []byte) (*http.Response, error) {
+	return doHTTPWith(ctx, cfg, cfg.httpClient, method, url, header, body)
+}
+
+// doHTTPWith — doHTTP через указанный клиент (для API Zabbix — клиент с его TLS)
+func doHTTPWith(ctx context.Context, cfg *Config, client *http.Client, method, url string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	for hops := 0; ; hops++ {
+		resp, err := client.Do(req)
+		if err != nil {
+			if os.IsTimeout(err) && req.Context().Err() == nil {
+				return nil, fmt.Errorf("нет ответа от %s за %v: %w", req.URL.Host, client.Timeout, err)
+			}
+			return nil, err
+		}
+		if !isRedirect(resp.StatusCode) {
+			return resp, nil
+		}
+		loc, err := resp.Location()
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("редирект без Location от %s: %v", req.URL.Redacted(), err)
+		}
+		if hops >= maxRedirects {
+			return nil, fmt.Errorf("слишком много редиректов (последний на %s)", loc.Redacted())
+		}
+		if cfg.HTTPRedirects == redirectSameHost && loc.Host != req.URL.Host {
+			return nil, fmt.Errorf("редирект на другой хост отклонён: %s -> %s", req.URL.Host, loc.Host)
+		}
+
+		next, err := http.NewRequestWithContext(req.Context(), method, loc.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		next.Header = req.Header.Clone()
+		if loc.Host != req.URL.Host {
+			// не отдаём токены чужому хосту
+			next.Header.Del("Authorization")
+		}
+		req = next
+	}
+}
+
+// postJSON — POST с телом application/json
+func postJSON(ctx context.Context, cfg *Config, url string, body []byte) (*http.Response, error) {
+	return doHTTP(ctx, cfg, http.MethodPost, url, http.Header{"Content-Type": {"application/json"}}, body)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// receivedRequest — запрос, дошедший до конечного получателя
+type receivedRequest struct {
+	Method, Path, Body, Auth string
+}
+
+func TestDoHTTPRedirects(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		code      int
+		crossHost bool
+		loop      bool
+		noLoc     bool
+		wantErr   string
+		wantAuth  string
+	}{
+		{name: "302 на тот же хост", mode: redirectPreserve, code: http.StatusFound, wantAuth: "Bearer secret"},
+		{name: "301 на тот же хост", mode: redirectPreserve, code: http.StatusMovedPermanently, wantAuth: "Bearer secret"},
+		{name: "303 на тот же хост", mode: redirectPreserve, code: http.StatusSeeOther, wantAuth: "Bearer secret"},
+		{name: "307 на тот же хост в same-host", mode: redirectSameHost, code: http.StatusTemporaryRedirect, wantAuth: "Bearer secret"},
+		{name: "308 на другой хост без токена", mode: redirectPreserve, code: http.StatusPermanentRedirect, crossHost: true},
+		{name: "на другой хост в same-host", mode: redirectSameHost, code: http.StatusFound, crossHost: true, wantErr: "редирект на другой хост отклонён"},
+		{name: "зацикленный редирект", mode: redirectPreserve, code: http.StatusFound, loop: true, wantErr: "слишком много редиректов"},
+		{name: "редирект без Location", mode: redirectPreserve, code: http.StatusFound, noLoc: true, wantErr: "редирект без Location"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				received []receivedRequest
+			)
+			record := func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				mu.Lock()
+				received = append(received, receivedRequest{r.Method, r.URL.Path, string(body), r.Header.Get("Authorization")})
+				mu.Unlock()
+				_, _ = w.Write([]byte("ok"))
+			}
+			other := httptest.NewServer(http.HandlerFunc(record))
+			defer other.Close()
+			var proxy *httptest.Server
+			proxy = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/new":
+					record(w, r)
+				case tt.noLoc:
+					w.WriteHeader(tt.code)
+				case tt.loop:
+					http.Redirect(w, r, "/old", tt.code)
+				case tt.crossHost:
+					w.Header().Set("Location", other.URL+"/new")
+					w.WriteHeader(tt.code)
+				default:
+					w.Header().Set("Location", proxy.URL+"/new")
+					w.WriteHeader(tt.code)
+				}
+			}))
+			defer proxy.Close()
+			cfg, _ := newTestConfig(t, map[string]string{"HTTP_REDIRECTS": tt.mode})
+
+			header := http.Header{"Content-Type": {"application/json"}, "Authorization": {"Bearer secret"}}
+			resp, err := doHTTP(context.Background(), cfg, http.MethodPost, proxy.URL+"/old", header, []byte(`{"text":"x"}`))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+				}
+				if len(received) != 0 {
+					t.Errorf("запрос дошёл до получателя: %+v", received)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			want := []receivedRequest{{Method: http.MethodPost, Path: "/new", Body: `{"text":"x"}`, Auth: tt.wantAuth}}
+			if len(received) != 1 || received[0] != want[0] {
+				t.Errorf("получатель получил %+v, ожидалось %+v", received, want)
+			}
+		})
+	}
+}
+
+func TestHTTPRedirectsConfig(t *testing.T) {
+	for _, mode := range []string{"", "preserve", "Same-Host"} {
+		if _, err := loadTestConfig(t, map[string]string{"HTTP_REDIRECTS": mode}); err != nil {
+			t.Errorf("HTTP_REDIRECTS=%q: %v", mode, err)
+		}
+	}
+	if _, err := loadTestConfig(t, map[string]string{"HTTP_REDIRECTS": "follow"}); err == nil || !strings.Contains(err.Error(), "HTTP_REDIRECTS") {
+		t.Errorf("HTTP_REDIRECTS=follow: ошибка %v", err)
+	}
+}
+
+// forwardProxy — HTTP-прокси: запоминает хосты запросов и сам отвечает за API Zabbix
+type forwardProxy struct {
+	*httptest.Server
+	mu    sync.Mutex
+	hosts []string
+}
+
+func newForwardProxy(t *testing.T, zabbixHost, reply string) *forwardProxy {
+	t.Helper()
+	p := &forwardProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.hosts = append(p.hosts, r.Host)
+		p.mu.Unlock()
+		// через прокси запрос идёт с абсолютным URI
+		if !r.URL.IsAbs() || r.URL.Host != zabbixHost {
+			http.Error(w, "unexpected target "+r.URL.String(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, reply)
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *forwardProxy) Hosts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.hosts...)
+}
+
+func TestZabbixProxyURL(t *testing.T) {
+	const zabbixHost = "zabbix.example.invalid"
+	proxy := newForwardProxy(t, zabbixHost, `{"jsonrpc":"2.0","result":[{"mediatypeid":"1","name":"Email","status":"1"}],"id":1}`)
+	mm := newMattermostRecorder(t)
+	cfg, _ := newTestConfig(t, map[string]string{
+		// без прокси этот адрес не разрешить
+		"ZABBIX_API_URL":   "http://" + zabbixHost + "/api_jsonrpc.php",
+		"ZABBIX_PROXY_URL": proxy.URL,
+		"MM_WEBHOOK_URL":   mm.URL,
+	})
+	logger := testLogger(t)
+
+	media, err := getMediaTypes(context.Background(), cfg, logger)
+	if err != nil {
+		t.Fatalf("mediatype.get через прокси: %v", err)
+	}
+	if len(media) != 1 || media[0].Name != "Email" {
+		t.Errorf("медиа %+v", media)
+	}
+
+	// ZABBIX_PROXY_URL только для Zabbix: webhook идёт напрямую, хоть и на loopback,
+	// который явный прокси не исключает
+	if !notify(context.Background(), cfg, Event{Type: EventMediaDisabled, Message: "Email выключено"}, logger) {
+		t.Error("уведомление не доставлено")
+	}
+	if got := len(mm.Texts()); got != 1 {
+		t.Errorf("webhook получил %d сообщений", got)
+	}
+	if hosts := proxy.Hosts(); len(hosts) != 1 || hosts[0] != zabbixHost {
+		t.Errorf("через прокси прошли запросы к %v, ожидался только %s", hosts, zabbixHost)
+	}
+}
+
+func TestZabbixProxyURLConfig(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: ""},
+		{value: "http://proxy.local:3128"},
+		{value: "socks5://proxy.local:1080"},
+		{value: "proxy.local:3128", wantErr: true},
+		{value: "ftp://proxy.local", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			_, err := loadTestConfig(t, map[string]string{"ZABBIX_PROXY_URL": tt.value})
+			if tt.wantErr != (err != nil) {
+				t.Errorf("ошибка %v", err)
+			}
+			if err != nil && !strings.Contains(err.Error(), "ZABBIX_PROXY_URL") {
+				t.Errorf("в ошибке не назван ZABBIX_PROXY_URL: %v", err)
+			}
+		})
+	}
+}
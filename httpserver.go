@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Встроенный HTTP-сервер ----------------
+
+// Обработчики регистрируются по адресу: разные функции могут слушать общий порт или свои отдельные.
+var (
+	httpMu      sync.Mutex
+	httpMuxs    = map[string]*http.ServeMux{}
+	httpServers []*http.Server
+)
+
+// httpShutdownTimeout — сколько ждать завершения активных запросов при остановке
+const httpShutdownTimeout = 5 * time.Second
+
+// handleHTTP регистрирует обработчик на сервере с адресом addr
+func handleHTTP(addr, pattern string, handler http.HandlerFunc) {
+	httpMu.Lock()
+	defer httpMu.Unlock()
+	mux, ok := httpMuxs[addr]
+	if !ok {
+		mux = http.NewServeMux()
+		httpMuxs[addr] = mux
+	}
+	mux.HandleFunc(pattern, handler)
+}
+
+// startHTTPServers запускает в фоне по серверу на каждый зарегистрированный адрес
+func startHTTPServers(logger *logrus.Logger) {
+	httpMu.Lock()
+	defer httpMu.Unlock()
+	for addr, mux := range httpMuxs {
+		srv := &http.Server{Addr: addr, Handler: mux}
+		httpServers = append(httpServers, srv)
+		go func(addr string) {
+			logger.Infof("HTTP-сервер слушает %s", addr)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Errorf("Ошибка HTTP-сервера %s: %v", addr, err)
+			}
+		}(addr)
+	}
+}
+
+// stopHTTPServers останавливает запущенные серверы, давая активным запросам завершиться
+func stopHTTPServers(logger *logrus.Logger) {
+	httpMu.Lock()
+	defer httpMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	for _, srv := range httpServers {
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Errorf("Ошибка остановки HTTP-сервера %s: %v", srv.Addr, err)
+		}
+	}
+	httpServers = nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------- Лог в файл с ротацией ----------------
+
+// rotatingFile — io.Writer поверх файла: при превышении размера текущий файл
+// переименовывается в <name>.<время>, лишние и слишком старые копии удаляются.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	// nil — файл не удалось открыть заново после ротации, повторная попытка при следующей записи
+	file *os.File
+	size int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// ротация не удалась — продолжаем писать в текущий файл, чтобы не терять строки
+			fmt.Fprintf(os.Stderr, "ротация %s: %v\n", r.path, err)
+		}
+	}
+	if r.file == nil {
+		return 0, fmt.Errorf("лог-файл %s не открыт", r.path)
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	err := r.file.Close()
+	r.file = nil
+	if err != nil {
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	stamp := time.Now().UTC().Format("20060102T150405.000")
+	backup := r.path + "." + stamp
+	// при частых ротациях имя может совпасть с копией из той же миллисекунды — её не затираем
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s.%s-%d", r.path, stamp, i)
+	}
+	if err := os.Rename(r.path, backup); err != nil {
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.cleanup()
+	return nil
+}
+
+// cleanup удаляет копии сверх maxBackups и старше maxAge
+func (r *rotatingFile) cleanup() {
+	matches, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return
+	}
+	backups := matches[:0]
+	for _, m := range matches {
+		// временные файлы cycleCommit и прочее рядом не трогаем
+		if !strings.Contains(m, ".tmp-") {
+			backups = append(backups, m)
+		}
+	}
+	// имя содержит время, поэтому сортировка по имени — от старых к новым
+	sort.Strings(backups)
+
+	now := time.Now()
+	for i, b := range backups {
+		tooMany := r.maxBackups > 0 && i < len(backups)-r.maxBackups
+		tooOld := false
+		if r.maxAge > 0 {
+			if info, err := os.Stat(b); err == nil && now.Sub(info.ModTime()) > r.maxAge {
+				tooOld = true
+			}
+		}
+		if tooMany || tooOld {
+			_ = os.Remove(b)
+		}
+	}
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
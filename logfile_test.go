@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// readLogLines читает JSON-строки лога и проверяет, что каждая разбирается целиком
+func readLogLines(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var msgs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("%s: строка не JSON: %q", path, scanner.Text())
+		}
+		msgs = append(msgs, entry["msg"].(string))
+	}
+	return msgs
+}
+
+func TestRotatingLogFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxSize    int64
+		maxBackups int
+		lines      int
+		// сколько копий должно остаться; -1 — несколько, точное число зависит от длины строк
+		wantBackups int
+	}{
+		{name: "без ротации", maxSize: 1 << 20, maxBackups: 5, lines: 10},
+		{name: "ротация по размеру", maxSize: 400, maxBackups: 100, lines: 20, wantBackups: -1},
+		{name: "лишние копии удаляются", maxSize: 400, maxBackups: 2, lines: 20, wantBackups: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "watcher.log")
+			file, err := newRotatingFile(path, tt.maxSize, 0, tt.maxBackups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			var stdout bytes.Buffer
+			logger := logrus.New()
+			logger.SetFormatter(&logrus.JSONFormatter{})
+			logger.SetOutput(io.MultiWriter(&stdout, file))
+
+			for i := 0; i < tt.lines; i++ {
+				// строка около 100 байт
+				logger.WithField("media_id", i).Info("Проверка медиа")
+			}
+
+			if n := bytes.Count(stdout.Bytes(), []byte("\n")); n != tt.lines {
+				t.Errorf("в stdout %d строк, ожидалось %d", n, tt.lines)
+			}
+			backups, _ := filepath.Glob(path + ".*")
+			sort.Strings(backups)
+			if (tt.wantBackups < 0 && len(backups) < 2) || (tt.wantBackups >= 0 && len(backups) != tt.wantBackups) {
+				t.Fatalf("копий %d (%v), ожидалось %d", len(backups), backups, tt.wantBackups)
+			}
+			total := 0
+			for _, p := range append(backups, path) {
+				info, err := os.Stat(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() > tt.maxSize {
+					t.Errorf("%s: %d байт больше лимита %d", p, info.Size(), tt.maxSize)
+				}
+				total += len(readLogLines(t, p))
+			}
+			// без удалённых копий ни одна строка не потеряна
+			if tt.wantBackups < 0 && total != tt.lines {
+				t.Errorf("во всех файлах %d строк, ожидалось %d", total, tt.lines)
+			}
+			if last := readLogLines(t, path); len(last) == 0 {
+				t.Error("текущий файл пуст")
+			}
+		})
+	}
+}
+
+func TestRotatingLogFileMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "watcher.log")
+	old := path + ".20200101T000000.000"
+	fresh := path + ".20990101T000000.000"
+	tmp := path + ".tmp-123"
+	for _, p := range []string{old, fresh, tmp} {
+		if err := os.WriteFile(p, []byte("{}\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	week := 7 * 24 * time.Hour
+	if err := os.Chtimes(old, time.Now().Add(-2*week), time.Now().Add(-2*week)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(tmp, time.Now().Add(-2*week), time.Now().Add(-2*week)); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := newRotatingFile(path, 10, week, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := file.Write([]byte(`{"msg":"строка"}` + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("копия старше LOG_FILE_MAX_AGE не удалена")
+	}
+	for _, p := range []string{fresh, tmp} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s удалён: %v", p, err)
+		}
+	}
+}
+
+func TestLogMaxSizeAlias(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want int64 // МБ
+	}{
+		{name: "по умолчанию", want: 100},
+		{name: "LOG_FILE_MAX_SIZE", env: map[string]string{"LOG_FILE_MAX_SIZE": "20"}, want: 20},
+		{name: "LOG_MAX_SIZE_MB", env: map[string]string{"LOG_MAX_SIZE_MB": "30"}, want: 30},
+		{name: "оба", env: map[string]string{"LOG_FILE_MAX_SIZE": "20", "LOG_MAX_SIZE_MB": "30"}, want: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := newTestConfig(t, tt.env)
+			if got := cfg.LogFileMaxSize / (1024 * 1024); got != tt.want {
+				t.Errorf("размер лог-файла %d МБ, ожидалось %d", got, tt.want)
+			}
+		})
+	}
+}
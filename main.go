@@ -1,64 +1,376 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log/syslog"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
 // Конфиг скрипта
 type Config struct {
-	ZabbixAPIURL      string
-	APIToken          string
-	CheckInterval     time.Duration
-	OffDuration       time.Duration
-	MediaNames        []string
+	ZabbixAPIURL string
+	APIToken     string
+	// Имя сервера Zabbix, когда их несколько (ZABBIX_SERVER_NAMES); пусто — сервер один
+	ServerName string
+	servers    []zabbixServer
+	// Вход по логину и паролю (user.login), если ZABBIX_API_TOKEN не задан
+	ZabbixUser     string
+	ZabbixPassword string
+	zabbixLogin    bool
+	// сервер не принял пакетный запрос — запросы отправляются по отдельности
+	zabbixNoBatch bool
+	CheckInterval time.Duration
+	OffDuration   time.Duration
+	// Пороги отключения для отдельных медиа по имени (MEDIA_OFF_DURATION_OVERRIDES)
+	OffDurationOverrides map[string]time.Duration
+	MediaNames           []string
+	// Действия (trigger actions) для отслеживания (ACTION_NAMES); пусто — действия не проверяются
+	ActionNames       []string
 	StateFile         string
+	GroupStateFile    string
 	MattermostWebhook string
+	// Имя отправителя, канал по умолчанию и иконка сообщений webhook (MM_USERNAME, MM_CHANNEL, MM_ICON)
+	MattermostUsername string
+	MattermostChannel  string
+	MattermostIcon     string
+	StateCompact       bool
+	// Хранилище состояния медиа и групп: files или sqlite (STATE_BACKEND, STATE_DSN)
+	StateBackend string
+	StateDSN     string
+	// Через сколько подряд неудачных сохранений состояния слать критическое уведомление
+	StateSaveFailThreshold int
+	// Режим --simulate: события синтетические, в Zabbix ничего не пишем
+	Simulate bool
+	// Пробный режим (DRY_RUN): медиа не включаются и не выключаются, остальное работает как обычно
+	DryRun bool
+	// Разовая проверка (-once / RUN_ONCE): один цикл без расписания и выход
+	RunOnce bool
+	// Следить за изменениями шаблонов сообщений (message_templates) медиа-типов
+	WatchMessageTemplates bool
+	// Поля медиа-типов, об изменении которых уведомлять (MEDIA_WATCH_FIELDS); пусто — не следить
+	MediaWatchFields []string
+	// Отслеживать создание/удаление пользователей Zabbix
+	MonitorUsers bool
+	// Что делать с повторяющимися ошибками включения: always — уведомлять каждый цикл,
+	// suppress — уведомлять о той же ошибке не чаще EnableFailureRealert
+	EnableFailurePolicy  string
+	EnableFailureRealert time.Duration
+	// Предупреждать о периодах обслуживания, активных дольше этого (0 — не проверять)
+	MaintenanceMaxDuration time.Duration
+	// Удалять закончившиеся периоды обслуживания (MAINTENANCE_AUTO_CLEANUP)
+	MaintenanceAutoCleanup bool
+	// Приёмник событий в формате CloudEvents (пусто — не отправлять)
+	CloudEventsURL    string
+	CloudEventsSource string
+	// Секрет для подписи CloudEvents (HMAC-SHA256 в X-Signature), пусто — не подписывать
+	CloudEventsSecret string
+	// Произвольный webhook: адрес, шаблон тела, Content-Type и секрет подписи (GENERIC_WEBHOOK_*)
+	WebhookURL         string
+	WebhookContentType string
+	WebhookSecret      string
+	webhookTemplate    *template.Template
+	// Именованные политики для групп медиа (MEDIA_POLICIES)
+	Policies []MediaPolicy
+	// Пока этот файл существует, вотчер ничего не меняет и не уведомляет
+	MaintenanceFile string
+	// Не больше стольких запросов к API в секунду (0 — без ограничения)
+	APIRate    float64
+	apiLimiter *rateLimiter
+	// Отчёт об изменениях групп для аудита: файл JSON Lines и срок хранения записей
+	GroupReportFile      string
+	GroupReportRetention time.Duration
+	// Адрес встроенного HTTP-сервера (например ":8080"), пусто — не запускать
+	HTTPAddr string
+	// Адрес для /metrics Prometheus (может совпадать с HTTPAddr), пусто — не отдавать
+	MetricsAddr string
+	// Адрес для GET /healthz и число неудачных циклов подряд, после которого вотчер не готов
+	HealthAddr string
+	health     *healthState
+	// Отвечать в тред первого сообщения по медиа (если Mattermost вернул ID поста)
+	MattermostThreads bool
+	// Оформлять уведомления по медиа вложениями с цветом и полями (MM_RICH)
+	MattermostRich bool
+	mmThreads      *threadStore
+	// Не больше MM_MAX_PER_MINUTE сообщений в канал Mattermost, лишние подавляются
+	MattermostMaxPerMinute int
+	mmBucket               *tokenBucket
+	mediaNames             *mediaNameCache
+	// Периодический heartbeat (0 — выключен) и добавление в него счётчиков открытых проблем
+	HeartbeatInterval        time.Duration
+	HeartbeatIncludeProblems bool
+	// Кулдауны уведомлений по типу события (NOTIFY_COOLDOWNS), действуют отдельно для каждого медиа
+	NotifyCooldowns map[string]time.Duration
+	throttle        *eventThrottle
+	// Личные сообщения дежурным через бота Mattermost: адрес сервера, токен бота,
+	// usernames получателей и события, которые шлются в личку вместо канала
+	MattermostURL      string
+	MattermostBotToken string
+	MattermostDMUsers  []string
+	MattermostDMEvents []string
+	mmDM               *dmCache
+	// Бот и чат Telegram для уведомлений в дополнение к Mattermost
+	TelegramBotToken string
+	TelegramChatID   string
+	TelegramAPIURL   string
+	// Отслеживать медиа с этим тегом ("watch:true") вместо MEDIA_NAMES
+	WatchTag string
+	// Отслеживать, помимо MEDIA_NAMES, медиа, имя которых подходит под регулярное выражение (MEDIA_NAME_PATTERN)
+	MediaNamePattern *regexp.Regexp
+	// Медиа, выбранные по тегу и шаблону имени в последнем цикле; MEDIA_NAMES при этом не меняется
+	watchedMedia []string
+	// Тег медиа, значение которого (true/false) включает или запрещает автовключение именно этого медиа
+	AutoEnableTag string
+	// Общий клиент для Zabbix и webhook-ов и режим обработки редиректов (preserve, same-host)
+	HTTPRedirects string
+	// Таймаут одного HTTP-запроса целиком: соединение, отправка и чтение ответа
+	HTTPTimeout time.Duration
+	// Повторы запроса к Zabbix API при сетевой ошибке или ответе 5xx
+	ZabbixMaxRetries int
+	// Лог в файл дополнительно к stdout и параметры ротации
+	LogFile           string
+	LogFileMaxSize    int64
+	LogFileMaxAge     time.Duration
+	LogFileMaxBackups int
+	// Дописывать в описание медиа отметку об автовключении и предельная длина описания
+	AnnotateEnable    bool
+	AnnotateMaxLength int
+	httpClient        *http.Client
+	// Клиент для API Zabbix: со своими ZABBIX_CA_CERT, клиентским сертификатом и ZABBIX_PROXY_URL
+	zabbixHTTPClient *http.Client
+	// Проверка сертификата Zabbix отключена (ZABBIX_INSECURE_SKIP_VERIFY)
+	ZabbixInsecureSkipVerify bool
+	// Куда класть токен API: поле auth запроса или заголовок Authorization (ZABBIX_AUTH_MODE)
+	ZabbixAuthMode string
+	// Поля группы, изменения которых отслеживаются
+	GroupWatchFields groupFields
+	// Предельная длительность одного цикла; 0 — без ограничения
+	CycleTimeout time.Duration
+	// Увеличение интервала при недоступном API: после BackoffAfter неудачных циклов подряд
+	// (0 — не увеличивать и не уведомлять) интервал растёт в BackoffFactor раз до BackoffMax
+	BackoffAfter  int
+	BackoffFactor float64
+	BackoffMax    time.Duration
+	// Метка окружения (prod, stage, dev…) и оформление уведомлений по окружениям
+	Environment string
+	EnvThemes   map[string]EnvTheme
+	// Включать все медиа, превысившие порог, одним mediatype.update
+	BatchEnable bool
+	// Сколько медиа проверяется одновременно (MEDIA_CONCURRENCY)
+	MediaConcurrency int
+	// Тихие часы: уведомления копятся и уходят сводкой после окончания окна
+	quiet *quietHours
+	// Окно без автовключения медиа (ENABLE_QUIET_HOURS)
+	enableQuiet *enableQuietHours
+	// Секреты из Vault при SECRETS_BACKEND=vault
+	vault *vaultClient
+	// Сообщать о включённых медиа с пустыми обязательными полями
+	ValidateEnabledMedia bool
+	// Задержка перед первым циклом и ожидание доступности Zabbix API по TCP
+	StartupDelay        time.Duration
+	StartupWaitReady    bool
+	StartupReadyTimeout time.Duration
+	// Желаемое состояние медиа из DESIRED_STATE_FILE и разрешение выключать медиа по нему
+	desired             *desiredState
+	DesiredStateDisable bool
+	// Правила выключения медиа по расписанию
+	DisableSchedule []disableRule
+	// Откладывать напоминания и автовключение, пока открыта подходящая проблема Zabbix
+	suppressor *problemSuppressor
+	// Facility и тег syslog по категориям событий
+	SyslogRoutes map[string]syslogTarget
+	// Журнал событий в JSONL
+	history *historyLog
+	// Журнал изменений для аудита в JSONL (AUDIT_LOG_FILE)
+	auditLog *auditLog
+	// Повторы и очередь недоставленных уведомлений
+	delivery *deliveryPipeline
+	// Следующий и последний запуски цикла (GET /schedule)
+	schedule *cycleSchedule
+	// Отслеживать медиа отдельных пользователей (user.get с selectMedias)
+	MonitorUserMedia bool
+	// Сообщать о пропаже медиа только после стольких циклов отсутствия подряд (0 — не следить)
+	VanishGrace int
+	// Период сводки изменений для аудита (0 — не отправлять)
+	AuditSummaryInterval time.Duration
+	// Завершаться с ошибкой при сомнительной конфигурации вместо предупреждения
+	FailFast bool
+	// Источник текущего времени (по умолчанию системные часы)
+	Clock      Clock
+	clockSteps *clockWatch
 }
 
+// ZabbixRequest — запрос JSON-RPC. Params — типизированные параметры частых вызовов
+// (MediaTypeGetParams, MediaTypeUpdateParams) или map для остальных методов.
 type ZabbixRequest struct {
 	JSONRPC string      `json:"jsonrpc"`
 	Method  string      `json:"method"`
 	Params  interface{} `json:"params"`
-	Auth    string      `json:"auth"`
+	Auth    string      `json:"auth,omitempty"`
 	ID      int         `json:"id"`
 }
 
-type MediaType struct {
+// MediaTypeGetParams — параметры mediatype.get
+type MediaTypeGetParams struct {
+	Output []string `json:"output"`
+	// nil — без фильтра, все медиа
+	Filter                 *MediaTypeFilter `json:"filter,omitempty"`
+	SelectMessageTemplates string           `json:"selectMessageTemplates,omitempty"`
+	SelectTags             string           `json:"selectTags,omitempty"`
+}
+
+type MediaTypeFilter struct {
+	Name []string `json:"name"`
+}
+
+// MediaTypeUpdateParams — параметры mediatype.update; пустые поля не отправляются и не меняются
+type MediaTypeUpdateParams struct {
 	MediaTypeID string `json:"mediatypeid"`
-	Name        string `json:"name"`
-	Status      string `json:"status"`
+	Status      string `json:"status,omitempty"`
+	Description string `json:"description,omitempty"`
 }
 
-type MediaState map[string]time.Time
+// Значения status медиа-типа
+const (
+	mediaStatusEnabled  = "0"
+	mediaStatusDisabled = "1"
+)
+
+type MediaType struct {
+	MediaTypeID      string            `json:"mediatypeid"`
+	Name             string            `json:"name"`
+	Status           string            `json:"status"`
+	MessageTemplates []MessageTemplate `json:"message_templates,omitempty"`
+	Tags             []MediaTag        `json:"tags,omitempty"`
+	Description      string            `json:"description,omitempty"`
+	// Поля конфигурации — запрашиваются только при VALIDATE_ENABLED_MEDIA и MEDIA_WATCH_FIELDS
+	Type               string           `json:"type,omitempty"`
+	SMTPServer         string           `json:"smtp_server,omitempty"`
+	SMTPPort           string           `json:"smtp_port,omitempty"`
+	SMTPHelo           string           `json:"smtp_helo,omitempty"`
+	SMTPEmail          string           `json:"smtp_email,omitempty"`
+	SMTPSecurity       string           `json:"smtp_security,omitempty"`
+	SMTPAuthentication string           `json:"smtp_authentication,omitempty"`
+	Username           string           `json:"username,omitempty"`
+	ExecPath           string           `json:"exec_path,omitempty"`
+	ExecParams         string           `json:"exec_params,omitempty"`
+	GSMModem           string           `json:"gsm_modem,omitempty"`
+	Script             string           `json:"script,omitempty"`
+	Parameters         []MediaParameter `json:"parameters,omitempty"`
+	Timeout            string           `json:"timeout,omitempty"`
+	MaxAttempts        string           `json:"maxattempts,omitempty"`
+	AttemptInterval    string           `json:"attempt_interval,omitempty"`
+}
+
+// MediaRecord — отслеживаемое выключенное медиа
+type MediaRecord struct {
+	FirstSeen    time.Time `json:"first_seen"`
+	LastNotified time.Time `json:"last_notified,omitempty"`
+	Name         string    `json:"name,omitempty"`
+	NotifyCount  int       `json:"notify_count,omitempty"`
+}
+
+// equal сравнивает записи; время — через Equal: прочитанное из хранилища теряет зону
+// и монотонные показания, а == сочло бы такие записи разными
+func (r MediaRecord) equal(o MediaRecord) bool {
+	return r.Name == o.Name && r.NotifyCount == o.NotifyCount &&
+		r.FirstSeen.Equal(o.FirstSeen) && r.LastNotified.Equal(o.LastNotified)
+}
+
+// notified отмечает отправленное по медиа уведомление
+func (r *MediaRecord) notified(now time.Time) {
+	r.LastNotified = now
+	r.NotifyCount++
+}
+
+// MediaState — отслеживаемые выключенные медиа по id
+type MediaState map[string]*MediaRecord
+
+// EnableFailures — последнее уведомление об ошибке включения по каждому медиа (только в памяти)
+type EnableFailures map[string]enableFailure
+
+type enableFailure struct {
+	LastNotified time.Time
+	LastError    string
+}
+
+const (
+	enableFailurePolicyAlways   = "always"
+	enableFailurePolicySuppress = "suppress"
+)
+
+// shouldNotify решает, слать ли уведомление об ошибке включения. При политике suppress
+// одинаковая ошибка повторяется не чаще EnableFailureRealert, новая ошибка уходит сразу.
+func (f EnableFailures) shouldNotify(cfg *Config, mediaID string, err error, now time.Time) bool {
+	if cfg.EnableFailurePolicy != enableFailurePolicySuppress {
+		return true
+	}
+	prev, ok := f[mediaID]
+	if ok && prev.LastError == err.Error() && now.Sub(prev.LastNotified) < cfg.EnableFailureRealert {
+		return false
+	}
+	f[mediaID] = enableFailure{LastNotified: now, LastError: err.Error()}
+	return true
+}
 
 type UserGroup struct {
 	ID    string   `json:"usrgrpid"`
 	Name  string   `json:"name"`
 	Users []string `json:"users"`
+	// Хэш отсортированного списка userid — для быстрого сравнения состава
+	UsersHash string `json:"users_hash,omitempty"`
+	// userid -> username, чтобы в изменениях состава показывать имена, в том числе удалённых
+	UserNames map[string]string `json:"user_names,omitempty"`
+	// Права на группы хостов/шаблонов и users_status — заполняются, только если эти поля отслеживаются
+	Rights     []string `json:"rights,omitempty"`
+	RightsHash string   `json:"rights_hash,omitempty"`
+	Status     string   `json:"users_status,omitempty"`
 }
 type GroupState map[string]UserGroup
 
-const groupStateFilename = "usergroup_state.json"
+// Файлы состояния по умолчанию, пути переопределяются MEDIA_STATE_FILE и GROUP_STATE_FILE
+const defaultStateFilename = "media_state.json"
+const defaultGroupStateFilename = "usergroup_state.json"
+const templateStateFilename = "media_templates_state.json"
 
 func main() {
+	simulate := flag.String("simulate", "", `прогнать синтетические события без обращения к Zabbix, например "disable:Email,overdue:Email,enable:SMS"`)
+	previewEnables := flag.Bool("preview-enables", false, "показать, какие медиа будут включены в ближайшем цикле, и выйти")
+	once := flag.Bool("once", false, "выполнить одну проверку и выйти (для cron и systemd-таймеров)")
+	flag.Parse()
+
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
 	logger.SetOutput(os.Stdout)
+	// уровень и формат нужны до первой записи, поэтому .env читается уже здесь
+	_ = godotenv.Load()
+	if err := configureLogger(logger); err != nil {
+		logger.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
 
-	sysLogger, err := syslog.New(syslog.LOG_INFO|syslog.LOG_LOCAL0, "zabbix-media-watcher")
+	sysLogger, err := openSyslog(syslog.LOG_INFO|syslog.LOG_LOCAL0, defaultSyslogTag)
 	if err != nil {
 		logger.Warnf("Не удалось подключиться к syslog: %v", err)
 		sysLogger = nil
@@ -70,301 +382,1597 @@ func main() {
 	if err != nil {
 		logger.Fatalf("Ошибка загрузки конфигурации: %v", err)
 	}
+	if *once {
+		cfg.RunOnce = true
+	}
+
+	if cfg.LogFile != "" {
+		logFile, err := newRotatingFile(cfg.LogFile, cfg.LogFileMaxSize, cfg.LogFileMaxAge, cfg.LogFileMaxBackups)
+		if err != nil {
+			logger.Fatalf("Не удалось открыть LOG_FILE %s: %v", cfg.LogFile, err)
+		}
+		defer logFile.Close()
+		logger.SetOutput(io.MultiWriter(os.Stdout, logFile))
+	}
 
 	logger.WithFields(logrus.Fields{
-		"api_url":         cfg.ZabbixAPIURL,
-		"check_interval":  cfg.CheckInterval,
-		"off_duration":    cfg.OffDuration,
-		"media_names":     cfg.MediaNames,
-		"mm_webhook_used": cfg.MattermostWebhook != "",
+		"api_url":          cfg.ZabbixAPIURL,
+		"check_interval":   cfg.CheckInterval,
+		"off_duration":     cfg.OffDuration,
+		"media_names":      cfg.MediaNames,
+		"mm_webhook_used":  cfg.MattermostWebhook != "",
+		"telegram_used":    cfg.telegramEnabled(),
+		"cloudevents_used": cfg.CloudEventsURL != "",
+		"environment":      cfg.Environment,
 	}).Info("Конфигурация загружена")
+	if cfg.ZabbixInsecureSkipVerify {
+		logger.Warn("ВНИМАНИЕ: ZABBIX_INSECURE_SKIP_VERIFY — сертификат API Zabbix не проверяется, соединение уязвимо для подмены. Только для тестовых стендов")
+	}
+	if cfg.DryRun {
+		logger.Warn("Включён пробный режим DRY_RUN: медиа не будут включаться и выключаться")
+	}
+	for _, w := range cfg.resolutionWarnings() {
+		logger.Warn(w)
+	}
+
+	sysLogs := newSyslogRouter(cfg.SyslogRoutes, sysLogger, logger)
+
+	stateDB, err := openStateDB(cfg)
+	if err != nil {
+		logger.Fatalf("Ошибка открытия базы состояния: %v", err)
+	}
+	if stateDB != nil {
+		defer stateDB.Close()
+	}
+
+	if *simulate != "" {
+		if err := runSimulation(context.Background(), cfg, *simulate, logger, sysLogs.For(syslogMedia)); err != nil {
+			logger.Fatalf("Ошибка симуляции: %v", err)
+		}
+		return
+	}
+
+	if *previewEnables {
+		if err := runPreviewEnables(context.Background(), cfg, stateDB, os.Stdout, logger); err != nil {
+			logger.Fatalf("Ошибка предпросмотра: %v", err)
+		}
+		return
+	}
+
+	servers, err := cfg.forServers()
+	if err != nil {
+		logger.Fatalf("Ошибка загрузки конфигурации: %v", err)
+	}
+	watchers := make([]*watcher, 0, len(servers))
+	for _, c := range servers {
+		cfg.health.Register(c.ServerName)
+		l := serverLogger(logger, c.ServerName)
+		if c.ServerName != "" {
+			l.WithField("api_url", c.ZabbixAPIURL).Info("Подключён сервер Zabbix")
+		}
+		watchers = append(watchers, newWatcher(c, newStateStore(c, stateDB), l, sysLogs))
+	}
+
+	if cfg.RunOnce {
+		// без HTTP-серверов: процесс завершается сразу после проверки
+		waitForServers(context.Background(), watchers)
+		if err := runOnce(cfg, watchers, logger); err != nil {
+			logger.Fatalf("Разовая проверка завершилась ошибкой: %v", err)
+		}
+		logger.Info("Разовая проверка завершена")
+		return
+	}
+
+	if cfg.HTTPAddr != "" {
+		handleHTTP(cfg.HTTPAddr, "/schedule", cfg.schedule.ServeHTTP)
+	}
+	if cfg.MetricsAddr != "" {
+		handleHTTP(cfg.MetricsAddr, "/metrics", promhttp.Handler().ServeHTTP)
+	}
+	if cfg.HealthAddr != "" {
+		handleHTTP(cfg.HealthAddr, "/healthz", cfg.health.ServeHTTP)
+	}
+	startHTTPServers(logger)
+	waitForServers(context.Background(), watchers)
+
+	paused := false
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	runScheduled(ctx, cfg, func(ctx context.Context) error {
+		if maintenanceModeActive(cfg) {
+			if !paused {
+				logger.Warnf("Режим обслуживания активен (найден %s) — изменения и уведомления приостановлены", cfg.MaintenanceFile)
+			}
+			paused = true
+			logger.WithField("next_run", cfg.schedule.Next().Format(time.RFC3339)).Infof("Режим обслуживания активен — цикл пропущен, следующая проверка через %v", time.Until(cfg.schedule.Next()).Round(time.Second))
+			return nil
+		}
+		if paused {
+			logger.Infof("Файл %s удалён — режим обслуживания завершён, работа возобновлена", cfg.MaintenanceFile)
+			paused = false
+		}
+
+		return runWatchers(ctx, cfg, watchers, logger)
+	}, logger)
+
+	logger.Info("Остановка сервиса")
+	// файлы, не записанные из-за ошибки в последнем цикле, — последняя попытка
+	for _, w := range watchers {
+		if err := w.commit.Commit(w.logger); err != nil {
+			w.logger.Errorf("Ошибка сохранения состояния при остановке: %v", err)
+		}
+	}
+	stopHTTPServers(logger)
+	logger.Info("Сервис мониторинга медиа Zabbix остановлен")
+}
+
+// configureLogger применяет LOG_LEVEL (trace, debug, info, warn, error) и LOG_FORMAT (json, text);
+// по умолчанию — info и JSON
+func configureLogger(logger *logrus.Logger) error {
+	if v := strings.TrimSpace(os.Getenv("LOG_LEVEL")); v != "" {
+		level, err := logrus.ParseLevel(v)
+		if err != nil {
+			return fmt.Errorf("неверный LOG_LEVEL %q: ожидается trace, debug, info, warn или error", v)
+		}
+		logger.SetLevel(level)
+	}
+	switch v := strings.ToLower(strings.TrimSpace(os.Getenv("LOG_FORMAT"))); v {
+	case "", "json":
+	case "text":
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return fmt.Errorf("неверный LOG_FORMAT %q: ожидается json или text", v)
+	}
+	return nil
+}
+
+// waitForServers ждёт готовности серверов Zabbix и входит в API по ZABBIX_USER
+func waitForServers(ctx context.Context, watchers []*watcher) {
+	for i, w := range watchers {
+		if i > 0 {
+			// STARTUP_DELAY выдерживается один раз, дальше — только готовность остальных серверов
+			w.cfg.StartupDelay = 0
+		}
+		waitForStartup(w.cfg, w.logger)
+		if w.cfg.usesZabbixLogin() {
+			// при неудаче вход повторится перед первым запросом цикла
+			if err := zabbixLogin(ctx, w.cfg, w.logger); err != nil {
+				w.logger.WithError(err).Error("Не удалось войти в Zabbix API по ZABBIX_USER")
+			}
+		}
+	}
+}
+
+// runWatchers выполняет один цикл проверок всех серверов. Возвращает ошибки по серверам, API
+// которых был недоступен (сетевая ошибка, таймаут, HTTP 5xx); если не ответил ни один сервер,
+// ошибка оборачивает errZabbixUnreachable. Ошибки JSON-RPC остаются обычными ошибками цикла.
+func runWatchers(ctx context.Context, cfg *Config, watchers []*watcher, logger *logrus.Logger) error {
+	logger.Info("Начало цикла проверки медиа-типов")
+	cfg.vault.MaybeRefresh(ctx, cfg, logger)
+	// сводка за закончившиеся тихие часы уходит раньше новых уведомлений цикла
+	cfg.delivery.Flush(ctx, cfg, logger)
+	cfg.quiet.Flush(ctx, cfg, logger)
+	var failed []string
+	for _, w := range watchers {
+		// серверы проверяются в рамках дедлайна общего цикла
+		if err := w.runCycle(ctx); err != nil {
+			if w.cfg.ServerName != "" {
+				err = fmt.Errorf("%s: %v", w.cfg.ServerName, err)
+			}
+			failed = append(failed, err.Error())
+		}
+	}
+	reportMattermostDropped(ctx, cfg, logger)
+	switch {
+	case len(failed) == len(watchers):
+		return fmt.Errorf("%w: %s", errZabbixUnreachable, strings.Join(failed, "; "))
+	case len(failed) > 0:
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// maintenanceModeActive сообщает, существует ли файл MAINTENANCE_FILE ("touch, чтобы поставить на паузу")
+func maintenanceModeActive(cfg *Config) bool {
+	if cfg.MaintenanceFile == "" {
+		return false
+	}
+	_, err := os.Stat(cfg.MaintenanceFile)
+	return err == nil
+}
+
+func loadConfig() (*Config, error) {
+	_ = godotenv.Load()
+
+	checkInterval, err := envMinutes("MEDIA_CHECK_INTERVAL")
+	if err != nil {
+		return nil, err
+	}
+
+	offDuration, err := envMinutes("MEDIA_OFF_DURATION")
+	if err != nil {
+		return nil, err
+	}
+	if offDuration < 0 {
+		return nil, fmt.Errorf("MEDIA_OFF_DURATION не может быть отрицательным")
+	}
+
+	saveFailThreshold, err := envInt("STATE_SAVE_FAIL_THRESHOLD", 3)
+	if err != nil {
+		return nil, err
+	}
+
+	failurePolicy := strings.ToLower(strings.TrimSpace(os.Getenv("ENABLE_FAILURE_POLICY")))
+	if failurePolicy == "" {
+		failurePolicy = enableFailurePolicyAlways
+	}
+	if failurePolicy != enableFailurePolicyAlways && failurePolicy != enableFailurePolicySuppress {
+		return nil, fmt.Errorf("неверное значение ENABLE_FAILURE_POLICY: %q (допустимо: always, suppress)", failurePolicy)
+	}
+	stateBackend := strings.ToLower(strings.TrimSpace(os.Getenv("STATE_BACKEND")))
+	if stateBackend == "" {
+		stateBackend = stateBackendFiles
+	}
+	if stateBackend != stateBackendFiles && stateBackend != stateBackendSQLite {
+		return nil, fmt.Errorf("неверное значение STATE_BACKEND: %q (допустимо: files, sqlite)", stateBackend)
+	}
+	var namePattern *regexp.Regexp
+	if raw := strings.TrimSpace(os.Getenv("MEDIA_NAME_PATTERN")); raw != "" {
+		if namePattern, err = regexp.Compile(raw); err != nil {
+			return nil, fmt.Errorf("неверный MEDIA_NAME_PATTERN %q: %v", raw, err)
+		}
+	}
+	failureRealert, err := envInt("ENABLE_FAILURE_REALERT", 60)
+	if err != nil {
+		return nil, err
+	}
+
+	maintenanceMax, err := envInt("MAINTENANCE_MAX_DURATION", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaNames := []string{}
+	if s := strings.TrimSpace(os.Getenv("MEDIA_NAMES")); s != "" {
+		for _, p := range strings.Split(s, ",") {
+			mediaNames = append(mediaNames, strings.TrimSpace(p))
+		}
+	}
+
+	apiRate := 0.0
+	if v := strings.TrimSpace(os.Getenv("API_RATE")); v != "" {
+		apiRate, err = strconv.ParseFloat(v, 64)
+		if err != nil || apiRate < 0 {
+			return nil, fmt.Errorf("неверный формат API_RATE: %q", v)
+		}
+	}
+
+	notifyRate := 0.0
+	if v := strings.TrimSpace(os.Getenv("NOTIFY_RATE")); v != "" {
+		notifyRate, err = strconv.ParseFloat(v, 64)
+		if err != nil || notifyRate < 0 {
+			return nil, fmt.Errorf("неверный формат NOTIFY_RATE: %q", v)
+		}
+	}
+	if (strings.TrimSpace(os.Getenv("TELEGRAM_BOT_TOKEN")) == "") != (strings.TrimSpace(os.Getenv("TELEGRAM_CHAT_ID")) == "") {
+		return nil, fmt.Errorf("для уведомлений в Telegram нужны и TELEGRAM_BOT_TOKEN, и TELEGRAM_CHAT_ID")
+	}
+
+	webhookURL := strings.TrimSpace(os.Getenv("GENERIC_WEBHOOK_URL"))
+	var webhookTemplate *template.Template
+	if webhookURL != "" {
+		if webhookTemplate, err = parseWebhookTemplate(os.Getenv("GENERIC_WEBHOOK_TEMPLATE")); err != nil {
+			return nil, fmt.Errorf("неверный GENERIC_WEBHOOK_TEMPLATE: %v", err)
+		}
+		if err := checkWebhookTemplate(webhookTemplate); err != nil {
+			return nil, err
+		}
+	}
 
-	state, err := loadState(cfg.StateFile)
+	mmMaxPerMinute, err := envInt("MM_MAX_PER_MINUTE", 20)
 	if err != nil {
-		logger.Warnf("Ошибка загрузки состояния: %v", err)
-		state = make(MediaState)
-	} else {
-		logger.Infof("Состояние загружено: %d записей", len(state))
+		return nil, err
+	}
+
+	notifyRetries, err := envInt("NOTIFY_RETRIES", 2)
+	if err != nil {
+		return nil, err
+	}
+	notifyBackoff, err := envInt("NOTIFY_BACKOFF", 1)
+	if err != nil {
+		return nil, err
+	}
+	notifyBackoffMax, err := envInt("NOTIFY_BACKOFF_MAX", 1800)
+	if err != nil {
+		return nil, err
+	}
+	spoolMaxAge, err := envInt("NOTIFY_SPOOL_MAX_AGE", 24)
+	if err != nil {
+		return nil, err
+	}
+	if notifyRetries < 0 || notifyBackoff <= 0 || notifyBackoffMax < notifyBackoff {
+		return nil, fmt.Errorf("NOTIFY_RETRIES не может быть отрицательным, NOTIFY_BACKOFF должен быть больше 0 и не больше NOTIFY_BACKOFF_MAX")
+	}
+	delivery, err := newDeliveryPipeline(strings.TrimSpace(os.Getenv("NOTIFY_SPOOL_FILE")), notifyRetries,
+		time.Duration(notifyBackoff)*time.Second, time.Duration(notifyBackoffMax)*time.Second,
+		time.Duration(spoolMaxAge)*time.Hour, notifyRate)
+	if err != nil {
+		return nil, fmt.Errorf("NOTIFY_SPOOL_FILE: %v", err)
+	}
+
+	reportRetention, err := envInt("GROUP_REPORT_RETENTION_DAYS", 90)
+	if err != nil {
+		return nil, err
 	}
 
-	groupState, groupStateExisted, err := loadGroupState(groupStateFilename)
-	if err != nil {
-		logger.Warnf("Ошибка загрузки состояния групп: %v", err)
-		groupState = make(GroupState)
-		groupStateExisted = false
-	} else {
-		if groupStateExisted {
-			logger.Infof("Состояние групп загружено: %d записей", len(groupState))
-		} else {
-			logger.Infof("Файл состояния групп не найден — при первой проверке будет создан baseline (уведомлений не будет)")
+	heartbeatInterval, err := envInt("HEARTBEAT_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	repeatInterval := defaultCooldowns[EventMediaStillDisabled]
+	if strings.TrimSpace(os.Getenv("NOTIFY_REPEAT_INTERVAL")) != "" {
+		if repeatInterval, err = envMinutes("NOTIFY_REPEAT_INTERVAL"); err != nil {
+			return nil, err
+		}
+		if repeatInterval < 0 {
+			return nil, fmt.Errorf("NOTIFY_REPEAT_INTERVAL не может быть отрицательным")
+		}
+	}
+	cooldowns, err := parseCooldowns(os.Getenv("NOTIFY_COOLDOWNS"), repeatInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	auditInterval, err := envInt("AUDIT_SUMMARY_INTERVAL", 0)
+	if err != nil {
+		return nil, err
+	}
+	if auditInterval < 0 {
+		return nil, fmt.Errorf("AUDIT_SUMMARY_INTERVAL не может быть отрицательным")
+	}
+	if auditInterval > 0 && strings.TrimSpace(os.Getenv("HISTORY_FILE")) == "" && strings.TrimSpace(os.Getenv("GROUP_REPORT_FILE")) == "" {
+		return nil, fmt.Errorf("для AUDIT_SUMMARY_INTERVAL нужен HISTORY_FILE или GROUP_REPORT_FILE — сводке не из чего собирать изменения")
+	}
+
+	vanishGrace, err := envInt("VANISH_GRACE", 3)
+	if err != nil {
+		return nil, err
+	}
+	if vanishGrace < 0 {
+		return nil, fmt.Errorf("VANISH_GRACE не может быть отрицательным")
+	}
+
+	autoEnableTag := envDefault("AUTO_ENABLE_TAG", "autoenable")
+	if autoEnableTag == "off" {
+		autoEnableTag = ""
+	}
+
+	historyEvents, err := parseHistoryEvents(os.Getenv("HISTORY_EVENTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	dmEvents := splitList(os.Getenv("MM_DM_EVENTS"))
+	if len(dmEvents) == 0 {
+		dmEvents = defaultDMEvents
+	}
+
+	annotateMax, err := envInt("ANNOTATE_ENABLE_MAX_LENGTH", 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	// LOG_MAX_SIZE_MB — синоним LOG_FILE_MAX_SIZE; если заданы оба, действует LOG_FILE_MAX_SIZE
+	logMaxSizeKey := "LOG_FILE_MAX_SIZE"
+	if strings.TrimSpace(os.Getenv(logMaxSizeKey)) == "" && strings.TrimSpace(os.Getenv("LOG_MAX_SIZE_MB")) != "" {
+		logMaxSizeKey = "LOG_MAX_SIZE_MB"
+	}
+	logMaxSize, err := envInt(logMaxSizeKey, 100)
+	if err != nil {
+		return nil, err
+	}
+	logMaxAge, err := envInt("LOG_FILE_MAX_AGE", 30)
+	if err != nil {
+		return nil, err
+	}
+	logMaxBackups, err := envInt("LOG_FILE_MAX_BACKUPS", 5)
+	if err != nil {
+		return nil, err
+	}
+
+	envThemes, err := parseEnvThemes(os.Getenv("ENVIRONMENT_THEMES"))
+	if err != nil {
+		return nil, err
+	}
+
+	quietBypass := defaultQuietBypass
+	if v, ok := os.LookupEnv("QUIET_HOURS_BYPASS"); ok {
+		quietBypass = splitList(v)
+	}
+	quiet, err := parseQuietHours(os.Getenv("QUIET_HOURS"), os.Getenv("QUIET_HOURS_TZ"), quietBypass)
+	if err != nil {
+		return nil, err
+	}
+	enableQuiet, err := parseEnableQuietHours(os.Getenv("ENABLE_QUIET_HOURS"), os.Getenv("ENABLE_QUIET_HOURS_TZ"))
+	if err != nil {
+		return nil, err
+	}
+
+	disableSchedule, err := parseDisableSchedule(os.Getenv("DISABLE_SCHEDULE"))
+	if err != nil {
+		return nil, err
+	}
+
+	syslogRoutes, err := parseSyslogRoutes(os.Getenv("SYSLOG_ROUTES"))
+	if err != nil {
+		return nil, err
+	}
+
+	startupDelay, err := envInt("STARTUP_DELAY", 0)
+	if err != nil {
+		return nil, err
+	}
+	startupReadyTimeout, err := envInt("STARTUP_READY_TIMEOUT", 300)
+	if err != nil {
+		return nil, err
+	}
+
+	cycleTimeout, err := envInt("CYCLE_TIMEOUT", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaConcurrency, err := envInt("MEDIA_CONCURRENCY", 4)
+	if err != nil || mediaConcurrency < 1 {
+		return nil, fmt.Errorf("неверный формат MEDIA_CONCURRENCY: %q (целое число от 1)", os.Getenv("MEDIA_CONCURRENCY"))
+	}
+
+	backoffAfter, err := envInt("ZABBIX_BACKOFF_AFTER", 3)
+	if err != nil || backoffAfter < 0 {
+		return nil, fmt.Errorf("неверный формат ZABBIX_BACKOFF_AFTER: %q", os.Getenv("ZABBIX_BACKOFF_AFTER"))
+	}
+	backoffFactor := 2.0
+	if v := strings.TrimSpace(os.Getenv("ZABBIX_BACKOFF_FACTOR")); v != "" {
+		backoffFactor, err = strconv.ParseFloat(v, 64)
+		if err != nil || backoffFactor < 1 {
+			return nil, fmt.Errorf("неверный формат ZABBIX_BACKOFF_FACTOR: %q (число не меньше 1)", v)
+		}
+	}
+	backoffMax := time.Hour
+	if strings.TrimSpace(os.Getenv("ZABBIX_BACKOFF_MAX")) != "" {
+		if backoffMax, err = envMinutes("ZABBIX_BACKOFF_MAX"); err != nil {
+			return nil, err
+		}
+	}
+	if backoffAfter > 0 && backoffMax < checkInterval {
+		// интервал при недоступном API не должен становиться короче обычного
+		backoffMax = checkInterval
+	}
+
+	groupWatchFields, err := parseGroupFields(os.Getenv("GROUP_WATCH_FIELDS"))
+	if err != nil {
+		return nil, err
+	}
+	mediaWatchFields, err := parseMediaWatchFields(os.Getenv("MEDIA_WATCH_FIELDS"))
+	if err != nil {
+		return nil, err
+	}
+
+	redirects := strings.ToLower(envDefault("HTTP_REDIRECTS", redirectPreserve))
+	if redirects != redirectPreserve && redirects != redirectSameHost {
+		return nil, fmt.Errorf("неверное значение HTTP_REDIRECTS: %q (допустимо: preserve, same-host)", redirects)
+	}
+
+	zabbixMaxRetries, err := envInt("ZABBIX_MAX_RETRIES", 3)
+	if err != nil {
+		return nil, err
+	}
+	if zabbixMaxRetries < 0 {
+		return nil, fmt.Errorf("ZABBIX_MAX_RETRIES не может быть отрицательным")
+	}
+
+	httpTimeout, err := envInt("HTTP_TIMEOUT", 30)
+	if err != nil {
+		return nil, err
+	}
+	if httpTimeout <= 0 {
+		return nil, fmt.Errorf("HTTP_TIMEOUT должен быть больше 0")
+	}
+
+	zabbixInsecure := envBool("ZABBIX_INSECURE_SKIP_VERIFY")
+	zabbixTLS, err := zabbixTLSConfig(strings.TrimSpace(os.Getenv("ZABBIX_CA_CERT")), strings.TrimSpace(os.Getenv("ZABBIX_CLIENT_CERT")),
+		strings.TrimSpace(os.Getenv("ZABBIX_CLIENT_KEY")), zabbixInsecure)
+	if err != nil {
+		return nil, err
+	}
+	zabbixProxy, err := parseProxyURL("ZABBIX_PROXY_URL", strings.TrimSpace(os.Getenv("ZABBIX_PROXY_URL")))
+	if err != nil {
+		return nil, err
+	}
+	zabbixClient := newHTTPClient(time.Duration(httpTimeout)*time.Second, zabbixTLS, zabbixProxy)
+	zabbixAuthMode := strings.ToLower(strings.TrimSpace(os.Getenv("ZABBIX_AUTH_MODE")))
+	if zabbixAuthMode == "" {
+		zabbixAuthMode = zabbixAuthField
+	}
+	if zabbixAuthMode != zabbixAuthField && zabbixAuthMode != zabbixAuthHeader {
+		return nil, fmt.Errorf("неверное значение ZABBIX_AUTH_MODE: %q (допустимо: field, header)", zabbixAuthMode)
+	}
+
+	policies, err := parseMediaPolicies(os.Getenv("MEDIA_POLICIES"), offDuration)
+	if err != nil {
+		return nil, err
+	}
+	mediaNames = mergeNames(mediaNames, policies)
+
+	healthAddr := envDefault("HEALTH_ADDR", ":8080")
+	if healthAddr == "off" {
+		healthAddr = ""
+	}
+	healthFailCycles, err := envInt("HEALTH_FAIL_CYCLES", 3)
+	if err != nil {
+		return nil, err
+	}
+	if healthFailCycles <= 0 {
+		return nil, fmt.Errorf("HEALTH_FAIL_CYCLES должен быть больше 0")
+	}
+	var health *healthState
+	if healthAddr != "" {
+		health = newHealthState(healthFailCycles)
+	}
+
+	servers, err := parseZabbixServers(os.Getenv("ZABBIX_API_URL"), os.Getenv("ZABBIX_API_TOKEN"), os.Getenv("ZABBIX_SERVER_NAMES"))
+	if err != nil {
+		return nil, err
+	}
+
+	offOverrides, err := parseOffDurationOverrides(os.Getenv("MEDIA_OFF_DURATION_OVERRIDES"))
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		ZabbixAPIURL:         servers[0].URL,
+		APIToken:             servers[0].Token,
+		servers:              servers,
+		health:               health,
+		ZabbixUser:           strings.TrimSpace(os.Getenv("ZABBIX_USER")),
+		ZabbixPassword:       os.Getenv("ZABBIX_PASSWORD"),
+		CheckInterval:        checkInterval,
+		OffDuration:          offDuration,
+		OffDurationOverrides: offOverrides,
+		MediaNames:           mediaNames,
+		ActionNames:          splitList(os.Getenv("ACTION_NAMES")),
+		StateFile:            envDefault("MEDIA_STATE_FILE", defaultStateFilename),
+		GroupStateFile:       envDefault("GROUP_STATE_FILE", defaultGroupStateFilename),
+		MattermostWebhook:    strings.TrimSpace(os.Getenv("MM_WEBHOOK_URL")),
+		MattermostUsername:   strings.TrimSpace(os.Getenv("MM_USERNAME")),
+		MattermostChannel:    strings.TrimSpace(os.Getenv("MM_CHANNEL")),
+		MattermostIcon:       strings.TrimSpace(os.Getenv("MM_ICON")),
+		StateCompact:         envBool("STATE_COMPACT"),
+		StateBackend:         stateBackend,
+		StateDSN:             envDefault("STATE_DSN", defaultStateDSN),
+		DryRun:               envBool("DRY_RUN"),
+		RunOnce:              envBool("RUN_ONCE"),
+
+		StateSaveFailThreshold: saveFailThreshold,
+		WatchMessageTemplates:  envBool("WATCH_MESSAGE_TEMPLATES"),
+		MediaWatchFields:       mediaWatchFields,
+		MonitorUsers:           envBool("MONITOR_USERS"),
+		EnableFailurePolicy:    failurePolicy,
+		EnableFailureRealert:   time.Duration(failureRealert) * time.Minute,
+		MaintenanceMaxDuration: time.Duration(maintenanceMax) * time.Minute,
+		MaintenanceAutoCleanup: envBool("MAINTENANCE_AUTO_CLEANUP"),
+		CloudEventsURL:         strings.TrimSpace(os.Getenv("CLOUDEVENTS_URL")),
+		CloudEventsSource:      envDefault("CLOUDEVENTS_SOURCE", "/zabbix-media-watcher"),
+		CloudEventsSecret:      os.Getenv("CLOUDEVENTS_SECRET"),
+		WebhookURL:             webhookURL,
+		WebhookContentType:     envDefault("GENERIC_WEBHOOK_CONTENT_TYPE", defaultWebhookContentType),
+		WebhookSecret:          os.Getenv("GENERIC_WEBHOOK_SECRET"),
+		webhookTemplate:        webhookTemplate,
+		Policies:               policies,
+		MaintenanceFile:        strings.TrimSpace(os.Getenv("MAINTENANCE_FILE")),
+		APIRate:                apiRate,
+		GroupReportFile:        strings.TrimSpace(os.Getenv("GROUP_REPORT_FILE")),
+		GroupReportRetention:   time.Duration(reportRetention) * 24 * time.Hour,
+		HTTPAddr:               strings.TrimSpace(os.Getenv("HTTP_ADDR")),
+		HealthAddr:             healthAddr,
+		MetricsAddr:            strings.TrimSpace(os.Getenv("METRICS_ADDR")),
+		MattermostThreads:      envBool("MM_THREADS"),
+		MattermostRich:         envBool("MM_RICH"),
+		MattermostMaxPerMinute: mmMaxPerMinute,
+		mmBucket:               newTokenBucket(mmMaxPerMinute, time.Minute),
+		mmThreads:              newThreadStore(),
+		mediaNames:             &mediaNameCache{},
+
+		HeartbeatInterval:        time.Duration(heartbeatInterval) * time.Minute,
+		HeartbeatIncludeProblems: envBool("HEARTBEAT_INCLUDE_PROBLEMS"),
+		NotifyCooldowns:          cooldowns,
+		throttle:                 newEventThrottle(),
+
+		MattermostURL:            strings.TrimRight(strings.TrimSpace(os.Getenv("MM_URL")), "/"),
+		MattermostBotToken:       strings.TrimSpace(os.Getenv("MM_BOT_TOKEN")),
+		TelegramBotToken:         strings.TrimSpace(os.Getenv("TELEGRAM_BOT_TOKEN")),
+		TelegramChatID:           strings.TrimSpace(os.Getenv("TELEGRAM_CHAT_ID")),
+		TelegramAPIURL:           strings.TrimRight(envDefault("TELEGRAM_API_URL", defaultTelegramAPIURL), "/"),
+		MattermostDMUsers:        splitList(os.Getenv("MM_DM_USERS")),
+		MattermostDMEvents:       dmEvents,
+		mmDM:                     newDMCache(),
+		WatchTag:                 strings.TrimSpace(os.Getenv("MEDIA_WATCH_TAG")),
+		MediaNamePattern:         namePattern,
+		AutoEnableTag:            autoEnableTag,
+		HTTPRedirects:            redirects,
+		HTTPTimeout:              time.Duration(httpTimeout) * time.Second,
+		ZabbixMaxRetries:         zabbixMaxRetries,
+		AnnotateEnable:           envBool("ANNOTATE_ENABLE"),
+		LogFile:                  strings.TrimSpace(os.Getenv("LOG_FILE")),
+		LogFileMaxSize:           int64(logMaxSize) * 1024 * 1024,
+		LogFileMaxAge:            time.Duration(logMaxAge) * 24 * time.Hour,
+		LogFileMaxBackups:        logMaxBackups,
+		AnnotateMaxLength:        annotateMax,
+		httpClient:               newHTTPClient(time.Duration(httpTimeout)*time.Second, nil, nil),
+		zabbixHTTPClient:         zabbixClient,
+		ZabbixInsecureSkipVerify: zabbixInsecure,
+		ZabbixAuthMode:           zabbixAuthMode,
+		apiLimiter:               newRateLimiter(apiRate),
+		Clock:                    realClock{},
+		FailFast:                 envBool("FAIL_FAST"),
+		GroupWatchFields:         groupWatchFields,
+		CycleTimeout:             time.Duration(cycleTimeout) * time.Second,
+		BackoffAfter:             backoffAfter,
+		BackoffFactor:            backoffFactor,
+		BackoffMax:               backoffMax,
+		Environment:              strings.TrimSpace(os.Getenv("ENVIRONMENT")),
+		BatchEnable:              envBool("BATCH_ENABLE"),
+		MediaConcurrency:         mediaConcurrency,
+		quiet:                    quiet,
+		enableQuiet:              enableQuiet,
+		ValidateEnabledMedia:     envBool("VALIDATE_ENABLED_MEDIA"),
+		StartupDelay:             time.Duration(startupDelay) * time.Second,
+		StartupWaitReady:         envBool("STARTUP_WAIT_READY"),
+		StartupReadyTimeout:      time.Duration(startupReadyTimeout) * time.Second,
+		DesiredStateDisable:      envBool("DESIRED_STATE_DISABLE"),
+		DisableSchedule:          disableSchedule,
+		SyslogRoutes:             syslogRoutes,
+		MonitorUserMedia:         envBool("MONITOR_USER_MEDIA"),
+		VanishGrace:              vanishGrace,
+		AuditSummaryInterval:     time.Duration(auditInterval) * time.Hour,
+		history:                  newHistoryLog(strings.TrimSpace(os.Getenv("HISTORY_FILE")), historyEvents),
+		auditLog:                 newAuditLog(strings.TrimSpace(os.Getenv("AUDIT_LOG_FILE"))),
+		EnvThemes:                envThemes,
+		delivery:                 delivery,
+		clockSteps:               &clockWatch{},
+		schedule:                 newCycleSchedule(checkInterval),
+	}
+
+	if envBool("SUPPRESS_DURING_PROBLEMS") {
+		minSeverity, err := envInt("SUPPRESS_MIN_SEVERITY", 4)
+		if err != nil {
+			return nil, err
+		}
+		cfg.suppressor, err = newProblemSuppressor(minSeverity, strings.TrimSpace(os.Getenv("SUPPRESS_PROBLEM_TAG")), strings.TrimSpace(os.Getenv("SUPPRESS_PROBLEM_NAME")))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if file := strings.TrimSpace(os.Getenv("DESIRED_STATE_FILE")); file != "" {
+		if cfg.desired, err = newDesiredState(file); err != nil {
+			return nil, fmt.Errorf("DESIRED_STATE_FILE: %v", err)
+		}
+	}
+
+	switch backend := strings.ToLower(envDefault("SECRETS_BACKEND", "env")); backend {
+	case "env":
+	case "vault":
+		if len(servers) > 1 || servers[0].Name != "" {
+			return nil, fmt.Errorf("SECRETS_BACKEND=vault поддерживает только один сервер Zabbix без ZABBIX_SERVER_NAMES")
+		}
+		if cfg.vault, err = newVaultClient(); err != nil {
+			return nil, err
+		}
+		if err := cfg.vault.fetch(context.Background(), cfg); err != nil {
+			return nil, fmt.Errorf("получение секретов из Vault: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("неверное значение SECRETS_BACKEND: %q (допустимо: env, vault)", backend)
+	}
+
+	// статический токен, в том числе из Vault, важнее логина и пароля
+	cfg.zabbixLogin = cfg.APIToken == "" && cfg.ZabbixUser != "" && cfg.ZabbixPassword != ""
+	if cfg.ZabbixUser != "" && cfg.ZabbixPassword == "" {
+		return nil, fmt.Errorf("ZABBIX_USER задан без ZABBIX_PASSWORD")
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	if warnings := cfg.resolutionWarnings(); len(warnings) > 0 && cfg.FailFast {
+		return nil, fmt.Errorf("%s (FAIL_FAST)", strings.Join(warnings, "; "))
+	}
+	return cfg, nil
+}
+
+// validate проверяет обязательные параметры, без которых первый же цикл упадёт на запросе
+// к API, и возвращает все найденные проблемы одной ошибкой
+func (cfg *Config) validate() error {
+	var problems []string
+	servers := cfg.servers
+	if len(servers) == 0 {
+		servers = []zabbixServer{{URL: cfg.ZabbixAPIURL, Token: cfg.APIToken}}
+	}
+	// токен из Vault уже в cfg.APIToken, а Vault допускается только с одним сервером
+	if len(servers) == 1 {
+		servers[0].Token = cfg.APIToken
+	}
+	for _, s := range servers {
+		if u, err := url.Parse(s.URL); s.URL == "" {
+			problems = append(problems, "ZABBIX_API_URL не задан")
+		} else if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("ZABBIX_API_URL %q — не http(s)-адрес", s.URL))
+		}
+		if s.Token == "" && !cfg.zabbixLogin {
+			problems = append(problems, fmt.Sprintf("для %s не задан ни ZABBIX_API_TOKEN, ни ZABBIX_USER с ZABBIX_PASSWORD", s.URL))
+		}
+	}
+	if cfg.CheckInterval <= 0 {
+		problems = append(problems, "MEDIA_CHECK_INTERVAL должен быть больше нуля")
+	}
+	if len(cfg.MediaNames) == 0 && cfg.WatchTag == "" && cfg.MediaNamePattern == nil {
+		// пустой фильтр mediatype.get вернул бы все медиа
+		problems = append(problems, "не задан MEDIA_NAMES (или MEDIA_WATCH_TAG, MEDIA_NAME_PATTERN, MEDIA_POLICIES) — отслеживались бы все медиа")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// resolutionWarnings сообщает о порогах отключения меньше интервала проверки:
+// медиа проверяется раз в CheckInterval, поэтому реальный момент включения наступает
+// на первой проверке после порога, т.е. через время от порога до порога+интервала.
+// Нулевой порог означает «включать на ближайшей проверке» и не считается ошибкой.
+func (cfg *Config) resolutionWarnings() []string {
+	var out []string
+	check := func(name string, off time.Duration) {
+		if off > 0 && off < cfg.CheckInterval {
+			out = append(out, fmt.Sprintf("%s: порог отключения %v меньше интервала проверки %v — фактически медиа будет включено через %v–%v после отключения",
+				name, off, cfg.CheckInterval, off, off+cfg.CheckInterval))
 		}
 	}
+	check("MEDIA_OFF_DURATION", cfg.OffDuration)
+	for _, p := range cfg.Policies {
+		check("политика "+p.Name, p.OffDuration)
+	}
+	for name, off := range cfg.OffDurationOverrides {
+		check("MEDIA_OFF_DURATION_OVERRIDES "+name, off)
+	}
+	return out
+}
 
-	for {
-		logger.Info("Начало цикла проверки медиа-типов")
-		processMediaTypes(cfg, state, logger, sysLogger)
-
-		baselineMode := !groupStateExisted
-		processUserGroups(cfg, groupState, logger, sysLogger, baselineMode)
+// envDefault читает строку из окружения, при пустом значении возвращает def
+func envDefault(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
 
-		if baselineMode {
-			groupStateExisted = true
+// splitList разбирает список через запятую, пропуская пустые элементы
+func splitList(s string) []string {
+	out := []string{}
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
 		}
-
-		logger.Infof("Ожидание следующей проверки через %v", cfg.CheckInterval)
-		time.Sleep(cfg.CheckInterval)
 	}
+	return out
 }
 
-func loadConfig() (*Config, error) {
-	_ = godotenv.Load()
-
-	checkInterval, err := strconv.Atoi(os.Getenv("MEDIA_CHECK_INTERVAL"))
+// envInt читает целое из окружения, при пустом значении возвращает def
+func envInt(key string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
 	if err != nil {
-		return nil, fmt.Errorf("неверный формат MEDIA_CHECK_INTERVAL: %v", err)
+		return 0, fmt.Errorf("неверный формат %s: %v", key, err)
 	}
+	return n, nil
+}
 
-	offDuration, err := strconv.Atoi(os.Getenv("MEDIA_OFF_DURATION"))
+// envMinutes читает длительность: целое число — минуты (как раньше), иначе строка Go ("30s", "5m", "2h")
+func envMinutes(key string) (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Minute, nil
+	}
+	d, err := time.ParseDuration(v)
 	if err != nil {
-		return nil, fmt.Errorf("неверный формат MEDIA_OFF_DURATION: %v", err)
+		return 0, fmt.Errorf("неверный формат %s: %q (минуты числом или длительность вида 30s, 5m, 2h)", key, v)
 	}
+	return d, nil
+}
 
-	mediaNames := []string{}
-	if s := strings.TrimSpace(os.Getenv("MEDIA_NAMES")); s != "" {
-		for _, p := range strings.Split(s, ",") {
-			mediaNames = append(mediaNames, strings.TrimSpace(p))
-		}
-	}
+// envBool читает булев флаг из окружения ("1", "true" и т.п.), пустое/невалидное значение — false
+func envBool(key string) bool {
+	v, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(key)))
+	return err == nil && v
+}
 
-	return &Config{
-		ZabbixAPIURL:      strings.TrimRight(os.Getenv("ZABBIX_API_URL"), "/"),
-		APIToken:          os.Getenv("ZABBIX_API_TOKEN"),
-		CheckInterval:     time.Duration(checkInterval) * time.Minute,
-		OffDuration:       time.Duration(offDuration) * time.Minute,
-		MediaNames:        mediaNames,
-		StateFile:         "media_state.json",
-		MattermostWebhook: strings.TrimSpace(os.Getenv("MM_WEBHOOK_URL")),
-	}, nil
+// marshalState сериализует состояние: по умолчанию с отступами, при STATE_COMPACT — в одну строку
+func marshalState(v interface{}, compact bool) ([]byte, error) {
+	if compact {
+		return json.Marshal(v)
+	}
+	return json.MarshalIndent(v, "", "  ")
 }
 
-func loadState(filename string) (MediaState, error) {
+// loadState читает файл состояния медиа. migrated — файл в прежнем формате (id -> время
+// обнаружения) и переведён в записи MediaRecord; его стоит пересохранить.
+func loadState(filename string) (MediaState, bool, error) {
 	state := make(MediaState)
 	file, err := os.Open(filename)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return state, nil
+			return state, false, nil
 		}
-		return nil, err
+		return nil, false, err
 	}
 	defer file.Close()
 	data, err := io.ReadAll(file)
 	if err != nil || len(data) == 0 {
-		return state, err
+		return state, false, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, false, err
+	}
+	migrated := false
+	for id, value := range raw {
+		rec := &MediaRecord{}
+		if len(value) > 0 && value[0] == '"' {
+			// прежний формат: id -> время первого обнаружения
+			if err := json.Unmarshal(value, &rec.FirstSeen); err != nil {
+				return nil, false, fmt.Errorf("медиа %s: %v", id, err)
+			}
+			migrated = true
+		} else if err := json.Unmarshal(value, rec); err != nil {
+			return nil, false, fmt.Errorf("медиа %s: %v", id, err)
+		}
+		state[id] = rec
 	}
-	return state, json.Unmarshal(data, &state)
+	return state, migrated, nil
 }
 
-func saveState(filename string, state MediaState, logger *logrus.Logger) error {
-	data, err := json.MarshalIndent(state, "", "  ")
+// saveState готовит файл состояния к записи в конце цикла
+func saveState(commit *cycleCommit, filename string, state MediaState, compact bool) error {
+	data, err := marshalState(state, compact)
 	if err != nil {
 		return err
 	}
-	if err = os.WriteFile(filename, data, 0644); err != nil {
-		return err
-	}
-	logger.Infof("Состояние сохранено в %s", filename)
+	commit.Stage(filename, data)
 	return nil
 }
 
-func processMediaTypes(cfg *Config, state MediaState, logger *logrus.Logger, sysLogger *syslog.Writer) {
-	mediaTypes, err := getMediaTypes(cfg, logger)
+// processMediaTypes обрабатывает полученные из Zabbix медиа (err — ошибка их получения) и возвращает
+// итог цикла; при ошибке получения или пустом списке MediaTypes в нём nil. vanished — состояние
+// пропавших медиа, nil без VANISH_GRACE.
+func processMediaTypes(ctx context.Context, cfg *Config, mediaTypes []MediaType, err error, store StateStore, state MediaState, failures EnableFailures, vanished VanishState, commit *cycleCommit, logger *logrus.Logger, sysLogger *syslog.Writer) CycleResult {
 	if err != nil {
 		logger.Errorf("Ошибка получения медиа-типов: %v", err)
-		return
+		cfg.health.Failure(cfg.ServerName, err)
+		return CycleResult{Errors: []error{fmt.Errorf("получение медиа-типов: %v", err)}, FetchError: err}
 	}
+	cfg.health.Success(cfg.ServerName, cfg.Clock.Now())
+	mediaTypes = selectWatchedMedia(cfg, mediaTypes, logger)
+	warnMissingMedia(ctx, cfg, mediaTypes, logger)
 	if len(mediaTypes) == 0 {
 		logger.Warning("Не получено ни одного медиа-типа для обработки")
-		return
+		return CycleResult{}
 	}
-	currentTime := time.Now()
-	stateChanged := false
-	foundDisabled := false
-	for _, media := range mediaTypes {
-		logEntry := logger.WithFields(logrus.Fields{
-			"media_id":   media.MediaTypeID,
-			"media_name": media.Name,
-			"status":     media.Status,
-		})
-		logEntry.Info("Проверка медиа")
-		if media.Status == "1" {
-			foundDisabled = true
-			firstSeen, exists := state[media.MediaTypeID]
-			if !exists {
-				state[media.MediaTypeID] = currentTime
-				stateChanged = true
-				logEntry.WithField("action", "state_recorded").Warn("Обнаружено отключённое медиа")
-				if sysLogger != nil {
-					_ = sysLogger.Warning(fmt.Sprintf("Обнаружено выключенное media: id=%s name=%s", media.MediaTypeID, media.Name))
-				}
-				if cfg.MattermostWebhook != "" {
-					remaining := cfg.OffDuration - time.Since(currentTime)
-					msg := fmt.Sprintf("Обнаружено отключенное медиа: %s\nБудет автоматически включено через: %s",
-						media.Name, remaining.Round(time.Minute))
-					sendMattermostNotification(cfg, msg, logger)
-				}
-			} else {
-				disabledDuration := currentTime.Sub(firstSeen)
-				logEntry = logEntry.WithField("disabled_duration", disabledDuration.Round(time.Second))
-				if disabledDuration >= cfg.OffDuration {
-					logEntry.Warn("Медиа отключено дольше разрешённого времени")
-					if sysLogger != nil {
-						_ = sysLogger.Warning(fmt.Sprintf("Media id=%s name=%s отключено %v — превышен порог %v", media.MediaTypeID, media.Name, disabledDuration.Round(time.Second), cfg.OffDuration))
-					}
-
-					err := enableMediaType(cfg, media.MediaTypeID, logger)
-					if err != nil {
-						logEntry.WithError(err).Error("Ошибка включения медиа")
-						if cfg.MattermostWebhook != "" {
-							msg := fmt.Sprintf("Ошибка включения медиа: %s\nОшибка: %v", media.Name, err)
-							sendMattermostNotification(cfg, msg, logger)
-						}
-					} else {
-						logEntry.Info("Медиа успешно включено")
-						if sysLogger != nil {
-							_ = sysLogger.Info(fmt.Sprintf("Скрипт включил media id=%s name=%s", media.MediaTypeID, media.Name))
-						}
-						if cfg.MattermostWebhook != "" {
-							msg := fmt.Sprintf("Медиа %s было автоматически включено скриптом.", media.Name)
-							sendMattermostNotification(cfg, msg, logger)
-						}
-						delete(state, media.MediaTypeID)
-						stateChanged = true
-					}
-				} else {
-					logEntry.Info("Медиа отключено, но ещё не превышен лимит времени")
-					if cfg.MattermostWebhook != "" && time.Since(firstSeen).Minutes() >= 30 {
-						remaining := cfg.OffDuration - disabledDuration
-						msg := fmt.Sprintf("Медиа отключено: %s\nОтключено: %s назад\nАвтоматическое включение через: %s",
-							media.Name, disabledDuration.Round(time.Minute), remaining.Round(time.Minute))
-						sendMattermostNotification(cfg, msg, logger)
-					}
+	if cfg.VanishGrace > 0 {
+		// до очистки состояния: удалять можно только записи, пропажу которых processVanished подтвердил
+		processVanished(ctx, cfg, mediaTypes, vanished, commit, logger, sysLogger)
+	}
+	// пустой ответ mediatype.get бывает и при временном сбое API: очистка по нему стёрла бы всё
+	// состояние, поэтому записи удаляются, только если API вернул хотя бы одно медиа
+	var pruneErr error
+	if len(mediaTypes) > 0 {
+		pruned, vanishChanged := pruneVanishedRecords(ctx, cfg, mediaTypes, state, failures, vanished, logger)
+		if pruned {
+			pruneErr = store.SaveMedia(commit, state)
+		}
+		// очистка дополняет состояние пропавших после processVanished — в коммит идёт итоговое
+		if vanishChanged {
+			saveVanishState(cfg, vanished, commit, logger)
+		}
+	}
+	result := handleMediaTypes(ctx, cfg, mediaTypes, store, state, failures, commit, logger, sysLogger)
+	if pruneErr != nil {
+		logger.Errorf("Ошибка сохранения состояния: %v", pruneErr)
+		result.Errors = append(result.Errors, fmt.Errorf("сохранение состояния: %v", pruneErr))
+	}
+	result.MediaTypes = mediaTypes
+	updateMediaGauges(cfg, result)
+	return result
+}
+
+// pendingEnable — медиа, отложенное до пакетного включения (BATCH_ENABLE)
+type pendingEnable struct {
+	Media       MediaType
+	Policy      MediaPolicy
+	DisabledFor time.Duration
+}
+
+// mediaCycle — общие данные обхода медиа за цикл. Медиа проверяются несколькими воркерами,
+// поэтому состояние, ошибки включения, пакет BATCH_ENABLE и отметки подавления меняются только под mu.
+type mediaCycle struct {
+	cfg        *Config
+	logger     *logrus.Logger
+	sysLogger  *syslog.Writer
+	now        time.Time
+	suppressed func(MediaType) (string, bool)
+
+	mu       sync.Mutex
+	state    MediaState
+	failures EnableFailures
+	batch    []pendingEnable
+
+	stateChanged  atomic.Bool
+	foundDisabled atomic.Bool
+}
+
+func (mc *mediaCycle) record(id string) (*MediaRecord, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	rec, ok := mc.state[id]
+	return rec, ok
+}
+
+func (mc *mediaCycle) track(id string, rec *MediaRecord) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.state[id] = rec
+}
+
+// untrack удаляет медиа из состояния и сообщает, было ли оно там
+func (mc *mediaCycle) untrack(id string) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	_, ok := mc.state[id]
+	delete(mc.state, id)
+	return ok
+}
+
+func (mc *mediaCycle) clearFailure(id string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.failures, id)
+}
+
+func (mc *mediaCycle) shouldNotifyFailure(id string, err error, now time.Time) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.failures.shouldNotify(mc.cfg, id, err, now)
+}
+
+// markSuppressed отмечает, что об отложенном медиа уже сообщено; false — отметка уже была
+func (mc *mediaCycle) markSuppressed(id string) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.cfg.suppressor.notified[id] {
+		return false
+	}
+	mc.cfg.suppressor.notified[id] = true
+	return true
+}
+
+// unmarkSuppressed снимает отметку подавления и сообщает, была ли она
+func (mc *mediaCycle) unmarkSuppressed(id string) bool {
+	if mc.cfg.suppressor == nil {
+		return false
+	}
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	ok := mc.cfg.suppressor.notified[id]
+	delete(mc.cfg.suppressor.notified, id)
+	return ok
+}
+
+// mediaLess — порядок обработки медиа: по имени, при совпадении — по id
+func mediaLess(a, b MediaType) bool {
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.MediaTypeID < b.MediaTypeID
+}
+
+func sortMediaTypes(list []MediaType) {
+	sort.SliceStable(list, func(i, j int) bool { return mediaLess(list[i], list[j]) })
+}
+
+// handleMediaTypes применяет логику отслеживания к уже полученному списку медиа.
+// Медиа проверяются параллельно (до MEDIA_CONCURRENCY одновременно), чтобы медленное включение
+// одного не задерживало остальные; раздаются и попадают в итог цикла они в порядке имён.
+func handleMediaTypes(ctx context.Context, cfg *Config, mediaTypes []MediaType, store StateStore, state MediaState, failures EnableFailures, commit *cycleCommit, logger *logrus.Logger, sysLogger *syslog.Writer) CycleResult {
+	var result CycleResult
+	mc := &mediaCycle{
+		cfg:       cfg,
+		logger:    logger,
+		sysLogger: sysLogger,
+		now:       cfg.Clock.Now(),
+		state:     state,
+		failures:  failures,
+	}
+	if step := cfg.clockSteps.Step(time.Now()); step != 0 && len(state) > 0 {
+		logger.WithField("clock_step", step.Round(time.Second)).Warnf("Системное время переведено на %v — время отключения медиа пересчитано", step.Round(time.Second))
+		shiftState(state, step)
+		mc.stateChanged.Store(true)
+	}
+	mc.suppressed = cfg.suppressor.forCycle(ctx, cfg, logger)
+
+	sorted := append([]MediaType(nil), mediaTypes...)
+	sortMediaTypes(sorted)
+	outcomes := make([]*MediaOutcome, len(sorted))
+	workers := cfg.MediaConcurrency
+	if workers > len(sorted) {
+		workers = len(sorted)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if o, ok := mc.checkMedia(ctx, sorted[j]); ok {
+					outcomes[j] = &o
 				}
 			}
-		} else if _, exists := state[media.MediaTypeID]; exists {
-			delete(state, media.MediaTypeID)
-			stateChanged = true
-			logEntry.Info("Медиа включено - удалено из состояния")
-			if cfg.MattermostWebhook != "" {
-				msg := fmt.Sprintf("Медиа восстановлено: %s", media.Name)
-				sendMattermostNotification(cfg, msg, logger)
+		}()
+	}
+	for j := range sorted {
+		jobs <- j
+	}
+	close(jobs)
+	wg.Wait()
+	for _, o := range outcomes {
+		if o != nil {
+			result.add(*o)
+		}
+	}
+
+	// при BATCH_ENABLE медиа к включению копятся и включаются одним запросом после обхода
+	if batch := mc.batch; len(batch) > 0 {
+		sort.SliceStable(batch, func(i, j int) bool { return mediaLess(batch[i].Media, batch[j].Media) })
+		results := enableMediaTypesBatch(ctx, cfg, batch, mc.now, logger)
+		for _, p := range batch {
+			logEntry := logger.WithFields(logrus.Fields{
+				"media_id":          p.Media.MediaTypeID,
+				"media_name":        p.Media.Name,
+				"policy":            p.Policy.Name,
+				"disabled_duration": p.DisabledFor.Round(time.Second),
+			})
+			if mc.finishEnable(ctx, p.Media, p.Policy, p.DisabledFor, results[p.Media.MediaTypeID], logEntry) {
+				mc.stateChanged.Store(true)
 			}
+			o := MediaOutcome{MediaID: p.Media.MediaTypeID, MediaName: p.Media.Name, DisabledFor: p.DisabledFor}
+			o.Outcome, o.Error = enableOutcome(results[p.Media.MediaTypeID])
+			result.add(o)
 		}
 	}
-	if !foundDisabled {
+	if !mc.foundDisabled.Load() {
 		logger.Info("Все отслеживаемые медиа включены")
 	}
-	if stateChanged {
-		if err := saveState(cfg.StateFile, state, logger); err != nil {
+	if mc.stateChanged.Load() {
+		if err := store.SaveMedia(commit, state); err != nil {
 			logger.Errorf("Ошибка сохранения состояния: %v", err)
+			result.Errors = append(result.Errors, fmt.Errorf("сохранение состояния: %v", err))
 		}
 	}
+	return result
 }
 
-func getMediaTypes(cfg *Config, logger *logrus.Logger) ([]MediaType, error) {
-	requestBody := ZabbixRequest{
-		JSONRPC: "2.0",
-		Method:  "mediatype.get",
-		Params: map[string]interface{}{
-			"output": []string{"mediatypeid", "name", "status"},
-			"filter": map[string]interface{}{
-				"name": cfg.MediaNames,
-			},
-		},
-		Auth: cfg.APIToken,
-		ID:   1,
+// mediaVerdict — решение по медиа в текущем цикле
+type mediaVerdict struct {
+	Media       MediaType
+	Outcome     string // исход, к которому приведёт цикл; outcomeAutoEnabled — медиа будет включаться
+	Policy      MediaPolicy
+	DisabledFor time.Duration
+	Tracked     bool
+	Reason      string // открытая проблема, из-за которой автовключение отложено
+}
+
+// decideMedia решает, что цикл сделает с медиа, ничего не меняя: по этому решению действует
+// checkMedia, его же показывает --preview-enables. rec — запись состояния, nil — медиа не отслеживается.
+func decideMedia(cfg *Config, media MediaType, rec *MediaRecord, now time.Time, suppressed func(MediaType) (string, bool), logger *logrus.Logger) mediaVerdict {
+	v := mediaVerdict{Media: media, Tracked: rec != nil}
+	if cfg.desired.Manages(media.Name) || (media.Status == mediaStatusDisabled && cfg.inDisableWindow(media.Name, now)) {
+		// состоянием медиа управляет DESIRED_STATE_FILE или расписание выключения
+		v.Outcome = outcomeManaged
+		return v
 	}
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return nil, err
+	v.Policy = cfg.mediaPolicy(media, logger)
+	switch {
+	case media.Status != mediaStatusDisabled && rec != nil:
+		v.Outcome = outcomeRestored
+		return v
+	case media.Status != mediaStatusDisabled:
+		v.Outcome = outcomeOK
+		return v
+	case rec == nil:
+		v.Outcome = outcomeDetected
+		if v.Policy.AutoEnable {
+			v.Reason, _ = suppressed(media)
+		}
+		return v
+	}
+	// время из будущего или пустое — отсчёт начнётся заново
+	if !rec.FirstSeen.IsZero() && !rec.FirstSeen.After(now) {
+		v.DisabledFor = now.Sub(rec.FirstSeen)
+	}
+	if reason, ok := suppressed(media); ok {
+		v.Outcome, v.Reason = outcomeSuppressed, reason
+		return v
+	}
+	switch {
+	case v.DisabledFor < v.Policy.OffDuration:
+		v.Outcome = outcomeWaiting
+	case !v.Policy.AutoEnable:
+		v.Outcome = outcomeNoAutoEnable
+	case cfg.DryRun:
+		v.Outcome = outcomeDryRun
+	case cfg.enableQuiet.Active(now):
+		v.Outcome = outcomeQuietHours
+	default:
+		v.Outcome = outcomeAutoEnabled
+	}
+	return v
+}
+
+// checkMedia проверяет одно медиа и возвращает его исход; false — медиа отложено
+// до пакетного включения (BATCH_ENABLE) и исход станет известен после него
+func (mc *mediaCycle) checkMedia(ctx context.Context, media MediaType) (MediaOutcome, bool) {
+	cfg, logger, sysLogger, currentTime := mc.cfg, mc.logger, mc.sysLogger, mc.now
+	rec, _ := mc.record(media.MediaTypeID)
+	v := decideMedia(cfg, media, rec, currentTime, mc.suppressed, logger)
+	if v.Outcome == outcomeManaged {
+		if mc.untrack(media.MediaTypeID) {
+			mc.stateChanged.Store(true)
+		}
+		return MediaOutcome{MediaID: media.MediaTypeID, MediaName: media.Name, Outcome: outcomeManaged}, true
+	}
+	policy := v.Policy
+	outcome := MediaOutcome{MediaID: media.MediaTypeID, MediaName: media.Name, Outcome: outcomeOK}
+	logEntry := logger.WithFields(logrus.Fields{
+		"media_id":   media.MediaTypeID,
+		"media_name": media.Name,
+		"status":     media.Status,
+		"policy":     policy.Name,
+	})
+	logEntry.Info("Проверка медиа")
+	switch v.Outcome {
+	case outcomeOK:
+		return outcome, true
+	case outcomeRestored:
+		if !mc.untrack(media.MediaTypeID) {
+			return outcome, true
+		}
+		mc.clearFailure(media.MediaTypeID)
+		cfg.throttle.Forget(media.MediaTypeID)
+		mc.stateChanged.Store(true)
+		logEntry.Info("Медиа включено - удалено из состояния")
+		notify(ctx, cfg, Event{
+			Type:      EventMediaRestored,
+			MediaID:   media.MediaTypeID,
+			MediaName: media.Name,
+			Channel:   policy.Channel,
+			Message:   fmt.Sprintf("Медиа восстановлено: %s", media.Name),
+		}, logger)
+		outcome.Outcome = outcomeRestored
+		return outcome, true
+	}
+
+	mc.foundDisabled.Store(true)
+	if v.Outcome == outcomeDetected {
+		rec = &MediaRecord{FirstSeen: currentTime, Name: media.Name}
+		mc.track(media.MediaTypeID, rec)
+		mc.stateChanged.Store(true)
+		mediaDisabledTotal.WithLabelValues(cfg.ServerName, cfg.mediaLabel(media.Name)).Inc()
+		logEntry.WithField("action", "state_recorded").Warn("Обнаружено отключённое медиа")
+		if sysLogger != nil {
+			_ = sysLogger.Warning(fmt.Sprintf("Обнаружено выключенное media: id=%s name=%s", media.MediaTypeID, media.Name))
+		}
+		// Медиа только что записано в state, поэтому до включения остаётся весь порог
+		// именно этого медиа (с учётом политики), а не глобальный MEDIA_OFF_DURATION
+		msg := fmt.Sprintf("Обнаружено отключенное медиа: %s\nБудет автоматически включено через: %s",
+			media.Name, policy.OffDuration.Round(time.Minute))
+		if !policy.AutoEnable {
+			msg = fmt.Sprintf("Обнаружено отключенное медиа: %s\nАвтоматическое включение отключено %s",
+				media.Name, policy.autoEnableSource())
+		} else if v.Reason != "" {
+			msg += fmt.Sprintf("\nНапоминания и автовключение отложены: %s", v.Reason)
+			mc.markSuppressed(media.MediaTypeID)
+		}
+		logEntry.WithFields(logrus.Fields{"threshold": policy.OffDuration, "threshold_source": policy.offDurationSource()}).Info("Применён порог отключения")
+		if notify(ctx, cfg, Event{
+			Type:      EventMediaDisabled,
+			MediaID:   media.MediaTypeID,
+			MediaName: media.Name,
+			Threshold: policy.OffDuration,
+			Channel:   policy.Channel,
+			Message:   msg,
+		}, logger) {
+			rec.notified(currentTime)
+		}
+		// первое напоминание — не раньше чем через кулдаун после обнаружения
+		cfg.throttle.Mark(media.MediaTypeID, EventMediaStillDisabled, currentTime)
+		outcome.Outcome = outcomeDetected
+		return outcome, true
+	}
+
+	if rec.Name != media.Name {
+		rec.Name = media.Name
+		mc.stateChanged.Store(true)
+	}
+	if rec.FirstSeen.IsZero() || rec.FirstSeen.After(currentTime) {
+		// время из будущего или пустое — после перевода часов или повреждения файла состояния;
+		// отсчёт начинается заново, чтобы не включить медиа раньше срока
+		logEntry.WithField("first_seen", rec.FirstSeen).Warn("Некорректное время обнаружения отключения — отсчёт начат заново")
+		rec.FirstSeen = currentTime
+		mc.stateChanged.Store(true)
+	}
+	disabledDuration := v.DisabledFor
+	outcome.DisabledFor = disabledDuration
+	outcome.Outcome = v.Outcome
+	logEntry = logEntry.WithFields(logrus.Fields{
+		"disabled_duration": disabledDuration.Round(time.Second),
+		"threshold":         policy.OffDuration,
+		"threshold_source":  policy.offDurationSource(),
+	})
+	if v.Outcome == outcomeSuppressed {
+		logEntry.WithField("reason", v.Reason).Info("Медиа выключено во время проблемы — напоминание и автовключение отложены")
+		if mc.markSuppressed(media.MediaTypeID) {
+			if notify(ctx, cfg, Event{
+				Type:        EventMediaSuppressed,
+				MediaID:     media.MediaTypeID,
+				MediaName:   media.Name,
+				DisabledFor: disabledDuration,
+				Threshold:   policy.OffDuration,
+				Channel:     policy.Channel,
+				Message: fmt.Sprintf("Медиа отключено: %s\nОтключено: %s назад\nНапоминания и автовключение отложены: %s",
+					media.Name, disabledDuration.Round(time.Minute), v.Reason),
+			}, logger) {
+				rec.notified(currentTime)
+				mc.stateChanged.Store(true)
+			}
+		}
+		return outcome, true
+	}
+	if mc.unmarkSuppressed(media.MediaTypeID) {
+		logEntry.Info("Подходящих проблем больше нет — обычная обработка медиа возобновлена")
 	}
-	resp, err := http.Post(cfg.ZabbixAPIURL+"/api_jsonrpc.php", "application/json", bytes.NewBuffer(jsonData))
+
+	switch v.Outcome {
+	case outcomeWaiting:
+		logEntry.Info("Медиа отключено, но ещё не превышен лимит времени")
+		remaining := policy.OffDuration - disabledDuration
+		if notify(ctx, cfg, Event{
+			Type:        EventMediaStillDisabled,
+			MediaID:     media.MediaTypeID,
+			MediaName:   media.Name,
+			DisabledFor: disabledDuration,
+			Threshold:   policy.OffDuration,
+			Channel:     policy.Channel,
+			Message: fmt.Sprintf("Медиа отключено: %s\nОтключено: %s назад\nАвтоматическое включение через: %s",
+				media.Name, disabledDuration.Round(time.Minute), remaining.Round(time.Minute)),
+		}, logger) {
+			rec.notified(currentTime)
+			mc.stateChanged.Store(true)
+		}
+		return outcome, true
+	case outcomeNoAutoEnable:
+		logEntry.WithField("auto_enable_by", policy.autoEnableSource()).Warn("Медиа отключено дольше порога, автовключение отключено")
+		if notify(ctx, cfg, Event{
+			Type:        EventMediaStillDisabled,
+			MediaID:     media.MediaTypeID,
+			MediaName:   media.Name,
+			DisabledFor: disabledDuration,
+			Threshold:   policy.OffDuration,
+			Channel:     policy.Channel,
+			Message: fmt.Sprintf("Медиа отключено: %s\nОтключено: %s назад\nАвтоматическое включение отключено %s",
+				media.Name, disabledDuration.Round(time.Minute), policy.autoEnableSource()),
+		}, logger) {
+			rec.notified(currentTime)
+			mc.stateChanged.Store(true)
+		}
+		return outcome, true
+	}
+
+	logEntry.Warn("Медиа отключено дольше разрешённого времени")
+	if sysLogger != nil {
+		_ = sysLogger.Warning(fmt.Sprintf("Media id=%s name=%s отключено %v — превышен порог %v", media.MediaTypeID, media.Name, disabledDuration.Round(time.Second), policy.OffDuration))
+	}
+	switch v.Outcome {
+	case outcomeDryRun:
+		// медиа остаётся в состоянии, чтобы пробный режим продолжал о нём сообщать
+		logEntry.Infof("[DRY-RUN] Медиа %s было бы включено", media.Name)
+		if notify(ctx, cfg, Event{
+			Type:        EventMediaStillDisabled,
+			MediaID:     media.MediaTypeID,
+			MediaName:   media.Name,
+			DisabledFor: disabledDuration,
+			Threshold:   policy.OffDuration,
+			Channel:     policy.Channel,
+			Message: fmt.Sprintf("Медиа отключено: %s\nОтключено: %s назад\nБыло бы включено автоматически (пробный режим)",
+				media.Name, disabledDuration.Round(time.Minute)),
+		}, logger) {
+			rec.notified(currentTime)
+			mc.stateChanged.Store(true)
+			// в журнал — то, что произошло бы без пробного режима (запись помечается simulated)
+			cfg.history.Record(cfg, Event{
+				Type:        EventMediaAutoEnabled,
+				MediaID:     media.MediaTypeID,
+				MediaName:   media.Name,
+				DisabledFor: disabledDuration,
+				Time:        currentTime,
+				Message:     fmt.Sprintf("[DRY-RUN] Медиа %s было бы включено автоматически", media.Name),
+			}, logger)
+		}
+		return outcome, true
+	case outcomeQuietHours:
+		// медиа остаётся в состоянии и будет включено первым циклом после окна
+		logEntry.WithField("enable_quiet_hours", cfg.enableQuiet.spec).Warn("Автовключение подавлено: действует окно ENABLE_QUIET_HOURS")
+		if notify(ctx, cfg, Event{
+			Type:        EventMediaStillDisabled,
+			MediaID:     media.MediaTypeID,
+			MediaName:   media.Name,
+			DisabledFor: disabledDuration,
+			Threshold:   policy.OffDuration,
+			Channel:     policy.Channel,
+			Message: fmt.Sprintf("Медиа отключено: %s\nОтключено: %s назад\nАвтоматическое включение отложено до конца окна %s",
+				media.Name, disabledDuration.Round(time.Minute), cfg.enableQuiet.spec),
+		}, logger) {
+			rec.notified(currentTime)
+			mc.stateChanged.Store(true)
+		}
+		return outcome, true
+	}
+
+	if cfg.BatchEnable {
+		// исход станет известен после пакетного включения
+		mc.mu.Lock()
+		mc.batch = append(mc.batch, pendingEnable{Media: media, Policy: policy, DisabledFor: disabledDuration})
+		mc.mu.Unlock()
+		return MediaOutcome{}, false
+	}
+	err := enableMediaType(ctx, cfg, media, currentTime, logger)
+	if mc.finishEnable(ctx, media, policy, disabledDuration, err, logEntry) {
+		mc.stateChanged.Store(true)
+	}
+	outcome.Outcome, outcome.Error = enableOutcome(err)
+	return outcome, true
+}
+
+func enableOutcome(err error) (string, error) {
 	if err != nil {
+		return outcomeEnableFailed, err
+	}
+	return outcomeAutoEnabled, nil
+}
+
+func getMediaTypes(ctx context.Context, cfg *Config, logger *logrus.Logger) ([]MediaType, error) {
+	var result []MediaType
+	if err := callZabbix(ctx, cfg, mediaTypesRequest(cfg), &result, logger); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-	var response struct {
-		Result []MediaType `json:"result"`
-		Error  struct {
-			Code    int    `json:"code"`
-			Message string `json:"message"`
-			Data    string `json:"data"`
-		} `json:"error"`
+	logger.Infof("Получено %d медиа-типов", len(result))
+	return result, nil
+}
+
+// cycleFetch — медиа и группы, полученные за цикл, с ошибками получения
+type cycleFetch struct {
+	MediaTypes []MediaType
+	MediaErr   error
+	Groups     GroupState
+	GroupErr   error
+}
+
+// fetchMediaAndGroups получает медиа и пользовательские группы одним пакетным запросом
+func fetchMediaAndGroups(ctx context.Context, cfg *Config, logger *logrus.Logger) cycleFetch {
+	var mediaTypes []MediaType
+	var groups []userGroupResult
+	errs := callZabbixBatch(ctx, cfg, []ZabbixRequest{mediaTypesRequest(cfg), userGroupsRequest(cfg)}, []interface{}{&mediaTypes, &groups}, logger)
+	f := cycleFetch{MediaErr: errs[0], GroupErr: errs[1]}
+	if f.MediaErr == nil {
+		f.MediaTypes = mediaTypes
+		logger.Infof("Получено %d медиа-типов", len(mediaTypes))
+	}
+	if f.GroupErr == nil {
+		f.Groups = buildGroupState(cfg, groups)
+		logger.Infof("Получено %d пользовательских групп", len(f.Groups))
 	}
-	if err = json.Unmarshal(body, &response); err != nil {
-		return nil, err
+	return f
+}
+
+// mediaTypesRequest — mediatype.get с полями, которые нужны включённым проверкам
+func mediaTypesRequest(cfg *Config) ZabbixRequest {
+	params := MediaTypeGetParams{Filter: &MediaTypeFilter{Name: cfg.MediaNames}}
+	output := []string{"mediatypeid", "name", "status"}
+	if cfg.AnnotateEnable {
+		output = append(output, "description")
+	}
+	if cfg.ValidateEnabledMedia {
+		output = append(output, mediaConfigFields...)
+	}
+	for _, f := range cfg.MediaWatchFields {
+		if !containsString(output, f) {
+			output = append(output, f)
+		}
+	}
+	params.Output = output
+	if cfg.WatchMessageTemplates {
+		params.SelectMessageTemplates = "extend"
+	}
+	if cfg.WatchTag != "" {
+		// список медиа определяется тегом, поэтому забираем все и фильтруем у себя
+		params.Filter = nil
+		params.SelectTags = "extend"
+	}
+	if cfg.MediaNamePattern != nil {
+		// mediatype.get не умеет регулярные выражения — имена сверяются у себя
+		params.Filter = nil
+	}
+	if cfg.AutoEnableTag != "" {
+		params.SelectTags = "extend"
 	}
-	if response.Error.Code != 0 {
-		return nil, fmt.Errorf("ошибка API (%d): %s - %s", response.Error.Code, response.Error.Message, response.Error.Data)
+	return ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "mediatype.get",
+		Params:  params,
+		Auth:    zabbixSession(cfg),
+		ID:      1,
+	}
+}
+
+// finishEnable обрабатывает результат включения медиа: уведомления, метрики и состояние.
+// Возвращает true, если состояние изменилось.
+func (mc *mediaCycle) finishEnable(ctx context.Context, media MediaType, policy MediaPolicy, disabledDuration time.Duration, err error, logEntry *logrus.Entry) bool {
+	cfg, logger, sysLogger := mc.cfg, mc.logger, mc.sysLogger
+	currentTime := cfg.Clock.Now()
+	if err != nil {
+		logEntry.WithError(err).Error("Ошибка включения медиа")
+		if !mc.shouldNotifyFailure(media.MediaTypeID, err, currentTime) {
+			logEntry.Info("Повторная ошибка включения — уведомление подавлено")
+		} else {
+			notify(ctx, cfg, Event{
+				Type:        EventMediaEnableFailed,
+				MediaID:     media.MediaTypeID,
+				MediaName:   media.Name,
+				DisabledFor: disabledDuration,
+				Threshold:   policy.OffDuration,
+				Channel:     policy.Channel,
+				Error:       err.Error(),
+				Message:     fmt.Sprintf("Ошибка включения медиа: %s\nОшибка: %v", media.Name, err),
+			}, logger)
+		}
+		return false
+	}
+	mc.clearFailure(media.MediaTypeID)
+	logEntry.Info("Медиа успешно включено")
+	cfg.auditLog.MediaEnabled(cfg, media, policy, disabledDuration, logger)
+	mediaAutoEnabledTotal.WithLabelValues(cfg.ServerName, cfg.mediaLabel(media.Name)).Inc()
+	if sysLogger != nil {
+		_ = sysLogger.Info(fmt.Sprintf("Скрипт включил media id=%s name=%s", media.MediaTypeID, media.Name))
 	}
-	logger.Infof("Получено %d медиа-типов", len(response.Result))
-	return response.Result, nil
+	notify(ctx, cfg, Event{
+		Type:        EventMediaAutoEnabled,
+		MediaID:     media.MediaTypeID,
+		MediaName:   media.Name,
+		DisabledFor: disabledDuration,
+		Threshold:   policy.OffDuration,
+		Channel:     policy.Channel,
+		Message:     fmt.Sprintf("Медиа %s было автоматически включено скриптом.", media.Name),
+	}, logger)
+	mc.untrack(media.MediaTypeID)
+	cfg.throttle.Forget(media.MediaTypeID)
+	return true
 }
 
-func enableMediaType(cfg *Config, mediaTypeID string, logger *logrus.Logger) error {
+func enableMediaType(ctx context.Context, cfg *Config, media MediaType, now time.Time, logger *logrus.Logger) error {
+	if cfg.Simulate {
+		logger.Infof("[SIMULATE] mediatype.update для %s не отправлен", media.MediaTypeID)
+		return nil
+	}
+	if cfg.DryRun {
+		logger.Infof("[DRY-RUN] Медиа %s было бы включено", media.Name)
+		return nil
+	}
 	requestBody := ZabbixRequest{
 		JSONRPC: "2.0",
 		Method:  "mediatype.update",
-		Params: map[string]interface{}{
-			"mediatypeid": mediaTypeID,
-			"status":      "0",
-		},
-		Auth: cfg.APIToken,
-		ID:   2,
+		Params:  enableParams(cfg, media, now, logger),
+		Auth:    zabbixSession(cfg),
+		ID:      2,
 	}
-	jsonData, _ := json.Marshal(requestBody)
-	resp, err := http.Post(cfg.ZabbixAPIURL+"/api_jsonrpc.php", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
+	var result struct {
+		MediaTypeIDs []string `json:"mediatypeids"`
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-	var response struct {
-		Result struct {
-			MediaTypeIDs []string `json:"mediatypeids"`
-		} `json:"result"`
-		Error struct {
-			Code    int    `json:"code"`
-			Message string `json:"message"`
-			Data    string `json:"data"`
-		} `json:"error"`
-	}
-	if err = json.Unmarshal(body, &response); err != nil {
+	if err := callZabbix(ctx, cfg, requestBody, &result, logger); err != nil {
 		return err
 	}
-	if response.Error.Code != 0 {
-		return fmt.Errorf("ошибка API (%d): %s - %s", response.Error.Code, response.Error.Message, response.Error.Data)
+	// без ошибки, но и без id медиа в ответе — update ничего не изменил, медиа остаётся в состоянии
+	for _, id := range result.MediaTypeIDs {
+		if id == media.MediaTypeID {
+			return nil
+		}
 	}
-	return nil
+	return fmt.Errorf("mediatype.update завершился успешно, но не затронул медиа %s (id %s): mediatypeids=%v", media.Name, media.MediaTypeID, result.MediaTypeIDs)
 }
 
-func sendMattermostNotification(cfg *Config, message string, logger *logrus.Logger) {
-	if cfg.MattermostWebhook == "" {
-		logger.Warn("Mattermost Webhook URL не задан, уведомление не отправлено")
-		return
+// enableMediaTypesBatch включает несколько медиа одним mediatype.update с массивом параметров
+// и возвращает ошибку по каждому медиа: Zabbix подтверждает включённые медиа списком mediatypeids.
+func enableMediaTypesBatch(ctx context.Context, cfg *Config, batch []pendingEnable, now time.Time, logger *logrus.Logger) map[string]error {
+	results := make(map[string]error, len(batch))
+	if cfg.Simulate {
+		for _, p := range batch {
+			logger.Infof("[SIMULATE] mediatype.update для %s не отправлен", p.Media.MediaTypeID)
+			results[p.Media.MediaTypeID] = nil
+		}
+		return results
 	}
-	payload := map[string]string{"text": message}
-	data, _ := json.Marshal(payload)
-	resp, err := http.Post(cfg.MattermostWebhook, "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		logger.WithError(err).Error("Ошибка отправки уведомления в Mattermost")
-		return
+	params := make([]MediaTypeUpdateParams, 0, len(batch))
+	for _, p := range batch {
+		params = append(params, enableParams(cfg, p.Media, now, logger))
+	}
+	requestBody := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "mediatype.update",
+		Params:  params,
+		Auth:    zabbixSession(cfg),
+		ID:      2,
+	}
+	var result struct {
+		MediaTypeIDs []string `json:"mediatypeids"`
+	}
+	err := callZabbix(ctx, cfg, requestBody, &result, logger)
+	confirmed := map[string]bool{}
+	for _, id := range result.MediaTypeIDs {
+		confirmed[id] = true
+	}
+	logger.WithField("count", len(batch)).Infof("Пакетное включение медиа: подтверждено %d из %d", len(confirmed), len(batch))
+	for _, p := range batch {
+		id := p.Media.MediaTypeID
+		switch {
+		case err != nil:
+			results[id] = err
+		case !confirmed[id]:
+			results[id] = fmt.Errorf("Zabbix не подтвердил включение медиа %s", id)
+		default:
+			results[id] = nil
+		}
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		logger.Errorf("Ошибка отправки уведомления в Mattermost: %s", string(body))
+	return results
+}
+
+// enableParams — параметры mediatype.update для включения одного медиа
+func enableParams(cfg *Config, media MediaType, now time.Time, logger *logrus.Logger) MediaTypeUpdateParams {
+	params := MediaTypeUpdateParams{MediaTypeID: media.MediaTypeID, Status: mediaStatusEnabled}
+	if cfg.AnnotateEnable {
+		if desc, ok := annotateDescription(media.Description, now, cfg.AnnotateMaxLength); ok {
+			params.Description = desc
+		} else {
+			logger.Warnf("Описание медиа %s не помещается в %d символов — отметка о включении не добавлена", media.Name, cfg.AnnotateMaxLength)
+		}
 	}
+	return params
 }
 
 // ---------------- Мониторинг UserGroup----------------
@@ -396,54 +2004,70 @@ func loadGroupState(filename string) (GroupState, bool, error) {
 	return state, true, nil
 }
 
-func saveGroupState(filename string, state GroupState, logger *logrus.Logger) error {
-	data, err := json.MarshalIndent(state, "", "  ")
+func saveGroupState(commit *cycleCommit, filename string, state GroupState, compact bool) error {
+	data, err := marshalState(state, compact)
 	if err != nil {
 		return err
 	}
-	if err = os.WriteFile(filename, data, 0644); err != nil {
-		return err
-	}
-	logger.Infof("Состояние групп сохранено в %s", filename)
+	commit.Stage(filename, data)
 	return nil
 }
 
-func processUserGroups(cfg *Config, prev GroupState, logger *logrus.Logger, sysLogger *syslog.Writer, baselineMode bool) {
-	current, err := getUserGroups(cfg, logger)
+// processUserGroups сравнивает полученные группы (err — ошибка их получения) с прошлым циклом,
+// уведомляет об изменениях и возвращает итог
+func processUserGroups(ctx context.Context, cfg *Config, current GroupState, err error, store StateStore, prev GroupState, report *groupReport, commit *cycleCommit, logger *logrus.Logger, sysLogger *syslog.Writer, baselineMode bool) GroupCycleResult {
+	var result GroupCycleResult
 	if err != nil {
 		logger.Errorf("Ошибка получения групп пользователей: %v", err)
-		return
+		result.Errors = append(result.Errors, fmt.Errorf("получение групп: %v", err))
+		result.FetchError = err
+		return result
 	}
+	result.Groups = len(current)
 
 	// При первом запуске сохраняем и НЕ шлём уведомлений. А то засрёт весь канал в ММ
 	if baselineMode {
-		if err := saveGroupState(groupStateFilename, current, logger); err != nil {
+		result.Baseline = true
+		if err := store.SaveGroups(commit, current); err != nil {
 			logger.Errorf("Не удалось сохранить baseline групп: %v", err)
+			result.Errors = append(result.Errors, fmt.Errorf("сохранение baseline групп: %v", err))
 		} else {
-			logger.Infof("Baseline групп сохранён в %s — уведомлений не отправлено", groupStateFilename)
+			where := cfg.GroupStateFile
+			if cfg.StateBackend == stateBackendSQLite {
+				where = cfg.StateDSN
+			}
+			logger.Infof("Baseline групп будет сохранён в %s — уведомлений не отправлено", where)
 		}
 		// обновляем prev в памяти
 		for k, v := range current {
 			prev[k] = v
 		}
-		return
+		return result
 	}
 
-	changes := compareGroupStates(prev, current)
+	changes := compareGroupStates(prev, current, cfg.GroupWatchFields)
+	resolveChangedUsers(ctx, cfg, changes, logger)
+	result.Changes = changes
 	if len(changes) > 0 {
 		for _, c := range changes {
 			// syslog + mm
 			if sysLogger != nil {
 				_ = sysLogger.Warning(fmt.Sprintf("UserGroup change detected: %s", c))
 			}
-			if cfg.MattermostWebhook != "" {
-				sendMattermostNotification(cfg, fmt.Sprintf("Изменения в UserGroup: %s", c), logger)
-			}
+			notify(ctx, cfg, Event{Type: EventGroupChanged, Message: fmt.Sprintf("Изменения в UserGroup: %s", c)}, logger)
 			logger.Warnf("UserGroup change: %s", c)
+			cfg.auditLog.GroupChanged(cfg, c, logger)
+		}
+		if report != nil {
+			if err := report.Add(cfg, changes, commit); err != nil {
+				logger.Errorf("Ошибка записи отчёта по группам: %v", err)
+				result.Errors = append(result.Errors, fmt.Errorf("отчёт по группам: %v", err))
+			}
 		}
 		// сохраняем новое состояние
-		if err := saveGroupState(groupStateFilename, current, logger); err != nil {
+		if err := store.SaveGroups(commit, current); err != nil {
 			logger.Errorf("Ошибка сохранения состояния групп: %v", err)
+			result.Errors = append(result.Errors, fmt.Errorf("сохранение состояния групп: %v", err))
 		}
 		// обновляем prev (в памяти)
 		// пересоберём prev полностью на основе current
@@ -456,97 +2080,324 @@ func processUserGroups(cfg *Config, prev GroupState, logger *logrus.Logger, sysL
 			prev[k] = v
 		}
 	}
+	return result
 }
 
-// getUserGroups вызывает usergroup.get и собирает state
-func getUserGroups(cfg *Config, logger *logrus.Logger) (GroupState, error) {
-	req := ZabbixRequest{
-		JSONRPC: "2.0",
-		Method:  "usergroup.get",
-		Params: map[string]interface{}{
-			"output":      []string{"usrgrpid", "name"},
-			"selectUsers": "extend",
-		},
-		Auth: cfg.APIToken,
-		ID:   10,
-	}
-	jsonData, _ := json.Marshal(req)
-	resp, err := http.Post(cfg.ZabbixAPIURL+"/api_jsonrpc.php", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
+// groupRight — право группы на группу хостов или шаблонов
+type groupRight struct {
+	ID         string `json:"id"`
+	Permission string `json:"permission"`
+}
 
-	var response struct {
-		Result []struct {
-			ID    string `json:"usrgrpid"`
-			Name  string `json:"name"`
-			Users []struct {
-				UserID string `json:"userid"`
-			} `json:"users"`
-		} `json:"result"`
-		Error struct {
-			Code    int    `json:"code"`
-			Message string `json:"message"`
-			Data    string `json:"data"`
-		} `json:"error"`
+// userGroupResult — группа в ответе usergroup.get
+type userGroupResult struct {
+	ID    string `json:"usrgrpid"`
+	Name  string `json:"name"`
+	Users []struct {
+		UserID   string `json:"userid"`
+		Username string `json:"username"`
+	} `json:"users"`
+	Status              string       `json:"users_status"`
+	HostGroupRights     []groupRight `json:"hostgroup_rights"`
+	TemplateGroupRights []groupRight `json:"templategroup_rights"`
+}
+
+// userGroupsRequest — usergroup.get с составом и отслеживаемыми полями групп
+func userGroupsRequest(cfg *Config) ZabbixRequest {
+	params := map[string]interface{}{
+		"output":      []string{"usrgrpid", "name"},
+		"selectUsers": []string{"userid", "username"},
 	}
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, err
+	if cfg.GroupWatchFields[groupFieldStatus] {
+		params["output"] = []string{"usrgrpid", "name", "users_status"}
+	}
+	if cfg.GroupWatchFields[groupFieldRights] {
+		params["selectHostGroupRights"] = "extend"
+		params["selectTemplateGroupRights"] = "extend"
 	}
-	if response.Error.Code != 0 {
-		return nil, fmt.Errorf("ошибка API (%d): %s - %s", response.Error.Code, response.Error.Message, response.Error.Data)
+	return ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "usergroup.get",
+		Params:  params,
+		Auth:    zabbixSession(cfg),
+		ID:      10,
 	}
+}
 
+// buildGroupState переводит ответ usergroup.get в состояние групп
+func buildGroupState(cfg *Config, result []userGroupResult) GroupState {
 	state := make(GroupState)
-	for _, g := range response.Result {
+	for _, g := range result {
 		users := []string{}
+		names := map[string]string{}
 		for _, u := range g.Users {
 			users = append(users, u.UserID)
+			if u.Username != "" {
+				names[u.UserID] = u.Username
+			}
 		}
 		sort.Strings(users)
-		state[g.ID] = UserGroup{ID: g.ID, Name: g.Name, Users: users}
+		group := UserGroup{ID: g.ID, Name: g.Name, Users: users, UsersHash: membershipHash(users), Status: g.Status}
+		if len(names) > 0 {
+			group.UserNames = names
+		}
+		if cfg.GroupWatchFields[groupFieldRights] {
+			// право записывается как "<host|template>:<id группы>=<permission>"
+			rights := []string{}
+			for _, r := range g.HostGroupRights {
+				rights = append(rights, "host:"+r.ID+"="+r.Permission)
+			}
+			for _, r := range g.TemplateGroupRights {
+				rights = append(rights, "template:"+r.ID+"="+r.Permission)
+			}
+			sort.Strings(rights)
+			group.Rights = rights
+			group.RightsHash = membershipHash(rights)
+		}
+		state[g.ID] = group
+	}
+	return state
+}
+
+// Типы изменений групп
+const (
+	GroupChangeAdded   = "added"
+	GroupChangeRemoved = "removed"
+	GroupChangeRenamed = "renamed"
+	GroupChangeMembers = "members"
+	GroupChangeRights  = "rights"
+	GroupChangeStatus  = "status"
+)
+
+// Поля группы, изменения которых считаются изменением группы (GROUP_WATCH_FIELDS)
+const (
+	groupFieldName    = "name"
+	groupFieldMembers = "members"
+	groupFieldRights  = "rights"
+	groupFieldStatus  = "status"
+)
+
+type groupFields map[string]bool
+
+var defaultGroupFields = groupFields{groupFieldName: true, groupFieldMembers: true}
+
+// parseGroupFields разбирает список полей через запятую; пустая строка — поля по умолчанию
+func parseGroupFields(s string) (groupFields, error) {
+	names := splitList(s)
+	if len(names) == 0 {
+		return defaultGroupFields, nil
+	}
+	fields := groupFields{}
+	for _, n := range names {
+		n = strings.ToLower(n)
+		switch n {
+		case groupFieldName, groupFieldMembers, groupFieldRights, groupFieldStatus:
+			fields[n] = true
+		default:
+			return nil, fmt.Errorf("неверное поле в GROUP_WATCH_FIELDS: %q (допустимо: name, members, rights, status)", n)
+		}
+	}
+	return fields, nil
+}
+
+// GroupChange — одно изменение группы пользователей
+type GroupChange struct {
+	Type         string   `json:"type"`
+	GroupID      string   `json:"group_id"`
+	GroupName    string   `json:"group_name"`
+	OldName      string   `json:"old_name,omitempty"`
+	AddedUsers   []string `json:"added_users,omitempty"`
+	RemovedUsers []string `json:"removed_users,omitempty"`
+	// Имена добавленных и удалённых пользователей, если Zabbix их вернул
+	UserNames map[string]string `json:"user_names,omitempty"`
+
+	AddedRights   []string `json:"added_rights,omitempty"`
+	RemovedRights []string `json:"removed_rights,omitempty"`
+	OldStatus     string   `json:"old_status,omitempty"`
+	NewStatus     string   `json:"new_status,omitempty"`
+}
+
+// String возвращает текст изменения для логов и уведомлений
+func (c GroupChange) String() string {
+	switch c.Type {
+	case GroupChangeAdded:
+		return fmt.Sprintf("Добавлена группа: %s ", c.GroupName)
+	case GroupChangeRemoved:
+		return fmt.Sprintf("Удалена группа: %s ", c.GroupName)
+	case GroupChangeRenamed:
+		return fmt.Sprintf("Переименована группа %s -> %s ", c.OldName, c.GroupName)
+	case GroupChangeMembers:
+		return fmt.Sprintf("Изменён состав пользователей в группе %s: добавлены [%s], удалены [%s] ",
+			c.GroupName, c.userList(c.AddedUsers), c.userList(c.RemovedUsers))
+	case GroupChangeRights:
+		return fmt.Sprintf("Изменены права группы %s: добавлены [%s], удалены [%s] ",
+			c.GroupName, strings.Join(c.AddedRights, ","), strings.Join(c.RemovedRights, ","))
+	case GroupChangeStatus:
+		return fmt.Sprintf("Изменён статус группы %s: %s -> %s ", c.GroupName, c.OldStatus, c.NewStatus)
 	}
-	logger.Infof("Получено %d пользовательских групп", len(state))
-	return state, nil
+	return fmt.Sprintf("Изменение группы %s (%s) ", c.GroupName, c.Type)
 }
 
-func compareGroupStates(prev, curr GroupState) []string {
-	changes := []string{}
+// compareGroupStates сравнивает состояния групп; изменения полей, не входящих в fields, игнорируются.
+// Появление и удаление групп сообщается всегда.
+func compareGroupStates(prev, curr GroupState, fields groupFields) []GroupChange {
+	changes := []GroupChange{}
 
 	for id, cur := range curr {
 		if p, ok := prev[id]; !ok {
-			changes = append(changes, fmt.Sprintf("Добавлена группа: %s ", cur.Name))
+			changes = append(changes, GroupChange{Type: GroupChangeAdded, GroupID: id, GroupName: cur.Name})
 		} else {
 
-			if p.Name != cur.Name {
-				changes = append(changes, fmt.Sprintf("Переименована группа %s -> %s ", p.Name, cur.Name))
+			if fields[groupFieldName] && p.Name != cur.Name {
+				changes = append(changes, GroupChange{Type: GroupChangeRenamed, GroupID: id, GroupName: cur.Name, OldName: p.Name})
+			}
+
+			// сначала сравниваем хэши, списки разбираем только если состав реально изменился
+			if fields[groupFieldMembers] && groupUsersHash(p) != groupUsersHash(cur) {
+				added, removed := diffUsers(p.Users, cur.Users)
+				changes = append(changes, GroupChange{
+					Type:         GroupChangeMembers,
+					GroupID:      id,
+					GroupName:    cur.Name,
+					AddedUsers:   added,
+					RemovedUsers: removed,
+					UserNames:    changedUserNames(added, cur.UserNames, removed, p.UserNames),
+				})
 			}
 
-			if !stringSlicesEqual(p.Users, cur.Users) {
+			// в состоянии, сохранённом до включения поля, прав/статуса нет — сравнивать не с чем
+			if fields[groupFieldRights] && p.RightsHash != "" && cur.RightsHash != "" && p.RightsHash != cur.RightsHash {
+				added, removed := diffUsers(p.Rights, cur.Rights)
+				changes = append(changes, GroupChange{
+					Type:          GroupChangeRights,
+					GroupID:       id,
+					GroupName:     cur.Name,
+					AddedRights:   added,
+					RemovedRights: removed,
+				})
+			}
 
-				changes = append(changes, fmt.Sprintf("Изменён состав пользователей в группе %s ", cur.Name))
+			if fields[groupFieldStatus] && p.Status != "" && cur.Status != "" && p.Status != cur.Status {
+				changes = append(changes, GroupChange{Type: GroupChangeStatus, GroupID: id, GroupName: cur.Name, OldStatus: p.Status, NewStatus: cur.Status})
 			}
 		}
 	}
 
 	for id, p := range prev {
 		if _, ok := curr[id]; !ok {
-			changes = append(changes, fmt.Sprintf("Удалена группа: %s ", p.Name))
+			changes = append(changes, GroupChange{Type: GroupChangeRemoved, GroupID: id, GroupName: p.Name})
 		}
 	}
+	// состояния — карты, поэтому порядок задаём явно: по группе, внутри группы — в порядке проверок
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].GroupName != changes[j].GroupName {
+			return changes[i].GroupName < changes[j].GroupName
+		}
+		return changes[i].GroupID < changes[j].GroupID
+	})
 	return changes
 }
 
-func stringSlicesEqual(a, b []string) bool {
-	if len(a) != len(b) {
-		return false
+// membershipHash считает sha256 по отсортированному списку userid
+func membershipHash(users []string) string {
+	sorted := append([]string(nil), users...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// groupUsersHash возвращает сохранённый хэш, а для старого состояния без хэша — считает его по списку
+func groupUsersHash(g UserGroup) string {
+	if g.UsersHash != "" {
+		return g.UsersHash
 	}
-	for i := range a {
-		if a[i] != b[i] {
-			return false
+	return membershipHash(g.Users)
+}
+
+// changedUserNames собирает имена изменившихся пользователей: добавленных — из текущего
+// состояния, удалённых — из прошлого
+func changedUserNames(added []string, currNames map[string]string, removed []string, prevNames map[string]string) map[string]string {
+	names := map[string]string{}
+	for _, id := range added {
+		if n, ok := currNames[id]; ok {
+			names[id] = n
 		}
 	}
-	return true
+	for _, id := range removed {
+		if n, ok := prevNames[id]; ok {
+			names[id] = n
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return names
+}
+
+// resolveChangedUsers дописывает имена пользователей, которых нет ни в текущем, ни в прошлом
+// состоянии групп (состояние без имён, сохранённое старой версией). user.get вызывается
+// не больше одного раза за цикл и только если такие пользователи есть; не найденные
+// остаются в тексте как id.
+func resolveChangedUsers(ctx context.Context, cfg *Config, changes []GroupChange, logger *logrus.Logger) {
+	var users map[string]string
+	for i := range changes {
+		c := &changes[i]
+		if c.Type != GroupChangeMembers {
+			continue
+		}
+		for _, id := range append(append([]string(nil), c.AddedUsers...), c.RemovedUsers...) {
+			if _, ok := c.UserNames[id]; ok {
+				continue
+			}
+			if users == nil {
+				var err error
+				if users, err = getUsers(ctx, cfg, logger); err != nil {
+					logger.WithError(err).Warn("Не удалось получить имена пользователей — в изменениях групп будут только id")
+					return
+				}
+			}
+			if n, ok := users[id]; ok {
+				if c.UserNames == nil {
+					c.UserNames = map[string]string{}
+				}
+				c.UserNames[id] = n
+			}
+		}
+	}
+}
+
+// userList — список пользователей для текста изменения: "alice(5),7" (без имени — только id)
+func (c GroupChange) userList(ids []string) string {
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if n, ok := c.UserNames[id]; ok {
+			parts = append(parts, n+"("+id+")")
+		} else {
+			parts = append(parts, id)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// diffUsers возвращает отсортированные списки добавленных и удалённых элементов (userid, права групп)
+func diffUsers(prev, curr []string) (added, removed []string) {
+	prevSet := make(map[string]struct{}, len(prev))
+	for _, u := range prev {
+		prevSet[u] = struct{}{}
+	}
+	currSet := make(map[string]struct{}, len(curr))
+	for _, u := range curr {
+		currSet[u] = struct{}{}
+		if _, ok := prevSet[u]; !ok {
+			added = append(added, u)
+		}
+	}
+	for _, u := range prev {
+		if _, ok := currSet[u]; !ok {
+			removed = append(removed, u)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
 }
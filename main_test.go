@@ -0,0 +1,1025 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testLogger — логгер без вывода, чтобы не засорять вывод go test
+func testLogger(t *testing.T) *logrus.Logger {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// fakeClock — управляемые часы: время идёт только через Advance
+type fakeClock struct {
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// chdirTemp переходит во временный каталог до конца теста: файлы состояния по умолчанию
+// пишутся в текущий каталог
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	return dir
+}
+
+// newTestConfig собирает конфигурацию через loadConfig из минимального окружения и env поверх него.
+// Время идёт по fakeClock, файлы состояния лежат во временном каталоге.
+func newTestConfig(t *testing.T, env map[string]string) (*Config, *fakeClock) {
+	t.Helper()
+	cfg, err := loadTestConfig(t, env)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	clock := newFakeClock()
+	cfg.Clock = clock
+	return cfg, clock
+}
+
+// loadTestConfig — loadConfig с тестовыми переменными окружения поверх минимально нужных
+func loadTestConfig(t *testing.T, env map[string]string) (*Config, error) {
+	t.Helper()
+	chdirTemp(t)
+	defaults := map[string]string{
+		"ZABBIX_API_URL":       "http://zabbix.invalid",
+		"ZABBIX_API_TOKEN":     "test-token",
+		"MEDIA_CHECK_INTERVAL": "1",
+		"MEDIA_OFF_DURATION":   "60",
+		"MEDIA_NAMES":          "Email",
+	}
+	for k, v := range env {
+		defaults[k] = v
+	}
+	for k, v := range defaults {
+		t.Setenv(k, v)
+	}
+	return loadConfig()
+}
+
+// fakeError — ошибка JSON-RPC в ответе fakeZabbix
+type fakeError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+type fakeCall struct {
+	Method string
+	Params json.RawMessage
+	Auth   string
+}
+
+// fakeZabbix — сервер JSON-RPC с заготовленными ответами по методам. Пакеты запросов
+// обрабатываются как настоящим Zabbix: ответ — массив в том же порядке.
+type fakeZabbix struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	handlers map[string]func(params json.RawMessage) (interface{}, *fakeError)
+	calls    []fakeCall
+	posts    int
+}
+
+func newFakeZabbix(t *testing.T) *fakeZabbix {
+	t.Helper()
+	z := &fakeZabbix{handlers: map[string]func(json.RawMessage) (interface{}, *fakeError){}}
+	z.Server = httptest.NewServer(http.HandlerFunc(z.serve))
+	t.Cleanup(z.Close)
+	return z
+}
+
+// Handle задаёт ответ на метод
+func (z *fakeZabbix) Handle(method string, h func(params json.RawMessage) (interface{}, *fakeError)) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.handlers[method] = h
+}
+
+// Result задаёт постоянный result для метода
+func (z *fakeZabbix) Result(method string, result interface{}) {
+	z.Handle(method, func(json.RawMessage) (interface{}, *fakeError) { return result, nil })
+}
+
+// Calls возвращает полученные запросы метода (все запросы при пустом method)
+func (z *fakeZabbix) Calls(method string) []fakeCall {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	var out []fakeCall
+	for _, c := range z.calls {
+		if method == "" || c.Method == method {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Posts — число HTTP-запросов; пакет считается одним
+func (z *fakeZabbix) Posts() int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.posts
+}
+
+type fakeRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Auth   string          `json:"auth"`
+	ID     int             `json:"id"`
+}
+
+func (z *fakeZabbix) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	z.mu.Lock()
+	z.posts++
+	z.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var reqs []fakeRequest
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		out := make([]interface{}, 0, len(reqs))
+		for _, req := range reqs {
+			out = append(out, z.answer(req))
+		}
+		_ = json.NewEncoder(w).Encode(out)
+		return
+	}
+	var req fakeRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(z.answer(req))
+}
+
+func (z *fakeZabbix) answer(req fakeRequest) map[string]interface{} {
+	z.mu.Lock()
+	z.calls = append(z.calls, fakeCall{Method: req.Method, Params: req.Params, Auth: req.Auth})
+	h := z.handlers[req.Method]
+	z.mu.Unlock()
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+	if h == nil {
+		resp["error"] = fakeError{Code: -32601, Message: "Method not found.", Data: json.RawMessage(`"Incorrect API \"` + req.Method + `\"."`)}
+		return resp
+	}
+	result, zerr := h(req.Params)
+	if zerr != nil {
+		resp["error"] = zerr
+	} else {
+		resp["result"] = result
+	}
+	return resp
+}
+
+// liveMedia — медиа-типы на fakeZabbix, которые mediatype.update действительно меняет
+type liveMedia struct {
+	mu    sync.Mutex
+	media []MediaType
+}
+
+// liveUpdate — параметры mediatype.update, которые понимает liveMedia
+type liveUpdate struct {
+	MediaTypeID string `json:"mediatypeid"`
+	Status      string `json:"status"`
+	Description string `json:"description"`
+}
+
+// serveLiveMedia отвечает на mediatype.get (с фильтром по имени) и mediatype.update (одиночный и пакетный)
+func serveLiveMedia(z *fakeZabbix, media ...MediaType) *liveMedia {
+	live := &liveMedia{media: media}
+	z.Handle("mediatype.get", func(raw json.RawMessage) (interface{}, *fakeError) {
+		var params struct {
+			Filter *struct {
+				Name []string `json:"name"`
+			} `json:"filter"`
+		}
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, &fakeError{Code: -32602, Message: err.Error()}
+		}
+		live.mu.Lock()
+		defer live.mu.Unlock()
+		out := []MediaType{}
+		for _, m := range live.media {
+			if params.Filter == nil || containsName(params.Filter.Name, m.Name) {
+				out = append(out, m)
+			}
+		}
+		return out, nil
+	})
+	z.Handle("mediatype.update", func(raw json.RawMessage) (interface{}, *fakeError) {
+		var list []liveUpdate
+		if err := json.Unmarshal(raw, &list); err != nil {
+			var one liveUpdate
+			if err := json.Unmarshal(raw, &one); err != nil {
+				return nil, &fakeError{Code: -32602, Message: err.Error()}
+			}
+			list = []liveUpdate{one}
+		}
+		live.mu.Lock()
+		defer live.mu.Unlock()
+		var ids []string
+		for _, p := range list {
+			i := live.index(p.MediaTypeID)
+			if i < 0 {
+				return nil, &fakeError{Code: -32500, Message: "Application error.", Data: json.RawMessage(`"No permissions to referred object or it does not exist!"`)}
+			}
+			if p.Status != "" {
+				live.media[i].Status = p.Status
+			}
+			if p.Description != "" {
+				live.media[i].Description = p.Description
+			}
+			ids = append(ids, p.MediaTypeID)
+		}
+		return map[string][]string{"mediatypeids": ids}, nil
+	})
+	return live
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *liveMedia) index(id string) int {
+	for i, m := range l.media {
+		if m.MediaTypeID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Set меняет status медиа, как если бы его переключили в интерфейсе Zabbix
+func (l *liveMedia) Set(id, status string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.media[l.index(id)].Status = status
+}
+
+// Statuses — текущие status медиа по именам
+func (l *liveMedia) Statuses() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := map[string]string{}
+	for _, m := range l.media {
+		out[m.Name] = m.Status
+	}
+	return out
+}
+
+// mattermostRecorder — входящий webhook Mattermost, запоминающий полученные сообщения
+type mattermostRecorder struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads []mattermostPayload
+}
+
+func newMattermostRecorder(t *testing.T) *mattermostRecorder {
+	t.Helper()
+	rec := &mattermostRecorder{}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p mattermostPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rec.mu.Lock()
+		rec.payloads = append(rec.payloads, p)
+		rec.mu.Unlock()
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+// Payloads возвращает полученные сообщения и очищает список
+func (rec *mattermostRecorder) Payloads() []mattermostPayload {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	p := rec.payloads
+	rec.payloads = nil
+	return p
+}
+
+// Texts возвращает тексты полученных сообщений (nil — сообщений не было) и очищает список
+func (rec *mattermostRecorder) Texts() []string {
+	var texts []string
+	for _, p := range rec.Payloads() {
+		texts = append(texts, p.Text)
+	}
+	return texts
+}
+
+// recordedEvent — событие, полученное eventRecorder в формате GENERIC_WEBHOOK_URL по умолчанию
+type recordedEvent struct {
+	Type      string `json:"type"`
+	MediaID   string `json:"media_id"`
+	MediaName string `json:"media_name"`
+	Message   string `json:"message"`
+}
+
+// eventRecorder — приёмник GENERIC_WEBHOOK_URL, запоминающий все события
+type eventRecorder struct {
+	*httptest.Server
+	mu     sync.Mutex
+	events []recordedEvent
+}
+
+func newEventRecorder(t *testing.T) *eventRecorder {
+	t.Helper()
+	rec := &eventRecorder{}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev recordedEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rec.mu.Lock()
+		rec.events = append(rec.events, ev)
+		rec.mu.Unlock()
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+// Types возвращает типы полученных событий по порядку (nil — событий не было) и очищает список
+func (rec *eventRecorder) Types() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var types []string
+	for _, ev := range rec.events {
+		types = append(types, ev.Type)
+	}
+	rec.events = nil
+	return types
+}
+
+// cloudEventsRecorder — приёмник CloudEvents, запоминающий типы исходных событий
+type cloudEventsRecorder struct {
+	*httptest.Server
+	mu    sync.Mutex
+	types []string
+}
+
+func newCloudEventsRecorder(t *testing.T) *cloudEventsRecorder {
+	t.Helper()
+	rec := &cloudEventsRecorder{}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ce CloudEvent
+		if err := json.NewDecoder(r.Body).Decode(&ce); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rec.mu.Lock()
+		rec.types = append(rec.types, ce.Type)
+		rec.mu.Unlock()
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+// Types возвращает типы полученных CloudEvents (nil — событий не было) и очищает список
+func (rec *cloudEventsRecorder) Types() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	types := rec.types
+	rec.types = nil
+	return types
+}
+
+func TestStateCompact(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      string
+		compact  bool
+		indented bool
+	}{
+		{name: "по умолчанию с отступами", env: "", compact: false, indented: true},
+		{name: "STATE_COMPACT=1", env: "1", compact: true, indented: false},
+		{name: "STATE_COMPACT=false", env: "false", compact: false, indented: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"STATE_COMPACT": tt.env})
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			if cfg.StateCompact != tt.compact {
+				t.Fatalf("StateCompact = %v, ожидалось %v", cfg.StateCompact, tt.compact)
+			}
+			dir := t.TempDir()
+			mediaFile := filepath.Join(dir, "media.json")
+			groupFile := filepath.Join(dir, "groups.json")
+			now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+			media := MediaState{"1": {FirstSeen: now}}
+			groups := GroupState{"7": {ID: "7", Name: "Ops", Users: []string{"1", "2"}}}
+			commit := newCycleCommit()
+			if err := saveState(commit, mediaFile, media, cfg.StateCompact); err != nil {
+				t.Fatal(err)
+			}
+			if err := saveGroupState(commit, groupFile, groups, cfg.StateCompact); err != nil {
+				t.Fatal(err)
+			}
+			if err := commit.Commit(testLogger(t)); err != nil {
+				t.Fatal(err)
+			}
+			for _, file := range []string{mediaFile, groupFile} {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := strings.Contains(string(data), "\n  \""); got != tt.indented {
+					t.Errorf("%s: отступы %v, ожидалось %v:\n%s", filepath.Base(file), got, tt.indented, data)
+				}
+			}
+			// формат не влияет на чтение
+			gotMedia, _, err := loadState(mediaFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !gotMedia["1"].FirstSeen.Equal(now) {
+				t.Errorf("прочитано состояние медиа %+v", gotMedia["1"])
+			}
+			gotGroups, _, err := loadGroupState(groupFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotGroups["7"].Users, groups["7"].Users) {
+				t.Errorf("прочитана группа %+v", gotGroups["7"])
+			}
+		})
+	}
+}
+
+func TestCompareGroupMembershipByHash(t *testing.T) {
+	group := func(users ...string) UserGroup {
+		return UserGroup{ID: "7", Name: "Ops", Users: users, UsersHash: membershipHash(users)}
+	}
+	tests := []struct {
+		name        string
+		prev, curr  UserGroup
+		wantChange  bool
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{name: "тот же состав", prev: group("1", "2", "3"), curr: group("1", "2", "3")},
+		{name: "порядок не важен", prev: group("3", "1", "2"), curr: group("1", "2", "3")},
+		{
+			// хэши совпадают — списки не разбираются, даже если расходятся
+			name: "решает хэш",
+			prev: UserGroup{ID: "7", Name: "Ops", Users: []string{"1"}, UsersHash: membershipHash([]string{"1", "2"})},
+			curr: group("1", "2"),
+		},
+		{name: "добавлен", prev: group("1", "2"), curr: group("1", "2", "3"), wantChange: true, wantAdded: []string{"3"}},
+		{name: "удалён", prev: group("1", "2", "3"), curr: group("1", "3"), wantChange: true, wantRemoved: []string{"2"}},
+		{name: "замена", prev: group("1", "2"), curr: group("1", "4"), wantChange: true, wantAdded: []string{"4"}, wantRemoved: []string{"2"}},
+		{
+			name:       "старое состояние без хэша",
+			prev:       UserGroup{ID: "7", Name: "Ops", Users: []string{"1", "2"}},
+			curr:       group("1", "2", "5"),
+			wantChange: true, wantAdded: []string{"5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := compareGroupStates(GroupState{"7": tt.prev}, GroupState{"7": tt.curr}, defaultGroupFields)
+			if !tt.wantChange {
+				if len(changes) != 0 {
+					t.Fatalf("лишние изменения: %+v", changes)
+				}
+				return
+			}
+			if len(changes) != 1 || changes[0].Type != GroupChangeMembers {
+				t.Fatalf("изменения %+v, ожидалось одно %s", changes, GroupChangeMembers)
+			}
+			if !reflect.DeepEqual(changes[0].AddedUsers, tt.wantAdded) || !reflect.DeepEqual(changes[0].RemovedUsers, tt.wantRemoved) {
+				t.Errorf("добавлены %v, удалены %v; ожидалось %v и %v", changes[0].AddedUsers, changes[0].RemovedUsers, tt.wantAdded, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestBuildGroupStateHashesMembership(t *testing.T) {
+	cfg := &Config{GroupWatchFields: defaultGroupFields}
+	var result []userGroupResult
+	if err := json.Unmarshal([]byte(`[{"usrgrpid":"7","name":"Ops","users":[{"userid":"3","username":"carol"},{"userid":"1","username":"alice"}]}]`), &result); err != nil {
+		t.Fatal(err)
+	}
+	g := buildGroupState(cfg, result)["7"]
+	if !reflect.DeepEqual(g.Users, []string{"1", "3"}) {
+		t.Errorf("состав %v, ожидался отсортированный [1 3]", g.Users)
+	}
+	if g.UsersHash != membershipHash([]string{"3", "1"}) {
+		t.Errorf("хэш состава %q не совпадает с хэшем тех же userid", g.UsersHash)
+	}
+	if g.UserNames["1"] != "alice" || g.UserNames["3"] != "carol" {
+		t.Errorf("имена пользователей %v", g.UserNames)
+	}
+
+	// usergroup.get запрашивает только id и имена участников, а не полные записи
+	params := userGroupsRequest(cfg).Params.(map[string]interface{})
+	if !reflect.DeepEqual(params["selectUsers"], []string{"userid", "username"}) {
+		t.Errorf("selectUsers = %v", params["selectUsers"])
+	}
+}
+
+func TestMediaLifecycleWithFakeClock(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{
+		"ZABBIX_API_URL":         zabbix.URL,
+		"MM_WEBHOOK_URL":         mm.URL,
+		"MEDIA_OFF_DURATION":     "60",
+		"NOTIFY_REPEAT_INTERVAL": "30",
+	})
+	media := MediaType{MediaTypeID: "1", Name: "Email", Status: "1"}
+	zabbix.Handle("mediatype.get", func(json.RawMessage) (interface{}, *fakeError) {
+		return []MediaType{media}, nil
+	})
+	zabbix.Handle("mediatype.update", func(params json.RawMessage) (interface{}, *fakeError) {
+		var p struct {
+			MediaTypeID string `json:"mediatypeid"`
+			Status      string `json:"status"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &fakeError{Code: -32602, Message: err.Error()}
+		}
+		media.Status = p.Status
+		return map[string][]string{"mediatypeids": {p.MediaTypeID}}, nil
+	})
+	logger := testLogger(t)
+	state := make(MediaState)
+	failures := make(EnableFailures)
+
+	steps := []struct {
+		advance time.Duration
+		notice  string
+	}{
+		{advance: 0, notice: "Обнаружено отключенное медиа: Email"},
+		{advance: 10 * time.Minute},
+		{advance: 10 * time.Minute},
+		{advance: 10 * time.Minute, notice: "Медиа отключено: Email"},
+		// следующее напоминание ограничено кулдауном media_still_disabled
+		{advance: 29*time.Minute + 59*time.Second},
+		{advance: time.Second, notice: "Медиа Email было автоматически включено"},
+		{advance: 10 * time.Minute},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		commit := newCycleCommit()
+		media, err := getMediaTypes(context.Background(), cfg, logger)
+		processMediaTypes(context.Background(), cfg, media, err, newStateStore(cfg, nil), state, failures, make(VanishState), commit, logger, nil)
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+		got := mm.Texts()
+		if step.notice == "" {
+			if len(got) != 0 {
+				t.Fatalf("шаг %d: лишние уведомления %q", i+1, got)
+			}
+			continue
+		}
+		if len(got) != 1 || !strings.Contains(got[0], step.notice) {
+			t.Fatalf("шаг %d: уведомления %q, ожидалось %q", i+1, got, step.notice)
+		}
+	}
+	if updates := zabbix.Calls("mediatype.update"); len(updates) != 1 {
+		t.Fatalf("mediatype.update вызван %d раз, ожидался один", len(updates))
+	}
+	if saved, _, err := loadState(cfg.StateFile); err != nil || len(saved) != 0 {
+		t.Errorf("после включения в состоянии осталось %+v (%v)", saved, err)
+	}
+}
+
+func TestEnableFailureNotifications(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		// ошибка mediatype.update по циклам; циклы идут раз в 10 минут
+		errors []string
+		want   []bool
+	}{
+		{
+			name:   "always",
+			policy: enableFailurePolicyAlways,
+			errors: []string{"No permissions.", "No permissions.", "No permissions."},
+			want:   []bool{true, true, true},
+		},
+		{
+			name:   "suppress: повтор той же ошибки до ENABLE_FAILURE_REALERT",
+			policy: enableFailurePolicySuppress,
+			errors: []string{"No permissions.", "No permissions.", "No permissions.", "No permissions.", "No permissions."},
+			// ENABLE_FAILURE_REALERT=30: первое уведомление в 0 минут, повтор — на 30-й
+			want: []bool{true, false, false, true, false},
+		},
+		{
+			name:   "suppress: новая ошибка сообщается сразу",
+			policy: enableFailurePolicySuppress,
+			errors: []string{"No permissions.", "No permissions.", "Media type is locked.", "Media type is locked."},
+			want:   []bool{true, false, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			mm := newMattermostRecorder(t)
+			cfg, clock := newTestConfig(t, map[string]string{
+				"ZABBIX_API_URL":         zabbix.URL,
+				"MM_WEBHOOK_URL":         mm.URL,
+				"ENABLE_FAILURE_POLICY":  tt.policy,
+				"ENABLE_FAILURE_REALERT": "30",
+			})
+			media := MediaType{MediaTypeID: "1", Name: "Email", Status: "1"}
+			state := MediaState{"1": {FirstSeen: clock.Now().Add(-2 * time.Hour)}}
+			failures := make(EnableFailures)
+			logger := testLogger(t)
+			for i, msg := range tt.errors {
+				msg := msg
+				zabbix.Handle("mediatype.update", func(json.RawMessage) (interface{}, *fakeError) {
+					return nil, &fakeError{Code: -32500, Message: "Application error.", Data: json.RawMessage(`"` + msg + `"`)}
+				})
+				handleMediaTypes(context.Background(), cfg, []MediaType{media}, newMemoryStore(), state, failures, newCycleCommit(), logger, nil)
+				var notified bool
+				for _, text := range mm.Texts() {
+					if strings.Contains(text, "Ошибка включения медиа: Email") {
+						notified = true
+					}
+				}
+				if notified != tt.want[i] {
+					t.Errorf("цикл %d (%q): уведомление %v, ожидалось %v", i+1, msg, notified, tt.want[i])
+				}
+				clock.Advance(10 * time.Minute)
+			}
+			if _, ok := state["1"]; !ok {
+				t.Error("медиа с ошибкой включения пропало из состояния")
+			}
+		})
+	}
+}
+
+func TestMaintenanceModeActive(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		create bool
+		want   bool
+	}{
+		{name: "MAINTENANCE_FILE не задан"},
+		{name: "файла нет", file: "pause"},
+		{name: "файл есть", file: "pause", create: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, _ := newTestConfig(t, map[string]string{"MAINTENANCE_FILE": tt.file})
+			if tt.create {
+				if err := os.WriteFile(tt.file, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := maintenanceModeActive(cfg); got != tt.want {
+				t.Errorf("maintenanceModeActive = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirstDisableMessageThreshold(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{name: "глобальный порог", want: "Будет автоматически включено через: 1h0m0s"},
+		{
+			name: "порог из MEDIA_OFF_DURATION_OVERRIDES",
+			env:  map[string]string{"MEDIA_OFF_DURATION_OVERRIDES": "Email:10"},
+			want: "Будет автоматически включено через: 10m0s",
+		},
+		{
+			name: "переопределение другого медиа не влияет",
+			env:  map[string]string{"MEDIA_OFF_DURATION_OVERRIDES": "SMS:10"},
+			want: "Будет автоматически включено через: 1h0m0s",
+		},
+		{
+			name: "порог политики",
+			env:  map[string]string{"MEDIA_POLICIES": `[{"name":"critical","names":["Email"],"off_duration":5}]`},
+			want: "Будет автоматически включено через: 5m0s",
+		},
+		{
+			name: "политика другого медиа не влияет",
+			env:  map[string]string{"MEDIA_POLICIES": `[{"name":"critical","names":["SMS"],"off_duration":5}]`},
+			want: "Будет автоматически включено через: 1h0m0s",
+		},
+		{
+			name: "переопределение важнее политики",
+			env: map[string]string{
+				"MEDIA_POLICIES":               `[{"name":"critical","names":["Email"],"off_duration":5}]`,
+				"MEDIA_OFF_DURATION_OVERRIDES": "Email:15",
+			},
+			want: "Будет автоматически включено через: 15m0s",
+		},
+		{
+			name: "политика без автовключения",
+			env:  map[string]string{"MEDIA_POLICIES": `[{"name":"optional","names":["Email"],"auto_enable":false}]`},
+			want: "Автоматическое включение отключено политикой optional",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mm := newMattermostRecorder(t)
+			env := map[string]string{"MM_WEBHOOK_URL": mm.URL, "MEDIA_OFF_DURATION": "60"}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			cfg, _ := newTestConfig(t, env)
+			media := []MediaType{{MediaTypeID: "1", Name: "Email", Status: "1"}}
+			handleMediaTypes(context.Background(), cfg, media, newMemoryStore(), make(MediaState), make(EnableFailures), newCycleCommit(), testLogger(t), nil)
+
+			texts := mm.Texts()
+			if len(texts) != 1 || !strings.Contains(texts[0], "Обнаружено отключенное медиа: Email") {
+				t.Fatalf("уведомления %q, ожидалось одно об отключении", texts)
+			}
+			if !strings.Contains(texts[0], tt.want) {
+				t.Errorf("сообщение %q, ожидалось %q", texts[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestResolutionWarnings(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    []string
+		wantErr string
+	}{
+		{name: "порог больше интервала", env: map[string]string{"MEDIA_OFF_DURATION": "60"}},
+		{name: "порог равен интервалу", env: map[string]string{"MEDIA_OFF_DURATION": "5"}},
+		{name: "нулевой порог", env: map[string]string{"MEDIA_OFF_DURATION": "0"}},
+		{
+			name: "порог меньше интервала",
+			env:  map[string]string{"MEDIA_OFF_DURATION": "3"},
+			want: []string{"MEDIA_OFF_DURATION: порог отключения 3m0s меньше интервала проверки 5m0s — фактически медиа будет включено через 3m0s–8m0s после отключения"},
+		},
+		{
+			name: "порог политики",
+			env:  map[string]string{"MEDIA_POLICIES": `[{"name":"critical","names":["Email"],"off_duration":2}]`},
+			want: []string{"политика critical: порог отключения 2m0s меньше интервала проверки 5m0s — фактически медиа будет включено через 2m0s–7m0s после отключения"},
+		},
+		{
+			name: "переопределение медиа",
+			env:  map[string]string{"MEDIA_OFF_DURATION_OVERRIDES": "Email:1"},
+			want: []string{"MEDIA_OFF_DURATION_OVERRIDES Email: порог отключения 1m0s меньше интервала проверки 5m0s — фактически медиа будет включено через 1m0s–6m0s после отключения"},
+		},
+		{
+			name:    "FAIL_FAST",
+			env:     map[string]string{"MEDIA_OFF_DURATION": "3", "FAIL_FAST": "1"},
+			wantErr: "MEDIA_OFF_DURATION: порог отключения 3m0s меньше интервала проверки 5m0s",
+		},
+		{name: "FAIL_FAST без проблем", env: map[string]string{"FAIL_FAST": "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"MEDIA_CHECK_INTERVAL": "5"}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			cfg, err := loadTestConfig(t, env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.HasSuffix(err.Error(), "(FAIL_FAST)") {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.resolutionWarnings(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("предупреждения %q, ожидалось %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareGroupStatesWatchedFields(t *testing.T) {
+	group := func(name string, users, rights []string, status string) UserGroup {
+		return UserGroup{ID: "7", Name: name, Users: users, UsersHash: membershipHash(users), Rights: rights, RightsHash: membershipHash(rights), Status: status}
+	}
+	prev := GroupState{
+		"7": group("Ops", []string{"1", "2"}, []string{"hostgroup:2:read"}, "0"),
+		"8": {ID: "8", Name: "Old"},
+	}
+	curr := GroupState{
+		"7": group("SRE", []string{"1", "3"}, []string{"hostgroup:2:write"}, "1"),
+		"9": {ID: "9", Name: "New"},
+	}
+	tests := []struct {
+		fields string
+		want   []string
+	}{
+		// появление и удаление групп сообщаются при любом наборе полей
+		{fields: "", want: []string{GroupChangeAdded, GroupChangeRemoved, GroupChangeRenamed, GroupChangeMembers}},
+		{fields: "name", want: []string{GroupChangeAdded, GroupChangeRemoved, GroupChangeRenamed}},
+		{fields: "members", want: []string{GroupChangeAdded, GroupChangeRemoved, GroupChangeMembers}},
+		{fields: "rights", want: []string{GroupChangeAdded, GroupChangeRemoved, GroupChangeRights}},
+		{fields: "Status", want: []string{GroupChangeAdded, GroupChangeRemoved, GroupChangeStatus}},
+		{fields: "rights, members", want: []string{GroupChangeAdded, GroupChangeRemoved, GroupChangeMembers, GroupChangeRights}},
+		{fields: "name,members,rights,status", want: []string{GroupChangeAdded, GroupChangeRemoved, GroupChangeRenamed, GroupChangeMembers, GroupChangeRights, GroupChangeStatus}},
+	}
+	for _, tt := range tests {
+		t.Run("GROUP_WATCH_FIELDS="+tt.fields, func(t *testing.T) {
+			fields, err := parseGroupFields(tt.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, c := range compareGroupStates(prev, curr, fields) {
+				got = append(got, c.Type)
+			}
+			// порядок групп в изменениях не гарантирован
+			sort.Strings(got)
+			want := append([]string(nil), tt.want...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("изменения %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseGroupFields("name,permissions"); err == nil || !strings.Contains(err.Error(), `"permissions"`) {
+		t.Errorf("неизвестное поле: ошибка %v", err)
+	}
+}
+
+func TestCompareGroupStatesWithoutSavedRights(t *testing.T) {
+	// состояние сохранено до включения rights и status — первое сравнение их не сообщает
+	prev := GroupState{"7": {ID: "7", Name: "Ops"}}
+	curr := GroupState{"7": {ID: "7", Name: "Ops", Rights: []string{"hostgroup:2:read"}, RightsHash: membershipHash([]string{"hostgroup:2:read"}), Status: "1"}}
+	fields, _ := parseGroupFields("rights,status")
+	if changes := compareGroupStates(prev, curr, fields); len(changes) != 0 {
+		t.Errorf("лишние изменения: %+v", changes)
+	}
+}
+
+func TestBatchEnable(t *testing.T) {
+	type updateParams struct {
+		MediaTypeID string `json:"mediatypeid"`
+		Status      string `json:"status"`
+	}
+	tests := []struct {
+		name      string
+		batch     string
+		confirm   []string // id, которые Zabbix вернёт в mediatypeids; nil — ответ с ошибкой
+		wantCalls int
+		enabled   map[string]bool
+	}{
+		{
+			name: "все подтверждены", batch: "1", confirm: []string{"1", "2", "3"}, wantCalls: 1,
+			enabled: map[string]bool{"Email": true, "SMS": true, "Fax": true},
+		},
+		{
+			name: "подтверждена часть", batch: "1", confirm: []string{"3", "1"}, wantCalls: 1,
+			enabled: map[string]bool{"Email": true, "SMS": false, "Fax": true},
+		},
+		{
+			name: "ошибка пакета", batch: "1", wantCalls: 1,
+			enabled: map[string]bool{"Email": false, "SMS": false, "Fax": false},
+		},
+		{
+			name: "без BATCH_ENABLE", batch: "0", confirm: []string{"1", "2", "3"}, wantCalls: 3,
+			enabled: map[string]bool{"Email": true, "SMS": true, "Fax": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			var batched [][]updateParams
+			zabbix.Handle("mediatype.update", func(raw json.RawMessage) (interface{}, *fakeError) {
+				if tt.confirm == nil {
+					return nil, &fakeError{Code: -32500, Message: "Application error.", Data: json.RawMessage(`"No permissions to referred object or it does not exist!"`)}
+				}
+				var list []updateParams
+				if err := json.Unmarshal(raw, &list); err == nil {
+					batched = append(batched, list)
+					return map[string][]string{"mediatypeids": tt.confirm}, nil
+				}
+				var one updateParams
+				if err := json.Unmarshal(raw, &one); err != nil {
+					return nil, &fakeError{Code: -32602, Message: err.Error()}
+				}
+				return map[string][]string{"mediatypeids": {one.MediaTypeID}}, nil
+			})
+			cfg, clock := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "MEDIA_NAMES": "Email,SMS,Fax", "BATCH_ENABLE": tt.batch})
+			media := []MediaType{
+				{MediaTypeID: "1", Name: "Email", Status: "1"},
+				{MediaTypeID: "2", Name: "SMS", Status: "1"},
+				{MediaTypeID: "3", Name: "Fax", Status: "1"},
+			}
+			state := make(MediaState)
+			for _, m := range media {
+				state[m.MediaTypeID] = &MediaRecord{FirstSeen: clock.Now().Add(-2 * time.Hour)}
+			}
+
+			handleMediaTypes(context.Background(), cfg, media, newMemoryStore(), state, make(EnableFailures), newCycleCommit(), testLogger(t), nil)
+
+			if n := len(zabbix.Calls("mediatype.update")); n != tt.wantCalls {
+				t.Errorf("mediatype.update вызван %d раз, ожидалось %d", n, tt.wantCalls)
+			}
+			if tt.batch == "1" && tt.confirm != nil {
+				if len(batched) != 1 || len(batched[0]) != 3 {
+					t.Fatalf("пакеты %+v, ожидался один на 3 медиа", batched)
+				}
+				for _, p := range batched[0] {
+					if p.Status != "0" {
+						t.Errorf("в пакете %+v", p)
+					}
+				}
+			}
+			// неподтверждённые медиа остаются в состоянии до следующей попытки
+			for _, m := range media {
+				_, tracked := state[m.MediaTypeID]
+				if tracked == tt.enabled[m.Name] {
+					t.Errorf("%s в состоянии: %v, ожидалось включение %v", m.Name, tracked, tt.enabled[m.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigDurations(t *testing.T) {
+	tests := []struct {
+		name         string
+		interval     string
+		off          string
+		wantInterval time.Duration
+		wantOff      time.Duration
+		wantErr      string
+	}{
+		{name: "минуты числом", interval: "5", off: "120", wantInterval: 5 * time.Minute, wantOff: 2 * time.Hour},
+		{name: "длительности Go", interval: "30s", off: "1h30m", wantInterval: 30 * time.Second, wantOff: 90 * time.Minute},
+		{name: "вперемешку и с пробелами", interval: " 45s ", off: " 10 ", wantInterval: 45 * time.Second, wantOff: 10 * time.Minute},
+		{name: "без порога", interval: "1m", off: "0", wantInterval: time.Minute},
+		{name: "пустой интервал", interval: "", off: "60", wantErr: "неверный формат MEDIA_CHECK_INTERVAL"},
+		{name: "единица не указана", interval: "5 minutes", off: "60", wantErr: "неверный формат MEDIA_CHECK_INTERVAL"},
+		{name: "дробные минуты", interval: "1", off: "1.5", wantErr: "неверный формат MEDIA_OFF_DURATION"},
+		{name: "нулевой интервал", interval: "0s", off: "60", wantErr: "MEDIA_CHECK_INTERVAL должен быть больше нуля"},
+		{name: "отрицательный порог", interval: "1", off: "-10m", wantErr: "MEDIA_OFF_DURATION не может быть отрицательным"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, map[string]string{"MEDIA_CHECK_INTERVAL": tt.interval, "MEDIA_OFF_DURATION": tt.off})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.CheckInterval != tt.wantInterval || cfg.OffDuration != tt.wantOff {
+				t.Errorf("интервал %v, порог %v; ожидалось %v, %v", cfg.CheckInterval, cfg.OffDuration, tt.wantInterval, tt.wantOff)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Контроль периодов обслуживания ----------------
+// Предупреждение уходит, если период активен дольше MAINTENANCE_MAX_DURATION или уже закончился
+// (active_till в прошлом), но остался в Zabbix. При MAINTENANCE_AUTO_CLEANUP закончившиеся
+// периоды удаляются через maintenance.delete.
+
+type Maintenance struct {
+	ID          string `json:"maintenanceid"`
+	Name        string `json:"name"`
+	ActiveSince string `json:"active_since"`
+	ActiveTill  string `json:"active_till"`
+}
+
+// MaintenanceWatch — по каким периодам обслуживания уже отправлено предупреждение (только в памяти).
+// Для закончившихся периодов ключ — id с суффиксом /expired.
+type MaintenanceWatch map[string]time.Time
+
+func parseUnixTime(s string) (time.Time, error) {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("неверная метка времени %q: %v", s, err)
+	}
+	return time.Unix(sec, 0), nil
+}
+
+func getMaintenances(ctx context.Context, cfg *Config, logger *logrus.Logger) ([]Maintenance, error) {
+	req := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "maintenance.get",
+		Params: map[string]interface{}{
+			"output": []string{"maintenanceid", "name", "active_since", "active_till"},
+		},
+		Auth: zabbixSession(cfg),
+		ID:   30,
+	}
+	var result []Maintenance
+	if err := callZabbix(ctx, cfg, req, &result, logger); err != nil {
+		return nil, err
+	}
+	logger.Infof("Получено %d периодов обслуживания", len(result))
+	return result, nil
+}
+
+func deleteMaintenance(ctx context.Context, cfg *Config, m Maintenance, logger *logrus.Logger) error {
+	if cfg.DryRun {
+		logger.Infof("[DRY-RUN] Период обслуживания %s был бы удалён", m.Name)
+		return nil
+	}
+	req := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "maintenance.delete",
+		Params:  []string{m.ID},
+		Auth:    zabbixSession(cfg),
+		ID:      31,
+	}
+	var result struct {
+		MaintenanceIDs []string `json:"maintenanceids"`
+	}
+	return callZabbix(ctx, cfg, req, &result, logger)
+}
+
+// processMaintenances предупреждает, если период обслуживания активен дольше MAINTENANCE_MAX_DURATION
+// или закончился, но не удалён: забытое обслуживание молча глушит все алерты. Предупреждение по каждому
+// периоду отправляется один раз, пока он остаётся в этом состоянии.
+func processMaintenances(ctx context.Context, cfg *Config, watch MaintenanceWatch, logger *logrus.Logger, sysLogger *syslog.Writer) {
+	maintenances, err := getMaintenances(ctx, cfg, logger)
+	if err != nil {
+		logger.Errorf("Ошибка получения периодов обслуживания: %v", err)
+		return
+	}
+	now := cfg.Clock.Now()
+	seen := map[string]bool{}
+
+	for _, m := range maintenances {
+		since, err := parseUnixTime(m.ActiveSince)
+		if err != nil {
+			logger.WithField("maintenance_id", m.ID).Warnf("Пропущен период обслуживания: %v", err)
+			continue
+		}
+		till, err := parseUnixTime(m.ActiveTill)
+		if err != nil {
+			logger.WithField("maintenance_id", m.ID).Warnf("Пропущен период обслуживания: %v", err)
+			continue
+		}
+		if now.After(till) {
+			processExpiredMaintenance(ctx, cfg, m, till, watch, seen, logger, sysLogger)
+			continue
+		}
+		if now.Before(since) {
+			continue
+		}
+		seen[m.ID] = true
+
+		activeFor := now.Sub(since)
+		if cfg.MaintenanceMaxDuration <= 0 || activeFor < cfg.MaintenanceMaxDuration {
+			continue
+		}
+		if _, notified := watch[m.ID]; notified {
+			continue
+		}
+		watch[m.ID] = now
+
+		msg := fmt.Sprintf("Период обслуживания %s активен уже %s (допустимо %s), алерты по нему подавляются. Активен до: %s",
+			m.Name, activeFor.Round(time.Minute), cfg.MaintenanceMaxDuration, till.Format("2006-01-02 15:04"))
+		logger.WithFields(logrus.Fields{
+			"maintenance_id": m.ID,
+			"active_for":     activeFor.Round(time.Second),
+		}).Warn(msg)
+		if sysLogger != nil {
+			_ = sysLogger.Warning(msg)
+		}
+		notify(ctx, cfg, Event{Type: EventMaintenanceOverrun, Message: msg}, logger)
+	}
+
+	for id := range watch {
+		if !seen[id] {
+			delete(watch, id)
+		}
+	}
+}
+
+// processExpiredMaintenance удаляет закончившийся период при MAINTENANCE_AUTO_CLEANUP,
+// иначе (или если удалить не удалось) предупреждает о нём
+func processExpiredMaintenance(ctx context.Context, cfg *Config, m Maintenance, till time.Time, watch MaintenanceWatch, seen map[string]bool, logger *logrus.Logger, sysLogger *syslog.Writer) {
+	logEntry := logger.WithFields(logrus.Fields{"maintenance_id": m.ID, "active_till": till.Format(time.RFC3339)})
+	key := m.ID + "/expired"
+	if cfg.MaintenanceAutoCleanup {
+		err := deleteMaintenance(ctx, cfg, m, logger)
+		if err == nil {
+			if cfg.DryRun {
+				return
+			}
+			msg := fmt.Sprintf("Период обслуживания %s закончился %s и удалён", m.Name, till.Format("2006-01-02 15:04"))
+			logEntry.Info(msg)
+			if sysLogger != nil {
+				_ = sysLogger.Info(msg)
+			}
+			notify(ctx, cfg, Event{Type: EventMaintenanceDeleted, Message: msg}, logger)
+			return
+		}
+		logEntry.WithError(err).Error("Ошибка удаления закончившегося периода обслуживания")
+	}
+	seen[key] = true
+	if _, notified := watch[key]; notified {
+		return
+	}
+	watch[key] = cfg.Clock.Now()
+
+	msg := fmt.Sprintf("Период обслуживания %s закончился %s, но не удалён из Zabbix", m.Name, till.Format("2006-01-02 15:04"))
+	logEntry.Warn(msg)
+	if sysLogger != nil {
+		_ = sysLogger.Warning(msg)
+	}
+	notify(ctx, cfg, Event{Type: EventMaintenanceExpired, Message: msg}, logger)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessMaintenances(t *testing.T) {
+	unix := func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) }
+	tests := []struct {
+		name string
+		env  map[string]string
+		// период относительно текущего времени
+		since, till time.Duration
+		want        []string
+		wantDeletes int
+	}{
+		{name: "в пределах MAINTENANCE_MAX_DURATION", since: -30 * time.Minute, till: 2 * time.Hour},
+		{name: "дольше MAINTENANCE_MAX_DURATION", since: -3 * time.Hour, till: 10 * time.Hour, want: []string{"Период обслуживания DB upgrade активен уже 3h0m0s"}},
+		{name: "ещё не начался", since: 3 * time.Hour, till: 10 * time.Hour},
+		{name: "закончился и не удалён", since: -5 * time.Hour, till: -time.Hour, want: []string{"Период обслуживания DB upgrade закончился"}},
+		{
+			name:  "закончился, MAINTENANCE_AUTO_CLEANUP",
+			env:   map[string]string{"MAINTENANCE_AUTO_CLEANUP": "1"},
+			since: -5 * time.Hour, till: -time.Hour,
+			want: []string{"Период обслуживания DB upgrade закончился"}, wantDeletes: 1,
+		},
+		{
+			name:  "закончился, MAINTENANCE_AUTO_CLEANUP в пробном режиме",
+			env:   map[string]string{"MAINTENANCE_AUTO_CLEANUP": "1", "DRY_RUN": "1"},
+			since: -5 * time.Hour, till: -time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			mm := newMattermostRecorder(t)
+			env := map[string]string{"ZABBIX_API_URL": zabbix.URL, "MM_WEBHOOK_URL": mm.URL, "MAINTENANCE_MAX_DURATION": "60"}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			cfg, clock := newTestConfig(t, env)
+			now := clock.Now()
+			zabbix.Result("maintenance.get", []Maintenance{{ID: "5", Name: "DB upgrade", ActiveSince: unix(now.Add(tt.since)), ActiveTill: unix(now.Add(tt.till))}})
+			zabbix.Result("maintenance.delete", map[string][]string{"maintenanceids": {"5"}})
+			watch := make(MaintenanceWatch)
+
+			processMaintenances(context.Background(), cfg, watch, testLogger(t), nil)
+			got := mm.Texts()
+			if len(got) != len(tt.want) {
+				t.Fatalf("уведомления %q, ожидалось %q", got, tt.want)
+			}
+			for i, prefix := range tt.want {
+				if !strings.Contains(got[i], prefix) {
+					t.Errorf("уведомление %q, ожидалось %q", got[i], prefix)
+				}
+			}
+			if got := len(zabbix.Calls("maintenance.delete")); got != tt.wantDeletes {
+				t.Errorf("maintenance.delete вызван %d раз, ожидалось %d", got, tt.wantDeletes)
+			}
+			if tt.wantDeletes > 0 {
+				var ids []string
+				if err := json.Unmarshal(zabbix.Calls("maintenance.delete")[0].Params, &ids); err != nil || !reflect.DeepEqual(ids, []string{"5"}) {
+					t.Errorf("параметры maintenance.delete: %s", zabbix.Calls("maintenance.delete")[0].Params)
+				}
+			}
+
+			// предупреждение по тому же периоду не повторяется
+			if tt.wantDeletes == 0 {
+				clock.Advance(10 * time.Minute)
+				processMaintenances(context.Background(), cfg, watch, testLogger(t), nil)
+				if got := mm.Texts(); got != nil {
+					t.Errorf("повторные уведомления %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessMaintenancesRealertsAfterRemoval(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "MM_WEBHOOK_URL": mm.URL, "MAINTENANCE_MAX_DURATION": "60"})
+	since := strconv.FormatInt(clock.Now().Add(-2*time.Hour).Unix(), 10)
+	till := strconv.FormatInt(clock.Now().Add(10*time.Hour).Unix(), 10)
+	overrun := []Maintenance{{ID: "5", Name: "DB upgrade", ActiveSince: since, ActiveTill: till}}
+	watch := make(MaintenanceWatch)
+
+	steps := []struct {
+		maintenances []Maintenance
+		want         int
+	}{
+		{maintenances: overrun, want: 1},
+		{maintenances: overrun},
+		{maintenances: []Maintenance{}},
+		// период снова появился — это новое превышение
+		{maintenances: overrun, want: 1},
+	}
+	for i, step := range steps {
+		zabbix.Result("maintenance.get", step.maintenances)
+		processMaintenances(context.Background(), cfg, watch, testLogger(t), nil)
+		if got := mm.Texts(); len(got) != step.want {
+			t.Errorf("шаг %d: уведомления %q, ожидалось %d", i+1, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Mattermost ----------------
+
+type mattermostPayload struct {
+	Text        string                 `json:"text,omitempty"`
+	Channel     string                 `json:"channel,omitempty"`
+	Username    string                 `json:"username,omitempty"`
+	IconEmoji   string                 `json:"icon_emoji,omitempty"`
+	IconURL     string                 `json:"icon_url,omitempty"`
+	RootID      string                 `json:"root_id,omitempty"`
+	Attachments []mattermostAttachment `json:"attachments,omitempty"`
+}
+
+// threadStore — корневой пост Mattermost по каждому медиа, чтобы продолжать переписку в треде
+type threadStore struct {
+	mu    sync.Mutex
+	posts map[string]string
+}
+
+func newThreadStore() *threadStore {
+	return &threadStore{posts: map[string]string{}}
+}
+
+func (t *threadStore) Get(key string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.posts[key]
+}
+
+func (t *threadStore) Set(key, postID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.posts[key] = postID
+}
+
+func (t *threadStore) Delete(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.posts, key)
+}
+
+// notifyMattermost отправляет событие в Mattermost и ведёт треды по медиа при MM_THREADS
+func notifyMattermost(ctx context.Context, cfg *Config, ev Event, logger *logrus.Logger) {
+	payload := mattermostPayload{Text: ev.Message, Channel: ev.Channel}
+	if cfg.MattermostRich {
+		if a, ok := richAttachment(ev); ok {
+			payload.Attachments = []mattermostAttachment{a}
+			payload.Text = ""
+		}
+	}
+	threaded := cfg.MattermostThreads && ev.MediaID != ""
+	if threaded {
+		payload.RootID = cfg.mmThreads.Get(ev.MediaID)
+	}
+
+	postID, err := sendMattermostNotification(ctx, cfg, ev.Type, payload, logger)
+	if errors.Is(err, errSpooled) {
+		return
+	}
+	if err != nil {
+		logger.WithError(err).WithField("event", ev.Type).Error("Ошибка отправки уведомления в Mattermost")
+		return
+	}
+	if postID != "" {
+		logger.WithFields(logrus.Fields{"post_id": postID, "event": ev.Type}).Info("Уведомление доставлено в Mattermost")
+	}
+	if !threaded {
+		return
+	}
+	switch {
+	case ev.Type == EventMediaRestored || ev.Type == EventMediaAutoEnabled ||
+		ev.Type == EventActionRestored || ev.Type == EventActionAutoEnabled:
+		// инцидент по медиа закрыт — следующий раз начнём новый тред
+		cfg.mmThreads.Delete(ev.MediaID)
+	case payload.RootID == "" && postID != "":
+		cfg.mmThreads.Set(ev.MediaID, postID)
+	}
+}
+
+// reportMattermostDropped отправляет в канал одну сводку о сообщениях, подавленных MM_MAX_PER_MINUTE.
+// Сводка идёт мимо лимита, иначе после всплеска она сама была бы подавлена.
+func reportMattermostDropped(ctx context.Context, cfg *Config, logger *logrus.Logger) {
+	n := cfg.mmBucket.TakeDropped()
+	if n == 0 {
+		return
+	}
+	logger.WithField("count", n).Warnf("За цикл лимитом MM_MAX_PER_MINUTE подавлено уведомлений в Mattermost: %d", n)
+	notifyMattermost(ctx, cfg, Event{
+		Type:    EventNotifySuppressed,
+		Message: fmt.Sprintf("Ещё %d уведомлений подавлено (лимит MM_MAX_PER_MINUTE=%d)", n, cfg.MattermostMaxPerMinute),
+		Time:    cfg.Clock.Now(),
+	}, logger)
+}
+
+// sendMattermostNotification отправляет сообщение в webhook и возвращает ID созданного поста,
+// если Mattermost его вернул (обычный incoming webhook отвечает просто "ok")
+func sendMattermostNotification(ctx context.Context, cfg *Config, event string, payload mattermostPayload, logger *logrus.Logger) (string, error) {
+	if mattermostWebhook(cfg) == "" {
+		logger.Warn("Mattermost Webhook URL не задан, уведомление не отправлено")
+		return "", nil
+	}
+	mark := ""
+	if cfg.Simulate {
+		mark = "[SIMULATE] "
+	} else if cfg.DryRun {
+		mark = "[DRY-RUN] "
+	}
+	if mark != "" && len(payload.Attachments) > 0 {
+		payload.Attachments[0].Fallback = mark + payload.Attachments[0].Fallback
+		payload.Attachments[0].Title = mark + payload.Attachments[0].Title
+	} else if mark != "" {
+		payload.Text = mark + payload.Text
+	}
+	applyEnvTheme(cfg, &payload)
+	applySender(cfg, &payload)
+	data, _ := json.Marshal(payload)
+	return cfg.delivery.Deliver(ctx, cfg, deliveryMattermost, event, data, logger)
+}
+
+// parseMattermostResponse разбирает тело успешного по коду ответа. Mattermost может вернуть 200
+// с ошибкой в теле — такой ответ считается неудачей.
+func parseMattermostResponse(body []byte) (string, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || string(trimmed) == "ok" {
+		return "", nil
+	}
+	var resp struct {
+		ID            string `json:"id"`
+		ChannelID     string `json:"channel_id"`
+		Message       string `json:"message"`
+		DetailedError string `json:"detailed_error"`
+		StatusCode    int    `json:"status_code"`
+	}
+	if err := json.Unmarshal(trimmed, &resp); err != nil {
+		return "", fmt.Errorf("неожиданный ответ Mattermost: %s", string(trimmed))
+	}
+	if resp.StatusCode >= 400 || (resp.ChannelID == "" && resp.Message != "") {
+		return "", fmt.Errorf("Mattermost вернул ошибку (%d): %s %s", resp.StatusCode, resp.Message, resp.DetailedError)
+	}
+	return resp.ID, nil
+}
+
+// applySender подставляет MM_USERNAME, MM_CHANNEL и MM_ICON; канал из политики медиа важнее MM_CHANNEL
+func applySender(cfg *Config, payload *mattermostPayload) {
+	payload.Username = cfg.MattermostUsername
+	if payload.Channel == "" {
+		payload.Channel = cfg.MattermostChannel
+	}
+	switch icon := cfg.MattermostIcon; {
+	case icon == "":
+	case strings.HasPrefix(icon, "http://") || strings.HasPrefix(icon, "https://"):
+		payload.IconURL = icon
+	default:
+		payload.IconEmoji = icon
+	}
+}
+
+// Цвета вложений MM_RICH
+const (
+	richColorCritical = "#D32F2F"
+	richColorWarning  = "#F9A825"
+	richColorOK       = "#388E3C"
+)
+
+// richTitles — заголовки вложений по типу события; оформляются только события по медиа и действиям
+var richTitles = map[string]string{
+	EventMediaDisabled:       "Медиа отключено",
+	EventMediaStillDisabled:  "Медиа всё ещё отключено",
+	EventMediaSuppressed:     "Медиа отключено во время проблемы",
+	EventMediaEnableFailed:   "Ошибка включения медиа",
+	EventMediaAutoEnabled:    "Медиа включено автоматически",
+	EventMediaRestored:       "Медиа восстановлено",
+	EventActionDisabled:      "Действие отключено",
+	EventActionStillDisabled: "Действие всё ещё отключено",
+	EventActionEnableFailed:  "Ошибка включения действия",
+	EventActionAutoEnabled:   "Действие включено автоматически",
+	EventActionRestored:      "Действие восстановлено",
+}
+
+// richAttachment собирает вложение с цветом по серьёзности: красный — порог превышен или включить
+// не удалось, жёлтый — отключено в пределах порога, зелёный — снова включено
+func richAttachment(ev Event) (mattermostAttachment, bool) {
+	title, ok := richTitles[ev.Type]
+	if !ok {
+		return mattermostAttachment{}, false
+	}
+	color := richColorWarning
+	switch ev.Type {
+	case EventMediaEnableFailed, EventActionEnableFailed:
+		color = richColorCritical
+	case EventMediaAutoEnabled, EventMediaRestored, EventActionAutoEnabled, EventActionRestored:
+		color = richColorOK
+	default:
+		if ev.Threshold > 0 && ev.DisabledFor >= ev.Threshold {
+			color = richColorCritical
+		}
+	}
+	nameTitle := "Медиа"
+	if strings.HasPrefix(ev.Type, "action_") {
+		nameTitle = "Действие"
+	}
+	fields := []mattermostField{{Short: true, Title: nameTitle, Value: ev.MediaName}}
+	if ev.DisabledFor > 0 {
+		fields = append(fields, mattermostField{Short: true, Title: "Отключено", Value: ev.DisabledFor.Round(time.Minute).String()})
+	}
+	if ev.Threshold > 0 {
+		fields = append(fields, mattermostField{Short: true, Title: "Порог", Value: ev.Threshold.Round(time.Minute).String()})
+	}
+	if ev.Error != "" {
+		fields = append(fields, mattermostField{Title: "Ошибка", Value: ev.Error})
+	}
+	return mattermostAttachment{
+		Fallback: ev.Message,
+		Color:    color,
+		Title:    title,
+		Text:     ev.Message,
+		Fields:   fields,
+	}, true
+}
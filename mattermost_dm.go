@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Личные сообщения Mattermost через бота ----------------
+// Incoming webhook не умеет писать в личку, поэтому для DM используется REST API Mattermost
+// с токеном бота: находим пользователей, открываем direct-канал и создаём пост.
+
+// defaultDMEvents — события, которые по умолчанию уходят дежурным в личку
+var defaultDMEvents = []string{EventMediaEnableFailed, EventActionEnableFailed, EventStatePersistFailed}
+
+// dmCache — ID бота и direct-каналов по username, чтобы не дёргать API на каждое сообщение
+type dmCache struct {
+	mu       sync.Mutex
+	botID    string
+	channels map[string]string
+}
+
+func newDMCache() *dmCache {
+	return &dmCache{channels: map[string]string{}}
+}
+
+// wantsDM сообщает, нужно ли отправить событие дежурным в личку
+func (cfg *Config) wantsDM(ev Event) bool {
+	if cfg.MattermostBotToken == "" || cfg.MattermostURL == "" || len(cfg.MattermostDMUsers) == 0 {
+		return false
+	}
+	for _, t := range cfg.MattermostDMEvents {
+		if t == ev.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// mattermostAPI выполняет запрос к REST API Mattermost от имени бота и раскладывает ответ в out
+func mattermostAPI(ctx context.Context, cfg *Config, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = data
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+cfg.MattermostBotToken)
+	header.Set("Content-Type", "application/json")
+	resp, err := doHTTP(ctx, cfg, method, cfg.MattermostURL+"/api/v4"+path, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: HTTP %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// directChannels возвращает ID direct-каналов бота с каждым из пользователей MM_DM_USERS
+func (c *dmCache) directChannels(ctx context.Context, cfg *Config) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.botID == "" {
+		var me struct {
+			ID string `json:"id"`
+		}
+		if err := mattermostAPI(ctx, cfg, http.MethodGet, "/users/me", nil, &me); err != nil {
+			return nil, err
+		}
+		c.botID = me.ID
+	}
+
+	missing := []string{}
+	for _, u := range cfg.MattermostDMUsers {
+		if _, ok := c.channels[u]; !ok {
+			missing = append(missing, u)
+		}
+	}
+	if len(missing) > 0 {
+		var users []struct {
+			ID       string `json:"id"`
+			Username string `json:"username"`
+		}
+		if err := mattermostAPI(ctx, cfg, http.MethodPost, "/users/usernames", missing, &users); err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			var ch struct {
+				ID string `json:"id"`
+			}
+			if err := mattermostAPI(ctx, cfg, http.MethodPost, "/channels/direct", []string{c.botID, u.ID}, &ch); err != nil {
+				return nil, err
+			}
+			c.channels[u.Username] = ch.ID
+		}
+	}
+
+	out := make(map[string]string, len(c.channels))
+	for k, v := range c.channels {
+		out[k] = v
+	}
+	return out, nil
+}
+
+// sendMattermostDM отправляет сообщение каждому дежурному в личку. Ошибка возвращается,
+// только если не удалось доставить ни одного сообщения.
+func sendMattermostDM(ctx context.Context, cfg *Config, message string, logger *logrus.Logger) error {
+	if cfg.Simulate {
+		message = "[SIMULATE] " + message
+	} else if cfg.DryRun {
+		message = "[DRY-RUN] " + message
+	}
+	message = cfg.themedText(message)
+	channels, err := cfg.mmDM.directChannels(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	delivered := 0
+	for _, username := range cfg.MattermostDMUsers {
+		channelID, ok := channels[username]
+		if !ok {
+			logger.Warnf("Пользователь Mattermost %s не найден, личное сообщение не отправлено", username)
+			continue
+		}
+		post := map[string]string{"channel_id": channelID, "message": message}
+		var created struct {
+			ID string `json:"id"`
+		}
+		if err := mattermostAPI(ctx, cfg, http.MethodPost, "/posts", post, &created); err != nil {
+			logger.WithError(err).Errorf("Ошибка отправки личного сообщения %s", username)
+			continue
+		}
+		delivered++
+		logger.WithFields(logrus.Fields{"post_id": created.ID, "user": username}).Info("Личное сообщение доставлено в Mattermost")
+	}
+	if delivered == 0 {
+		return fmt.Errorf("ни одно личное сообщение не доставлено")
+	}
+	return nil
+}
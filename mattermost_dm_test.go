@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// fakeMattermostAPI — REST API Mattermost для бота: пользователи, direct-каналы и посты
+type fakeMattermostAPI struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	requests  []string // "<метод> <путь>"
+	posts     map[string]string
+	failPosts bool
+}
+
+func newFakeMattermostAPI(t *testing.T) *fakeMattermostAPI {
+	t.Helper()
+	users := map[string]string{"alice": "u-alice", "bob": "u-bob"}
+	api := &fakeMattermostAPI{posts: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/users/me", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": "bot"})
+	})
+	mux.HandleFunc("/api/v4/users/usernames", func(w http.ResponseWriter, r *http.Request) {
+		var names []string
+		_ = json.NewDecoder(r.Body).Decode(&names)
+		out := []map[string]string{}
+		for _, n := range names {
+			if id, ok := users[n]; ok {
+				out = append(out, map[string]string{"id": id, "username": n})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	})
+	mux.HandleFunc("/api/v4/channels/direct", func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		_ = json.NewDecoder(r.Body).Decode(&ids)
+		if len(ids) != 2 || ids[0] != "bot" {
+			http.Error(w, `{"message":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": "dm-" + ids[1]})
+	})
+	mux.HandleFunc("/api/v4/posts", func(w http.ResponseWriter, r *http.Request) {
+		var post map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&post)
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		if api.failPosts {
+			http.Error(w, `{"message":"internal error"}`, http.StatusInternalServerError)
+			return
+		}
+		api.posts[post["channel_id"]] = post["message"]
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": "post-" + post["channel_id"]})
+	})
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer bot-token" {
+			http.Error(w, `{"message":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		api.mu.Lock()
+		api.requests = append(api.requests, r.Method+" "+r.URL.Path)
+		api.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+// Requests возвращает запросы к API и очищает список
+func (api *fakeMattermostAPI) Requests() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	r := api.requests
+	api.requests = nil
+	return r
+}
+
+// Posts возвращает созданные посты по каналам и очищает их
+func (api *fakeMattermostAPI) Posts() map[string]string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	p := api.posts
+	api.posts = map[string]string{}
+	return p
+}
+
+func TestMattermostDM(t *testing.T) {
+	tests := []struct {
+		name      string
+		botToken  string
+		event     string
+		failPosts bool
+		wantDMs   []string // direct-каналы, получившие сообщение
+		wantHook  bool     // сообщение ушло в канал webhook
+	}{
+		{name: "критичное событие дежурным", botToken: "bot-token", event: EventMediaEnableFailed, wantDMs: []string{"dm-u-alice", "dm-u-bob"}},
+		{name: "без MM_BOT_TOKEN — в канал", event: EventMediaEnableFailed, wantHook: true},
+		{name: "обычное событие — в канал", botToken: "bot-token", event: EventMediaDisabled, wantHook: true},
+		{name: "личные не доставлены — в канал", botToken: "bot-token", event: EventMediaEnableFailed, failPosts: true, wantHook: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeMattermostAPI(t)
+			api.failPosts = tt.failPosts
+			hook := newMattermostRecorder(t)
+			cfg, clock := newTestConfig(t, map[string]string{
+				"MM_WEBHOOK_URL": hook.URL,
+				"MM_URL":         api.URL + "/",
+				"MM_BOT_TOKEN":   tt.botToken,
+				// carol в Mattermost нет — ей сообщение не уходит, остальным уходит
+				"MM_DM_USERS": "alice, bob, carol",
+			})
+
+			notify(context.Background(), cfg, Event{Type: tt.event, MediaID: "1", Message: "Не удалось включить Email", Time: clock.Now()}, testLogger(t))
+
+			var gotDMs []string
+			for ch, msg := range api.Posts() {
+				gotDMs = append(gotDMs, ch)
+				if msg != "Не удалось включить Email" {
+					t.Errorf("личное сообщение %q", msg)
+				}
+			}
+			sort.Strings(gotDMs)
+			if !reflect.DeepEqual(gotDMs, tt.wantDMs) {
+				t.Errorf("личные сообщения в %v, ожидалось %v", gotDMs, tt.wantDMs)
+			}
+			if got := len(hook.Payloads()) == 1; got != tt.wantHook {
+				t.Errorf("сообщение в канал webhook: %v, ожидалось %v", got, tt.wantHook)
+			}
+			if tt.botToken == "" {
+				if reqs := api.Requests(); len(reqs) != 0 {
+					t.Errorf("без токена бота были запросы к API: %v", reqs)
+				}
+			}
+		})
+	}
+}
+
+func TestMattermostDMChannelCache(t *testing.T) {
+	api := newFakeMattermostAPI(t)
+	cfg, clock := newTestConfig(t, map[string]string{"MM_URL": api.URL, "MM_BOT_TOKEN": "bot-token", "MM_DM_USERS": "alice,bob"})
+	logger := testLogger(t)
+	ev := Event{Type: EventMediaEnableFailed, MediaID: "1", Message: "x", Time: clock.Now()}
+
+	notify(context.Background(), cfg, ev, logger)
+	first := []string{
+		"GET /api/v4/users/me",
+		"POST /api/v4/users/usernames",
+		"POST /api/v4/channels/direct",
+		"POST /api/v4/channels/direct",
+		"POST /api/v4/posts",
+		"POST /api/v4/posts",
+	}
+	if got := api.Requests(); !reflect.DeepEqual(got, first) {
+		t.Errorf("первая отправка: %v, ожидалось %v", got, first)
+	}
+	// бот и каналы уже известны — только посты
+	notify(context.Background(), cfg, ev, logger)
+	if got, want := api.Requests(), []string{"POST /api/v4/posts", "POST /api/v4/posts"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("повторная отправка: %v, ожидалось %v", got, want)
+	}
+}
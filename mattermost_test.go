@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseMattermostResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr string
+	}{
+		{name: "пустое тело"},
+		{name: "ответ incoming webhook", body: "ok"},
+		{name: "ok с переводом строки", body: " ok\n"},
+		{name: "созданный пост", body: `{"id":"p1abc","channel_id":"c1","message":"Медиа отключено"}`, want: "p1abc"},
+		{
+			name:    "ошибка в ответе 200",
+			body:    `{"id":"web.incoming_webhook.invalid.app_error","message":"Invalid webhook","detailed_error":"channel not found","status_code":400}`,
+			wantErr: "Mattermost вернул ошибку (400): Invalid webhook channel not found",
+		},
+		{name: "сообщение без канала", body: `{"message":"Unable to create post"}`, wantErr: "Unable to create post"},
+		{name: "не JSON", body: "<html>proxy error</html>", wantErr: "неожиданный ответ Mattermost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMattermostResponse([]byte(tt.body))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("post id %q, ожидался %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendMattermostNotificationResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantPost  string
+		wantError bool
+	}{
+		{name: "пост", body: `{"id":"p1abc","channel_id":"c1"}`, wantPost: "p1abc"},
+		{name: "ok", body: "ok"},
+		{name: "ошибка в ответе 200", body: `{"message":"Invalid webhook","status_code":400}`, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer mm.Close()
+			cfg, _ := newTestConfig(t, map[string]string{"MM_WEBHOOK_URL": mm.URL})
+
+			postID, err := sendMattermostNotification(context.Background(), cfg, EventMediaDisabled, mattermostPayload{Text: "x"}, testLogger(t))
+			if tt.wantError {
+				if err == nil {
+					t.Fatalf("ошибка не возвращена, пост %q", postID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if postID != tt.wantPost {
+				t.Errorf("post id %q, ожидался %q", postID, tt.wantPost)
+			}
+		})
+	}
+}
+
+func TestMattermostThreadsFromPostID(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		roots []string
+	)
+	mm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p mattermostPayload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		mu.Lock()
+		roots = append(roots, p.RootID)
+		n := len(roots)
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": fmt.Sprintf("post%d", n), "channel_id": "c1"})
+	}))
+	defer mm.Close()
+	cfg, _ := newTestConfig(t, map[string]string{"MM_WEBHOOK_URL": mm.URL, "MM_THREADS": "1"})
+	logger := testLogger(t)
+
+	for _, ev := range []Event{
+		{Type: EventMediaDisabled, MediaID: "1", Message: "отключено"},
+		{Type: EventMediaStillDisabled, MediaID: "1", Message: "всё ещё отключено"},
+		{Type: EventMediaAutoEnabled, MediaID: "1", Message: "включено"},
+		// инцидент закрыт — новое отключение начинает новый тред
+		{Type: EventMediaDisabled, MediaID: "1", Message: "снова отключено"},
+	} {
+		notifyMattermost(context.Background(), cfg, ev, logger)
+	}
+	want := []string{"", "post1", "post1", ""}
+	if strings.Join(roots, ",") != strings.Join(want, ",") {
+		t.Errorf("root_id сообщений %q, ожидалось %q", roots, want)
+	}
+	if got := cfg.mmThreads.Get("1"); got != "post4" {
+		t.Errorf("корневой пост нового треда %q, ожидался post4", got)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Проверка конфигурации включённых медиа (VALIDATE_ENABLED_MEDIA) ----------------
+// Включённое медиа с пустым SMTP-сервером или путём скрипта молча не доставляет алерты,
+// что хуже явного отключения, поэтому о нём тоже сообщаем.
+
+// Типы медиа Zabbix (поле type)
+const (
+	mediaTypeEmail   = "0"
+	mediaTypeScript  = "1"
+	mediaTypeSMS     = "2"
+	mediaTypeWebhook = "4"
+)
+
+// mediaConfigFields — поля mediatype.get, нужные для проверки
+var mediaConfigFields = []string{"type", "smtp_server", "smtp_email", "exec_path", "gsm_modem", "script"}
+
+// requiredMediaFields — обязательные поля по типу медиа
+var requiredMediaFields = map[string][]string{
+	mediaTypeEmail:   {"smtp_server", "smtp_email"},
+	mediaTypeScript:  {"exec_path"},
+	mediaTypeSMS:     {"gsm_modem"},
+	mediaTypeWebhook: {"script"},
+}
+
+// emptyMediaFields возвращает обязательные поля медиа, оставленные пустыми
+func emptyMediaFields(media MediaType) []string {
+	values := map[string]string{
+		"smtp_server": media.SMTPServer,
+		"smtp_email":  media.SMTPEmail,
+		"exec_path":   media.ExecPath,
+		"gsm_modem":   media.GSMModem,
+		"script":      media.Script,
+	}
+	var empty []string
+	for _, f := range requiredMediaFields[media.Type] {
+		if strings.TrimSpace(values[f]) == "" {
+			empty = append(empty, f)
+		}
+	}
+	return empty
+}
+
+// mediaConfigWatch — последняя известная проблема по каждому медиа, чтобы не повторять уведомление каждый цикл
+type mediaConfigWatch map[string]string
+
+// Check проверяет включённые медиа и уведомляет о новых и исправленных проблемах конфигурации
+func (w mediaConfigWatch) Check(ctx context.Context, cfg *Config, mediaTypes []MediaType, logger *logrus.Logger, sysLogger *syslog.Writer) {
+	seen := map[string]bool{}
+	for _, media := range mediaTypes {
+		if media.Status != "0" {
+			continue
+		}
+		seen[media.MediaTypeID] = true
+		logEntry := logger.WithFields(logrus.Fields{"media_id": media.MediaTypeID, "media_name": media.Name})
+		empty := emptyMediaFields(media)
+		problem := strings.Join(empty, ", ")
+		prev, known := w[media.MediaTypeID]
+
+		if problem == "" {
+			if known {
+				delete(w, media.MediaTypeID)
+				logEntry.Info("Конфигурация медиа исправлена")
+				notify(ctx, cfg, Event{
+					Type:      EventMediaConfigFixed,
+					MediaID:   media.MediaTypeID,
+					MediaName: media.Name,
+					Channel:   cfg.policyFor(media.Name).Channel,
+					Message:   fmt.Sprintf("Конфигурация медиа %s исправлена", media.Name),
+				}, logger)
+			}
+			continue
+		}
+		if known && prev == problem {
+			continue
+		}
+		w[media.MediaTypeID] = problem
+		msg := fmt.Sprintf("Медиа %s включено, но не настроено: пустые поля %s — алерты через него не доставляются", media.Name, problem)
+		logEntry.WithField("empty_fields", empty).Warn(msg)
+		if sysLogger != nil {
+			_ = sysLogger.Warning(msg)
+		}
+		notify(ctx, cfg, Event{
+			Type:      EventMediaMisconfigured,
+			MediaID:   media.MediaTypeID,
+			MediaName: media.Name,
+			Channel:   cfg.policyFor(media.Name).Channel,
+			Error:     "empty fields: " + problem,
+			Message:   msg,
+		}, logger)
+	}
+	// выключенные и пропавшие медиа отслеживаются основной логикой
+	for id := range w {
+		if !seen[id] {
+			delete(w, id)
+		}
+	}
+}
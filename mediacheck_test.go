@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEmptyMediaFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		media MediaType
+		want  []string
+	}{
+		{name: "email настроен", media: MediaType{Type: mediaTypeEmail, SMTPServer: "mail.local", SMTPEmail: "zabbix@local"}},
+		{name: "email без сервера", media: MediaType{Type: mediaTypeEmail, SMTPServer: " ", SMTPEmail: "zabbix@local"}, want: []string{"smtp_server"}},
+		{name: "email пустой", media: MediaType{Type: mediaTypeEmail}, want: []string{"smtp_server", "smtp_email"}},
+		{name: "скрипт без пути", media: MediaType{Type: mediaTypeScript}, want: []string{"exec_path"}},
+		{name: "SMS без модема", media: MediaType{Type: mediaTypeSMS}, want: []string{"gsm_modem"}},
+		{name: "webhook без скрипта", media: MediaType{Type: mediaTypeWebhook, Script: "\n"}, want: []string{"script"}},
+		{name: "webhook настроен", media: MediaType{Type: mediaTypeWebhook, Script: "return 'OK';"}},
+		// для остальных типов обязательные поля не проверяются
+		{name: "неизвестный тип", media: MediaType{Type: "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := emptyMediaFields(tt.media); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("пустые поля %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMediaConfigWatch(t *testing.T) {
+	mm := newMattermostRecorder(t)
+	cfg, _ := newTestConfig(t, map[string]string{"MM_WEBHOOK_URL": mm.URL, "VALIDATE_ENABLED_MEDIA": "1", "MEDIA_NAMES": "Email,Script"})
+	logger := testLogger(t)
+	email := func(status, server string) MediaType {
+		return MediaType{MediaTypeID: "1", Name: "Email", Status: status, Type: mediaTypeEmail, SMTPServer: server, SMTPEmail: "zabbix@local"}
+	}
+	script := MediaType{MediaTypeID: "2", Name: "Script", Status: "0", Type: mediaTypeScript, ExecPath: "notify.sh"}
+
+	cycles := []struct {
+		name  string
+		media []MediaType
+		want  []string // начала отправленных сообщений
+	}{
+		{
+			name:  "включено без SMTP-сервера",
+			media: []MediaType{email("0", ""), script},
+			want:  []string{"Медиа Email включено, но не настроено: пустые поля smtp_server"},
+		},
+		{name: "та же проблема не повторяется", media: []MediaType{email("0", ""), script}},
+		{
+			name:  "исправлено",
+			media: []MediaType{email("0", "mail.local"), script},
+			want:  []string{"Конфигурация медиа Email исправлена"},
+		},
+		{name: "выключенное медиа не проверяется", media: []MediaType{email("1", ""), script}},
+		{
+			// после выключения проблема забыта — при новом включении сообщаем снова
+			name:  "снова включено без сервера",
+			media: []MediaType{email("0", ""), script},
+			want:  []string{"Медиа Email включено, но не настроено: пустые поля smtp_server"},
+		},
+		{name: "пропало из ответа", media: []MediaType{script}},
+		{
+			name:  "вернулось с той же проблемой",
+			media: []MediaType{email("0", ""), script},
+			want:  []string{"Медиа Email включено, но не настроено: пустые поля smtp_server"},
+		},
+	}
+	watch := make(mediaConfigWatch)
+	for _, c := range cycles {
+		watch.Check(context.Background(), cfg, c.media, logger, nil)
+		got := mm.Texts()
+		if len(got) != len(c.want) {
+			t.Errorf("%s: отправлено %q, ожидалось %q", c.name, got, c.want)
+			continue
+		}
+		for i := range got {
+			if !strings.HasPrefix(got[i], c.want[i]) {
+				t.Errorf("%s: сообщение %q, ожидалось начало %q", c.name, got[i], c.want[i])
+			}
+		}
+	}
+}
+
+func TestMediaTypesRequestConfigFields(t *testing.T) {
+	for _, validate := range []string{"0", "1"} {
+		zabbix := newFakeZabbix(t)
+		zabbix.Result("mediatype.get", []MediaType{})
+		cfg, _ := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "VALIDATE_ENABLED_MEDIA": validate})
+		if _, err := getMediaTypes(context.Background(), cfg, testLogger(t)); err != nil {
+			t.Fatal(err)
+		}
+		var params struct {
+			Output []string `json:"output"`
+		}
+		if err := json.Unmarshal(zabbix.Calls("mediatype.get")[0].Params, &params); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range mediaConfigFields {
+			has := false
+			for _, o := range params.Output {
+				has = has || o == f
+			}
+			if has != (validate == "1") {
+				t.Errorf("VALIDATE_ENABLED_MEDIA=%s: поле %s в output: %v", validate, f, has)
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Мониторинг полей медиа-типов ----------------
+// MEDIA_WATCH_FIELDS — поля mediatype.get, смена которых между циклами считается изменением медиа
+// (SMTP-сервер, путь скрипта, параметры webhook и т. п.). Как и для групп, первый запуск
+// только записывает baseline.
+
+const mediaFieldsStateFilename = "media_fields_state.json"
+
+// MediaParameter — параметр скрипта или webhook медиа-типа
+type MediaParameter struct {
+	Name      string `json:"name,omitempty"`
+	SortOrder string `json:"sortorder,omitempty"`
+	Value     string `json:"value"`
+}
+
+// mediaWatchFields — поля, которые можно отслеживать, и их значение у медиа
+var mediaWatchFields = map[string]func(m MediaType) string{
+	"type":                func(m MediaType) string { return m.Type },
+	"smtp_server":         func(m MediaType) string { return m.SMTPServer },
+	"smtp_port":           func(m MediaType) string { return m.SMTPPort },
+	"smtp_helo":           func(m MediaType) string { return m.SMTPHelo },
+	"smtp_email":          func(m MediaType) string { return m.SMTPEmail },
+	"smtp_security":       func(m MediaType) string { return m.SMTPSecurity },
+	"smtp_authentication": func(m MediaType) string { return m.SMTPAuthentication },
+	"username":            func(m MediaType) string { return m.Username },
+	"exec_path":           func(m MediaType) string { return m.ExecPath },
+	"exec_params":         func(m MediaType) string { return m.ExecParams },
+	"gsm_modem":           func(m MediaType) string { return m.GSMModem },
+	"script":              func(m MediaType) string { return m.Script },
+	"parameters":          func(m MediaType) string { return formatMediaParameters(m.Parameters) },
+	"timeout":             func(m MediaType) string { return m.Timeout },
+	"maxattempts":         func(m MediaType) string { return m.MaxAttempts },
+	"attempt_interval":    func(m MediaType) string { return m.AttemptInterval },
+}
+
+// mediaFieldsHidden — поля, значения которых не попадают ни в уведомление, ни в файл состояния
+// (там хранится sha256): длинные, а в параметрах webhook нередко лежат токены
+var mediaFieldsHidden = map[string]bool{"script": true, "parameters": true, "exec_params": true}
+
+// parseMediaWatchFields разбирает MEDIA_WATCH_FIELDS; пусто — поля медиа не отслеживаются
+func parseMediaWatchFields(s string) ([]string, error) {
+	var fields []string
+	seen := map[string]bool{}
+	for _, n := range splitList(s) {
+		n = strings.ToLower(n)
+		if _, ok := mediaWatchFields[n]; !ok {
+			known := make([]string, 0, len(mediaWatchFields))
+			for k := range mediaWatchFields {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("неверное поле в MEDIA_WATCH_FIELDS: %q (допустимо: %s)", n, strings.Join(known, ", "))
+		}
+		if !seen[n] {
+			seen[n] = true
+			fields = append(fields, n)
+		}
+	}
+	return fields, nil
+}
+
+func formatMediaParameters(params []MediaParameter) string {
+	parts := make([]string, 0, len(params))
+	for _, p := range params {
+		key := p.Name
+		if key == "" {
+			key = p.SortOrder
+		}
+		parts = append(parts, key+"="+p.Value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "; ")
+}
+
+// MediaFields — снимок отслеживаемых полей одного медиа
+type MediaFields struct {
+	Name   string            `json:"name"`
+	Fields map[string]string `json:"fields"`
+}
+
+type MediaFieldsState map[string]MediaFields
+
+func loadMediaFieldsState(filename string) (MediaFieldsState, bool, error) {
+	state := make(MediaFieldsState)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	if len(data) == 0 {
+		return state, true, nil
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, true, err
+	}
+	return state, true, nil
+}
+
+func snapshotMediaFields(mediaTypes []MediaType, fields []string) MediaFieldsState {
+	state := make(MediaFieldsState)
+	for _, m := range mediaTypes {
+		values := make(map[string]string, len(fields))
+		for _, f := range fields {
+			v := mediaWatchFields[f](m)
+			if mediaFieldsHidden[f] {
+				sum := sha256.Sum256([]byte(v))
+				v = hex.EncodeToString(sum[:])
+			}
+			values[f] = v
+		}
+		state[m.MediaTypeID] = MediaFields{Name: m.Name, Fields: values}
+	}
+	return state
+}
+
+// diffMediaFields описывает изменения полей одного медиа; поле, которого нет в прошлом снимке
+// (его только что добавили в MEDIA_WATCH_FIELDS), изменением не считается
+func diffMediaFields(prev, curr MediaFields, fields []string) []string {
+	var diffs []string
+	for _, f := range fields {
+		old, ok := prev.Fields[f]
+		if !ok || old == curr.Fields[f] {
+			continue
+		}
+		if mediaFieldsHidden[f] {
+			diffs = append(diffs, fmt.Sprintf("%s изменено", f))
+		} else {
+			diffs = append(diffs, fmt.Sprintf("%s %q -> %q", f, old, curr.Fields[f]))
+		}
+	}
+	return diffs
+}
+
+// processMediaFields сравнивает отслеживаемые поля медиа с прошлым циклом и уведомляет о различиях
+func processMediaFields(ctx context.Context, cfg *Config, mediaTypes []MediaType, prev MediaFieldsState, commit *cycleCommit, logger *logrus.Logger, sysLogger *syslog.Writer, baselineMode bool) {
+	current := snapshotMediaFields(mediaTypes, cfg.MediaWatchFields)
+	changed := baselineMode || len(prev) != len(current)
+
+	if !baselineMode {
+		ids := make([]string, 0, len(current))
+		for id := range current {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			cur := current[id]
+			p, ok := prev[id]
+			if !ok {
+				// новое медиа в фильтре — запоминаем без уведомления
+				changed = true
+				continue
+			}
+			if len(p.Fields) != len(cur.Fields) {
+				changed = true
+			}
+			diffs := diffMediaFields(p, cur, cfg.MediaWatchFields)
+			if len(diffs) == 0 {
+				continue
+			}
+			changed = true
+			msg := fmt.Sprintf("Изменены поля медиа %s: %s", cur.Name, strings.Join(diffs, ", "))
+			logger.WithField("media_id", id).Warn(msg)
+			if sysLogger != nil {
+				_ = sysLogger.Warning(msg)
+			}
+			notify(ctx, cfg, Event{Type: EventMediaFieldsChanged, MediaID: id, MediaName: cur.Name, Message: msg}, logger)
+		}
+	}
+
+	if !changed {
+		return
+	}
+	data, err := marshalState(current, cfg.StateCompact)
+	if err != nil {
+		logger.Errorf("Ошибка сохранения состояния полей медиа: %v", err)
+		return
+	}
+	commit.Stage(cfg.stateFile(mediaFieldsStateFilename), data)
+	if baselineMode {
+		logger.Info("Baseline полей медиа будет сохранён — уведомлений не отправлено")
+	}
+	for k := range prev {
+		delete(prev, k)
+	}
+	for k, v := range current {
+		prev[k] = v
+	}
+}
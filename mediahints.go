@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Подсказки для ненайденных медиа ----------------
+
+// Как часто можно перечитывать полный список имён медиа для подсказок
+const mediaNameCacheTTL = 15 * time.Minute
+
+// mediaNameCache — кэш полного (без фильтра) списка имён медиа-типов
+type mediaNameCache struct {
+	mu      sync.Mutex
+	names   []string
+	fetched time.Time
+}
+
+func (c *mediaNameCache) get(ctx context.Context, cfg *Config, logger *logrus.Logger) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := cfg.Clock.Now()
+	if !c.fetched.IsZero() && now.Sub(c.fetched) < mediaNameCacheTTL {
+		return c.names, nil
+	}
+	req := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "mediatype.get",
+		Params:  MediaTypeGetParams{Output: []string{"name"}},
+		Auth:    zabbixSession(cfg),
+		ID:      3,
+	}
+	var result []struct {
+		Name string `json:"name"`
+	}
+	if err := callZabbix(ctx, cfg, req, &result, logger); err != nil {
+		return nil, err
+	}
+	c.names = c.names[:0]
+	for _, m := range result {
+		c.names = append(c.names, m.Name)
+	}
+	c.fetched = now
+	return c.names, nil
+}
+
+// missingMediaNames возвращает имена из MEDIA_NAMES, которых нет в ответе mediatype.get
+func missingMediaNames(expected []string, mediaTypes []MediaType) []string {
+	found := make(map[string]bool, len(mediaTypes))
+	for _, m := range mediaTypes {
+		found[m.Name] = true
+	}
+	missing := []string{}
+	for _, n := range expected {
+		if n != "" && !found[n] {
+			missing = append(missing, n)
+		}
+	}
+	return missing
+}
+
+// suggestMediaName ищет среди существующих имён похожее на name (опечатка, регистр)
+func suggestMediaName(name string, existing []string) string {
+	target := strings.ToLower(name)
+	best := ""
+	bestDist := len([]rune(target))/3 + 1
+	if bestDist < 2 {
+		bestDist = 2
+	}
+	for _, e := range existing {
+		d := levenshtein(target, strings.ToLower(e))
+		if d < bestDist || (d == bestDist && best == "") {
+			best, bestDist = e, d
+		}
+	}
+	if best == name {
+		return ""
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// warnMissingMedia пишет предупреждение по каждому ненайденному медиа. Чтобы отличить опечатку в фильтре
+// от удалённого медиа, сверяется с полным списком имён и добавляет подсказку "возможно, имелось в виду".
+func warnMissingMedia(ctx context.Context, cfg *Config, mediaTypes []MediaType, logger *logrus.Logger) {
+	missing := missingMediaNames(cfg.MediaNames, mediaTypes)
+	if len(missing) == 0 {
+		return
+	}
+	existing, err := cfg.mediaNames.get(ctx, cfg, logger)
+	if err != nil {
+		logger.WithError(err).Debug("Не удалось получить полный список медиа для подсказок")
+	}
+	for _, name := range missing {
+		msg := fmt.Sprintf("Отслеживаемое медиа %s не найдено в Zabbix", name)
+		if hint := suggestMediaName(name, existing); hint != "" {
+			msg += fmt.Sprintf(" — возможно, имелось в виду %q?", hint)
+		}
+		logger.WithField("media_name", name).Warn(msg)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestSuggestMediaName(t *testing.T) {
+	existing := []string{"Email", "SMS", "Telegram", "Mattermost ops"}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Emial", want: "Email"},
+		{name: "email", want: "Email"},
+		{name: "Telegarm", want: "Telegram"},
+		{name: "SMS ", want: "SMS"},
+		{name: "Mattermost-ops", want: "Mattermost ops"},
+		{name: "Email", want: ""},
+		{name: "Slack", want: ""},
+		{name: "Jabber", want: ""},
+	}
+	for _, tt := range tests {
+		if got := suggestMediaName(tt.name, existing); got != tt.want {
+			t.Errorf("suggestMediaName(%q) = %q, ожидалось %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMissingMediaNames(t *testing.T) {
+	got := missingMediaNames([]string{"Email", "", "Emial", "SMS"}, []MediaType{{Name: "Email"}, {Name: "Telegram"}})
+	if want := []string{"Emial", "SMS"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ненайденные %v, ожидалось %v", got, want)
+	}
+}
+
+func TestWarnMissingMediaHint(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	cfg, clock := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "MEDIA_NAMES": "Email,Emial,Fax"})
+	zabbix.Result("mediatype.get", []MediaType{{Name: "Email"}, {Name: "SMS"}})
+	logger, hook := logtest.NewNullLogger()
+	returned := []MediaType{{MediaTypeID: "1", Name: "Email", Status: "0"}}
+
+	warnings := func() []string {
+		var out []string
+		for _, e := range hook.AllEntries() {
+			if e.Level == logrus.WarnLevel {
+				out = append(out, e.Message)
+			}
+		}
+		hook.Reset()
+		return out
+	}
+
+	warnMissingMedia(context.Background(), cfg, returned, logger)
+	want := []string{
+		`Отслеживаемое медиа Emial не найдено в Zabbix — возможно, имелось в виду "Email"?`,
+		"Отслеживаемое медиа Fax не найдено в Zabbix",
+	}
+	if got := warnings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("предупреждения %q, ожидалось %q", got, want)
+	}
+
+	// полный список имён кэшируется
+	warnMissingMedia(context.Background(), cfg, returned, logger)
+	if n := len(zabbix.Calls("mediatype.get")); n != 1 {
+		t.Errorf("mediatype.get вызван %d раз в пределах кэша, ожидался один", n)
+	}
+	clock.Advance(mediaNameCacheTTL)
+	zabbix.Result("mediatype.get", []MediaType{{Name: "Email"}, {Name: "Fax"}})
+	warnMissingMedia(context.Background(), cfg, returned, logger)
+	if n := len(zabbix.Calls("mediatype.get")); n != 2 {
+		t.Errorf("mediatype.get вызван %d раз после истечения кэша, ожидалось 2", n)
+	}
+	for _, w := range warnings() {
+		if strings.HasPrefix(w, "Отслеживаемое медиа Fax") && strings.Contains(w, "имелось в виду") {
+			t.Errorf("подсказка с тем же именем: %q", w)
+		}
+	}
+
+	// медиа нашлись — лишних запросов нет
+	warnMissingMedia(context.Background(), cfg, []MediaType{{Name: "Email"}, {Name: "Emial"}, {Name: "Fax"}}, logger)
+	if got := warnings(); got != nil {
+		t.Errorf("лишние предупреждения %q", got)
+	}
+}
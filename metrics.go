@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ---------------- Метрики Prometheus ----------------
+
+// Метка server — имя сервера Zabbix; с одним сервером она пустая, то есть её нет.
+
+// otherMediaLabel — метка для медиа вне списка отслеживаемых, чтобы не раздувать кардинальность
+const otherMediaLabel = "other"
+
+var (
+	mediaDisabledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zmw_media_disabled_total",
+		Help: "Сколько раз медиа было обнаружено отключённым.",
+	}, []string{"server", "media"})
+
+	mediaAutoEnabledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "zmw_media_auto_enabled_total",
+		Help: "Сколько раз медиа было автоматически включено.",
+	}, []string{"server", "media"})
+
+	mediaDisabled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zmw_media_disabled",
+		Help: "Сколько медиа сейчас отключено (по итогам последнего цикла).",
+	}, []string{"server", "media"})
+
+	mediaDisabledSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "zmw_media_disabled_seconds",
+		Help: "Сколько секунд медиа отключено (по итогам последнего цикла).",
+	}, []string{"server", "media"})
+
+	checkCycleDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "zmw_check_cycle_duration_seconds",
+		Help:    "Длительность цикла проверки.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+	})
+)
+
+// updateMediaGauges выставляет текущие значения по итогам цикла. Медиа без исходов в этом
+// цикле (перестали отслеживаться) из метрик пропадают.
+func updateMediaGauges(cfg *Config, result CycleResult) {
+	own := prometheus.Labels{"server": cfg.ServerName}
+	mediaDisabled.DeletePartialMatch(own)
+	mediaDisabledSeconds.DeletePartialMatch(own)
+	for _, o := range result.Outcomes {
+		label := cfg.mediaLabel(o.MediaName)
+		switch o.Outcome {
+		case outcomeDetected, outcomeWaiting, outcomeSuppressed, outcomeNoAutoEnable, outcomeEnableFailed, outcomeDryRun, outcomeQuietHours:
+			mediaDisabled.WithLabelValues(cfg.ServerName, label).Add(1)
+			mediaDisabledSeconds.WithLabelValues(cfg.ServerName, label).Add(o.DisabledFor.Seconds())
+		case outcomeManaged:
+		default:
+			mediaDisabled.WithLabelValues(cfg.ServerName, label).Add(0)
+		}
+	}
+}
+
+// mediaLabel возвращает имя медиа для метки, если оно в списке отслеживаемых, иначе otherMediaLabel
+func (cfg *Config) mediaLabel(name string) string {
+	if containsString(cfg.MediaNames, name) || containsString(cfg.watchedMedia, name) {
+		return name
+	}
+	return otherMediaLabel
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMediaLabel(t *testing.T) {
+	cfg, _ := newTestConfig(t, map[string]string{"MEDIA_NAMES": "Email,SMS"})
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "Email", want: "Email"},
+		{name: "SMS", want: "SMS"},
+		{name: "Fax", want: otherMediaLabel},
+		{name: "", want: otherMediaLabel},
+	}
+	for _, tt := range tests {
+		if got := cfg.mediaLabel(tt.name); got != tt.want {
+			t.Errorf("mediaLabel(%q) = %q, ожидалось %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLabeledMediaCounters(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	cfg, clock := newTestConfig(t, map[string]string{
+		"ZABBIX_API_URL":     zabbix.URL,
+		"MEDIA_NAMES":        "Email,SMS",
+		"MEDIA_OFF_DURATION": "60",
+		"MEDIA_POLICIES":     `[{"name":"slow","names":["SMS"],"off_duration":120}]`,
+	})
+	media := []MediaType{
+		{MediaTypeID: "1", Name: "Email", Status: "1"},
+		{MediaTypeID: "2", Name: "SMS", Status: "1"},
+	}
+	zabbix.Handle("mediatype.get", func(json.RawMessage) (interface{}, *fakeError) {
+		return append([]MediaType(nil), media...), nil
+	})
+	zabbix.Handle("mediatype.update", func(params json.RawMessage) (interface{}, *fakeError) {
+		var p struct {
+			MediaTypeID string `json:"mediatypeid"`
+			Status      string `json:"status"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &fakeError{Code: -32602, Message: err.Error()}
+		}
+		for i := range media {
+			if media[i].MediaTypeID == p.MediaTypeID {
+				media[i].Status = p.Status
+			}
+		}
+		return map[string][]string{"mediatypeids": {p.MediaTypeID}}, nil
+	})
+	logger := testLogger(t)
+	state := make(MediaState)
+	failures := make(EnableFailures)
+
+	// счётчики общие для всего пакета, поэтому сравниваются приросты
+	type counts struct{ disabled, enabled float64 }
+	read := func(name string) counts {
+		return counts{
+			disabled: testutil.ToFloat64(mediaDisabledTotal.WithLabelValues(cfg.ServerName, name)),
+			enabled:  testutil.ToFloat64(mediaAutoEnabledTotal.WithLabelValues(cfg.ServerName, name)),
+		}
+	}
+	base := map[string]counts{}
+	for _, name := range []string{"Email", "SMS", otherMediaLabel} {
+		base[name] = read(name)
+	}
+
+	steps := []struct {
+		advance time.Duration
+		disable func()
+		want    map[string]counts
+	}{
+		{want: map[string]counts{"Email": {1, 0}, "SMS": {1, 0}}},
+		{advance: time.Hour, want: map[string]counts{"Email": {1, 1}, "SMS": {1, 0}}},
+		{advance: time.Hour, want: map[string]counts{"Email": {1, 1}, "SMS": {1, 1}}},
+		// Email снова выключили — второе обнаружение
+		{disable: func() { media[0].Status = "1" }, want: map[string]counts{"Email": {2, 1}, "SMS": {1, 1}}},
+		{advance: time.Hour, want: map[string]counts{"Email": {2, 2}, "SMS": {1, 1}}},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		if step.disable != nil {
+			step.disable()
+		}
+		commit := newCycleCommit()
+		media, err := getMediaTypes(context.Background(), cfg, logger)
+		processMediaTypes(context.Background(), cfg, media, err, newMemoryStore(), state, failures, make(VanishState), commit, logger, nil)
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"Email", "SMS", otherMediaLabel} {
+			now := read(name)
+			got := counts{disabled: now.disabled - base[name].disabled, enabled: now.enabled - base[name].enabled}
+			if got != step.want[name] {
+				t.Errorf("шаг %d: %s — отключений %v, включений %v, ожидалось %+v", i+1, name, got.disabled, got.enabled, step.want[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Типы событий вотчера. По ним уведомления маршрутизируются и маппятся на внешние форматы.
+const (
+	EventMediaDisabled        = "media_disabled"
+	EventMediaStillDisabled   = "media_still_disabled"
+	EventMediaAutoEnabled     = "media_auto_enabled"
+	EventMediaEnableFailed    = "media_enable_failed"
+	EventMediaRestored        = "media_restored"
+	EventGroupChanged         = "group_changed"
+	EventUserChanged          = "user_changed"
+	EventTemplateChanged      = "template_changed"
+	EventMediaFieldsChanged   = "media_fields_changed"
+	EventMaintenanceOverrun   = "maintenance_overrun"
+	EventMaintenanceExpired   = "maintenance_expired"
+	EventMaintenanceDeleted   = "maintenance_deleted"
+	EventStatePersistFailed   = "state_persist_failed"
+	EventStatePersistOK       = "state_persist_restored"
+	EventHeartbeat            = "heartbeat"
+	EventCycleSkipped         = "cycle_skipped"
+	EventCycleTimeout         = "cycle_timeout"
+	EventQuietDigest          = "quiet_digest"
+	EventMediaMisconfigured   = "media_misconfigured"
+	EventMediaConfigFixed     = "media_config_fixed"
+	EventMediaReconciled      = "media_reconciled"
+	EventMediaReconcileFailed = "media_reconcile_failed"
+	EventMediaDrift           = "media_drift"
+	EventMediaScheduledOff    = "media_scheduled_off"
+	EventMediaScheduledOn     = "media_scheduled_on"
+	EventMediaSuppressed      = "media_suppressed"
+	EventUserMediaChanged     = "user_media_changed"
+	EventMediaVanished        = "media_vanished"
+	EventMediaReappeared      = "media_reappeared"
+	EventAuditSummary         = "audit_summary"
+	EventNotifySuppressed     = "notify_suppressed"
+	EventActionDisabled       = "action_disabled"
+	EventActionStillDisabled  = "action_still_disabled"
+	EventActionAutoEnabled    = "action_auto_enabled"
+	EventActionEnableFailed   = "action_enable_failed"
+	EventActionRestored       = "action_restored"
+	EventZabbixUnreachable    = "zabbix_unreachable"
+	EventZabbixRecovered      = "zabbix_recovered"
+)
+
+// Event — событие вотчера. Message — готовый текст для чатов, остальные поля — для структурированных получателей.
+type Event struct {
+	Type        string
+	Message     string
+	MediaID     string
+	MediaName   string
+	DisabledFor time.Duration
+	Threshold   time.Duration
+	Error       string
+	// Канал Mattermost из политики медиа (пусто — канал webhook)
+	Channel string
+	Time    time.Time
+}
+
+// notify рассылает событие во все настроенные каналы. Возвращает false, если событие подавлено кулдауном.
+func notify(ctx context.Context, cfg *Config, ev Event, logger *logrus.Logger) bool {
+	if ev.Time.IsZero() {
+		ev.Time = cfg.Clock.Now()
+	}
+	if cfg.ServerName != "" {
+		ev.Message = "[" + cfg.ServerName + "] " + ev.Message
+	}
+	if !cfg.throttle.Allow(ev.MediaID, ev.Type, cfg.NotifyCooldowns[ev.Type], ev.Time) {
+		logger.WithFields(logrus.Fields{"event": ev.Type, "media_id": ev.MediaID}).Debug("Уведомление подавлено кулдауном")
+		return false
+	}
+	cfg.history.Record(cfg, ev, logger)
+	if cfg.quiet.Defer(ev) {
+		logger.WithFields(logrus.Fields{"event": ev.Type, "media_id": ev.MediaID}).Info("Тихие часы — уведомление отложено до сводки")
+		return true
+	}
+	notifyChat(ctx, cfg, ev, logger)
+	notifyStructured(ctx, cfg, ev, logger)
+	return true
+}
+
+// notifyStructured отправляет событие получателям структурированных событий: CloudEvents и GENERIC_WEBHOOK_URL
+func notifyStructured(ctx context.Context, cfg *Config, ev Event, logger *logrus.Logger) {
+	if cfg.CloudEventsURL != "" {
+		sendCloudEvent(ctx, cfg, ev, logger)
+	}
+	if cfg.WebhookURL != "" {
+		sendGenericWebhook(ctx, cfg, ev, logger)
+	}
+}
+
+// notifyChat отправляет событие в чаты: в Mattermost критичные — дежурным в личку, остальные — в канал,
+// и в Telegram, если он настроен
+func notifyChat(ctx context.Context, cfg *Config, ev Event, logger *logrus.Logger) {
+	sentDM := false
+	if cfg.wantsDM(ev) {
+		// критичное событие уходит дежурным лично и в канал не дублируется
+		if err := sendMattermostDM(ctx, cfg, ev.Message, logger); err != nil {
+			logger.WithError(err).Warn("Не удалось отправить личные сообщения, уведомление уйдёт в канал")
+		} else {
+			sentDM = true
+		}
+	}
+	if mattermostWebhook(cfg) != "" && !sentDM {
+		if cfg.mmBucket.Allow(cfg.Clock.Now()) {
+			notifyMattermost(ctx, cfg, ev, logger)
+		} else {
+			logger.WithFields(logrus.Fields{"event": ev.Type, "media_id": ev.MediaID}).Warn("Превышен MM_MAX_PER_MINUTE — уведомление в Mattermost подавлено")
+		}
+	}
+	if cfg.telegramEnabled() {
+		notifyTelegram(ctx, cfg, ev, logger)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaPolicy — набор правил для группы медиа: порог отключения, автовключение и канал уведомлений.
+// Задаются в MEDIA_POLICIES как JSON-массив, off_duration — в минутах:
+//
+//	[{"name":"critical","names":["Email"],"off_duration":5,"channel":"ops-critical"},
+//	 {"name":"optional","names":["SMS"],"off_duration":120,"auto_enable":false}]
+type MediaPolicy struct {
+	Name        string        `json:"name"`
+	Names       []string      `json:"names"`
+	OffDuration time.Duration `json:"-"`
+	AutoEnable  bool          `json:"-"`
+	Channel     string        `json:"channel"`
+	// Тег медиа, переопределивший AutoEnable (AUTO_ENABLE_TAG), пусто — решение из политики
+	autoEnableTag string
+	// Порог задан для этого медиа в MEDIA_OFF_DURATION_OVERRIDES
+	offDurationOverride bool
+}
+
+type mediaPolicyJSON struct {
+	Name        string   `json:"name"`
+	Names       []string `json:"names"`
+	OffDuration *int     `json:"off_duration"`
+	AutoEnable  *bool    `json:"auto_enable"`
+	Channel     string   `json:"channel"`
+}
+
+// parseMediaPolicies разбирает MEDIA_POLICIES. Не заданный off_duration берётся из MEDIA_OFF_DURATION,
+// auto_enable по умолчанию true. Одно медиа может входить только в одну политику.
+func parseMediaPolicies(raw string, defaultOff time.Duration) ([]MediaPolicy, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	var items []mediaPolicyJSON
+	if err := json.Unmarshal([]byte(raw), &items); err != nil {
+		return nil, fmt.Errorf("неверный формат MEDIA_POLICIES: %v", err)
+	}
+
+	owner := map[string]string{}
+	policies := make([]MediaPolicy, 0, len(items))
+	for i, it := range items {
+		if it.Name == "" {
+			it.Name = fmt.Sprintf("policy-%d", i+1)
+		}
+		if len(it.Names) == 0 {
+			return nil, fmt.Errorf("MEDIA_POLICIES: у политики %s не задан список names", it.Name)
+		}
+		p := MediaPolicy{Name: it.Name, OffDuration: defaultOff, AutoEnable: true, Channel: it.Channel}
+		if it.OffDuration != nil {
+			if *it.OffDuration <= 0 {
+				return nil, fmt.Errorf("MEDIA_POLICIES: у политики %s off_duration должен быть больше 0", it.Name)
+			}
+			p.OffDuration = time.Duration(*it.OffDuration) * time.Minute
+		}
+		if it.AutoEnable != nil {
+			p.AutoEnable = *it.AutoEnable
+		}
+		for _, n := range it.Names {
+			n = strings.TrimSpace(n)
+			if prev, ok := owner[n]; ok {
+				return nil, fmt.Errorf("MEDIA_POLICIES: медиа %s указано в политиках %s и %s", n, prev, it.Name)
+			}
+			owner[n] = it.Name
+			p.Names = append(p.Names, n)
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// parseOffDurationOverrides разбирает MEDIA_OFF_DURATION_OVERRIDES вида "Email:10,SMS:120" (минуты)
+func parseOffDurationOverrides(raw string) (map[string]time.Duration, error) {
+	overrides := map[string]time.Duration{}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		// имя медиа может содержать двоеточие, поэтому минуты — после последнего
+		i := strings.LastIndex(part, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("неверный элемент MEDIA_OFF_DURATION_OVERRIDES: %q", part)
+		}
+		name := strings.TrimSpace(part[:i])
+		n, err := strconv.Atoi(strings.TrimSpace(part[i+1:]))
+		if name == "" || err != nil || n < 0 {
+			return nil, fmt.Errorf("неверный элемент MEDIA_OFF_DURATION_OVERRIDES: %q", part)
+		}
+		if _, dup := overrides[name]; dup {
+			return nil, fmt.Errorf("MEDIA_OFF_DURATION_OVERRIDES: медиа %s указано дважды", name)
+		}
+		overrides[name] = time.Duration(n) * time.Minute
+	}
+	return overrides, nil
+}
+
+// policyFor возвращает политику для медиа, а если медиа ни в одну не входит — политику по умолчанию
+// из глобальных настроек. Порог из MEDIA_OFF_DURATION_OVERRIDES важнее порога политики.
+func (cfg *Config) policyFor(mediaName string) MediaPolicy {
+	p := MediaPolicy{Name: "default", OffDuration: cfg.OffDuration, AutoEnable: true}
+find:
+	for _, policy := range cfg.Policies {
+		for _, n := range policy.Names {
+			if n == mediaName {
+				p = policy
+				break find
+			}
+		}
+	}
+	if off, ok := cfg.OffDurationOverrides[mediaName]; ok {
+		p.OffDuration = off
+		p.offDurationOverride = true
+	}
+	return p
+}
+
+// offDurationSource — откуда взят порог отключения, для логов
+func (p MediaPolicy) offDurationSource() string {
+	if p.offDurationOverride {
+		return "MEDIA_OFF_DURATION_OVERRIDES"
+	}
+	if p.Name == "default" {
+		return "MEDIA_OFF_DURATION"
+	}
+	return "политика " + p.Name
+}
+
+// autoEnableSource — кто решил про автовключение, для текстов уведомлений
+func (p MediaPolicy) autoEnableSource() string {
+	if p.autoEnableTag != "" {
+		return fmt.Sprintf("тегом %s медиа", p.autoEnableTag)
+	}
+	return "политикой " + p.Name
+}
+
+// mergeNames дополняет список отслеживаемых медиа именами из политик
+func mergeNames(names []string, policies []MediaPolicy) []string {
+	seen := map[string]bool{}
+	for _, n := range names {
+		seen[n] = true
+	}
+	for _, p := range policies {
+		for _, n := range p.Names {
+			if !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	}
+	return names
+}
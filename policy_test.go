@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMediaPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    []MediaPolicy
+		wantErr string
+	}{
+		{name: "пусто", raw: "  "},
+		{
+			name: "порог, автовключение и канал",
+			raw:  `[{"name":"critical","names":["Email"],"off_duration":5,"channel":"ops-critical"},{"name":"optional","names":["SMS"," Fax "],"off_duration":120,"auto_enable":false}]`,
+			want: []MediaPolicy{
+				{Name: "critical", Names: []string{"Email"}, OffDuration: 5 * time.Minute, AutoEnable: true, Channel: "ops-critical"},
+				{Name: "optional", Names: []string{"SMS", "Fax"}, OffDuration: 120 * time.Minute},
+			},
+		},
+		{
+			name: "значения по умолчанию",
+			raw:  `[{"names":["Email"]}]`,
+			want: []MediaPolicy{{Name: "policy-1", Names: []string{"Email"}, OffDuration: time.Hour, AutoEnable: true}},
+		},
+		{name: "не JSON", raw: `critical=Email`, wantErr: "неверный формат MEDIA_POLICIES"},
+		{name: "без names", raw: `[{"name":"critical"}]`, wantErr: "не задан список names"},
+		{name: "нулевой порог", raw: `[{"names":["Email"],"off_duration":0}]`, wantErr: "off_duration должен быть больше 0"},
+		{name: "медиа в двух политиках", raw: `[{"name":"a","names":["Email"]},{"name":"b","names":["Email"]}]`, wantErr: "указано в политиках a и b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMediaPolicies(tt.raw, time.Hour)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("политики %+v, ожидалось %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	cfg, _ := newTestConfig(t, map[string]string{
+		"MEDIA_NAMES":    "Email,Fax",
+		"MEDIA_POLICIES": `[{"name":"critical","names":["Email"],"off_duration":5,"channel":"ops-critical"},{"name":"optional","names":["SMS"],"off_duration":120,"auto_enable":false}]`,
+	})
+	tests := []struct {
+		media      string
+		policy     string
+		off        time.Duration
+		autoEnable bool
+		channel    string
+	}{
+		{media: "Email", policy: "critical", off: 5 * time.Minute, autoEnable: true, channel: "ops-critical"},
+		{media: "SMS", policy: "optional", off: 120 * time.Minute},
+		{media: "Fax", policy: "default", off: time.Hour, autoEnable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.media, func(t *testing.T) {
+			p := cfg.policyFor(tt.media)
+			if p.Name != tt.policy || p.OffDuration != tt.off || p.AutoEnable != tt.autoEnable || p.Channel != tt.channel {
+				t.Errorf("политика %+v, ожидалось %s/%v/%v/%q", p, tt.policy, tt.off, tt.autoEnable, tt.channel)
+			}
+		})
+	}
+	// медиа из политик отслеживаются, даже если их нет в MEDIA_NAMES
+	if want := []string{"Email", "Fax", "SMS"}; !reflect.DeepEqual(cfg.MediaNames, want) {
+		t.Errorf("MediaNames = %v, ожидалось %v", cfg.MediaNames, want)
+	}
+}
+
+func TestMediaPoliciesThresholdAndRouting(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{
+		"ZABBIX_API_URL":         zabbix.URL,
+		"MM_WEBHOOK_URL":         mm.URL,
+		"MEDIA_NAMES":            "Email,SMS,Fax",
+		"MEDIA_OFF_DURATION":     "60",
+		"NOTIFY_REPEAT_INTERVAL": "30",
+		"MEDIA_POLICIES":         `[{"name":"critical","names":["Email"],"off_duration":5,"channel":"ops-critical"},{"name":"optional","names":["SMS"],"off_duration":120,"auto_enable":false}]`,
+	})
+	media := []MediaType{
+		{MediaTypeID: "1", Name: "Email", Status: "1"},
+		{MediaTypeID: "2", Name: "SMS", Status: "1"},
+		{MediaTypeID: "3", Name: "Fax", Status: "1"},
+	}
+	zabbix.Handle("mediatype.get", func(json.RawMessage) (interface{}, *fakeError) {
+		return append([]MediaType(nil), media...), nil
+	})
+	zabbix.Handle("mediatype.update", func(params json.RawMessage) (interface{}, *fakeError) {
+		var p struct {
+			MediaTypeID string `json:"mediatypeid"`
+			Status      string `json:"status"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &fakeError{Code: -32602, Message: err.Error()}
+		}
+		for i := range media {
+			if media[i].MediaTypeID == p.MediaTypeID {
+				media[i].Status = p.Status
+			}
+		}
+		return map[string][]string{"mediatypeids": {p.MediaTypeID}}, nil
+	})
+	wantChannel := map[string]string{"Email": "ops-critical", "SMS": "", "Fax": ""}
+	logger := testLogger(t)
+	state := make(MediaState)
+	failures := make(EnableFailures)
+
+	steps := []struct {
+		advance time.Duration
+		// фрагменты сообщений за цикл по медиа
+		notices map[string]string
+	}{
+		{notices: map[string]string{"Email": "Обнаружено отключенное медиа", "SMS": "Обнаружено отключенное медиа", "Fax": "Обнаружено отключенное медиа"}},
+		// порог политики critical — 5 минут
+		{advance: 5 * time.Minute, notices: map[string]string{"Email": "было автоматически включено"}},
+		// Fax вне политик — глобальный MEDIA_OFF_DURATION
+		{advance: 55 * time.Minute, notices: map[string]string{"SMS": "Автоматическое включение через: 1h0m0s", "Fax": "было автоматически включено"}},
+		// напоминания не чаще кулдауна media_still_disabled
+		{advance: 29 * time.Minute},
+		{advance: time.Minute, notices: map[string]string{"SMS": "Автоматическое включение через: 30m0s"}},
+		// порог политики optional превышен, но автовключение ею запрещено
+		{advance: 30 * time.Minute, notices: map[string]string{"SMS": "Автоматическое включение отключено политикой optional"}},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		commit := newCycleCommit()
+		media, err := getMediaTypes(context.Background(), cfg, logger)
+		processMediaTypes(context.Background(), cfg, media, err, newMemoryStore(), state, failures, make(VanishState), commit, logger, nil)
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]string{}
+		for _, p := range mm.Payloads() {
+			for name, channel := range wantChannel {
+				if !strings.Contains(p.Text, name) {
+					continue
+				}
+				got[name] = p.Text
+				if p.Channel != channel {
+					t.Errorf("шаг %d: сообщение %q ушло в канал %q, ожидался %q", i+1, p.Text, p.Channel, channel)
+				}
+			}
+		}
+		if len(got) != len(step.notices) {
+			t.Errorf("шаг %d: сообщения %q, ожидалось %v", i+1, got, step.notices)
+		}
+		for name, want := range step.notices {
+			if !strings.Contains(got[name], want) {
+				t.Errorf("шаг %d: сообщение про %s %q, ожидалось с %q", i+1, name, got[name], want)
+			}
+		}
+	}
+	if updates := zabbix.Calls("mediatype.update"); len(updates) != 2 {
+		t.Errorf("mediatype.update вызван %d раз, ожидалось 2 (Email и Fax)", len(updates))
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Предпросмотр автовключений (--preview-enables) ----------------
+// Предпросмотр идёт по тому же решению decideMedia, что и цикл, поэтому учитывает DESIRED_STATE_FILE,
+// DISABLE_SCHEDULE, подавление по открытым проблемам, DRY_RUN и ENABLE_QUIET_HOURS, но ничего не меняет.
+
+// previewVerdicts возвращает решения цикла по всем выключенным отслеживаемым медиа
+func previewVerdicts(cfg *Config, mediaTypes []MediaType, state MediaState, now time.Time, suppressed func(MediaType) (string, bool), logger *logrus.Logger) []mediaVerdict {
+	var out []mediaVerdict
+	for _, media := range mediaTypes {
+		if media.Status != mediaStatusDisabled {
+			continue
+		}
+		out = append(out, decideMedia(cfg, media, state[media.MediaTypeID], now, suppressed, logger))
+	}
+	sort.Slice(out, func(i, j int) bool { return mediaLess(out[i].Media, out[j].Media) })
+	return out
+}
+
+func writePreview(w io.Writer, verdicts []mediaVerdict) {
+	if len(verdicts) == 0 {
+		fmt.Fprintln(w, "Выключенных отслеживаемых медиа нет")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "МЕДИА\tID\tПОЛИТИКА\tОТКЛЮЧЕНО\tПОРОГ\tРЕЗУЛЬТАТ")
+	for _, v := range verdicts {
+		var verdict string
+		switch v.Outcome {
+		case outcomeManaged:
+			verdict = "управляется DESIRED_STATE_FILE или DISABLE_SCHEDULE — не включается"
+		case outcomeDetected:
+			verdict = "впервые замечено — отсчёт начнётся в этом цикле"
+		case outcomeSuppressed:
+			verdict = "автовключение отложено: " + v.Reason
+		case outcomeNoAutoEnable:
+			verdict = "автовключение отключено " + v.Policy.autoEnableSource()
+		case outcomeDryRun:
+			verdict = "порог превышен, но включено не будет (DRY_RUN)"
+		case outcomeQuietHours:
+			verdict = "порог превышен, включение отложено окном ENABLE_QUIET_HOURS"
+		case outcomeAutoEnabled:
+			verdict = fmt.Sprintf("будет включено (просрочено на %v)", (v.DisabledFor - v.Policy.OffDuration).Round(time.Second))
+		default:
+			verdict = fmt.Sprintf("ожидание, осталось %v", (v.Policy.OffDuration - v.DisabledFor).Round(time.Second))
+		}
+		policy, disabled, threshold := "-", "-", "-"
+		if v.Outcome != outcomeManaged {
+			policy, threshold = v.Policy.Name, v.Policy.OffDuration.String()
+		}
+		if v.Tracked && v.Outcome != outcomeManaged {
+			disabled = v.DisabledFor.Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Media.Name, v.Media.MediaTypeID, policy, disabled, threshold, verdict)
+	}
+	tw.Flush()
+}
+
+// runPreviewEnables показывает, какие медиа были бы включены прямо сейчас, ничего не меняя.
+// Каждый сервер проверяется со своей конфигурацией и своим файлом состояния.
+func runPreviewEnables(ctx context.Context, cfg *Config, db *sql.DB, w io.Writer, logger *logrus.Logger) error {
+	servers, err := cfg.forServers()
+	if err != nil {
+		return err
+	}
+	now := cfg.Clock.Now()
+	if maintenanceModeActive(cfg) {
+		fmt.Fprintf(w, "Внимание: активен режим обслуживания (%s) — цикл будет пропущен\n", cfg.MaintenanceFile)
+	}
+	for _, c := range servers {
+		l := serverLogger(logger, c.ServerName)
+		state, _, err := newStateStore(c, db).LoadMedia()
+		if err != nil {
+			return fmt.Errorf("загрузка состояния %s: %v", c.StateFile, err)
+		}
+		mediaTypes, err := getMediaTypes(ctx, c, l)
+		if err != nil {
+			return fmt.Errorf("получение медиа-типов: %v", err)
+		}
+		mediaTypes = selectWatchedMedia(c, mediaTypes, l)
+		if c.ServerName != "" {
+			fmt.Fprintf(w, "\nСервер %s (%s)\n", c.ServerName, c.ZabbixAPIURL)
+		}
+		writePreview(w, previewVerdicts(c, mediaTypes, state, now, c.suppressor.forCycle(ctx, c, l), l))
+	}
+	return nil
+}
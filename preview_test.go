@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreviewVerdicts(t *testing.T) {
+	clock := newFakeClock()
+	now := clock.Now()
+	media := []MediaType{
+		{MediaTypeID: "1", Name: "Email", Status: mediaStatusDisabled},
+		{MediaTypeID: "2", Name: "SMS", Status: mediaStatusDisabled},
+		{MediaTypeID: "3", Name: "Slack", Status: mediaStatusDisabled},
+		{MediaTypeID: "4", Name: "Telegram", Status: mediaStatusEnabled},
+		{MediaTypeID: "5", Name: "Webhook", Status: mediaStatusDisabled},
+	}
+	state := MediaState{
+		"1": {Name: "Email", FirstSeen: now.Add(-2 * time.Hour)},
+		"2": {Name: "SMS", FirstSeen: now.Add(-10 * time.Minute)},
+		"3": {Name: "Slack", FirstSeen: now.Add(-2 * time.Hour)},
+		"4": {Name: "Telegram", FirstSeen: now.Add(-2 * time.Hour)},
+	}
+	noProblems := func(MediaType) (string, bool) { return "", false }
+	tests := []struct {
+		name       string
+		setup      func(cfg *Config)
+		suppressed func(MediaType) (string, bool)
+		want       map[string]string
+	}{
+		{
+			name: "обычный цикл",
+			want: map[string]string{"Email": outcomeAutoEnabled, "SMS": outcomeWaiting, "Slack": outcomeAutoEnabled, "Webhook": outcomeDetected},
+		},
+		{
+			name:  "DESIRED_STATE_FILE",
+			setup: func(cfg *Config) { cfg.desired = &desiredState{want: map[string]bool{"Email": false}} },
+			want:  map[string]string{"Email": outcomeManaged, "SMS": outcomeWaiting, "Slack": outcomeAutoEnabled, "Webhook": outcomeDetected},
+		},
+		{
+			name: "подавление по проблеме",
+			suppressed: func(m MediaType) (string, bool) {
+				return "открыта проблема", m.Name == "Slack"
+			},
+			want: map[string]string{"Email": outcomeAutoEnabled, "SMS": outcomeWaiting, "Slack": outcomeSuppressed, "Webhook": outcomeDetected},
+		},
+		{
+			name: "автовключение запрещено политикой",
+			setup: func(cfg *Config) {
+				cfg.Policies = []MediaPolicy{{Name: "manual", Names: []string{"Email"}, OffDuration: time.Hour}}
+			},
+			want: map[string]string{"Email": outcomeNoAutoEnable, "SMS": outcomeWaiting, "Slack": outcomeAutoEnabled, "Webhook": outcomeDetected},
+		},
+		{
+			name:  "DRY_RUN",
+			setup: func(cfg *Config) { cfg.DryRun = true },
+			want:  map[string]string{"Email": outcomeDryRun, "SMS": outcomeWaiting, "Slack": outcomeDryRun, "Webhook": outcomeDetected},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Clock: clock, OffDuration: time.Hour}
+			if tt.setup != nil {
+				tt.setup(cfg)
+			}
+			suppressed := tt.suppressed
+			if suppressed == nil {
+				suppressed = noProblems
+			}
+			verdicts := previewVerdicts(cfg, media, state, now, suppressed, testLogger(t))
+			got := map[string]string{}
+			for _, v := range verdicts {
+				got[v.Media.Name] = v.Outcome
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("решения %v, ожидалось %v", got, tt.want)
+			}
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("%s: %q, ожидалось %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestWritePreview(t *testing.T) {
+	clock := newFakeClock()
+	cfg := &Config{Clock: clock, OffDuration: time.Hour}
+	state := MediaState{
+		"1": {Name: "Email", FirstSeen: clock.Now().Add(-90 * time.Minute)},
+		"2": {Name: "SMS", FirstSeen: clock.Now().Add(-15 * time.Minute)},
+	}
+	media := []MediaType{
+		{MediaTypeID: "2", Name: "SMS", Status: mediaStatusDisabled},
+		{MediaTypeID: "1", Name: "Email", Status: mediaStatusDisabled},
+	}
+	var buf bytes.Buffer
+	writePreview(&buf, previewVerdicts(cfg, media, state, clock.Now(), func(MediaType) (string, bool) { return "", false }, testLogger(t)))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("ожидались заголовок и две строки:\n%s", buf.String())
+	}
+	if !strings.HasPrefix(lines[1], "Email") || !strings.Contains(lines[1], "будет включено (просрочено на 30m0s)") {
+		t.Errorf("строка Email: %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "SMS") || !strings.Contains(lines[2], "ожидание, осталось 45m0s") {
+		t.Errorf("строка SMS: %q", lines[2])
+	}
+
+	buf.Reset()
+	writePreview(&buf, nil)
+	if got := strings.TrimSpace(buf.String()); got != "Выключенных отслеживаемых медиа нет" {
+		t.Errorf("пустой предпросмотр: %q", got)
+	}
+}
+
+func TestRunPreviewEnables(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	serveLiveMedia(zabbix,
+		MediaType{MediaTypeID: "1", Name: "Email", Status: mediaStatusDisabled},
+		MediaType{MediaTypeID: "2", Name: "SMS", Status: mediaStatusDisabled},
+		MediaType{MediaTypeID: "3", Name: "Slack", Status: mediaStatusEnabled},
+	)
+	cfg, clock := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "MEDIA_NAMES": "Email,SMS,Slack"})
+	logger := testLogger(t)
+	// состояние из прошлых циклов: Email выключено давно, SMS недавно, Slack уже включили
+	seed := newCycleCommit()
+	if err := saveState(seed, cfg.StateFile, MediaState{
+		"1": {Name: "Email", FirstSeen: clock.Now().Add(-90 * time.Minute)},
+		"2": {Name: "SMS", FirstSeen: clock.Now().Add(-15 * time.Minute)},
+		"3": {Name: "Slack", FirstSeen: clock.Now().Add(-2 * time.Hour)},
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := seed.Commit(logger); err != nil {
+		t.Fatal(err)
+	}
+	before := readFile(t, cfg.StateFile)
+
+	var buf bytes.Buffer
+	if err := runPreviewEnables(context.Background(), cfg, nil, &buf, logger); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("ожидались заголовок и строки Email и SMS:\n%s", buf.String())
+	}
+	want := map[string]string{"Email": "будет включено (просрочено на 30m0s)", "SMS": "ожидание, осталось 45m0s"}
+	for _, line := range lines[1:] {
+		name := strings.Fields(line)[0]
+		if verdict, ok := want[name]; !ok || !strings.Contains(line, verdict) {
+			t.Errorf("строка %q, ожидалось %q", line, verdict)
+		}
+	}
+
+	// предпросмотр ничего не меняет ни в Zabbix, ни в состоянии
+	if n := len(zabbix.Calls("mediatype.update")); n != 0 {
+		t.Errorf("mediatype.update вызван %d раз", n)
+	}
+	if after := readFile(t, cfg.StateFile); after != before {
+		t.Errorf("файл состояния изменён:\n%s", after)
+	}
+}
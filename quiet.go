@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Тихие часы ----------------
+
+// enableQuietHours — окно, в которое медиа не включаются автоматически (ENABLE_QUIET_HOURS), например
+// на время ночных работ с намеренно выключенными медиа. Состояние и уведомления ведутся как обычно.
+type enableQuietHours struct {
+	clockWindow
+	spec string
+}
+
+// parseEnableQuietHours разбирает ENABLE_QUIET_HOURS вида "22:00-06:00"; пустая строка — окна нет
+func parseEnableQuietHours(spec, tz string) (*enableQuietHours, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	loc, err := loadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("неверный ENABLE_QUIET_HOURS_TZ: %v", err)
+	}
+	w, err := parseClockWindow(spec, loc)
+	if err != nil {
+		return nil, fmt.Errorf("ENABLE_QUIET_HOURS: %v", err)
+	}
+	return &enableQuietHours{clockWindow: w, spec: spec}, nil
+}
+
+// Active — отложено ли сейчас автовключение
+func (q *enableQuietHours) Active(now time.Time) bool {
+	return q != nil && q.Contains(now)
+}
+
+// quietHours — окно, в которое уведомления не отправляются, а копятся и уходят сводкой после его окончания.
+// Действия (автовключение и т.п.) выполняются как обычно. Окно может переходить через полночь.
+type quietHours struct {
+	clockWindow
+	bypass map[string]bool
+
+	mu      sync.Mutex
+	pending []Event
+}
+
+// defaultQuietBypass — события, которые отправляются и в тихие часы
+var defaultQuietBypass = []string{EventMediaEnableFailed, EventActionEnableFailed, EventStatePersistFailed, EventCycleTimeout, EventHeartbeat, EventZabbixUnreachable}
+
+// parseQuietHours разбирает QUIET_HOURS вида "22:00-07:00"; пустая строка — тихие часы выключены
+func parseQuietHours(spec, tz string, bypass []string) (*quietHours, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	loc, err := loadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("неверный QUIET_HOURS_TZ: %v", err)
+	}
+	w, err := parseClockWindow(spec, loc)
+	if err != nil {
+		return nil, fmt.Errorf("QUIET_HOURS: %v", err)
+	}
+	q := &quietHours{clockWindow: w, bypass: map[string]bool{}}
+	for _, t := range bypass {
+		q.bypass[t] = true
+	}
+	return q, nil
+}
+
+// Active — попадает ли момент в тихие часы
+func (q *quietHours) Active(now time.Time) bool {
+	return q != nil && q.Contains(now)
+}
+
+// Defer ставит событие в очередь, если сейчас тихие часы и событие не из списка исключений
+func (q *quietHours) Defer(ev Event) bool {
+	if q == nil || q.bypass[ev.Type] || !q.Active(ev.Time) {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, ev)
+	return true
+}
+
+// Flush отправляет накопленное одной сводкой, когда тихие часы закончились
+func (q *quietHours) Flush(ctx context.Context, cfg *Config, logger *logrus.Logger) {
+	if q == nil || q.Active(cfg.Clock.Now()) {
+		return
+	}
+	q.mu.Lock()
+	pending := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Сводка за тихие часы: %d уведомлений", len(pending))
+	for _, ev := range pending {
+		fmt.Fprintf(&b, "\n\n%s — %s", ev.Time.In(q.loc).Format("02.01 15:04"), ev.Message)
+	}
+	logger.WithField("count", len(pending)).Info("Тихие часы закончились — отправляется сводка")
+
+	notifyChat(ctx, cfg, Event{Type: EventQuietDigest, Message: b.String(), Time: cfg.Clock.Now()}, logger)
+	// структурированным получателям — исходные события с исходным временем
+	for _, ev := range pending {
+		notifyStructured(ctx, cfg, ev, logger)
+	}
+}
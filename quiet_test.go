@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		spec, tz string
+		wantErr  string
+		wantNil  bool
+		active   []time.Duration // смещения от начала суток (UTC), попадающие в окно
+		inactive []time.Duration
+	}{
+		{name: "выключены", spec: " ", wantNil: true},
+		{
+			name: "днём", spec: "11:00-13:00", tz: "UTC",
+			active:   []time.Duration{11 * time.Hour, 12*time.Hour + 59*time.Minute},
+			inactive: []time.Duration{10*time.Hour + 59*time.Minute, 13 * time.Hour},
+		},
+		{
+			name: "через полночь", spec: "22:00-07:00", tz: "UTC",
+			active:   []time.Duration{23 * time.Hour, 0, 6 * time.Hour},
+			inactive: []time.Duration{7 * time.Hour, 12 * time.Hour, 21 * time.Hour},
+		},
+		{
+			// 22:00–07:00 по Москве — 19:00–04:00 UTC
+			name: "в часовом поясе", spec: "22:00-07:00", tz: "Europe/Moscow",
+			active:   []time.Duration{19 * time.Hour, 3 * time.Hour},
+			inactive: []time.Duration{4 * time.Hour, 18 * time.Hour},
+		},
+		{name: "без дефиса", spec: "22:00", wantErr: "QUIET_HOURS"},
+		{name: "неверное время", spec: "25:00-07:00", wantErr: "QUIET_HOURS"},
+		{name: "пустое окно", spec: "07:00-07:00", wantErr: "совпадают"},
+		{name: "неверный пояс", spec: "22:00-07:00", tz: "Mars/Olympus", wantErr: "QUIET_HOURS_TZ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := parseQuietHours(tt.spec, tt.tz, defaultQuietBypass)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (q == nil) != tt.wantNil {
+				t.Fatalf("тихие часы %+v", q)
+			}
+			for _, off := range tt.active {
+				if !q.Active(day.Add(off)) {
+					t.Errorf("%s вне окна", day.Add(off).Format("15:04"))
+				}
+			}
+			for _, off := range tt.inactive {
+				if q.Active(day.Add(off)) {
+					t.Errorf("%s в окне", day.Add(off).Format("15:04"))
+				}
+			}
+		})
+	}
+}
+
+func TestQuietHoursDeferAndDigest(t *testing.T) {
+	ce := newCloudEventsRecorder(t)
+	mm := newMattermostRecorder(t)
+	// часы теста начинаются в 12:00 UTC — внутри окна
+	cfg, clock := newTestConfig(t, map[string]string{
+		"CLOUDEVENTS_URL":    ce.URL,
+		"MM_WEBHOOK_URL":     mm.URL,
+		"QUIET_HOURS":        "11:00-13:00",
+		"QUIET_HOURS_TZ":     "UTC",
+		"QUIET_HOURS_BYPASS": "media_enable_failed",
+	})
+	logger := testLogger(t)
+
+	sends := []struct {
+		event, media, message string
+		immediate             bool
+	}{
+		{EventMediaDisabled, "1", "Email выключен", false},
+		{EventMediaEnableFailed, "2", "SMS не удалось включить", true},
+		{EventMediaAutoEnabled, "1", "Email включён автоматически", false},
+	}
+	for _, s := range sends {
+		notify(context.Background(), cfg, Event{Type: s.event, MediaID: s.media, Message: s.message, Time: clock.Now()}, logger)
+		got := ce.Types()
+		if s.immediate != (len(got) == 1) {
+			t.Errorf("%s: отправлено %v, немедленно: %v", s.event, got, s.immediate)
+		}
+		if s.immediate != (len(mm.Payloads()) == 1) {
+			t.Errorf("%s в Mattermost, ожидалось немедленно: %v", s.event, s.immediate)
+		}
+		clock.Advance(10 * time.Minute)
+	}
+
+	// пока окно не закончилось, сводка не уходит
+	cfg.quiet.Flush(context.Background(), cfg, logger)
+	if got := ce.Types(); got != nil {
+		t.Fatalf("до конца окна отправлено %v", got)
+	}
+
+	clock.Advance(time.Hour)
+	cfg.quiet.Flush(context.Background(), cfg, logger)
+
+	payloads := mm.Payloads()
+	if len(payloads) != 1 {
+		t.Fatalf("в Mattermost %d сообщений, ожидалась одна сводка", len(payloads))
+	}
+	digest := payloads[0].Text
+	for _, want := range []string{"Сводка за тихие часы: 2", "01.03 12:00 — Email выключен", "01.03 12:20 — Email включён автоматически"} {
+		if !strings.Contains(digest, want) {
+			t.Errorf("в сводке нет %q:\n%s", want, digest)
+		}
+	}
+	if strings.Contains(digest, "SMS") {
+		t.Errorf("в сводку попало отправленное сразу событие:\n%s", digest)
+	}
+	// структурированные получатели получают исходные события
+	want := []string{cloudEventTypes[EventMediaDisabled], cloudEventTypes[EventMediaAutoEnabled]}
+	if got := ce.Types(); !reflect.DeepEqual(got, want) {
+		t.Errorf("после окна отправлено %v, ожидалось %v", got, want)
+	}
+
+	cfg.quiet.Flush(context.Background(), cfg, logger)
+	if len(mm.Payloads()) != 0 || ce.Types() != nil {
+		t.Error("сводка отправлена повторно")
+	}
+}
+
+func TestQuietHoursDoNotDeferActions(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	zabbix.Result("mediatype.update", map[string][]string{"mediatypeids": {"1"}})
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{
+		"ZABBIX_API_URL": zabbix.URL,
+		"MM_WEBHOOK_URL": mm.URL,
+		"QUIET_HOURS":    "11:00-13:00",
+		"QUIET_HOURS_TZ": "UTC",
+	})
+	logger := testLogger(t)
+	media := []MediaType{{MediaTypeID: "1", Name: "Email", Status: "1"}}
+	state := MediaState{"1": {FirstSeen: clock.Now().Add(-2 * time.Hour)}}
+
+	handleMediaTypes(context.Background(), cfg, media, newMemoryStore(), state, make(EnableFailures), newCycleCommit(), logger, nil)
+
+	if n := len(zabbix.Calls("mediatype.update")); n != 1 {
+		t.Fatalf("mediatype.update вызван %d раз, ожидался 1", n)
+	}
+	if _, ok := state["1"]; ok {
+		t.Error("медиа не включено")
+	}
+	if got := mm.Texts(); got != nil {
+		t.Errorf("в тихие часы отправлено %q", got)
+	}
+
+	clock.Advance(time.Hour)
+	cfg.quiet.Flush(context.Background(), cfg, logger)
+	if got := mm.Texts(); len(got) != 1 || !strings.Contains(got[0], "было автоматически включено") {
+		t.Errorf("после окна отправлено %q", got)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter разносит вызовы не чаще заданного числа в секунду. nil-лимитер ничего не ограничивает.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// Wait блокирует до следующего разрешённого слота и возвращает время ожидания.
+// При отмене ctx ожидание прерывается с ошибкой ctx.Err().
+func (l *rateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	var wait time.Duration
+	if l.next.After(now) {
+		wait = l.next.Sub(now)
+		l.next = l.next.Add(l.interval)
+	} else {
+		l.next = now.Add(l.interval)
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		if err := sleepContext(ctx, wait); err != nil {
+			return wait, err
+		}
+	}
+	return wait, nil
+}
+
+// sleepContext ждёт d или отмены ctx (тогда возвращает ctx.Err())
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tokenBucket пропускает подряд не больше capacity событий и восполняется до capacity за period.
+// Не пропущенные события считаются. nil-ведро ничего не ограничивает.
+type tokenBucket struct {
+	mu        sync.Mutex
+	capacity  float64
+	perSecond float64
+	tokens    float64
+	last      time.Time
+	dropped   int
+}
+
+func newTokenBucket(capacity int, period time.Duration) *tokenBucket {
+	if capacity <= 0 {
+		return nil
+	}
+	return &tokenBucket{capacity: float64(capacity), perSecond: float64(capacity) / period.Seconds(), tokens: float64(capacity)}
+}
+
+// Allow забирает жетон; без свободного жетона событие считается подавленным
+func (b *tokenBucket) Allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.perSecond
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		b.dropped++
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// TakeDropped возвращает число подавленных с прошлого вызова событий и сбрасывает счётчик
+func (b *tokenBucket) TakeDropped() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.dropped
+	b.dropped = 0
+	return n
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimiter(t *testing.T) {
+	tests := []struct {
+		perSecond float64
+		want      time.Duration // 0 — лимитера нет
+	}{
+		{perSecond: 0},
+		{perSecond: -1},
+		{perSecond: 1, want: time.Second},
+		{perSecond: 4, want: 250 * time.Millisecond},
+		{perSecond: 0.5, want: 2 * time.Second},
+	}
+	for _, tt := range tests {
+		l := newRateLimiter(tt.perSecond)
+		switch {
+		case tt.want == 0 && l != nil:
+			t.Errorf("API_RATE=%v: лимитер %+v, ожидалось без ограничения", tt.perSecond, l)
+		case tt.want != 0 && (l == nil || l.interval != tt.want):
+			t.Errorf("API_RATE=%v: лимитер %+v, ожидался интервал %v", tt.perSecond, l, tt.want)
+		}
+	}
+	if wait, err := (*rateLimiter)(nil).Wait(context.Background()); wait != 0 || err != nil {
+		t.Errorf("nil-лимитер ждал %v", wait)
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	l := newRateLimiter(0.1) // слот раз в 10s
+	if _, err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("ошибка %v, ожидался дедлайн контекста", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ожидание прервано через %v", elapsed)
+	}
+}
+
+func TestAPIRateSpacing(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	tests := []struct {
+		name       string
+		rate       string
+		minSpacing time.Duration
+	}{
+		{name: "без ограничения", rate: ""},
+		{name: "20 запросов в секунду", rate: "20", minSpacing: interval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			cfg, _ := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "API_RATE": tt.rate})
+			var (
+				mu       sync.Mutex
+				arrivals []time.Time
+			)
+			zabbix.Handle("mediatype.get", func(json.RawMessage) (interface{}, *fakeError) {
+				mu.Lock()
+				arrivals = append(arrivals, time.Now())
+				mu.Unlock()
+				return []MediaType{}, nil
+			})
+
+			// запросы из нескольких горутин делят один лимит
+			start := time.Now()
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func(id int) {
+					defer wg.Done()
+					req := ZabbixRequest{JSONRPC: "2.0", Method: "mediatype.get", Params: map[string]interface{}{"output": []string{"mediatypeid", "name", "status"}}, ID: id}
+					if _, err := doZabbixRequest(context.Background(), cfg, req, testLogger(t)); err != nil {
+						t.Error(err)
+					}
+				}(i + 1)
+			}
+			wg.Wait()
+
+			if len(arrivals) != 5 {
+				t.Fatalf("получено %d запросов, ожидалось 5", len(arrivals))
+			}
+			// задержка в сети только отодвигает приход, поэтому i-й запрос не может прийти раньше i слотов от старта
+			for i, at := range arrivals {
+				if elapsed := at.Sub(start); elapsed < time.Duration(i)*tt.minSpacing {
+					t.Errorf("запрос %d пришёл через %v, ожидалось не раньше %v", i+1, elapsed, time.Duration(i)*tt.minSpacing)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---------------- Отчёт об изменениях групп ----------------
+
+// GroupChangeRecord — запись отчёта: изменение группы и время его обнаружения
+type GroupChangeRecord struct {
+	Time time.Time `json:"time"`
+	GroupChange
+}
+
+// groupReport хранит записи за срок retention в памяти и в файле JSON Lines.
+// Файл переписывается целиком вместе с остальными файлами цикла, старые записи при этом отбрасываются.
+type groupReport struct {
+	mu        sync.RWMutex
+	filename  string
+	retention time.Duration
+	records   []GroupChangeRecord
+}
+
+func loadGroupReport(filename string, retention time.Duration) (*groupReport, error) {
+	r := &groupReport{filename: filename, retention: retention}
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return r, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec GroupChangeRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return r, err
+		}
+		r.records = append(r.records, rec)
+	}
+	return r, scanner.Err()
+}
+
+// Add добавляет изменения в отчёт и готовит файл к записи в конце цикла
+func (r *groupReport) Add(cfg *Config, changes []GroupChange, commit *cycleCommit) error {
+	now := cfg.Clock.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range changes {
+		r.records = append(r.records, GroupChangeRecord{Time: now, GroupChange: c})
+	}
+	if r.retention > 0 {
+		cutoff := now.Add(-r.retention)
+		kept := r.records[:0]
+		for _, rec := range r.records {
+			if !rec.Time.Before(cutoff) {
+				kept = append(kept, rec)
+			}
+		}
+		r.records = kept
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range r.records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	commit.Stage(r.filename, buf.Bytes())
+	return nil
+}
+
+// Between возвращает копию записей в интервале [from, to]; нулевая граница не ограничивает
+func (r *groupReport) Between(from, to time.Time) []GroupChangeRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := []GroupChangeRecord{}
+	for _, rec := range r.records {
+		if !from.IsZero() && rec.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && rec.Time.After(to) {
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+// parseReportTime принимает RFC3339 или дату YYYY-MM-DD; для даты в to берётся конец дня
+func parseReportTime(s string, endOfDay bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// ServeHTTP отдаёт GET /report/groups?from=...&to=...&format=json|csv
+func (r *groupReport) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := req.URL.Query()
+	from, err := parseReportTime(q.Get("from"), false)
+	if err != nil {
+		http.Error(w, "неверный параметр from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseReportTime(q.Get("to"), true)
+	if err != nil {
+		http.Error(w, "неверный параметр to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	records := r.Between(from, to)
+
+	format := strings.ToLower(q.Get("format"))
+	if format == "" && strings.Contains(req.Header.Get("Accept"), "text/csv") {
+		format = "csv"
+	}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="group_changes.csv"`)
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"time", "type", "group_id", "group_name", "old_name", "added_users", "removed_users", "description"})
+		for _, rec := range records {
+			_ = cw.Write([]string{
+				rec.Time.Format(time.RFC3339),
+				rec.Type,
+				rec.GroupID,
+				rec.GroupName,
+				rec.OldName,
+				strings.Join(rec.AddedUsers, ","),
+				strings.Join(rec.RemovedUsers, ","),
+				strings.TrimSpace(rec.String()),
+			})
+		}
+		cw.Flush()
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(records)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupReportQuery(t *testing.T) {
+	cfg, clock := newTestConfig(t, nil)
+	logger := testLogger(t)
+	report, err := loadGroupReport("group_changes.jsonl", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := []GroupChange{
+		{Type: GroupChangeAdded, GroupID: "7", GroupName: "Ops"},
+		{Type: GroupChangeMembers, GroupID: "7", GroupName: "Ops", AddedUsers: []string{"3", "4"}, RemovedUsers: []string{"1"}},
+		{Type: GroupChangeRenamed, GroupID: "7", GroupName: "SRE", OldName: "Ops"},
+	}
+	// по одному изменению в сутки
+	var days []string
+	var times []time.Time
+	for i, c := range changes {
+		if i > 0 {
+			clock.Advance(24 * time.Hour)
+		}
+		commit := newCycleCommit()
+		if err := report.Add(cfg, []GroupChange{c}, commit); err != nil {
+			t.Fatal(err)
+		}
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+		times = append(times, clock.Now())
+		days = append(days, clock.Now().In(time.Local).Format("2006-01-02"))
+	}
+
+	tests := []struct {
+		name   string
+		query  url.Values
+		method string
+		accept string
+		status int
+		want   []string // типы изменений в ответе
+		csv    bool
+	}{
+		{name: "без границ", want: []string{GroupChangeAdded, GroupChangeMembers, GroupChangeRenamed}},
+		{name: "from датой", query: url.Values{"from": {days[1]}}, want: []string{GroupChangeMembers, GroupChangeRenamed}},
+		{name: "один день", query: url.Values{"from": {days[1]}, "to": {days[1]}}, want: []string{GroupChangeMembers}},
+		{name: "to в RFC3339", query: url.Values{"to": {times[0].Format(time.RFC3339)}}, want: []string{GroupChangeAdded}},
+		{name: "пустой интервал", query: url.Values{"from": {times[2].Add(time.Second).Format(time.RFC3339)}}, want: []string{}},
+		{name: "CSV параметром", query: url.Values{"from": {days[1]}, "format": {"csv"}}, want: []string{GroupChangeMembers, GroupChangeRenamed}, csv: true},
+		{name: "CSV по Accept", query: url.Values{"to": {days[0]}}, accept: "text/csv", want: []string{GroupChangeAdded}, csv: true},
+		{name: "неверный from", query: url.Values{"from": {"вчера"}}, status: http.StatusBadRequest},
+		{name: "неверный to", query: url.Values{"to": {"2024-13-01"}}, status: http.StatusBadRequest},
+		{name: "POST", method: http.MethodPost, status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.method == "" {
+				tt.method = http.MethodGet
+			}
+			if tt.status == 0 {
+				tt.status = http.StatusOK
+			}
+			req := httptest.NewRequest(tt.method, "/report/groups?"+tt.query.Encode(), nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+			report.ServeHTTP(rr, req)
+			if rr.Code != tt.status {
+				t.Fatalf("код %d, ожидался %d: %s", rr.Code, tt.status, rr.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			got := []string{}
+			if tt.csv {
+				if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+					t.Errorf("Content-Type = %q", ct)
+				}
+				rows, err := csv.NewReader(rr.Body).ReadAll()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(rows) == 0 || rows[0][0] != "time" {
+					t.Fatalf("нет заголовка CSV: %v", rows)
+				}
+				for _, row := range rows[1:] {
+					got = append(got, row[1])
+					if row[1] == GroupChangeMembers && (row[5] != "3,4" || row[6] != "1") {
+						t.Errorf("строка CSV %v: ожидались added_users 3,4 и removed_users 1", row)
+					}
+				}
+			} else {
+				var records []GroupChangeRecord
+				if err := json.NewDecoder(rr.Body).Decode(&records); err != nil {
+					t.Fatal(err)
+				}
+				for _, rec := range records {
+					got = append(got, rec.Type)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("изменения %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupReportRetentionAndReload(t *testing.T) {
+	cfg, clock := newTestConfig(t, nil)
+	logger := testLogger(t)
+	report, err := loadGroupReport("group_changes.jsonl", 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"A", "B", "C"} {
+		commit := newCycleCommit()
+		if err := report.Add(cfg, []GroupChange{{Type: GroupChangeAdded, GroupID: name, GroupName: name}}, commit); err != nil {
+			t.Fatal(err)
+		}
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(36 * time.Hour)
+	}
+
+	reloaded, err := loadGroupReport("group_changes.jsonl", 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, rec := range reloaded.Between(time.Time{}, time.Time{}) {
+		got = append(got, rec.GroupName)
+	}
+	// A старше 48 часов на момент добавления C и в файл не попал
+	if want := []string{"B", "C"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("после перезагрузки %v, ожидалось %v", got, want)
+	}
+}
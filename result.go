@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// ---------------- Итоги цикла ----------------
+// processMediaTypes и processUserGroups кроме побочных действий возвращают сводку цикла,
+// чтобы вызывающий код мог решать по ней (коды выхода, метрики, ответы HTTP), не разбирая логи.
+
+// Исходы обработки одного медиа за цикл
+const (
+	outcomeOK           = "ok"             // включено и не отслеживается
+	outcomeManaged      = "managed"        // управляется DESIRED_STATE_FILE или расписанием
+	outcomeDetected     = "detected"       // впервые замечено выключенным
+	outcomeWaiting      = "waiting"        // выключено, порог ещё не превышен
+	outcomeSuppressed   = "suppressed"     // выключено, обработка отложена из-за проблемы Zabbix
+	outcomeNoAutoEnable = "no_auto_enable" // порог превышен, автовключение запрещено
+	outcomeAutoEnabled  = "auto_enabled"   // включено вотчером
+	outcomeEnableFailed = "enable_failed"  // включить не удалось
+	outcomeRestored     = "restored"       // включено кем-то другим, отслеживание снято
+	outcomeDryRun       = "dry_run"        // порог превышен, но в пробном режиме не включено
+	outcomeQuietHours   = "quiet_hours"    // порог превышен, автовключение отложено окном ENABLE_QUIET_HOURS
+)
+
+type MediaOutcome struct {
+	MediaID     string
+	MediaName   string
+	Outcome     string
+	DisabledFor time.Duration
+	Error       error
+}
+
+// CycleResult — итог обработки медиа за цикл
+type CycleResult struct {
+	// Полученные из Zabbix медиа (nil, если получить не удалось)
+	MediaTypes []MediaType
+	Outcomes   []MediaOutcome
+	// Счётчики по исходам: выключенные — все, кроме ok, managed и restored
+	Checked      int
+	Disabled     int
+	AutoEnabled  int
+	EnableFailed int
+	Restored     int
+	Errors       []error
+	// Ошибка получения медиа из API Zabbix (nil — список получен)
+	FetchError error
+}
+
+func (r *CycleResult) add(o MediaOutcome) {
+	r.Outcomes = append(r.Outcomes, o)
+	if o.Outcome == outcomeManaged {
+		return
+	}
+	r.Checked++
+	switch o.Outcome {
+	case outcomeOK:
+	case outcomeRestored:
+		r.Restored++
+	default:
+		r.Disabled++
+	}
+	switch o.Outcome {
+	case outcomeAutoEnabled:
+		r.AutoEnabled++
+	case outcomeEnableFailed:
+		r.EnableFailed++
+		r.Errors = append(r.Errors, fmt.Errorf("включение %s: %v", o.MediaName, o.Error))
+	}
+}
+
+// Failed — за цикл были ошибки получения, включения или сохранения
+func (r CycleResult) Failed() bool {
+	return len(r.Errors) > 0
+}
+
+// GroupCycleResult — итог проверки пользовательских групп за цикл
+type GroupCycleResult struct {
+	Groups   int
+	Changes  []GroupChange
+	Baseline bool
+	Errors   []error
+	// Ошибка получения групп из API Zabbix (nil — список получен)
+	FetchError error
+}
+
+func (r GroupCycleResult) Failed() bool {
+	return len(r.Errors) > 0
+}
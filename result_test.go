@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestProcessMediaTypesResultMixed(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	// Jabber на сервере нет — его включение завершится ошибкой API
+	serveLiveMedia(zabbix,
+		MediaType{MediaTypeID: "1", Name: "Email", Status: "0"},
+		MediaType{MediaTypeID: "2", Name: "SMS", Status: "1"},
+		MediaType{MediaTypeID: "3", Name: "Slack", Status: "1"},
+		MediaType{MediaTypeID: "4", Name: "Fax", Status: "1"},
+		MediaType{MediaTypeID: "6", Name: "Teams", Status: "0"},
+	)
+	cfg, clock := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "MEDIA_NAMES": "Email,SMS,Slack,Fax,Jabber,Teams"})
+	logger := testLogger(t)
+	media := []MediaType{
+		{MediaTypeID: "1", Name: "Email", Status: "0"},
+		{MediaTypeID: "2", Name: "SMS", Status: "1"},
+		{MediaTypeID: "3", Name: "Slack", Status: "1"},
+		{MediaTypeID: "4", Name: "Fax", Status: "1"},
+		{MediaTypeID: "5", Name: "Jabber", Status: "1"},
+		{MediaTypeID: "6", Name: "Teams", Status: "0"},
+	}
+	state := MediaState{
+		"3": {FirstSeen: clock.Now().Add(-10 * time.Minute)},
+		"4": {FirstSeen: clock.Now().Add(-2 * time.Hour)},
+		"5": {FirstSeen: clock.Now().Add(-3 * time.Hour)},
+		"6": {FirstSeen: clock.Now().Add(-30 * time.Minute)},
+	}
+
+	commit := newCycleCommit()
+	result := handleMediaTypes(context.Background(), cfg, media, newMemoryStore(), state, make(EnableFailures), commit, logger, nil)
+	if err := commit.Commit(logger); err != nil {
+		t.Fatal(err)
+	}
+
+	outcomes := map[string]MediaOutcome{}
+	for _, o := range result.Outcomes {
+		outcomes[o.MediaName] = o
+	}
+	tests := []struct {
+		media       string
+		outcome     string
+		disabledFor time.Duration
+		wantErr     bool
+	}{
+		{media: "Email", outcome: outcomeOK},
+		{media: "SMS", outcome: outcomeDetected},
+		{media: "Slack", outcome: outcomeWaiting, disabledFor: 10 * time.Minute},
+		{media: "Fax", outcome: outcomeAutoEnabled, disabledFor: 2 * time.Hour},
+		{media: "Jabber", outcome: outcomeEnableFailed, disabledFor: 3 * time.Hour, wantErr: true},
+		{media: "Teams", outcome: outcomeRestored},
+	}
+	for _, tt := range tests {
+		t.Run(tt.media, func(t *testing.T) {
+			o, ok := outcomes[tt.media]
+			if !ok {
+				t.Fatal("исхода нет в результате")
+			}
+			if o.Outcome != tt.outcome || o.DisabledFor != tt.disabledFor || (o.Error != nil) != tt.wantErr {
+				t.Errorf("исход %+v, ожидалось %s за %v (ошибка: %v)", o, tt.outcome, tt.disabledFor, tt.wantErr)
+			}
+		})
+	}
+
+	got := [5]int{result.Checked, result.Disabled, result.AutoEnabled, result.EnableFailed, result.Restored}
+	if want := [5]int{6, 4, 1, 1, 1}; got != want {
+		t.Errorf("проверено/выключено/включено/ошибок включения/восстановлено %v, ожидалось %v", got, want)
+	}
+	if len(result.Errors) != 1 || !result.Failed() {
+		t.Errorf("ошибки %v, ожидалась одна — включение Jabber", result.Errors)
+	}
+
+	// processMediaTypes дополняет итог полученным списком
+	media, err := getMediaTypes(context.Background(), cfg, logger)
+	result = processMediaTypes(context.Background(), cfg, media, err, newMemoryStore(), state, make(EnableFailures), make(VanishState), newCycleCommit(), logger, nil)
+	if len(result.MediaTypes) != 5 {
+		t.Errorf("медиа в итоге %d, ожидалось 5", len(result.MediaTypes))
+	}
+
+	// при ошибке получения медиа в итоге только она
+	zabbix.Handle("mediatype.get", func(json.RawMessage) (interface{}, *fakeError) {
+		return nil, &fakeError{Code: -32500, Message: "Application error."}
+	})
+	media, err = getMediaTypes(context.Background(), cfg, logger)
+	result = processMediaTypes(context.Background(), cfg, media, err, newMemoryStore(), state, make(EnableFailures), make(VanishState), newCycleCommit(), logger, nil)
+	if result.MediaTypes != nil || len(result.Outcomes) != 0 || len(result.Errors) != 1 {
+		t.Errorf("итог при ошибке получения %+v", result)
+	}
+}
+
+func TestProcessUserGroupsResult(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	cfg, _ := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL})
+	logger := testLogger(t)
+	prev := make(GroupState)
+	tests := []struct {
+		name        string
+		groups      string // ответ usergroup.get, пусто — ошибка API
+		baseline    bool
+		wantGroups  int
+		wantChanges []string
+	}{
+		{name: "baseline", groups: `[{"usrgrpid":"7","name":"Admins","users":[{"userid":"1"}]}]`, baseline: true, wantGroups: 1},
+		{
+			name:        "изменения",
+			groups:      `[{"usrgrpid":"7","name":"Admins","users":[{"userid":"1"},{"userid":"2"}]},{"usrgrpid":"8","name":"Ops","users":[{"userid":"3"}]}]`,
+			wantGroups:  2,
+			wantChanges: []string{GroupChangeAdded, GroupChangeMembers},
+		},
+		{name: "ошибка получения"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix.Handle("usergroup.get", func(json.RawMessage) (interface{}, *fakeError) {
+				if tt.groups == "" {
+					return nil, &fakeError{Code: -32500, Message: "Application error."}
+				}
+				return json.RawMessage(tt.groups), nil
+			})
+			fetched := fetchMediaAndGroups(context.Background(), cfg, logger)
+			result := processUserGroups(context.Background(), cfg, fetched.Groups, fetched.GroupErr, newMemoryStore(), prev, nil, newCycleCommit(), logger, nil, tt.baseline)
+			var changes []string
+			for _, c := range result.Changes {
+				changes = append(changes, c.Type)
+			}
+			sort.Strings(changes)
+			if result.Groups != tt.wantGroups || result.Baseline != tt.baseline || !reflect.DeepEqual(changes, tt.wantChanges) {
+				t.Errorf("итог %+v: групп %d, baseline %v, изменения %v", result, tt.wantGroups, tt.baseline, tt.wantChanges)
+			}
+			if result.Failed() != (tt.groups == "") {
+				t.Errorf("ошибки %v", result.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Выключение медиа по расписанию (DISABLE_SCHEDULE) ----------------
+// Правила — JSON-массив; медиа выключается в начале окна и включается в конце:
+//
+//	[{"names":["SMS"],"window":"19:00-09:00","days":["mon","tue","wed","thu","fri"],"tz":"Europe/Moscow"},
+//	 {"names":["SMS"],"window":"00:00-23:59","days":["sat","sun"]}]
+//
+// days — дни, в которые окно начинается (для окна через полночь утро относится к вчерашнему дню);
+// пустой список — каждый день. Выключенные по расписанию медиа запоминаются в media_schedule_state.json,
+// чтобы после перезапуска включить их вовремя, и на время окна исключаются из логики «отключено слишком долго».
+
+const scheduleStateFilename = "media_schedule_state.json"
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+type disableRule struct {
+	Names  []string
+	window clockWindow
+	days   map[time.Weekday]bool
+}
+
+type disableRuleJSON struct {
+	Names  []string `json:"names"`
+	Window string   `json:"window"`
+	Days   []string `json:"days"`
+	TZ     string   `json:"tz"`
+}
+
+// Active — действует ли правило в момент t
+func (r disableRule) Active(t time.Time) bool {
+	if !r.window.Contains(t) {
+		return false
+	}
+	if len(r.days) == 0 {
+		return true
+	}
+	local := t.In(r.window.loc)
+	day := local.Weekday()
+	if r.window.start > r.window.end && local.Hour()*60+local.Minute() < r.window.end {
+		day = (day + 6) % 7
+	}
+	return r.days[day]
+}
+
+func parseDisableSchedule(raw string) ([]disableRule, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	var items []disableRuleJSON
+	if err := json.Unmarshal([]byte(raw), &items); err != nil {
+		return nil, fmt.Errorf("неверный формат DISABLE_SCHEDULE: %v", err)
+	}
+	rules := make([]disableRule, 0, len(items))
+	for i, it := range items {
+		if len(it.Names) == 0 {
+			return nil, fmt.Errorf("DISABLE_SCHEDULE: у правила %d не задан список names", i+1)
+		}
+		loc, err := loadLocation(it.TZ)
+		if err != nil {
+			return nil, fmt.Errorf("DISABLE_SCHEDULE: правило %d: неверный tz: %v", i+1, err)
+		}
+		w, err := parseClockWindow(it.Window, loc)
+		if err != nil {
+			return nil, fmt.Errorf("DISABLE_SCHEDULE: правило %d: %v", i+1, err)
+		}
+		r := disableRule{window: w}
+		for _, n := range it.Names {
+			r.Names = append(r.Names, strings.TrimSpace(n))
+		}
+		if len(it.Days) > 0 {
+			r.days = map[time.Weekday]bool{}
+			for _, d := range it.Days {
+				key := strings.ToLower(strings.TrimSpace(d))
+				if len(key) > 3 {
+					key = key[:3] // допускаем полные имена: monday, tuesday…
+				}
+				wd, ok := weekdayNames[key]
+				if !ok {
+					return nil, fmt.Errorf("DISABLE_SCHEDULE: правило %d: неверный день %q", i+1, d)
+				}
+				r.days[wd] = true
+			}
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// ScheduleState — медиа, выключенные по расписанию: id -> запись
+type ScheduleState map[string]ScheduledDisable
+
+type ScheduledDisable struct {
+	Name       string    `json:"name"`
+	DisabledAt time.Time `json:"disabled_at"`
+}
+
+func loadScheduleState(filename string) (ScheduleState, error) {
+	state := make(ScheduleState)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	return state, json.Unmarshal(data, &state)
+}
+
+// scheduledNow — имена медиа, для которых сейчас действует правило выключения
+func (cfg *Config) scheduledNow(now time.Time) map[string]bool {
+	active := map[string]bool{}
+	for _, r := range cfg.DisableSchedule {
+		if r.Active(now) {
+			for _, n := range r.Names {
+				active[n] = true
+			}
+		}
+	}
+	return active
+}
+
+// inDisableWindow — выключено ли медиа сейчас по расписанию (такие медиа не включаются по порогу)
+func (cfg *Config) inDisableWindow(name string, now time.Time) bool {
+	return len(cfg.DisableSchedule) > 0 && cfg.scheduledNow(now)[name]
+}
+
+func scheduleNames(rules []disableRule) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, r := range rules {
+		for _, n := range r.Names {
+			if !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	}
+	return names
+}
+
+// processDisableSchedule выключает медиа в начале окна и включает обратно те, что выключил сам, в конце
+func processDisableSchedule(ctx context.Context, cfg *Config, state ScheduleState, commit *cycleCommit, logger *logrus.Logger, sysLogger *syslog.Writer) {
+	now := cfg.Clock.Now()
+	active := cfg.scheduledNow(now)
+	mediaTypes, err := getMediaTypesByName(ctx, cfg, scheduleNames(cfg.DisableSchedule), logger)
+	if err != nil {
+		logger.Errorf("Ошибка получения медиа для расписания выключения: %v", err)
+		return
+	}
+	changed := false
+	for _, media := range mediaTypes {
+		logEntry := logger.WithFields(logrus.Fields{"media_id": media.MediaTypeID, "media_name": media.Name})
+		entry, ours := state[media.MediaTypeID]
+		switch {
+		case active[media.Name] && !ours && media.Status == "0":
+			if err := disableMediaType(ctx, cfg, media.MediaTypeID, logger); err != nil {
+				logEntry.WithError(err).Error("Не удалось выключить медиа по расписанию")
+				continue
+			}
+			state[media.MediaTypeID] = ScheduledDisable{Name: media.Name, DisabledAt: now}
+			changed = true
+			msg := fmt.Sprintf("Медиа %s выключено по расписанию", media.Name)
+			logEntry.Info(msg)
+			if sysLogger != nil {
+				_ = sysLogger.Info(msg)
+			}
+			notify(ctx, cfg, Event{Type: EventMediaScheduledOff, MediaID: media.MediaTypeID, MediaName: media.Name, Channel: cfg.policyFor(media.Name).Channel, Message: msg}, logger)
+
+		case !active[media.Name] && ours:
+			if media.Status == "1" {
+				if err := enableMediaType(ctx, cfg, media, now, logger); err != nil {
+					logEntry.WithError(err).Error("Не удалось включить медиа по окончании окна расписания")
+					continue
+				}
+				msg := fmt.Sprintf("Медиа %s включено по окончании окна расписания (было выключено %v)", media.Name, now.Sub(entry.DisabledAt).Round(time.Minute))
+				logEntry.Info(msg)
+				if sysLogger != nil {
+					_ = sysLogger.Info(msg)
+				}
+				notify(ctx, cfg, Event{Type: EventMediaScheduledOn, MediaID: media.MediaTypeID, MediaName: media.Name, DisabledFor: now.Sub(entry.DisabledAt), Channel: cfg.policyFor(media.Name).Channel, Message: msg}, logger)
+			}
+			// если медиа уже включили вручную — просто забываем о нём
+			delete(state, media.MediaTypeID)
+			changed = true
+		}
+	}
+	if changed {
+		data, err := marshalState(state, cfg.StateCompact)
+		if err != nil {
+			logger.Errorf("Ошибка сохранения состояния расписания: %v", err)
+			return
+		}
+		commit.Stage(cfg.stateFile(scheduleStateFilename), data)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDisableSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    int // число правил
+		wantErr string
+	}{
+		{name: "пусто", raw: " "},
+		{
+			name: "будни и выходные",
+			raw:  `[{"names":["SMS"],"window":"19:00-09:00","days":["mon","Tuesday"," wed "],"tz":"Europe/Moscow"},{"names":["SMS"],"window":"00:00-23:59","days":["sat","sun"]}]`,
+			want: 2,
+		},
+		{name: "не JSON", raw: `SMS 19:00-09:00`, wantErr: "неверный формат DISABLE_SCHEDULE"},
+		{name: "без names", raw: `[{"window":"19:00-09:00"}]`, wantErr: "у правила 1 не задан список names"},
+		{name: "неверное окно", raw: `[{"names":["SMS"],"window":"19-09"}]`, wantErr: "правило 1"},
+		{name: "неверный пояс", raw: `[{"names":["SMS"],"window":"19:00-09:00","tz":"Nowhere/City"}]`, wantErr: "неверный tz"},
+		{name: "неверный день", raw: `[{"names":["SMS"],"window":"19:00-09:00"},{"names":["SMS"],"window":"19:00-09:00","days":["funday"]}]`, wantErr: "правило 2: неверный день"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseDisableSchedule(tt.raw)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rules) != tt.want {
+				t.Errorf("правил %d, ожидалось %d", len(rules), tt.want)
+			}
+		})
+	}
+}
+
+func TestDisableRuleActive(t *testing.T) {
+	rules, err := parseDisableSchedule(`[{"names":["SMS"],"window":"19:00-09:00","days":["mon","tue","wed","thu","fri"],"tz":"UTC"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2024-03-01 — пятница
+	fri := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"пятница днём", fri.Add(12 * time.Hour), false},
+		{"пятница вечером", fri.Add(20 * time.Hour), true},
+		{"утро субботы после пятницы", fri.Add(24*time.Hour + 8*time.Hour), true},
+		{"суббота вечером", fri.Add(24*time.Hour + 20*time.Hour), false},
+		{"утро понедельника после воскресенья", fri.Add(3*24*time.Hour + 8*time.Hour), false},
+		{"понедельник вечером", fri.Add(3*24*time.Hour + 19*time.Hour), true},
+	}
+	for _, tt := range tests {
+		if got := rules[0].Active(tt.at); got != tt.want {
+			t.Errorf("%s (%s): %v, ожидалось %v", tt.name, tt.at.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestScheduledDisableTransitions(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	live := serveLiveMedia(zabbix,
+		MediaType{MediaTypeID: "1", Name: "SMS", Status: "0"},
+		MediaType{MediaTypeID: "2", Name: "Fax", Status: "0"},
+	)
+	mm := newMattermostRecorder(t)
+	// часы теста начинаются в 12:00 UTC
+	cfg, clock := newTestConfig(t, map[string]string{
+		"ZABBIX_API_URL":   zabbix.URL,
+		"MM_WEBHOOK_URL":   mm.URL,
+		"MEDIA_NAMES":      "SMS,Fax",
+		"DISABLE_SCHEDULE": `[{"names":["SMS","Fax"],"window":"13:00-15:00","tz":"UTC"}]`,
+	})
+	logger := testLogger(t)
+	state := make(ScheduleState)
+
+	steps := []struct {
+		name     string
+		at       time.Duration // смещение от 12:00
+		manual   map[string]string
+		want     []string // отправленные сообщения
+		wantLive map[string]string
+		ours     []string // медиа, которые расписание считает выключенными им
+	}{
+		{
+			name: "до окна", at: 0,
+			wantLive: map[string]string{"SMS": "0", "Fax": "0"},
+		},
+		{
+			name: "начало окна", at: time.Hour,
+			want:     []string{"Медиа SMS выключено по расписанию", "Медиа Fax выключено по расписанию"},
+			wantLive: map[string]string{"SMS": "1", "Fax": "1"},
+			ours:     []string{"1", "2"},
+		},
+		{
+			// Fax включили вручную посреди окна — расписание не выключает его повторно
+			name: "внутри окна", at: time.Hour + 30*time.Minute, manual: map[string]string{"2": "0"},
+			wantLive: map[string]string{"SMS": "1", "Fax": "0"},
+			ours:     []string{"1", "2"},
+		},
+		{
+			name: "конец окна", at: 3 * time.Hour,
+			want:     []string{"Медиа SMS включено по окончании окна расписания (было выключено 2h0m0s)"},
+			wantLive: map[string]string{"SMS": "0", "Fax": "0"},
+		},
+		{
+			name: "после окна", at: 4 * time.Hour,
+			wantLive: map[string]string{"SMS": "0", "Fax": "0"},
+		},
+	}
+	start := clock.Now()
+	for _, step := range steps {
+		clock.now = start.Add(step.at)
+		for id, status := range step.manual {
+			live.Set(id, status)
+		}
+		commit := newCycleCommit()
+		processDisableSchedule(context.Background(), cfg, state, commit, logger, nil)
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := mm.Texts(); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: сообщения %q, ожидалось %q", step.name, got, step.want)
+		}
+		if statuses := live.Statuses(); !reflect.DeepEqual(statuses, step.wantLive) {
+			t.Errorf("%s: состояние в Zabbix %v, ожидалось %v", step.name, statuses, step.wantLive)
+		}
+		// сохранённое состояние совпадает с памятью — после перезапуска медиа включатся вовремя
+		saved, err := loadScheduleState(scheduleStateFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ours []string
+		for _, id := range []string{"1", "2"} {
+			if _, ok := saved[id]; ok {
+				ours = append(ours, id)
+			}
+		}
+		if !reflect.DeepEqual(ours, step.ours) || len(state) != len(saved) {
+			t.Errorf("%s: сохранено %v, в памяти %v, ожидалось %v", step.name, saved, state, step.ours)
+		}
+	}
+}
+
+func TestScheduledDisableSkipsOffDurationLogic(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	zabbix.Result("mediatype.update", map[string][]string{"mediatypeids": {"1"}})
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{
+		"ZABBIX_API_URL":   zabbix.URL,
+		"MM_WEBHOOK_URL":   mm.URL,
+		"MEDIA_NAMES":      "SMS",
+		"DISABLE_SCHEDULE": `[{"names":["SMS"],"window":"11:00-15:00","tz":"UTC"}]`,
+	})
+	logger := testLogger(t)
+	media := []MediaType{{MediaTypeID: "1", Name: "SMS", Status: "1"}}
+	// выключено дольше MEDIA_OFF_DURATION, но окно расписания ещё не закончилось
+	state := MediaState{"1": {FirstSeen: clock.Now().Add(-2 * time.Hour)}}
+
+	tests := []struct {
+		name        string
+		advance     time.Duration
+		wantNotice  string // пусто — сообщений нет
+		wantUpdates int
+	}{
+		{name: "в окне"},
+		// на время окна медиа снято с учёта: после окна отсчёт MEDIA_OFF_DURATION начинается заново
+		{name: "после окна", advance: 4 * time.Hour, wantNotice: "Обнаружено отключенное медиа: SMS"},
+		{name: "после окна и порога", advance: 2 * time.Hour, wantNotice: "Медиа SMS было автоматически включено", wantUpdates: 1},
+	}
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		commit := newCycleCommit()
+		handleMediaTypes(context.Background(), cfg, media, newMemoryStore(), state, make(EnableFailures), commit, logger, nil)
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+		if _, tracked := state["1"]; tracked && tt.wantNotice == "" {
+			t.Errorf("%s: медиа в окне расписания осталось в учёте", tt.name)
+		}
+		got := mm.Texts()
+		switch {
+		case tt.wantNotice == "" && got != nil:
+			t.Errorf("%s: лишние сообщения %q", tt.name, got)
+		case tt.wantNotice != "" && (len(got) != 1 || !strings.HasPrefix(got[0], tt.wantNotice)):
+			t.Errorf("%s: сообщения %q, ожидалось %q", tt.name, got, tt.wantNotice)
+		}
+		if n := len(zabbix.Calls("mediatype.update")); n != tt.wantUpdates {
+			t.Errorf("%s: mediatype.update вызван %d раз, ожидалось %d", tt.name, n, tt.wantUpdates)
+		}
+	}
+}
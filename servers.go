@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// ---------------- Несколько серверов Zabbix ----------------
+// ZABBIX_API_URL и ZABBIX_API_TOKEN могут быть списками через запятую — по серверу на элемент
+// (один токен на все серверы тоже допустим). Имена серверов задаются ZABBIX_SERVER_NAMES,
+// по умолчанию это хост из URL. Каждый сервер проверяется в общем цикле по очереди, со своими
+// файлами состояния (с суффиксом имени сервера), а уведомления начинаются с [имя сервера].
+// С одним сервером без имени всё как раньше: без суффиксов и префиксов.
+
+type zabbixServer struct {
+	Name  string
+	URL   string
+	Token string
+}
+
+// parseZabbixServers разбирает списки адресов, токенов и имён серверов
+func parseZabbixServers(urls, tokens, names string) ([]zabbixServer, error) {
+	urlList := splitList(urls)
+	tokenList := splitList(tokens)
+	nameList := splitList(names)
+	if len(urlList) <= 1 {
+		// один сервер — значения берутся как есть
+		if len(nameList) > 1 {
+			return nil, fmt.Errorf("ZABBIX_SERVER_NAMES: имён больше, чем серверов в ZABBIX_API_URL")
+		}
+		s := zabbixServer{URL: strings.TrimRight(strings.TrimSpace(urls), "/"), Token: strings.TrimSpace(tokens)}
+		if len(nameList) == 1 {
+			s.Name = nameList[0]
+		}
+		return []zabbixServer{s}, nil
+	}
+	if len(tokenList) > 1 && len(tokenList) != len(urlList) {
+		return nil, fmt.Errorf("ZABBIX_API_TOKEN: токенов %d, а серверов в ZABBIX_API_URL %d", len(tokenList), len(urlList))
+	}
+	if len(nameList) > 0 && len(nameList) != len(urlList) {
+		return nil, fmt.Errorf("ZABBIX_SERVER_NAMES: имён %d, а серверов в ZABBIX_API_URL %d", len(nameList), len(urlList))
+	}
+
+	servers := make([]zabbixServer, 0, len(urlList))
+	seen := map[string]bool{}
+	for i, u := range urlList {
+		s := zabbixServer{URL: strings.TrimRight(u, "/")}
+		switch len(tokenList) {
+		case 0:
+		case 1:
+			s.Token = tokenList[0]
+		default:
+			s.Token = tokenList[i]
+		}
+		if len(nameList) > 0 {
+			s.Name = nameList[i]
+		} else if parsed, err := url.Parse(s.URL); err == nil && parsed.Hostname() != "" {
+			s.Name = parsed.Hostname()
+		} else {
+			s.Name = fmt.Sprintf("zabbix%d", i+1)
+		}
+		if strings.Trim(s.Name, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789._-") != "" {
+			// имя входит в имена файлов состояния
+			return nil, fmt.Errorf("ZABBIX_SERVER_NAMES: недопустимое имя сервера %q (латиница, цифры, . _ -)", s.Name)
+		}
+		if seen[s.Name] {
+			return nil, fmt.Errorf("сервер %s указан дважды — задайте разные имена в ZABBIX_SERVER_NAMES", s.Name)
+		}
+		seen[s.Name] = true
+		servers = append(servers, s)
+	}
+	return servers, nil
+}
+
+// forServers возвращает конфигурацию для каждого сервера. Общими остаются доставка уведомлений,
+// тихие часы, журнал событий и расписание цикла; всё, что хранит данные по id медиа, у каждого сервера своё.
+func (cfg *Config) forServers() ([]*Config, error) {
+	if len(cfg.servers) == 0 || (len(cfg.servers) == 1 && cfg.servers[0].Name == "") {
+		return []*Config{cfg}, nil
+	}
+	out := make([]*Config, 0, len(cfg.servers))
+	for _, s := range cfg.servers {
+		c := *cfg
+		c.ServerName = s.Name
+		c.ZabbixAPIURL = s.URL
+		c.APIToken = s.Token
+		c.StateFile = c.stateFile(cfg.StateFile)
+		c.GroupStateFile = c.stateFile(cfg.GroupStateFile)
+		if cfg.GroupReportFile != "" {
+			c.GroupReportFile = c.stateFile(cfg.GroupReportFile)
+		}
+		c.CloudEventsSource = strings.TrimRight(cfg.CloudEventsSource, "/") + "/" + s.Name
+		c.mmThreads = newThreadStore()
+		c.mediaNames = &mediaNameCache{}
+		c.throttle = newEventThrottle()
+		c.clockSteps = &clockWatch{}
+		if cfg.suppressor != nil {
+			sup := *cfg.suppressor
+			sup.notified = map[string]bool{}
+			c.suppressor = &sup
+		}
+		if cfg.desired != nil {
+			var err error
+			if c.desired, err = newDesiredState(cfg.desired.file); err != nil {
+				return nil, fmt.Errorf("DESIRED_STATE_FILE: %v", err)
+			}
+		}
+		out = append(out, &c)
+	}
+	return out, nil
+}
+
+// stateFile добавляет к имени файла состояния имя сервера: media_state.json -> media_state.zbx1.json
+func (cfg *Config) stateFile(name string) string {
+	if cfg.ServerName == "" {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + cfg.ServerName + ext
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// signatureHeader — заголовок с HMAC-SHA256 тела запроса (hex) для проверки подлинности на стороне получателя
+const signatureHeader = "X-Signature"
+
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook отправляет тело на webhook; если задан secret — подписывает его в X-Signature
+func postWebhook(ctx context.Context, cfg *Config, url, contentType, secret string, body []byte) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	if secret != "" {
+		header.Set(signatureHeader, signPayload(secret, body))
+	}
+	return doHTTP(ctx, cfg, http.MethodPost, url, header, body)
+}
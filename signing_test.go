@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSignPayload(t *testing.T) {
+	// пример из документации HMAC (RFC 4231, тест 2)
+	got := signPayload("Jefe", []byte("what do ya want for nothing?"))
+	if want := "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"; got != want {
+		t.Errorf("подпись %s, ожидалась %s", got, want)
+	}
+}
+
+func TestWebhookSignatureHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		target string // какой приёмник проверяется: CLOUDEVENTS_URL или GENERIC_WEBHOOK_URL
+		secret string // ожидаемый ключ подписи, пусто — заголовка нет
+	}{
+		{name: "CloudEvents с секретом", env: map[string]string{"CLOUDEVENTS_SECRET": "ce-secret"}, target: "CLOUDEVENTS_URL", secret: "ce-secret"},
+		{name: "CloudEvents без секрета", target: "CLOUDEVENTS_URL"},
+		{name: "webhook со своим секретом", env: map[string]string{"GENERIC_WEBHOOK_SECRET": "hook-secret", "CLOUDEVENTS_SECRET": "ce-secret"}, target: "GENERIC_WEBHOOK_URL", secret: "hook-secret"},
+		{name: "секрет CloudEvents не подписывает webhook", env: map[string]string{"CLOUDEVENTS_SECRET": "ce-secret"}, target: "GENERIC_WEBHOOK_URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				body      []byte
+				signature string
+				signed    bool
+			)
+			sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = io.ReadAll(r.Body)
+				signature = r.Header.Get(signatureHeader)
+				_, signed = r.Header[signatureHeader]
+			}))
+			defer sink.Close()
+			env := map[string]string{tt.target: sink.URL}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			cfg, clock := newTestConfig(t, env)
+
+			notify(context.Background(), cfg, Event{Type: EventMediaDisabled, Message: "Email отключён", MediaID: "1", MediaName: "Email", Time: clock.Now()}, testLogger(t))
+
+			if len(body) == 0 {
+				t.Fatal("приёмник не получил запрос")
+			}
+			if tt.secret == "" {
+				if signed {
+					t.Errorf("тело подписано без секрета: %s", signature)
+				}
+				return
+			}
+			mac := hmac.New(sha256.New, []byte(tt.secret))
+			mac.Write(body)
+			if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+				t.Errorf("%s = %q, ожидался HMAC тела %q", signatureHeader, signature, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// simEvent — одно синтетическое событие из --simulate
+type simEvent struct {
+	Action string
+	Name   string
+}
+
+// parseSimulateSpec разбирает строку вида "disable:Email,enable:SMS"
+func parseSimulateSpec(spec string) ([]simEvent, error) {
+	events := []simEvent{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		action, name, ok := strings.Cut(part, ":")
+		action = strings.ToLower(strings.TrimSpace(action))
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("неверное событие %q, ожидается действие:имя", part)
+		}
+		switch action {
+		case "disable", "enable", "overdue":
+		default:
+			return nil, fmt.Errorf("неизвестное действие %q (допустимо: disable, enable, overdue)", action)
+		}
+		events = append(events, simEvent{Action: action, Name: name})
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("не задано ни одного события")
+	}
+	return events, nil
+}
+
+// runSimulation прогоняет события через обычную обработку медиа.
+// Состояние держится только в памяти и на диск не пишется, mediatype.update не вызывается,
+// а уведомления и syslog срабатывают как в боевом цикле.
+//
+//	disable — медиа впервые замечено выключенным
+//	overdue — медиа выключено дольше порога (срабатывает автовключение)
+//	enable  — медиа снова включено
+func runSimulation(ctx context.Context, cfg *Config, spec string, logger *logrus.Logger, sysLogger *syslog.Writer) error {
+	events, err := parseSimulateSpec(spec)
+	if err != nil {
+		return err
+	}
+	cfg.Simulate = true
+	store := newMemoryStore()
+	state := make(MediaState)
+	commit := newCycleCommit()
+	failures := make(EnableFailures)
+
+	for _, ev := range events {
+		media := MediaType{MediaTypeID: "sim-" + ev.Name, Name: ev.Name, Status: "1"}
+		switch ev.Action {
+		case "enable":
+			media.Status = "0"
+			if _, ok := state[media.MediaTypeID]; !ok {
+				state[media.MediaTypeID] = &MediaRecord{FirstSeen: cfg.Clock.Now(), Name: media.Name}
+			}
+		case "overdue":
+			state[media.MediaTypeID] = &MediaRecord{FirstSeen: cfg.Clock.Now().Add(-cfg.OffDuration), Name: media.Name}
+		}
+		logger.WithFields(logrus.Fields{"action": ev.Action, "media_name": ev.Name}).Info("[SIMULATE] Синтетическое событие")
+		handleMediaTypes(ctx, cfg, []MediaType{media}, store, state, failures, commit, logger, sysLogger)
+	}
+	logger.Infof("Симуляция завершена: обработано %d событий", len(events))
+	return nil
+}
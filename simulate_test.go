@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSimulateSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []simEvent
+		wantErr bool
+	}{
+		{spec: "disable:Email", want: []simEvent{{Action: "disable", Name: "Email"}}},
+		{spec: " Disable : Email , enable:SMS ,", want: []simEvent{{Action: "disable", Name: "Email"}, {Action: "enable", Name: "SMS"}}},
+		{spec: "overdue:Email Gateway", want: []simEvent{{Action: "overdue", Name: "Email Gateway"}}},
+		{spec: "", wantErr: true},
+		{spec: "disable", wantErr: true},
+		{spec: "disable:", wantErr: true},
+		{spec: "delete:Email", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSimulateSpec(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: ошибка %v, ожидалась ошибка: %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: %+v, ожидалось %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestRunSimulation(t *testing.T) {
+	const (
+		disabled = "Обнаружено отключенное медиа: Email"
+		enabled  = "Медиа Email было автоматически включено"
+		restored = "Медиа восстановлено: Email"
+	)
+	tests := []struct {
+		name string
+		spec string
+		want []string
+	}{
+		{name: "выключение", spec: "disable:Email", want: []string{disabled}},
+		{name: "просрочено", spec: "overdue:Email", want: []string{enabled}},
+		{name: "включение", spec: "enable:Email", want: []string{restored}},
+		{
+			name: "полный цикл",
+			spec: "disable:Email,overdue:Email,disable:Email,enable:Email",
+			want: []string{disabled, enabled, disabled, restored},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			mm := newMattermostRecorder(t)
+			cfg, err := loadTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "MM_WEBHOOK_URL": mm.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := runSimulation(context.Background(), cfg, tt.spec, testLogger(t), nil); err != nil {
+				t.Fatal(err)
+			}
+			got := mm.Texts()
+			if len(got) != len(tt.want) {
+				t.Fatalf("уведомления %q, ожидалось %d", got, len(tt.want))
+			}
+			for i, text := range got {
+				if !strings.HasPrefix(text, "[SIMULATE] ") || !strings.Contains(text, tt.want[i]) {
+					t.Errorf("уведомление %d = %q, ожидалось [SIMULATE] … %q", i+1, text, tt.want[i])
+				}
+			}
+			// симуляция не обращается к Zabbix
+			if calls := zabbix.Calls(""); len(calls) != 0 {
+				t.Errorf("запросы к Zabbix: %+v", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Задержка перед первым циклом ----------------
+
+// startupPollInterval — пауза между проверками готовности (в тестах уменьшается)
+var startupPollInterval = 5 * time.Second
+
+// waitForStartup выдерживает STARTUP_DELAY и, если включено, ждёт доступности хоста Zabbix API
+// по TCP — чтобы при загрузке узла не слать ложные алерты, пока не поднялись DNS, прокси и сам Zabbix.
+// По истечении STARTUP_READY_TIMEOUT работа начинается в любом случае.
+func waitForStartup(cfg *Config, logger *logrus.Logger) {
+	if cfg.StartupDelay > 0 {
+		logger.Infof("Ожидание %v перед первым циклом (STARTUP_DELAY)", cfg.StartupDelay)
+		time.Sleep(cfg.StartupDelay)
+	}
+	if !cfg.StartupWaitReady {
+		return
+	}
+
+	addr, err := apiHostPort(cfg.ZabbixAPIURL)
+	if err != nil {
+		logger.WithError(err).Warn("Не удалось определить адрес Zabbix API для проверки готовности")
+		return
+	}
+	deadline := time.Now().Add(cfg.StartupReadyTimeout)
+	for attempt := 1; ; attempt++ {
+		conn, err := net.DialTimeout("tcp", addr, startupPollInterval)
+		if err == nil {
+			conn.Close()
+			if attempt > 1 {
+				logger.Infof("Zabbix API %s доступен (попытка %d)", addr, attempt)
+			}
+			return
+		}
+		if !time.Now().Before(deadline) {
+			logger.WithError(err).Warnf("Zabbix API %s недоступен дольше %v — начинаем работу без готовности", addr, cfg.StartupReadyTimeout)
+			return
+		}
+		wait := startupPollInterval
+		if left := time.Until(deadline); left < wait {
+			wait = left
+		}
+		logger.WithError(err).Infof("Zabbix API %s пока недоступен, повтор через %v", addr, wait.Round(time.Second))
+		time.Sleep(wait)
+	}
+}
+
+// apiHostPort возвращает host:port из URL API с портом по умолчанию для схемы
+func apiHostPort(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
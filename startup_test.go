@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAPIHostPort(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"http://zabbix.local/api_jsonrpc.php", "zabbix.local:80"},
+		{"https://zabbix.local/api_jsonrpc.php", "zabbix.local:443"},
+		{"https://zabbix.local:8443/api_jsonrpc.php", "zabbix.local:8443"},
+		{"http://[::1]:8080/", "[::1]:8080"},
+	}
+	for _, tt := range tests {
+		got, err := apiHostPort(tt.raw)
+		if err != nil || got != tt.want {
+			t.Errorf("apiHostPort(%q) = %q, %v; ожидалось %q", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+// freeAddr возвращает локальный адрес, на котором сейчас никто не слушает
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestWaitForStartup(t *testing.T) {
+	prev := startupPollInterval
+	startupPollInterval = 20 * time.Millisecond
+	t.Cleanup(func() { startupPollInterval = prev })
+
+	tests := []struct {
+		name      string
+		delay     time.Duration
+		waitReady bool
+		timeout   time.Duration
+		readyIn   time.Duration // через сколько API начинает принимать соединения; 0 — никогда
+		min, max  time.Duration // допустимое время ожидания
+	}{
+		{name: "без задержки", max: 50 * time.Millisecond},
+		{name: "STARTUP_DELAY", delay: 150 * time.Millisecond, min: 150 * time.Millisecond, max: time.Second},
+		{
+			name: "ждём готовности API", waitReady: true, timeout: 5 * time.Second, readyIn: 200 * time.Millisecond,
+			min: 200 * time.Millisecond, max: 2 * time.Second,
+		},
+		{
+			name: "задержка, потом готовность", delay: 100 * time.Millisecond, waitReady: true, timeout: 5 * time.Second,
+			readyIn: 250 * time.Millisecond, min: 250 * time.Millisecond, max: 2 * time.Second,
+		},
+		{
+			name: "API так и не поднялся", waitReady: true, timeout: 150 * time.Millisecond,
+			min: 150 * time.Millisecond, max: 2 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := freeAddr(t)
+			cfg, _ := newTestConfig(t, map[string]string{"ZABBIX_API_URL": "http://" + addr + "/api_jsonrpc.php"})
+			cfg.StartupDelay = tt.delay
+			cfg.StartupWaitReady = tt.waitReady
+			cfg.StartupReadyTimeout = tt.timeout
+
+			if tt.readyIn > 0 {
+				listening := make(chan net.Listener, 1)
+				timer := time.AfterFunc(tt.readyIn, func() {
+					ln, err := net.Listen("tcp", addr)
+					if err != nil {
+						t.Errorf("не удалось занять %s: %v", addr, err)
+					}
+					listening <- ln
+				})
+				t.Cleanup(func() {
+					if timer.Stop() {
+						return
+					}
+					if ln := <-listening; ln != nil {
+						ln.Close()
+					}
+				})
+			}
+
+			start := time.Now()
+			waitForStartup(cfg, testLogger(t))
+			elapsed := time.Since(start)
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("ожидание %v, ожидалось от %v до %v", elapsed, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestWaitForServersDelaysFirstCycleOnce(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	logger := testLogger(t)
+	var watchers []*watcher
+	for i := 0; i < 2; i++ {
+		cfg, _ := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL})
+		cfg.StartupDelay = 150 * time.Millisecond
+		cfg.StartupWaitReady = true
+		cfg.StartupReadyTimeout = time.Second
+		watchers = append(watchers, newWatcher(cfg, newStateStore(cfg, nil), logger, &syslogRouter{}))
+	}
+
+	start := time.Now()
+	waitForServers(context.Background(), watchers)
+	elapsed := time.Since(start)
+	// задержка выдерживается один раз на все серверы, а не на каждый
+	if elapsed < 150*time.Millisecond || elapsed >= 300*time.Millisecond {
+		t.Errorf("ожидание %v, ожидалась одна задержка 150ms", elapsed)
+	}
+	if calls := zabbix.Calls(""); len(calls) != 0 {
+		t.Errorf("до первого цикла вызваны %+v", calls)
+	}
+}
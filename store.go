@@ -0,0 +1,417 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// ---------------- Хранилище состояния медиа и групп ----------------
+// По умолчанию состояние лежит в JSON-файлах (MEDIA_STATE_FILE, GROUP_STATE_FILE).
+// С STATE_BACKEND=sqlite — в базе STATE_DSN: её могут делить несколько реплик и серверов,
+// записи различаются по имени сервера, а в media_history копится история отслеживания медиа.
+
+const (
+	stateBackendFiles  = "files"
+	stateBackendSQLite = "sqlite"
+	defaultStateDSN    = "watcher_state.db"
+)
+
+// StateStore загружает и сохраняет состояние одного сервера; обработка медиа и групп
+// работает только через него. Save* только готовят запись: в файл или в базу она попадает
+// при commit.Commit в конце цикла.
+type StateStore interface {
+	// LoadMedia — migrated: состояние в прежнем формате, его стоит пересохранить
+	LoadMedia() (MediaState, bool, error)
+	SaveMedia(commit *cycleCommit, state MediaState) error
+	// LoadGroups — existed: снимок групп уже сохранялся (иначе первая проверка создаёт baseline)
+	LoadGroups() (GroupState, bool, error)
+	SaveGroups(commit *cycleCommit, state GroupState) error
+}
+
+// newStateStore создаёт хранилище сервера; db — база из openStateDB, nil для JSON-файлов
+func newStateStore(cfg *Config, db *sql.DB) StateStore {
+	if db == nil {
+		return &fileStore{mediaFile: cfg.StateFile, groupFile: cfg.GroupStateFile, compact: cfg.StateCompact}
+	}
+	return &sqliteStore{db: db, dsn: cfg.StateDSN, server: cfg.ServerName, clock: cfg.Clock}
+}
+
+// fileStore — состояние в JSON-файлах
+type fileStore struct {
+	mediaFile string
+	groupFile string
+	compact   bool
+}
+
+func (s *fileStore) LoadMedia() (MediaState, bool, error) { return loadState(s.mediaFile) }
+
+func (s *fileStore) SaveMedia(commit *cycleCommit, state MediaState) error {
+	return saveState(commit, s.mediaFile, state, s.compact)
+}
+
+func (s *fileStore) LoadGroups() (GroupState, bool, error) { return loadGroupState(s.groupFile) }
+
+func (s *fileStore) SaveGroups(commit *cycleCommit, state GroupState) error {
+	return saveGroupState(commit, s.groupFile, state, s.compact)
+}
+
+// memoryStore — состояние только в памяти процесса: для --simulate и проверок обработки без диска
+type memoryStore struct {
+	mu      sync.Mutex
+	media   map[string]MediaRecord
+	groups  GroupState
+	existed bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{media: make(map[string]MediaRecord), groups: make(GroupState)}
+}
+
+func (s *memoryStore) LoadMedia() (MediaState, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := make(MediaState, len(s.media))
+	for id, rec := range s.media {
+		rec := rec
+		state[id] = &rec
+	}
+	return state, false, nil
+}
+
+func (s *memoryStore) SaveMedia(commit *cycleCommit, state MediaState) error {
+	next := snapshotMedia(state)
+	commit.StageTx("памяти (медиа)", func() (pendingTx, error) {
+		return memoryTx(func() {
+			s.mu.Lock()
+			s.media = next
+			s.mu.Unlock()
+		}), nil
+	})
+	return nil
+}
+
+func (s *memoryStore) LoadGroups() (GroupState, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := make(GroupState, len(s.groups))
+	for id, g := range s.groups {
+		state[id] = g
+	}
+	return state, s.existed, nil
+}
+
+func (s *memoryStore) SaveGroups(commit *cycleCommit, state GroupState) error {
+	next := make(GroupState, len(state))
+	for id, g := range state {
+		next[id] = g
+	}
+	commit.StageTx("памяти (группы)", func() (pendingTx, error) {
+		return memoryTx(func() {
+			s.mu.Lock()
+			s.groups = next
+			s.existed = true
+			s.mu.Unlock()
+		}), nil
+	})
+	return nil
+}
+
+// memoryTx — запись в память, которая применяется при фиксации коммита
+type memoryTx func()
+
+func (t memoryTx) Commit() error {
+	t()
+	return nil
+}
+
+func (t memoryTx) Rollback() error { return nil }
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS media_state (
+	server        TEXT NOT NULL,
+	media_id      TEXT NOT NULL,
+	name          TEXT NOT NULL DEFAULT '',
+	first_seen    TEXT NOT NULL,
+	last_notified TEXT,
+	notify_count  INTEGER NOT NULL DEFAULT 0,
+	updated_at    TEXT NOT NULL,
+	PRIMARY KEY (server, media_id)
+);
+CREATE TABLE IF NOT EXISTS media_history (
+	server   TEXT NOT NULL,
+	media_id TEXT NOT NULL,
+	name     TEXT NOT NULL DEFAULT '',
+	event    TEXT NOT NULL,
+	at       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS media_history_media ON media_history (server, media_id, at);
+CREATE TABLE IF NOT EXISTS group_state (
+	server     TEXT NOT NULL,
+	usrgrpid   TEXT NOT NULL,
+	snapshot   TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	PRIMARY KEY (server, usrgrpid)
+);
+CREATE TABLE IF NOT EXISTS state_baseline (
+	server   TEXT NOT NULL,
+	kind     TEXT NOT NULL,
+	saved_at TEXT NOT NULL,
+	PRIMARY KEY (server, kind)
+);`
+
+// События media_history: медиа начали отслеживать выключенным и перестали (включено или пропало)
+const (
+	mediaHistoryTracked  = "tracked"
+	mediaHistoryReleased = "released"
+)
+
+// openStateDB открывает базу STATE_DSN и создаёт таблицы; nil — состояние в файлах
+func openStateDB(cfg *Config) (*sql.DB, error) {
+	if cfg.StateBackend != stateBackendSQLite {
+		return nil, nil
+	}
+	db, err := sql.Open("sqlite", cfg.StateDSN)
+	if err != nil {
+		return nil, err
+	}
+	// одно соединение: записи серверов идут по очереди, а прагмы действуют на всё время работы
+	db.SetMaxOpenConns(1)
+	for _, q := range []string{"PRAGMA busy_timeout = 5000", "PRAGMA journal_mode = WAL", sqliteSchema} {
+		if _, err := db.Exec(q); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%s: %v", cfg.StateDSN, err)
+		}
+	}
+	return db, nil
+}
+
+// sqliteStore — состояние сервера в общей базе SQLite. Медиа пишутся построчно:
+// в транзакцию попадают только записи, изменившиеся с прошлого сохранения.
+// Медиа и группы сервера записываются одной транзакцией: у базы одно соединение,
+// и вторая открытая транзакция ждала бы первую.
+type sqliteStore struct {
+	db     *sql.DB
+	dsn    string
+	server string
+	clock  Clock
+
+	mu    sync.Mutex
+	saved map[string]MediaRecord
+	// подготовленное к записи; nil — не менялось с прошлой записи
+	nextMedia  map[string]MediaRecord
+	nextGroups map[string]string
+}
+
+func (s *sqliteStore) LoadMedia() (MediaState, bool, error) {
+	rows, err := s.db.Query(`SELECT media_id, name, first_seen, last_notified, notify_count FROM media_state WHERE server = ?`, s.server)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	state := make(MediaState)
+	for rows.Next() {
+		var id, name, firstSeen string
+		var lastNotified sql.NullString
+		rec := &MediaRecord{}
+		if err := rows.Scan(&id, &name, &firstSeen, &lastNotified, &rec.NotifyCount); err != nil {
+			return nil, false, err
+		}
+		rec.Name = name
+		if rec.FirstSeen, err = time.Parse(time.RFC3339Nano, firstSeen); err != nil {
+			return nil, false, fmt.Errorf("медиа %s: %v", id, err)
+		}
+		if lastNotified.Valid {
+			if rec.LastNotified, err = time.Parse(time.RFC3339Nano, lastNotified.String); err != nil {
+				return nil, false, fmt.Errorf("медиа %s: %v", id, err)
+			}
+		}
+		state[id] = rec
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	s.mu.Lock()
+	s.saved = snapshotMedia(state)
+	s.mu.Unlock()
+	return state, false, nil
+}
+
+func (s *sqliteStore) SaveMedia(commit *cycleCommit, state MediaState) error {
+	next := snapshotMedia(state)
+	s.mu.Lock()
+	s.nextMedia = next
+	s.mu.Unlock()
+	commit.StageTx(s.dsn, s.begin)
+	return nil
+}
+
+// begin выполняет подготовленные записи медиа и групп в транзакции; зафиксирует её коммит цикла
+func (s *sqliteStore) begin() (pendingTx, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	media, groups := s.nextMedia, s.nextGroups
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if media != nil {
+		err = s.writeMedia(tx, media)
+	}
+	if err == nil && groups != nil {
+		err = s.writeGroups(tx, groups)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return &sqliteTx{Tx: tx, done: func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if media != nil {
+			s.saved = media
+		}
+		s.nextMedia, s.nextGroups = nil, nil
+	}}, nil
+}
+
+// sqliteTx — транзакция store.begin; после фиксации отмечает записанное сохранённым
+type sqliteTx struct {
+	*sql.Tx
+	done func()
+}
+
+func (t *sqliteTx) Commit() error {
+	if err := t.Tx.Commit(); err != nil {
+		return err
+	}
+	t.done()
+	return nil
+}
+
+// writeMedia записывает изменившиеся и удаляет пропавшие с прошлого сохранения медиа
+func (s *sqliteStore) writeMedia(tx *sql.Tx, next map[string]MediaRecord) error {
+	now := formatStoreTime(s.clock.Now())
+	for id, rec := range next {
+		prev, ok := s.saved[id]
+		if ok && prev.equal(rec) {
+			continue
+		}
+		var lastNotified any
+		if !rec.LastNotified.IsZero() {
+			lastNotified = formatStoreTime(rec.LastNotified)
+		}
+		if _, err := tx.Exec(`INSERT INTO media_state (server, media_id, name, first_seen, last_notified, notify_count, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (server, media_id) DO UPDATE SET name = excluded.name, first_seen = excluded.first_seen,
+				last_notified = excluded.last_notified, notify_count = excluded.notify_count, updated_at = excluded.updated_at`,
+			s.server, id, rec.Name, formatStoreTime(rec.FirstSeen), lastNotified, rec.NotifyCount, now); err != nil {
+			return fmt.Errorf("медиа %s: %v", id, err)
+		}
+		if !ok {
+			if err := s.addHistory(tx, id, rec.Name, mediaHistoryTracked, now); err != nil {
+				return err
+			}
+		}
+	}
+	for id, prev := range s.saved {
+		if _, ok := next[id]; ok {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM media_state WHERE server = ? AND media_id = ?`, s.server, id); err != nil {
+			return fmt.Errorf("медиа %s: %v", id, err)
+		}
+		if err := s.addHistory(tx, id, prev.Name, mediaHistoryReleased, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) addHistory(tx *sql.Tx, id, name, event, at string) error {
+	if _, err := tx.Exec(`INSERT INTO media_history (server, media_id, name, event, at) VALUES (?, ?, ?, ?, ?)`, s.server, id, name, event, at); err != nil {
+		return fmt.Errorf("история медиа %s: %v", id, err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) LoadGroups() (GroupState, bool, error) {
+	state := make(GroupState)
+	var savedAt string
+	err := s.db.QueryRow(`SELECT saved_at FROM state_baseline WHERE server = ? AND kind = 'groups'`, s.server).Scan(&savedAt)
+	if err == sql.ErrNoRows {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	rows, err := s.db.Query(`SELECT usrgrpid, snapshot FROM group_state WHERE server = ?`, s.server)
+	if err != nil {
+		return state, true, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, snapshot string
+		if err := rows.Scan(&id, &snapshot); err != nil {
+			return state, true, err
+		}
+		var g UserGroup
+		if err := json.Unmarshal([]byte(snapshot), &g); err != nil {
+			return state, true, fmt.Errorf("группа %s: %v", id, err)
+		}
+		state[id] = g
+	}
+	return state, true, rows.Err()
+}
+
+// SaveGroups заменяет снимок групп сервера целиком
+func (s *sqliteStore) SaveGroups(commit *cycleCommit, state GroupState) error {
+	snapshots := make(map[string]string, len(state))
+	for id, g := range state {
+		data, err := json.Marshal(g)
+		if err != nil {
+			return err
+		}
+		snapshots[id] = string(data)
+	}
+	s.mu.Lock()
+	s.nextGroups = snapshots
+	s.mu.Unlock()
+	commit.StageTx(s.dsn, s.begin)
+	return nil
+}
+
+func (s *sqliteStore) writeGroups(tx *sql.Tx, snapshots map[string]string) error {
+	now := formatStoreTime(s.clock.Now())
+	if _, err := tx.Exec(`DELETE FROM group_state WHERE server = ?`, s.server); err != nil {
+		return err
+	}
+	for id, snapshot := range snapshots {
+		if _, err := tx.Exec(`INSERT INTO group_state (server, usrgrpid, snapshot, updated_at) VALUES (?, ?, ?, ?)`, s.server, id, snapshot, now); err != nil {
+			return fmt.Errorf("группа %s: %v", id, err)
+		}
+	}
+	_, err := tx.Exec(`INSERT INTO state_baseline (server, kind, saved_at) VALUES (?, 'groups', ?)
+		ON CONFLICT (server, kind) DO UPDATE SET saved_at = excluded.saved_at`, s.server, now)
+	return err
+}
+
+// snapshotMedia копирует записи: состояние меняется дальше по циклу, а в базу уходит
+// то, что было на момент SaveMedia
+func snapshotMedia(state MediaState) map[string]MediaRecord {
+	out := make(map[string]MediaRecord, len(state))
+	for id, rec := range state {
+		if rec != nil {
+			out[id] = *rec
+		}
+	}
+	return out
+}
+
+func formatStoreTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stateStores — реализации StateStore для общих проверок; каждое хранилище пустое и своё у теста
+var stateStores = []struct {
+	name string
+	open func(t *testing.T) StateStore
+}{
+	{name: "files", open: func(t *testing.T) StateStore {
+		dir := t.TempDir()
+		return &fileStore{mediaFile: filepath.Join(dir, "media_state.json"), groupFile: filepath.Join(dir, "usergroup_state.json")}
+	}},
+	{name: "memory", open: func(t *testing.T) StateStore { return newMemoryStore() }},
+	{name: "sqlite", open: func(t *testing.T) StateStore {
+		cfg := &Config{StateBackend: stateBackendSQLite, StateDSN: filepath.Join(t.TempDir(), "state.db"), ServerName: "main", Clock: newFakeClock()}
+		db, err := openStateDB(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return newStateStore(cfg, db)
+	}},
+}
+
+// saveAndCommit готовит запись через save и фиксирует её, как в конце цикла
+func saveAndCommit(t *testing.T, save func(c *cycleCommit) error) {
+	t.Helper()
+	c := newCycleCommit()
+	if err := save(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Commit(testLogger(t)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadMedia(t *testing.T, store StateStore) map[string]MediaRecord {
+	t.Helper()
+	state, _, err := store.LoadMedia()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshotMedia(state)
+}
+
+func TestStateStoreMedia(t *testing.T) {
+	since := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	email := MediaRecord{Name: "Email", FirstSeen: since, LastNotified: since.Add(30 * time.Minute), NotifyCount: 2}
+	sms := MediaRecord{Name: "SMS", FirstSeen: since.Add(time.Hour)}
+	tests := []struct {
+		name string
+		// saves — последовательные сохранения, каждое фиксируется отдельным коммитом
+		saves []map[string]MediaRecord
+		want  map[string]MediaRecord
+	}{
+		{name: "пусто", want: map[string]MediaRecord{}},
+		{name: "запись и чтение", saves: []map[string]MediaRecord{{"1": email, "2": sms}}, want: map[string]MediaRecord{"1": email, "2": sms}},
+		{
+			name:  "обновление и удаление",
+			saves: []map[string]MediaRecord{{"1": email, "2": sms}, {"2": {Name: "SMS", FirstSeen: sms.FirstSeen, NotifyCount: 1}}},
+			want:  map[string]MediaRecord{"2": {Name: "SMS", FirstSeen: sms.FirstSeen, NotifyCount: 1}},
+		},
+		{name: "всё удалено", saves: []map[string]MediaRecord{{"1": email}, {}}, want: map[string]MediaRecord{}},
+	}
+	for _, impl := range stateStores {
+		for _, tt := range tests {
+			t.Run(impl.name+"/"+tt.name, func(t *testing.T) {
+				store := impl.open(t)
+				for _, records := range tt.saves {
+					state := make(MediaState, len(records))
+					for id, rec := range records {
+						rec := rec
+						state[id] = &rec
+					}
+					saveAndCommit(t, func(c *cycleCommit) error { return store.SaveMedia(c, state) })
+				}
+				if got := loadMedia(t, store); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("загружено %v, ожидалось %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestStateStoreMediaWrittenOnCommit(t *testing.T) {
+	since := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, impl := range stateStores {
+		t.Run(impl.name, func(t *testing.T) {
+			store := impl.open(t)
+			state := MediaState{"1": {Name: "Email", FirstSeen: since}}
+			c := newCycleCommit()
+			if err := store.SaveMedia(c, state); err != nil {
+				t.Fatal(err)
+			}
+			// до коммита записи нет
+			if got := loadMedia(t, store); len(got) != 0 {
+				t.Errorf("до коммита загружено %v", got)
+			}
+			// записывается состояние на момент SaveMedia, а не изменённое позже в том же цикле
+			state["1"].NotifyCount = 5
+			if err := c.Commit(testLogger(t)); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]MediaRecord{"1": {Name: "Email", FirstSeen: since}}
+			loaded, _, err := store.LoadMedia()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := snapshotMedia(loaded); !reflect.DeepEqual(got, want) {
+				t.Errorf("загружено %v, ожидалось %v", got, want)
+			}
+			// загруженное — копия: её изменения без сохранения в хранилище не попадают
+			loaded["1"].FirstSeen = since.Add(time.Hour)
+			if got := loadMedia(t, store); !reflect.DeepEqual(got, want) {
+				t.Errorf("после изменения копии загружено %v", got)
+			}
+		})
+	}
+}
+
+func TestStateStoreGroups(t *testing.T) {
+	admins := UserGroup{ID: "7", Name: "Admins", Users: []string{"1", "2"}, UserNames: map[string]string{"1": "admin", "2": "ivanov"}}
+	ops := UserGroup{ID: "8", Name: "Ops", Users: []string{"3"}}
+	tests := []struct {
+		name        string
+		saves       []GroupState
+		want        GroupState
+		wantExisted bool
+	}{
+		{name: "baseline ещё не сохранён", want: GroupState{}},
+		// пустой снимок — тоже baseline: следующая проверка сравнивает с ним
+		{name: "пустой baseline", saves: []GroupState{{}}, want: GroupState{}, wantExisted: true},
+		{name: "запись и чтение", saves: []GroupState{{"7": admins, "8": ops}}, want: GroupState{"7": admins, "8": ops}, wantExisted: true},
+		{name: "снимок заменяется целиком", saves: []GroupState{{"7": admins, "8": ops}, {"8": ops}}, want: GroupState{"8": ops}, wantExisted: true},
+	}
+	for _, impl := range stateStores {
+		for _, tt := range tests {
+			t.Run(impl.name+"/"+tt.name, func(t *testing.T) {
+				store := impl.open(t)
+				for _, groups := range tt.saves {
+					saveAndCommit(t, func(c *cycleCommit) error { return store.SaveGroups(c, groups) })
+				}
+				got, existed, err := store.LoadGroups()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if existed != tt.wantExisted || !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("загружено %v (existed=%v), ожидалось %v (existed=%v)", got, existed, tt.want, tt.wantExisted)
+				}
+			})
+		}
+	}
+}
+
+func TestSQLiteStoreSeparatesServers(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "state.db")
+	open := func(server string) StateStore {
+		cfg := &Config{StateBackend: stateBackendSQLite, StateDSN: dsn, ServerName: server, Clock: newFakeClock()}
+		db, err := openStateDB(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		return newStateStore(cfg, db)
+	}
+	primary, backup := open("main"), open("backup")
+	since := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	saveAndCommit(t, func(c *cycleCommit) error {
+		return primary.SaveMedia(c, MediaState{"1": {Name: "Email", FirstSeen: since}})
+	})
+	saveAndCommit(t, func(c *cycleCommit) error { return primary.SaveGroups(c, GroupState{"7": {ID: "7", Name: "Admins"}}) })
+
+	if got := loadMedia(t, backup); len(got) != 0 {
+		t.Errorf("у второго сервера медиа %v", got)
+	}
+	if _, existed, err := backup.LoadGroups(); err != nil || existed {
+		t.Errorf("у второго сервера baseline групп: %v, %v", existed, err)
+	}
+	if got := loadMedia(t, primary); len(got) != 1 {
+		t.Errorf("у первого сервера медиа %v", got)
+	}
+}
+
+// TestSQLiteStoreSkipsUnchangedMedia: запись, отличающаяся от сохранённой только зоной времени,
+// не переписывается
+func TestSQLiteStoreSkipsUnchangedMedia(t *testing.T) {
+	clock := newFakeClock()
+	cfg := &Config{StateBackend: stateBackendSQLite, StateDSN: filepath.Join(t.TempDir(), "state.db"), ServerName: "main", Clock: clock}
+	db, err := openStateDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	since := time.Date(2024, 3, 1, 13, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	state := MediaState{"1": {Name: "Email", FirstSeen: since, LastNotified: since.Add(time.Minute)}}
+	saveAndCommit(t, func(c *cycleCommit) error { return newStateStore(cfg, db).SaveMedia(c, state) })
+	updatedAt := func() string {
+		t.Helper()
+		var s string
+		if err := db.QueryRow(`SELECT updated_at FROM media_state WHERE server = ? AND media_id = ?`, "main", "1").Scan(&s); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	before := updatedAt()
+
+	// после перезапуска сохранённые записи читаются из базы в UTC
+	store := newStateStore(cfg, db)
+	if _, _, err := store.LoadMedia(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	saveAndCommit(t, func(c *cycleCommit) error { return store.SaveMedia(c, state) })
+	if after := updatedAt(); after != before {
+		t.Errorf("неизменённая запись переписана: updated_at %s -> %s", before, after)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Подавление на время проблем Zabbix (SUPPRESS_DURING_PROBLEMS) ----------------
+// Медиа могли выключить намеренно, чтобы не шуметь во время крупной аварии. Пока есть открытая проблема,
+// подходящая под условие, напоминания и автовключение для выключенного медиа откладываются.
+// Условие: severity не ниже SUPPRESS_MIN_SEVERITY и, если заданы, тег SUPPRESS_PROBLEM_TAG
+// и подстрока SUPPRESS_PROBLEM_NAME в имени проблемы. В теге и имени {media} заменяется именем медиа.
+
+type problemSuppressor struct {
+	minSeverity int
+	tag         string
+	name        string
+	// медиа, о подавлении которых уже сообщили
+	notified map[string]bool
+}
+
+type openProblem struct {
+	EventID  string     `json:"eventid"`
+	Name     string     `json:"name"`
+	Severity string     `json:"severity"`
+	Tags     []MediaTag `json:"tags"`
+}
+
+func newProblemSuppressor(minSeverity int, tag, name string) (*problemSuppressor, error) {
+	if minSeverity < 0 || minSeverity > 5 {
+		return nil, fmt.Errorf("неверное значение SUPPRESS_MIN_SEVERITY: %d (допустимо 0–5)", minSeverity)
+	}
+	return &problemSuppressor{minSeverity: minSeverity, tag: tag, name: name, notified: map[string]bool{}}, nil
+}
+
+func getOpenProblems(ctx context.Context, cfg *Config, minSeverity int, logger *logrus.Logger) ([]openProblem, error) {
+	severities := []int{}
+	for s := minSeverity; s <= 5; s++ {
+		severities = append(severities, s)
+	}
+	req := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "problem.get",
+		Params: map[string]interface{}{
+			"output":     []string{"eventid", "name", "severity"},
+			"selectTags": "extend",
+			"severities": severities,
+		},
+		Auth: zabbixSession(cfg),
+		ID:   41,
+	}
+	var result []openProblem
+	err := callZabbix(ctx, cfg, req, &result, logger)
+	return result, err
+}
+
+// forCycle возвращает проверку подавления для текущего цикла; проблемы запрашиваются один раз и только при необходимости
+func (s *problemSuppressor) forCycle(ctx context.Context, cfg *Config, logger *logrus.Logger) func(media MediaType) (string, bool) {
+	if s == nil {
+		return func(MediaType) (string, bool) { return "", false }
+	}
+	var problems []openProblem
+	// медиа проверяются параллельно — проблемы запрашиваются один раз первым из воркеров
+	var once sync.Once
+	return func(media MediaType) (string, bool) {
+		once.Do(func() {
+			var err error
+			if problems, err = getOpenProblems(ctx, cfg, s.minSeverity, logger); err != nil {
+				// без данных о проблемах работаем как обычно, чтобы не оставить медиа выключенным
+				logger.WithError(err).Warn("Не удалось получить открытые проблемы — подавление в этом цикле не применяется")
+			}
+		})
+		for _, p := range problems {
+			if s.matches(p, media.Name) {
+				return fmt.Sprintf("открыта проблема «%s» (severity %s, eventid %s)", p.Name, severityName(p.Severity), p.EventID), true
+			}
+		}
+		return "", false
+	}
+}
+
+func (s *problemSuppressor) matches(p openProblem, mediaName string) bool {
+	if sev, err := strconv.Atoi(p.Severity); err != nil || sev < s.minSeverity {
+		return false
+	}
+	if s.name != "" && !strings.Contains(strings.ToLower(p.Name), strings.ToLower(strings.ReplaceAll(s.name, "{media}", mediaName))) {
+		return false
+	}
+	if s.tag != "" {
+		tag, value, withValue := parseTagSpec(strings.ReplaceAll(s.tag, "{media}", mediaName))
+		if !(MediaType{Tags: p.Tags}).hasTag(tag, value, withValue) {
+			return false
+		}
+	}
+	return true
+}
+
+func severityName(s string) string {
+	if n, ok := severityNames[s]; ok {
+		return n
+	}
+	return s
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProblemSuppressorMatches(t *testing.T) {
+	outage := openProblem{EventID: "10", Name: "Авария канала SMS-шлюза", Severity: "5", Tags: []MediaTag{{Tag: "media", Value: "SMS"}, {Tag: "scope", Value: "outage"}}}
+	tests := []struct {
+		name        string
+		minSeverity int
+		tag, match  string
+		problem     openProblem
+		media       string
+		want        bool
+	}{
+		{name: "по severity", minSeverity: 4, problem: outage, media: "Email", want: true},
+		{name: "severity ниже порога", minSeverity: 4, problem: openProblem{Name: "x", Severity: "3"}, media: "SMS"},
+		{name: "severity не число", minSeverity: 0, problem: openProblem{Name: "x", Severity: "high"}, media: "SMS"},
+		{name: "имя с {media}", minSeverity: 4, match: "канала {media}", problem: outage, media: "SMS", want: true},
+		{name: "имя без регистра", minSeverity: 4, match: "АВАРИЯ", problem: outage, media: "SMS", want: true},
+		{name: "имя не совпало", minSeverity: 4, match: "канала {media}", problem: outage, media: "Email"},
+		{name: "тег со значением {media}", minSeverity: 4, tag: "media:{media}", problem: outage, media: "SMS", want: true},
+		{name: "тег другого медиа", minSeverity: 4, tag: "media:{media}", problem: outage, media: "Email"},
+		{name: "тег без значения", minSeverity: 4, tag: "scope", problem: outage, media: "Email", want: true},
+		{name: "тег и имя", minSeverity: 4, tag: "scope:outage", match: "Email", problem: outage, media: "Email"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newProblemSuppressor(tt.minSeverity, tt.tag, tt.match)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.matches(tt.problem, tt.media); got != tt.want {
+				t.Errorf("matches = %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuppressMinSeverityConfig(t *testing.T) {
+	for _, sev := range []string{"-1", "6"} {
+		_, err := loadTestConfig(t, map[string]string{"SUPPRESS_DURING_PROBLEMS": "true", "SUPPRESS_MIN_SEVERITY": sev})
+		if err == nil || !strings.Contains(err.Error(), "SUPPRESS_MIN_SEVERITY") {
+			t.Errorf("SUPPRESS_MIN_SEVERITY=%s: ошибка %v", sev, err)
+		}
+	}
+}
+
+func TestSuppressDuringProblems(t *testing.T) {
+	highOutage := []openProblem{{EventID: "42", Name: "Авария SMS-шлюза", Severity: "5", Tags: []MediaTag{{Tag: "media", Value: "Email"}}}}
+	tests := []struct {
+		name        string
+		enabled     bool          // SUPPRESS_DURING_PROBLEMS
+		problems    []openProblem // nil при problemErr — problem.get отвечает ошибкой
+		problemErr  bool
+		wantNotice  string // начало единственного сообщения
+		wantUpdates int
+		wantQueries int
+	}{
+		{
+			name: "проблема открыта", enabled: true, problems: highOutage,
+			wantNotice: "Медиа отключено: Email", wantQueries: 1,
+		},
+		{
+			name: "проблем нет", enabled: true, problems: []openProblem{},
+			wantNotice: "Медиа Email было автоматически включено", wantUpdates: 1, wantQueries: 1,
+		},
+		{
+			// без данных о проблемах медиа не оставляем выключенным
+			name: "problem.get недоступен", enabled: true, problemErr: true,
+			wantNotice: "Медиа Email было автоматически включено", wantUpdates: 1, wantQueries: 1,
+		},
+		{
+			name: "подавление выключено", problems: highOutage,
+			wantNotice: "Медиа Email было автоматически включено", wantUpdates: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			zabbix.Result("mediatype.update", map[string][]string{"mediatypeids": {"1"}})
+			zabbix.Handle("problem.get", func(json.RawMessage) (interface{}, *fakeError) {
+				if tt.problemErr {
+					return nil, &fakeError{Code: -32500, Message: "Application error.", Data: json.RawMessage(`"No permissions."`)}
+				}
+				return tt.problems, nil
+			})
+			mm := newMattermostRecorder(t)
+			env := map[string]string{"ZABBIX_API_URL": zabbix.URL, "MM_WEBHOOK_URL": mm.URL}
+			if tt.enabled {
+				env["SUPPRESS_DURING_PROBLEMS"] = "true"
+				env["SUPPRESS_PROBLEM_TAG"] = "media:{media}"
+			}
+			cfg, clock := newTestConfig(t, env)
+			media := []MediaType{{MediaTypeID: "1", Name: "Email", Status: "1"}}
+			state := MediaState{"1": {FirstSeen: clock.Now().Add(-2 * time.Hour)}}
+
+			handleMediaTypes(context.Background(), cfg, media, newMemoryStore(), state, make(EnableFailures), newCycleCommit(), testLogger(t), nil)
+
+			texts := mm.Texts()
+			if len(texts) != 1 || !strings.HasPrefix(texts[0], tt.wantNotice) {
+				t.Fatalf("сообщения %q, ожидалось %q", texts, tt.wantNotice)
+			}
+			if tt.wantUpdates == 0 && !strings.Contains(texts[0], "открыта проблема «Авария SMS-шлюза» (severity") {
+				t.Errorf("сообщение без причины: %q", texts[0])
+			}
+			if n := len(zabbix.Calls("mediatype.update")); n != tt.wantUpdates {
+				t.Errorf("mediatype.update вызван %d раз, ожидалось %d", n, tt.wantUpdates)
+			}
+			queries := zabbix.Calls("problem.get")
+			if len(queries) != tt.wantQueries {
+				t.Fatalf("problem.get вызван %d раз, ожидалось %d", len(queries), tt.wantQueries)
+			}
+			if len(queries) > 0 {
+				var params struct {
+					Severities []int `json:"severities"`
+				}
+				if err := json.Unmarshal(queries[0].Params, &params); err != nil || !reflect.DeepEqual(params.Severities, []int{4, 5}) {
+					t.Errorf("severities %v (%v), ожидалось [4 5]", params.Severities, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSuppressionLiftsWhenProblemResolves(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	zabbix.Result("mediatype.update", map[string][]string{"mediatypeids": {"1", "2"}})
+	open := true
+	zabbix.Handle("problem.get", func(json.RawMessage) (interface{}, *fakeError) {
+		if !open {
+			return []openProblem{}, nil
+		}
+		return []openProblem{{EventID: "42", Name: "Авария почтового сервера", Severity: "4"}}, nil
+	})
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{
+		"ZABBIX_API_URL":           zabbix.URL,
+		"MM_WEBHOOK_URL":           mm.URL,
+		"MEDIA_NAMES":              "Email,SMS",
+		"SUPPRESS_DURING_PROBLEMS": "true",
+		"SUPPRESS_PROBLEM_NAME":    "почтового",
+		"NOTIFY_COOLDOWNS":         "media_still_disabled:0",
+	})
+	logger := testLogger(t)
+	media := []MediaType{
+		{MediaTypeID: "1", Name: "Email", Status: "1"},
+		{MediaTypeID: "2", Name: "SMS", Status: "1"},
+	}
+	state := make(MediaState)
+	failures := make(EnableFailures)
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		open    bool
+		texts   []string // первые строки сообщений
+		updates int      // всего вызовов mediatype.update
+	}{
+		{
+			name: "обнаружены во время проблемы", open: true,
+			texts: []string{"Обнаружено отключенное медиа: Email", "Обнаружено отключенное медиа: SMS"},
+		},
+		{
+			// о подавлении уже сообщили при обнаружении — повторно не сообщаем, не напоминаем и не включаем
+			name: "проблема держится", advance: 2 * time.Hour, open: true,
+		},
+		{
+			name: "проблема решена", advance: time.Minute,
+			texts:   []string{"Медиа Email было автоматически включено скриптом.", "Медиа SMS было автоматически включено скриптом."},
+			updates: 2,
+		},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		open = step.open
+		commit := newCycleCommit()
+		handleMediaTypes(context.Background(), cfg, media, newMemoryStore(), state, failures, commit, logger, nil)
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+		var texts []string
+		for _, text := range mm.Texts() {
+			texts = append(texts, strings.SplitN(text, "\n", 2)[0])
+		}
+		sort.Strings(texts)
+		if !reflect.DeepEqual(texts, step.texts) {
+			t.Errorf("%s: сообщения %q, ожидалось %q", step.name, texts, step.texts)
+		}
+		if n := len(zabbix.Calls("mediatype.update")); n != step.updates {
+			t.Errorf("%s: mediatype.update вызван %d раз, ожидалось %d", step.name, n, step.updates)
+		}
+	}
+	if len(cfg.suppressor.notified) != 0 {
+		t.Errorf("после решения проблемы остались отметки подавления %v", cfg.suppressor.notified)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Маршрутизация syslog по категориям событий ----------------
+// SYSLOG_ROUTES="groups=local1:zmw-security,users=local1" — категория=facility[:tag].
+// Категории без маршрута пишутся в общий writer (LOCAL0, тег zabbix-media-watcher).
+
+const (
+	syslogMedia       = "media"       // медиа-типы, шаблоны, расписание, желаемое состояние
+	syslogGroups      = "groups"      // группы пользователей
+	syslogUsers       = "users"       // пользователи
+	syslogMaintenance = "maintenance" // обслуживания Zabbix
+	syslogState       = "state"       // сохранение состояния
+)
+
+const defaultSyslogTag = "zabbix-media-watcher"
+
+var syslogCategories = []string{syslogMedia, syslogGroups, syslogUsers, syslogMaintenance, syslogState}
+
+var syslogFacilities = map[string]syslog.Priority{
+	"user": syslog.LOG_USER, "daemon": syslog.LOG_DAEMON, "auth": syslog.LOG_AUTH, "authpriv": syslog.LOG_AUTHPRIV,
+	"local0": syslog.LOG_LOCAL0, "local1": syslog.LOG_LOCAL1, "local2": syslog.LOG_LOCAL2, "local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4, "local5": syslog.LOG_LOCAL5, "local6": syslog.LOG_LOCAL6, "local7": syslog.LOG_LOCAL7,
+}
+
+type syslogTarget struct {
+	Facility syslog.Priority
+	Tag      string
+}
+
+func parseSyslogRoutes(spec string) (map[string]syslogTarget, error) {
+	routes := map[string]syslogTarget{}
+	for _, item := range splitList(spec) {
+		cat, target, ok := strings.Cut(item, "=")
+		cat = strings.ToLower(strings.TrimSpace(cat))
+		if !ok || !containsString(syslogCategories, cat) {
+			return nil, fmt.Errorf("неверный маршрут SYSLOG_ROUTES %q (категории: %s)", item, strings.Join(syslogCategories, ", "))
+		}
+		facility, tag, _ := strings.Cut(strings.TrimSpace(target), ":")
+		prio, ok := syslogFacilities[strings.ToLower(strings.TrimSpace(facility))]
+		if !ok {
+			return nil, fmt.Errorf("SYSLOG_ROUTES: неизвестная facility %q", facility)
+		}
+		if tag = strings.TrimSpace(tag); tag == "" {
+			tag = defaultSyslogTag
+		}
+		routes[cat] = syslogTarget{Facility: prio, Tag: tag}
+	}
+	return routes, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// openSyslog подключается к syslog; в тестах подменяется подключением к локальному приёмнику
+var openSyslog = syslog.New
+
+// syslogRouter выдаёт writer для категории; nil-writer означает, что syslog недоступен
+type syslogRouter struct {
+	def        *syslog.Writer
+	byCategory map[string]*syslog.Writer
+}
+
+func newSyslogRouter(routes map[string]syslogTarget, def *syslog.Writer, logger *logrus.Logger) *syslogRouter {
+	r := &syslogRouter{def: def, byCategory: map[string]*syslog.Writer{}}
+	for cat, t := range routes {
+		w, err := openSyslog(syslog.LOG_INFO|t.Facility, t.Tag)
+		if err != nil {
+			logger.Warnf("Не удалось подключиться к syslog для категории %s: %v — используется общий", cat, err)
+			continue
+		}
+		r.byCategory[cat] = w
+	}
+	return r
+}
+
+func (r *syslogRouter) For(category string) *syslog.Writer {
+	if w, ok := r.byCategory[category]; ok {
+		return w
+	}
+	return r.def
+}
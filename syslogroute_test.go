@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/syslog"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseSyslogRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    map[string]syslogTarget
+		wantErr string
+	}{
+		{name: "пусто", want: map[string]syslogTarget{}},
+		{
+			name: "facility и тег",
+			spec: "groups=local1:zmw-security, USERS = LOCAL1 ,state=daemon:",
+			want: map[string]syslogTarget{
+				syslogGroups: {Facility: syslog.LOG_LOCAL1, Tag: "zmw-security"},
+				syslogUsers:  {Facility: syslog.LOG_LOCAL1, Tag: defaultSyslogTag},
+				syslogState:  {Facility: syslog.LOG_DAEMON, Tag: defaultSyslogTag},
+			},
+		},
+		{name: "неизвестная категория", spec: "security=local1", wantErr: "неверный маршрут SYSLOG_ROUTES"},
+		{name: "без facility", spec: "groups", wantErr: "неверный маршрут SYSLOG_ROUTES"},
+		{name: "неизвестная facility", spec: "groups=local9", wantErr: "неизвестная facility"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSyslogRoutes(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("маршруты %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// syslogMessage — сообщение, полученное syslogReceiver
+type syslogMessage struct {
+	Facility syslog.Priority
+	Tag      string
+	Text     string
+}
+
+// syslogReceiver — UDP-приёмник syslog; openSyslog на время теста подключается к нему
+type syslogReceiver struct {
+	conn net.PacketConn
+	mu   sync.Mutex
+	msgs []syslogMessage
+}
+
+var syslogLine = regexp.MustCompile(`^<(\d+)>\S+ \S+ ([^\[\s]+)\[\d+\]: (.*)$`)
+
+func newSyslogReceiver(t *testing.T) *syslogReceiver {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &syslogReceiver{conn: conn}
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			m := syslogLine.FindStringSubmatch(strings.TrimSpace(string(buf[:n])))
+			if m == nil {
+				continue
+			}
+			prio, _ := strconv.Atoi(m[1])
+			r.mu.Lock()
+			r.msgs = append(r.msgs, syslogMessage{Facility: syslog.Priority(prio) &^ 7, Tag: m[2], Text: m[3]})
+			r.mu.Unlock()
+		}
+	}()
+	t.Cleanup(func() { conn.Close() })
+
+	prev := openSyslog
+	openSyslog = func(p syslog.Priority, tag string) (*syslog.Writer, error) {
+		return syslog.Dial("udp", conn.LocalAddr().String(), p, tag)
+	}
+	t.Cleanup(func() { openSyslog = prev })
+	return r
+}
+
+// Find ждёт сообщение с подстрокой (UDP доставляется асинхронно)
+func (r *syslogReceiver) Find(t *testing.T, substr string) syslogMessage {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		for _, m := range r.msgs {
+			if strings.Contains(m.Text, substr) {
+				r.mu.Unlock()
+				return m
+			}
+		}
+		r.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("в syslog нет сообщения с %q", substr)
+	return syslogMessage{}
+}
+
+func TestSyslogRoutesByCategory(t *testing.T) {
+	syslogs := newSyslogReceiver(t)
+	zabbix := newFakeZabbix(t)
+	zabbix.Result("mediatype.get", []MediaType{{MediaTypeID: "1", Name: "Email", Status: "1"}})
+	members := `{"userid":"1","username":"admin"}`
+	zabbix.Handle("usergroup.get", func(json.RawMessage) (interface{}, *fakeError) {
+		return json.RawMessage(`[{"usrgrpid":"7","name":"Admins","users":[` + members + `]}]`), nil
+	})
+	cfg, _ := newTestConfig(t, map[string]string{
+		"ZABBIX_API_URL": zabbix.URL,
+		"SYSLOG_ROUTES":  "groups=local1:zmw-security",
+	})
+	logger := testLogger(t)
+	def, err := openSyslog(syslog.LOG_INFO|syslog.LOG_LOCAL0, defaultSyslogTag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { def.Close() })
+	sysLogs := newSyslogRouter(cfg.SyslogRoutes, def, logger)
+
+	// медиа обнаружено выключенным, группы запомнены как baseline
+	commit := newCycleCommit()
+	fetched := fetchMediaAndGroups(context.Background(), cfg, logger)
+	processMediaTypes(context.Background(), cfg, fetched.MediaTypes, fetched.MediaErr, newMemoryStore(), make(MediaState), make(EnableFailures), make(VanishState), commit, logger, sysLogs.For(syslogMedia))
+	groups := make(GroupState)
+	processUserGroups(context.Background(), cfg, fetched.Groups, fetched.GroupErr, newMemoryStore(), groups, nil, commit, logger, sysLogs.For(syslogGroups), true)
+	// в группе новый участник
+	members += `,{"userid":"2","username":"intruder"}`
+	fetched = fetchMediaAndGroups(context.Background(), cfg, logger)
+	processUserGroups(context.Background(), cfg, fetched.Groups, fetched.GroupErr, newMemoryStore(), groups, nil, newCycleCommit(), logger, sysLogs.For(syslogGroups), false)
+
+	tests := []struct {
+		category, substr string
+		want             syslogMessage
+	}{
+		{syslogMedia, "Обнаружено выключенное media: id=1 name=Email", syslogMessage{Facility: syslog.LOG_LOCAL0, Tag: defaultSyslogTag}},
+		{syslogGroups, "UserGroup change detected", syslogMessage{Facility: syslog.LOG_LOCAL1, Tag: "zmw-security"}},
+	}
+	for _, tt := range tests {
+		got := syslogs.Find(t, tt.substr)
+		if got.Facility != tt.want.Facility || got.Tag != tt.want.Tag {
+			t.Errorf("%s: facility %d, тег %q; ожидалось %d, %q", tt.category, got.Facility>>3, got.Tag, tt.want.Facility>>3, tt.want.Tag)
+		}
+	}
+}
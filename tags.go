@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Список отслеживаемых медиа по тегу и шаблону имени ----------------
+
+type MediaTag struct {
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+// parseTagSpec разбирает "watch:true" в тег и значение; без двоеточия значение не проверяется
+func parseTagSpec(spec string) (tag, value string, withValue bool) {
+	tag, value, withValue = strings.Cut(strings.TrimSpace(spec), ":")
+	return strings.TrimSpace(tag), strings.TrimSpace(value), withValue
+}
+
+func (m MediaType) hasTag(tag, value string, withValue bool) bool {
+	for _, t := range m.Tags {
+		if t.Tag == tag && (!withValue || t.Value == value) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaPolicy — политика медиа с учётом тега AUTO_ENABLE_TAG на нём самом: решение об автовключении,
+// записанное в Zabbix владельцем медиа, важнее MEDIA_POLICIES. Без тега действует политика.
+func (cfg *Config) mediaPolicy(media MediaType, logger *logrus.Logger) MediaPolicy {
+	p := cfg.policyFor(media.Name)
+	if cfg.AutoEnableTag == "" {
+		return p
+	}
+	for _, t := range media.Tags {
+		if t.Tag != cfg.AutoEnableTag {
+			continue
+		}
+		enable, ok := parseTagBool(t.Value)
+		if !ok {
+			logger.WithFields(logrus.Fields{"media_name": media.Name, "tag": t.Tag, "value": t.Value}).Warn("Неверное значение тега автовключения — используется политика")
+			return p
+		}
+		p.AutoEnable = enable
+		p.autoEnableTag = t.Tag + ":" + t.Value
+		return p
+	}
+	return p
+}
+
+func parseTagBool(v string) (value, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "yes", "on":
+		return true, true
+	case "0", "false", "no", "off":
+		return false, true
+	}
+	return false, false
+}
+
+// selectWatchedMedia оставляет из ответа mediatype.get медиа с тегом MEDIA_WATCH_TAG и/или с именем
+// из MEDIA_NAMES или по MEDIA_NAME_PATTERN и запоминает их как отслеживаемые в этом цикле. Источник
+// правды — сам Zabbix: подходящее медиа подхватывается в ближайшем цикле, снятие тега или
+// переименование убирает его. Без тега и шаблона список уже отфильтрован по MEDIA_NAMES на стороне Zabbix.
+func selectWatchedMedia(cfg *Config, mediaTypes []MediaType, logger *logrus.Logger) []MediaType {
+	if cfg.WatchTag == "" && cfg.MediaNamePattern == nil {
+		return mediaTypes
+	}
+	tag, value, withValue := parseTagSpec(cfg.WatchTag)
+	watched := []MediaType{}
+	names := []string{}
+	for _, m := range mediaTypes {
+		if cfg.WatchTag != "" && !m.hasTag(tag, value, withValue) {
+			continue
+		}
+		if cfg.MediaNamePattern != nil && !containsString(cfg.MediaNames, m.Name) && !cfg.MediaNamePattern.MatchString(m.Name) {
+			continue
+		}
+		watched = append(watched, m)
+		names = append(names, m.Name)
+	}
+	sort.Strings(names)
+
+	added, removed := diffUsers(cfg.watchedMedia, names)
+	if len(added) > 0 || len(removed) > 0 {
+		fields := logrus.Fields{"added": added, "removed": removed}
+		if cfg.WatchTag != "" {
+			fields["tag"] = cfg.WatchTag
+		}
+		if cfg.MediaNamePattern != nil {
+			fields["pattern"] = cfg.MediaNamePattern.String()
+		}
+		logger.WithFields(fields).Info("Список отслеживаемых медиа изменился")
+	}
+	cfg.watchedMedia = names
+	return watched
+}
+
+// watchesMediaName — медиа с этим именем отслеживается: указано в MEDIA_NAMES, подходит под
+// MEDIA_NAME_PATTERN или выбрано по MEDIA_WATCH_TAG в последнем цикле
+func (cfg *Config) watchesMediaName(name string) bool {
+	if containsString(cfg.MediaNames, name) {
+		return true
+	}
+	switch {
+	case cfg.WatchTag != "":
+		return containsString(cfg.watchedMedia, name)
+	case cfg.MediaNamePattern != nil:
+		return cfg.MediaNamePattern.MatchString(name)
+	}
+	return len(cfg.MediaNames) == 0
+}
+
+// watchedCount — число отслеживаемых медиа для heartbeat
+func (cfg *Config) watchedCount() int {
+	if cfg.WatchTag == "" && cfg.MediaNamePattern == nil {
+		return len(cfg.MediaNames)
+	}
+	return len(cfg.watchedMedia)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchTagFollowsZabbix(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	cfg, _ := newTestConfig(t, map[string]string{
+		"ZABBIX_API_URL":  zabbix.URL,
+		"MEDIA_NAMES":     "",
+		"MEDIA_WATCH_TAG": "watch:true",
+	})
+	watchTag := []MediaTag{{Tag: "watch", Value: "true"}}
+	var mu sync.Mutex
+	media := []MediaType{
+		{MediaTypeID: "1", Name: "Email", Status: "1", Tags: watchTag},
+		{MediaTypeID: "2", Name: "SMS", Status: "1"},
+		{MediaTypeID: "3", Name: "Slack", Status: "1", Tags: []MediaTag{{Tag: "watch", Value: "false"}}},
+	}
+	zabbix.Handle("mediatype.get", func(json.RawMessage) (interface{}, *fakeError) {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]MediaType(nil), media...), nil
+	})
+	setTags := func(i int, tags []MediaTag) {
+		mu.Lock()
+		defer mu.Unlock()
+		media[i].Tags = tags
+	}
+	logger := testLogger(t)
+	state := make(MediaState)
+	failures := make(EnableFailures)
+
+	steps := []struct {
+		change  func()
+		watched []string
+		tracked []string
+	}{
+		{watched: []string{"Email"}, tracked: []string{"1"}},
+		// тег поставили на SMS — подхватывается в том же цикле
+		{change: func() { setTags(1, watchTag) }, watched: []string{"Email", "SMS"}, tracked: []string{"1", "2"}},
+		// тег сняли с Email — медиа больше не отслеживается и пропадает из состояния
+		{change: func() { setTags(0, nil) }, watched: []string{"SMS"}, tracked: []string{"2"}},
+	}
+	for i, step := range steps {
+		if step.change != nil {
+			step.change()
+		}
+		commit := newCycleCommit()
+		media, err := getMediaTypes(context.Background(), cfg, logger)
+		returned := processMediaTypes(context.Background(), cfg, media, err, newMemoryStore(), state, failures, make(VanishState), commit, logger, nil)
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+		var checked []string
+		for _, m := range returned.MediaTypes {
+			checked = append(checked, m.Name)
+		}
+		if !reflect.DeepEqual(checked, step.watched) {
+			t.Errorf("шаг %d: проверены %v, ожидалось %v", i+1, checked, step.watched)
+		}
+		if !reflect.DeepEqual(cfg.watchedMedia, step.watched) || cfg.watchedCount() != len(step.watched) {
+			t.Errorf("шаг %d: отслеживаемые %v, ожидалось %v", i+1, cfg.watchedMedia, step.watched)
+		}
+		var tracked []string
+		for id := range state {
+			tracked = append(tracked, id)
+		}
+		sort.Strings(tracked)
+		if !reflect.DeepEqual(tracked, step.tracked) {
+			t.Errorf("шаг %d: в состоянии %v, ожидалось %v", i+1, tracked, step.tracked)
+		}
+	}
+
+	// медиа выбираются по тегу, поэтому запрос идёт без фильтра по имени и с тегами
+	var params map[string]interface{}
+	if err := json.Unmarshal(zabbix.Calls("mediatype.get")[0].Params, &params); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := params["filter"]; ok || params["selectTags"] != "extend" {
+		t.Errorf("параметры mediatype.get: %v", params)
+	}
+}
+
+func TestAutoEnableTagOverridesPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		tag      MediaTag
+		want     map[string]string // статусы после истечения порога
+		wantText string            // в напоминании о невключённом Email
+	}{
+		{
+			name:     "autoenable:false",
+			tag:      MediaTag{Tag: "autoenable", Value: "false"},
+			want:     map[string]string{"Email": "1", "SMS": "0"},
+			wantText: "Автоматическое включение отключено тегом autoenable:false медиа",
+		},
+		{
+			name: "autoenable:true важнее политики",
+			env:  map[string]string{"MEDIA_POLICIES": `[{"name":"manual","names":["Email","SMS"],"auto_enable":false}]`},
+			tag:  MediaTag{Tag: "autoenable", Value: "true"},
+			want: map[string]string{"Email": "0", "SMS": "1"},
+		},
+		{
+			name: "неверное значение — по политике",
+			tag:  MediaTag{Tag: "autoenable", Value: "maybe"},
+			want: map[string]string{"Email": "0", "SMS": "0"},
+		},
+		{
+			name: "AUTO_ENABLE_TAG=off",
+			env:  map[string]string{"AUTO_ENABLE_TAG": "off"},
+			tag:  MediaTag{Tag: "autoenable", Value: "false"},
+			want: map[string]string{"Email": "0", "SMS": "0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			live := serveLiveMedia(zabbix,
+				MediaType{MediaTypeID: "1", Name: "Email", Status: "1", Tags: []MediaTag{tt.tag}},
+				MediaType{MediaTypeID: "2", Name: "SMS", Status: "1"},
+			)
+			mm := newMattermostRecorder(t)
+			env := map[string]string{"ZABBIX_API_URL": zabbix.URL, "MM_WEBHOOK_URL": mm.URL, "MEDIA_NAMES": "Email,SMS"}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			cfg, clock := newTestConfig(t, env)
+			logger := testLogger(t)
+			state := make(MediaState)
+			failures := make(EnableFailures)
+
+			// первый цикл обнаруживает выключенные медиа, второй — после порога
+			for _, advance := range []time.Duration{0, 2 * time.Hour} {
+				clock.Advance(advance)
+				media, err := getMediaTypes(context.Background(), cfg, logger)
+				processMediaTypes(context.Background(), cfg, media, err, newMemoryStore(), state, failures, make(VanishState), newCycleCommit(), logger, nil)
+			}
+
+			if got := live.Statuses(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("статусы %v, ожидалось %v", got, tt.want)
+			}
+			if tt.wantText == "" {
+				return
+			}
+			found := false
+			for _, text := range mm.Texts() {
+				if strings.HasPrefix(text, "Медиа отключено: Email\n") {
+					found = true
+					if !strings.Contains(text, tt.wantText) {
+						t.Errorf("сообщение %q, ожидалось %q", text, tt.wantText)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("нет напоминания о невключённом Email")
+			}
+		})
+	}
+}
+
+func TestSelectWatchedMedia(t *testing.T) {
+	media := []MediaType{
+		{MediaTypeID: "1", Name: "Email"},
+		{MediaTypeID: "2", Name: "SMS-Main", Tags: []MediaTag{{Tag: "watch", Value: "true"}}},
+		{MediaTypeID: "3", Name: "SMS-Backup"},
+		{MediaTypeID: "4", Name: "Slack", Tags: []MediaTag{{Tag: "watch", Value: "false"}}},
+	}
+	tests := []struct {
+		name    string
+		names   []string
+		pattern string
+		tag     string
+		want    []string
+	}{
+		{name: "только MEDIA_NAMES", names: []string{"Email"}, want: []string{"Email", "SMS-Main", "SMS-Backup", "Slack"}},
+		{name: "шаблон", pattern: "^SMS-", want: []string{"SMS-Main", "SMS-Backup"}},
+		{name: "имена и шаблон", names: []string{"Email"}, pattern: "^SMS-", want: []string{"Email", "SMS-Main", "SMS-Backup"}},
+		{name: "тег", tag: "watch:true", want: []string{"SMS-Main"}},
+		{name: "тег и шаблон", tag: "watch", pattern: "^S", want: []string{"SMS-Main", "Slack"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{MediaNames: tt.names, WatchTag: tt.tag}
+			if tt.pattern != "" {
+				cfg.MediaNamePattern = regexp.MustCompile(tt.pattern)
+			}
+			// два цикла: выбор не должен зависеть от предыдущего
+			for cycle := 0; cycle < 2; cycle++ {
+				var got []string
+				for _, m := range selectWatchedMedia(cfg, media, testLogger(t)) {
+					got = append(got, m.Name)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("цикл %d: отслеживаются %v, ожидалось %v", cycle+1, got, tt.want)
+				}
+				if !reflect.DeepEqual(cfg.MediaNames, tt.names) {
+					t.Fatalf("MEDIA_NAMES изменён: %v", cfg.MediaNames)
+				}
+			}
+		})
+	}
+}
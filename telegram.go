@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Уведомления в Telegram ----------------
+// При заданных TELEGRAM_BOT_TOKEN и TELEGRAM_CHAT_ID каждое уведомление, кроме Mattermost,
+// отправляется ботом в чат через sendMessage. Доставка идёт через общую очередь с повторами.
+
+const defaultTelegramAPIURL = "https://api.telegram.org"
+
+type telegramMessage struct {
+	ChatID string `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+// telegramResponse — ответ Bot API; при 429 в parameters.retry_after — сколько секунд ждать
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+func (cfg *Config) telegramEnabled() bool {
+	return cfg.TelegramBotToken != "" && cfg.TelegramChatID != ""
+}
+
+func notifyTelegram(ctx context.Context, cfg *Config, ev Event, logger *logrus.Logger) {
+	err := sendTelegramNotification(ctx, cfg, ev.Type, ev.Message, logger)
+	if err != nil && !errors.Is(err, errSpooled) {
+		logger.WithError(err).WithField("event", ev.Type).Error("Ошибка отправки уведомления в Telegram")
+	}
+}
+
+// sendTelegramNotification отправляет текст в чат TELEGRAM_CHAT_ID
+func sendTelegramNotification(ctx context.Context, cfg *Config, event, message string, logger *logrus.Logger) error {
+	if cfg.Simulate {
+		message = "[SIMULATE] " + message
+	} else if cfg.DryRun {
+		message = "[DRY-RUN] " + message
+	}
+	data, _ := json.Marshal(telegramMessage{ChatID: cfg.TelegramChatID, Text: cfg.themedText(message)})
+	_, err := cfg.delivery.Deliver(ctx, cfg, deliveryTelegram, event, data, logger)
+	return err
+}
+
+// sendTelegram — одна попытка sendMessage. Токен бота входит в URL, поэтому из ошибок
+// HTTP-клиента он вырезается.
+func sendTelegram(ctx context.Context, cfg *Config, body []byte) error {
+	url := fmt.Sprintf("%s/bot%s/sendMessage", cfg.TelegramAPIURL, cfg.TelegramBotToken)
+	resp, err := postJSON(ctx, cfg, url, body)
+	if err != nil {
+		return errors.New(strings.ReplaceAll(err.Error(), cfg.TelegramBotToken, "***"))
+	}
+	defer resp.Body.Close()
+	var tr telegramResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&tr)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		// повтор сделает очередь доставки; retry_after попадает в лог вместе с ошибкой
+		return fmt.Errorf("Telegram ограничил частоту отправки (HTTP 429), retry_after=%ds: %s", tr.Parameters.RetryAfter, tr.Description)
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("Telegram HTTP %d: %s", resp.StatusCode, tr.Description)
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return permanentError{fmt.Errorf("Telegram HTTP %d: %s", resp.StatusCode, tr.Description)}
+	case decodeErr != nil:
+		return permanentError{fmt.Errorf("неверный ответ Telegram: %v", decodeErr)}
+	case !tr.OK:
+		return permanentError{fmt.Errorf("Telegram отклонил сообщение: %s", tr.Description)}
+	}
+	return nil
+}
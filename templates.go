@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Мониторинг шаблонов сообщений медиа ----------------
+
+// MessageTemplate — шаблон сообщения медиа-типа (mediatype.get selectMessageTemplates)
+type MessageTemplate struct {
+	EventSource string `json:"eventsource"`
+	Recovery    string `json:"recovery"`
+	Subject     string `json:"subject"`
+	Message     string `json:"message"`
+}
+
+// MediaTemplates — снимок шаблонов одного медиа
+type MediaTemplates struct {
+	Name      string            `json:"name"`
+	Templates []MessageTemplate `json:"message_templates"`
+}
+
+type TemplateState map[string]MediaTemplates
+
+var eventSourceNames = map[string]string{
+	"0": "триггеры",
+	"1": "обнаружение",
+	"2": "авторегистрация",
+	"3": "внутренние",
+	"4": "сервисы",
+}
+
+var recoveryNames = map[string]string{
+	"0": "проблема",
+	"1": "восстановление",
+	"2": "обновление",
+}
+
+func (t MessageTemplate) key() string {
+	return t.EventSource + "/" + t.Recovery
+}
+
+// label — человекочитаемое имя шаблона, например "триггеры/проблема"
+func (t MessageTemplate) label() string {
+	src, ok := eventSourceNames[t.EventSource]
+	if !ok {
+		src = "eventsource " + t.EventSource
+	}
+	rec, ok := recoveryNames[t.Recovery]
+	if !ok {
+		rec = "recovery " + t.Recovery
+	}
+	return src + "/" + rec
+}
+
+func loadTemplateState(filename string) (TemplateState, bool, error) {
+	state := make(TemplateState)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	if len(data) == 0 {
+		return state, true, nil
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, true, err
+	}
+	return state, true, nil
+}
+
+func snapshotTemplates(mediaTypes []MediaType) TemplateState {
+	state := make(TemplateState)
+	for _, m := range mediaTypes {
+		tpls := append([]MessageTemplate(nil), m.MessageTemplates...)
+		sort.Slice(tpls, func(i, j int) bool { return tpls[i].key() < tpls[j].key() })
+		state[m.MediaTypeID] = MediaTemplates{Name: m.Name, Templates: tpls}
+	}
+	return state
+}
+
+// diffTemplates возвращает описание изменений шаблонов одного медиа: какие добавлены, удалены и изменены
+func diffTemplates(prev, curr []MessageTemplate) []string {
+	prevByKey := map[string]MessageTemplate{}
+	for _, t := range prev {
+		prevByKey[t.key()] = t
+	}
+	currByKey := map[string]MessageTemplate{}
+	for _, t := range curr {
+		currByKey[t.key()] = t
+	}
+
+	diffs := []string{}
+	for _, c := range curr {
+		p, ok := prevByKey[c.key()]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("добавлен шаблон %s", c.label()))
+			continue
+		}
+		fields := []string{}
+		if p.Subject != c.Subject {
+			fields = append(fields, fmt.Sprintf("тема %q -> %q", p.Subject, c.Subject))
+		}
+		if p.Message != c.Message {
+			fields = append(fields, "текст сообщения")
+		}
+		if len(fields) > 0 {
+			diffs = append(diffs, fmt.Sprintf("изменён шаблон %s: %s", c.label(), strings.Join(fields, ", ")))
+		}
+	}
+	for _, p := range prev {
+		if _, ok := currByKey[p.key()]; !ok {
+			diffs = append(diffs, fmt.Sprintf("удалён шаблон %s", p.label()))
+		}
+	}
+	return diffs
+}
+
+// processMessageTemplates сравнивает шаблоны сообщений с прошлым циклом и уведомляет о различиях.
+// Как и для групп, первый запуск только записывает baseline.
+func processMessageTemplates(ctx context.Context, cfg *Config, mediaTypes []MediaType, prev TemplateState, commit *cycleCommit, logger *logrus.Logger, sysLogger *syslog.Writer, baselineMode bool) {
+	current := snapshotTemplates(mediaTypes)
+	changed := baselineMode
+
+	if !baselineMode {
+		ids := make([]string, 0, len(current))
+		for id := range current {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			cur := current[id]
+			p, ok := prev[id]
+			if !ok {
+				// новое медиа в фильтре — запоминаем без уведомления
+				changed = true
+				continue
+			}
+			diffs := diffTemplates(p.Templates, cur.Templates)
+			if len(diffs) == 0 {
+				continue
+			}
+			changed = true
+			msg := fmt.Sprintf("Изменены шаблоны сообщений медиа %s: %s", cur.Name, strings.Join(diffs, "; "))
+			logger.WithField("media_id", id).Warn(msg)
+			if sysLogger != nil {
+				_ = sysLogger.Warning(msg)
+			}
+			notify(ctx, cfg, Event{Type: EventTemplateChanged, MediaID: id, MediaName: cur.Name, Message: msg}, logger)
+		}
+	}
+
+	if !changed {
+		return
+	}
+	data, err := marshalState(current, cfg.StateCompact)
+	if err != nil {
+		logger.Errorf("Ошибка сохранения состояния шаблонов сообщений: %v", err)
+		return
+	}
+	commit.Stage(cfg.stateFile(templateStateFilename), data)
+	if baselineMode {
+		logger.Info("Baseline шаблонов сообщений будет сохранён — уведомлений не отправлено")
+	}
+	for k := range prev {
+		delete(prev, k)
+	}
+	for k, v := range current {
+		prev[k] = v
+	}
+}
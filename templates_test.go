@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffTemplates(t *testing.T) {
+	problem := MessageTemplate{EventSource: "0", Recovery: "0", Subject: "Problem: {EVENT.NAME}", Message: "Host {HOST.NAME}"}
+	recovery := MessageTemplate{EventSource: "0", Recovery: "1", Subject: "Resolved", Message: "OK"}
+	discovery := MessageTemplate{EventSource: "1", Recovery: "0", Subject: "Discovered", Message: "New host"}
+	tests := []struct {
+		name string
+		prev []MessageTemplate
+		curr []MessageTemplate
+		want []string
+	}{
+		{name: "без изменений", prev: []MessageTemplate{problem, recovery}, curr: []MessageTemplate{recovery, problem}, want: []string{}},
+		{name: "добавлен", prev: []MessageTemplate{problem}, curr: []MessageTemplate{problem, discovery}, want: []string{"добавлен шаблон обнаружение/проблема"}},
+		{name: "удалён", prev: []MessageTemplate{problem, recovery}, curr: []MessageTemplate{problem}, want: []string{"удалён шаблон триггеры/восстановление"}},
+		{
+			name: "изменена тема",
+			prev: []MessageTemplate{problem},
+			curr: []MessageTemplate{{EventSource: "0", Recovery: "0", Subject: "Alarm", Message: problem.Message}},
+			want: []string{`изменён шаблон триггеры/проблема: тема "Problem: {EVENT.NAME}" -> "Alarm"`},
+		},
+		{
+			name: "изменены тема и текст",
+			prev: []MessageTemplate{recovery},
+			curr: []MessageTemplate{{EventSource: "0", Recovery: "1", Subject: "Fixed", Message: "Fixed"}},
+			want: []string{`изменён шаблон триггеры/восстановление: тема "Resolved" -> "Fixed", текст сообщения`},
+		},
+		{
+			name: "неизвестный источник",
+			prev: nil,
+			curr: []MessageTemplate{{EventSource: "9", Recovery: "5"}},
+			want: []string{"добавлен шаблон eventsource 9/recovery 5"},
+		},
+		{
+			name: "всё сразу",
+			prev: []MessageTemplate{problem, recovery},
+			curr: []MessageTemplate{{EventSource: "0", Recovery: "0", Subject: problem.Subject, Message: "changed"}, discovery},
+			want: []string{"изменён шаблон триггеры/проблема: текст сообщения", "добавлен шаблон обнаружение/проблема", "удалён шаблон триггеры/восстановление"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffTemplates(tt.prev, tt.curr); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%q, ожидалось %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessMessageTemplates(t *testing.T) {
+	mm := newMattermostRecorder(t)
+	cfg, err := loadTestConfig(t, map[string]string{"MM_WEBHOOK_URL": mm.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := testLogger(t)
+	email := MediaType{MediaTypeID: "1", Name: "Email", MessageTemplates: []MessageTemplate{{EventSource: "0", Recovery: "0", Subject: "Problem"}}}
+	state := make(TemplateState)
+
+	// первый запуск — только baseline
+	processMessageTemplates(context.Background(), cfg, []MediaType{email}, state, newCycleCommit(), logger, nil, true)
+	if got := mm.Texts(); got != nil {
+		t.Fatalf("baseline отправил уведомления %q", got)
+	}
+
+	email.MessageTemplates = []MessageTemplate{{EventSource: "0", Recovery: "0", Subject: "Alarm"}, {EventSource: "0", Recovery: "1", Subject: "OK"}}
+	processMessageTemplates(context.Background(), cfg, []MediaType{email}, state, newCycleCommit(), logger, nil, false)
+	texts := mm.Texts()
+	if len(texts) != 1 || !strings.Contains(texts[0], "Изменены шаблоны сообщений медиа Email") {
+		t.Fatalf("уведомления %q, ожидалось одно об изменении шаблонов Email", texts)
+	}
+	for _, want := range []string{`тема "Problem" -> "Alarm"`, "добавлен шаблон триггеры/восстановление"} {
+		if !strings.Contains(texts[0], want) {
+			t.Errorf("в сообщении нет %q: %s", want, texts[0])
+		}
+	}
+
+	// то же состояние повторно не сообщается
+	processMessageTemplates(context.Background(), cfg, []MediaType{email}, state, newCycleCommit(), logger, nil, false)
+	if got := mm.Texts(); got != nil {
+		t.Fatalf("повторные уведомления %q", got)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// EnvTheme — оформление уведомлений окружения: цвет полосы вложения Mattermost и префикс текста
+type EnvTheme struct {
+	Color  string `json:"color"`
+	Prefix string `json:"prefix"`
+}
+
+// defaultEnvThemes — оформление для привычных имён окружений; ENVIRONMENT_THEMES дополняет и переопределяет его
+var defaultEnvThemes = map[string]EnvTheme{
+	"prod":  {Color: "#D32F2F", Prefix: "[PROD]"},
+	"stage": {Color: "#F9A825", Prefix: "[STAGE]"},
+	"dev":   {Color: "#9E9E9E", Prefix: "[DEV]"},
+}
+
+// parseEnvThemes разбирает ENVIRONMENT_THEMES — JSON-объект с темами по имени окружения:
+//
+//	{"prod":{"color":"#FF0000","prefix":":red_circle: PROD"},"qa":{"color":"#2196F3","prefix":"[QA]"}}
+func parseEnvThemes(raw string) (map[string]EnvTheme, error) {
+	themes := map[string]EnvTheme{}
+	for k, v := range defaultEnvThemes {
+		themes[k] = v
+	}
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return themes, nil
+	}
+	var custom map[string]EnvTheme
+	if err := json.Unmarshal([]byte(raw), &custom); err != nil {
+		return nil, fmt.Errorf("неверный формат ENVIRONMENT_THEMES: %v", err)
+	}
+	for k, v := range custom {
+		themes[strings.ToLower(k)] = v
+	}
+	return themes, nil
+}
+
+// envTheme возвращает тему текущего окружения. Для окружения без темы префиксом служит его имя.
+func (cfg *Config) envTheme() (EnvTheme, bool) {
+	if cfg.Environment == "" {
+		return EnvTheme{}, false
+	}
+	if t, ok := cfg.EnvThemes[strings.ToLower(cfg.Environment)]; ok {
+		return t, true
+	}
+	return EnvTheme{Prefix: "[" + strings.ToUpper(cfg.Environment) + "]"}, true
+}
+
+// themedText добавляет к тексту префикс окружения — для каналов без вложений (личные сообщения)
+func (cfg *Config) themedText(text string) string {
+	t, ok := cfg.envTheme()
+	if !ok || t.Prefix == "" {
+		return text
+	}
+	return t.Prefix + " " + text
+}
+
+// mattermostAttachment — вложение сообщения Mattermost
+type mattermostAttachment struct {
+	Fallback string            `json:"fallback"`
+	Color    string            `json:"color,omitempty"`
+	Pretext  string            `json:"pretext,omitempty"`
+	Title    string            `json:"title,omitempty"`
+	Text     string            `json:"text"`
+	Fields   []mattermostField `json:"fields,omitempty"`
+	Footer   string            `json:"footer,omitempty"`
+}
+
+type mattermostField struct {
+	Short bool   `json:"short"`
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// applyEnvTheme оформляет сообщение webhook-а вложением в цветах окружения. Уже собранное
+// вложение (MM_RICH) сохраняет свой цвет, окружение добавляется в заголовок и подпись.
+func applyEnvTheme(cfg *Config, payload *mattermostPayload) {
+	t, ok := cfg.envTheme()
+	if !ok {
+		return
+	}
+	if len(payload.Attachments) > 0 {
+		a := &payload.Attachments[0]
+		a.Fallback = cfg.themedText(a.Fallback)
+		a.Pretext = t.Prefix
+		a.Footer = "environment: " + cfg.Environment
+		return
+	}
+	payload.Attachments = []mattermostAttachment{{
+		Fallback: cfg.themedText(payload.Text),
+		Color:    t.Color,
+		Pretext:  t.Prefix,
+		Text:     payload.Text,
+		Footer:   "environment: " + cfg.Environment,
+	}}
+	payload.Text = ""
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestEnvironmentThemes(t *testing.T) {
+	custom := `{"QA":{"color":"#2196F3","prefix":"[QA]"},"prod":{"color":"#FF0000","prefix":":red_circle: PROD"}}`
+	tests := []struct {
+		name       string
+		env        string
+		themes     string
+		wantPrefix string // пусто — сообщение без оформления
+		wantColor  string
+	}{
+		{name: "без ENVIRONMENT"},
+		{name: "prod", env: "prod", wantPrefix: "[PROD]", wantColor: "#D32F2F"},
+		{name: "регистр не важен", env: "Stage", wantPrefix: "[STAGE]", wantColor: "#F9A825"},
+		{name: "dev", env: "dev", wantPrefix: "[DEV]", wantColor: "#9E9E9E"},
+		{name: "своя тема", env: "qa", themes: custom, wantPrefix: "[QA]", wantColor: "#2196F3"},
+		{name: "своя тема вместо стандартной", env: "prod", themes: custom, wantPrefix: ":red_circle: PROD", wantColor: "#FF0000"},
+		{name: "окружение без темы", env: "sandbox", wantPrefix: "[SANDBOX]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mm := newMattermostRecorder(t)
+			cfg, clock := newTestConfig(t, map[string]string{"MM_WEBHOOK_URL": mm.URL, "ENVIRONMENT": tt.env, "ENVIRONMENT_THEMES": tt.themes})
+			ev := Event{Type: EventMediaDisabled, MediaID: "1", MediaName: "Email", Message: "Обнаружено отключенное медиа: Email", Time: clock.Now()}
+
+			notifyMattermost(context.Background(), cfg, ev, testLogger(t))
+
+			payloads := mm.Payloads()
+			if len(payloads) != 1 {
+				t.Fatalf("сообщений %d, ожидалось одно", len(payloads))
+			}
+			p := payloads[0]
+			if tt.wantPrefix == "" {
+				if p.Text != ev.Message || len(p.Attachments) != 0 {
+					t.Errorf("сообщение без окружения оформлено: %+v", p)
+				}
+				return
+			}
+			if p.Text != "" || len(p.Attachments) != 1 {
+				t.Fatalf("ожидалось одно вложение вместо текста: %+v", p)
+			}
+			a := p.Attachments[0]
+			if a.Color != tt.wantColor || a.Pretext != tt.wantPrefix || a.Text != ev.Message {
+				t.Errorf("вложение %+v, ожидались цвет %q и префикс %q", a, tt.wantColor, tt.wantPrefix)
+			}
+			if a.Fallback != tt.wantPrefix+" "+ev.Message {
+				t.Errorf("fallback %q", a.Fallback)
+			}
+			if a.Footer != "environment: "+tt.env {
+				t.Errorf("подпись %q, ожидалось окружение %s", a.Footer, tt.env)
+			}
+			if got := cfg.themedText("x"); got != tt.wantPrefix+" x" {
+				t.Errorf("текст личного сообщения %q", got)
+			}
+			if ce := buildCloudEvent(cfg, ev); ce.Environment != tt.env {
+				t.Errorf("environment в CloudEvent %q, ожидалось %q", ce.Environment, tt.env)
+			}
+		})
+	}
+}
+
+func TestParseEnvThemesInvalid(t *testing.T) {
+	if _, err := parseEnvThemes(`{"prod":"red"}`); err == nil || !strings.Contains(err.Error(), "ENVIRONMENT_THEMES") {
+		t.Errorf("ошибка %v", err)
+	}
+}
+
+func TestEnvironmentThemeKeepsRichColor(t *testing.T) {
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{"MM_WEBHOOK_URL": mm.URL, "ENVIRONMENT": "dev", "MM_RICH": "1"})
+
+	notifyMattermost(context.Background(), cfg, Event{Type: EventMediaEnableFailed, MediaID: "1", MediaName: "Email", Message: "Не удалось включить Email", Time: clock.Now()}, testLogger(t))
+
+	payloads := mm.Payloads()
+	if len(payloads) != 1 || len(payloads[0].Attachments) != 1 {
+		t.Fatalf("сообщения %+v", payloads)
+	}
+	a := payloads[0].Attachments[0]
+	// цвет серьёзности из MM_RICH важнее серого цвета dev
+	if a.Color != richColorCritical || a.Pretext != "[DEV]" || a.Footer != "environment: dev" || !strings.HasPrefix(a.Fallback, "[DEV] ") {
+		t.Errorf("вложение %+v", a)
+	}
+}
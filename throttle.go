@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCooldowns — кулдауны по умолчанию: напоминание "медиа всё ещё отключено" раз в час
+// (NOTIFY_REPEAT_INTERVAL), остальные события не ограничиваются
+var defaultCooldowns = map[string]time.Duration{
+	EventMediaStillDisabled:  time.Hour,
+	EventActionStillDisabled: time.Hour,
+}
+
+// throttleStateFilename — время последних уведомлений по медиа, чтобы перезапуск не сбрасывал кулдауны
+const throttleStateFilename = "notify_throttle_state.json"
+
+// knownEventTypes — события, для которых можно задать кулдаун
+var knownEventTypes = []string{
+	EventMediaDisabled, EventMediaStillDisabled, EventMediaAutoEnabled, EventMediaEnableFailed,
+	EventMediaRestored, EventTemplateChanged, EventMediaFieldsChanged,
+	EventActionDisabled, EventActionStillDisabled, EventActionAutoEnabled, EventActionEnableFailed, EventActionRestored,
+}
+
+// parseCooldowns разбирает NOTIFY_COOLDOWNS вида "media_still_disabled:30,media_enable_failed:10" (минуты)
+// поверх значений по умолчанию; repeat — интервал напоминаний из NOTIFY_REPEAT_INTERVAL
+func parseCooldowns(raw string, repeat time.Duration) (map[string]time.Duration, error) {
+	cooldowns := make(map[string]time.Duration, len(defaultCooldowns))
+	for k, v := range defaultCooldowns {
+		cooldowns[k] = v
+	}
+	cooldowns[EventMediaStillDisabled] = repeat
+	cooldowns[EventActionStillDisabled] = repeat
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		evType, minutes, ok := strings.Cut(part, ":")
+		evType = strings.TrimSpace(evType)
+		if !ok || !isKnownEventType(evType) {
+			return nil, fmt.Errorf("неверный элемент NOTIFY_COOLDOWNS: %q", part)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(minutes))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("неверный кулдаун в NOTIFY_COOLDOWNS: %q", part)
+		}
+		cooldowns[evType] = time.Duration(n) * time.Minute
+	}
+	return cooldowns, nil
+}
+
+func isKnownEventType(t string) bool {
+	for _, k := range knownEventTypes {
+		if k == t {
+			return true
+		}
+	}
+	return false
+}
+
+type throttleKey struct {
+	mediaID   string
+	eventType string
+}
+
+// eventThrottle хранит время последней отправки по паре (медиа, тип события),
+// поэтому напоминания и, например, ошибки включения одного медиа ограничиваются независимо
+type eventThrottle struct {
+	mu   sync.Mutex
+	sent map[throttleKey]time.Time
+	// есть изменения, не записанные в файл
+	dirty bool
+}
+
+func newEventThrottle() *eventThrottle {
+	return &eventThrottle{sent: map[throttleKey]time.Time{}}
+}
+
+// Allow сообщает, можно ли отправить событие сейчас, и при положительном ответе запоминает отправку
+func (t *eventThrottle) Allow(mediaID, eventType string, cooldown time.Duration, now time.Time) bool {
+	if cooldown <= 0 || mediaID == "" {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := throttleKey{mediaID, eventType}
+	if last, ok := t.sent[key]; ok && now.Sub(last) < cooldown {
+		return false
+	}
+	t.sent[key] = now
+	t.dirty = true
+	return true
+}
+
+// Mark запоминает отправку без проверки (например, чтобы отложить первое напоминание)
+func (t *eventThrottle) Mark(mediaID, eventType string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent[throttleKey{mediaID, eventType}] = now
+	t.dirty = true
+}
+
+// Forget сбрасывает все кулдауны медиа (после включения/восстановления)
+func (t *eventThrottle) Forget(mediaID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k := range t.sent {
+		if k.mediaID == mediaID {
+			delete(t.sent, k)
+			t.dirty = true
+		}
+	}
+}
+
+// load восстанавливает сохранённые отправки: ключ файла — "<id медиа>/<тип события>"
+func (t *eventThrottle) load(filename string) error {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved map[string]time.Time
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, v := range saved {
+		if id, evType, ok := strings.Cut(k, "/"); ok {
+			t.sent[throttleKey{id, evType}] = v
+		}
+	}
+	return nil
+}
+
+// snapshot возвращает содержимое для файла, если с прошлого вызова что-то изменилось.
+// Записи с истёкшим кулдауном ни на что не влияют и не сохраняются.
+func (t *eventThrottle) snapshot(cooldowns map[string]time.Duration, now time.Time) (map[string]time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return nil, false
+	}
+	t.dirty = false
+	out := make(map[string]time.Time, len(t.sent))
+	for k, v := range t.sent {
+		if now.Sub(v) < cooldowns[k.eventType] {
+			out[k.mediaID+"/"+k.eventType] = v
+		}
+	}
+	return out, true
+}
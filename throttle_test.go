@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCooldowns(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    map[string]time.Duration
+		wantErr string
+	}{
+		{
+			name: "по умолчанию",
+			want: map[string]time.Duration{EventMediaStillDisabled: 20 * time.Minute, EventActionStillDisabled: 20 * time.Minute},
+		},
+		{
+			name: "свои кулдауны поверх умолчаний",
+			raw:  " media_enable_failed:10 , media_still_disabled:45",
+			want: map[string]time.Duration{
+				EventMediaStillDisabled:  45 * time.Minute,
+				EventActionStillDisabled: 20 * time.Minute,
+				EventMediaEnableFailed:   10 * time.Minute,
+			},
+		},
+		{
+			name: "ноль снимает ограничение",
+			raw:  "media_still_disabled:0",
+			want: map[string]time.Duration{EventMediaStillDisabled: 0, EventActionStillDisabled: 20 * time.Minute},
+		},
+		{name: "неизвестное событие", raw: "heartbeat:10", wantErr: "неверный элемент NOTIFY_COOLDOWNS"},
+		{name: "без минут", raw: "media_enable_failed", wantErr: "неверный элемент NOTIFY_COOLDOWNS"},
+		{name: "отрицательные минуты", raw: "media_enable_failed:-5", wantErr: "неверный кулдаун"},
+		{name: "не число", raw: "media_enable_failed:10m", wantErr: "неверный кулдаун"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCooldowns(tt.raw, 20*time.Minute)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("кулдауны %v, ожидалось %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndependentThrottling(t *testing.T) {
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{
+		"MM_WEBHOOK_URL":         mm.URL,
+		"NOTIFY_COOLDOWNS":       "media_enable_failed:0",
+		"NOTIFY_REPEAT_INTERVAL": "30",
+	})
+	logger := testLogger(t)
+	type send struct {
+		media, event string
+	}
+	steps := []struct {
+		advance time.Duration
+		sends   []send
+		want    []string // "<медиа>/<событие>" реально отправленных
+	}{
+		{
+			sends: []send{{"1", EventMediaStillDisabled}, {"1", EventMediaEnableFailed}, {"2", EventMediaStillDisabled}},
+			want:  []string{"1/" + EventMediaStillDisabled, "1/" + EventMediaEnableFailed, "2/" + EventMediaStillDisabled},
+		},
+		{
+			// напоминания ограничены кулдауном, ошибки включения того же медиа — нет
+			advance: 10 * time.Minute,
+			sends:   []send{{"1", EventMediaStillDisabled}, {"1", EventMediaEnableFailed}, {"2", EventMediaStillDisabled}},
+			want:    []string{"1/" + EventMediaEnableFailed},
+		},
+		{
+			advance: 20 * time.Minute,
+			sends:   []send{{"1", EventMediaStillDisabled}, {"2", EventMediaStillDisabled}},
+			want:    []string{"1/" + EventMediaStillDisabled, "2/" + EventMediaStillDisabled},
+		},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		for _, s := range step.sends {
+			notify(context.Background(), cfg, Event{Type: s.event, MediaID: s.media, Message: s.media + "/" + s.event, Time: clock.Now()}, logger)
+		}
+		if got := mm.Texts(); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("шаг %d: отправлено %v, ожидалось %v", i+1, got, step.want)
+		}
+	}
+}
+
+func TestEventThrottleForget(t *testing.T) {
+	now := newFakeClock().Now()
+	th := newEventThrottle()
+	th.Mark("1", EventMediaStillDisabled, now)
+	th.Mark("2", EventMediaStillDisabled, now)
+
+	th.Forget("2")
+	if !th.Allow("2", EventMediaStillDisabled, time.Hour, now) {
+		t.Error("после Forget напоминание по медиа 2 ограничено")
+	}
+	if th.Allow("1", EventMediaStillDisabled, time.Hour, now.Add(30*time.Minute)) {
+		t.Error("Forget сбросил кулдаун другого медиа")
+	}
+}
+
+func TestThrottleSurvivesRestart(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cooldowns := map[string]time.Duration{EventMediaStillDisabled: time.Hour}
+	before := newEventThrottle()
+	before.Mark("1", EventMediaStillDisabled, now.Add(-10*time.Minute))
+	// кулдаун истёк — запись в файл не попадает
+	before.Mark("2", EventMediaStillDisabled, now.Add(-2*time.Hour))
+
+	sent, changed := before.snapshot(cooldowns, now)
+	if !changed || len(sent) != 1 {
+		t.Fatalf("снимок %v (изменён: %v), ожидалась одна запись", sent, changed)
+	}
+	if _, changed := before.snapshot(cooldowns, now); changed {
+		t.Error("снимок без новых отправок помечен изменённым")
+	}
+	data, err := marshalState(sent, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), throttleStateFilename)
+	writeFile(t, file, string(data))
+
+	after := newEventThrottle()
+	if err := after.load(file); err != nil {
+		t.Fatal(err)
+	}
+	if after.Allow("1", EventMediaStillDisabled, time.Hour, now) {
+		t.Error("после перезапуска напоминание отправлено раньше NOTIFY_REPEAT_INTERVAL")
+	}
+	if !after.Allow("1", EventMediaStillDisabled, time.Hour, now.Add(50*time.Minute)) {
+		t.Error("после перезапуска напоминание не отправлено по истечении интервала")
+	}
+	if !after.Allow("2", EventMediaStillDisabled, time.Hour, now) {
+		t.Error("медиа с истёкшим кулдауном не получило напоминание")
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Мониторинг медиа пользователей ----------------
+// Помимо медиа-типов у каждого пользователя свои назначения (адреса, номера).
+// Отслеживаем их добавление, удаление, выключение и смену адреса. Логика baseline как у групп.
+
+// UserMedia — одно медиа пользователя
+type UserMedia struct {
+	MediaTypeID string   `json:"mediatypeid"`
+	SendTo      []string `json:"sendto"`
+	Active      string   `json:"active"` // "0" — включено, "1" — выключено
+}
+
+type UserMediaEntry struct {
+	Username string               `json:"username"`
+	Medias   map[string]UserMedia `json:"medias"` // mediaid -> медиа
+}
+
+// UserMediaState — userid -> медиа пользователя
+type UserMediaState map[string]UserMediaEntry
+
+const userMediaStateFilename = "user_media_state.json"
+
+func loadUserMediaState(filename string) (UserMediaState, bool, error) {
+	state := make(UserMediaState)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	if len(data) == 0 {
+		return state, true, nil
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, true, err
+	}
+	return state, true, nil
+}
+
+// sendTo — у email-медиа Zabbix отдаёт sendto массивом, у остальных строкой
+type sendTo []string
+
+func (s *sendTo) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*s = list
+		return nil
+	}
+	var one string
+	if err := json.Unmarshal(data, &one); err != nil {
+		return err
+	}
+	*s = []string{one}
+	return nil
+}
+
+// getUserMedias вызывает user.get с selectMedias
+func getUserMedias(ctx context.Context, cfg *Config, logger *logrus.Logger) (UserMediaState, error) {
+	req := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "user.get",
+		Params: map[string]interface{}{
+			"output":       []string{"userid", "username"},
+			"selectMedias": []string{"mediaid", "mediatypeid", "sendto", "active"},
+		},
+		Auth: zabbixSession(cfg),
+		ID:   21,
+	}
+	var result []struct {
+		UserID   string `json:"userid"`
+		Username string `json:"username"`
+		Medias   []struct {
+			MediaID     string `json:"mediaid"`
+			MediaTypeID string `json:"mediatypeid"`
+			SendTo      sendTo `json:"sendto"`
+			Active      string `json:"active"`
+		} `json:"medias"`
+	}
+	if err := callZabbix(ctx, cfg, req, &result, logger); err != nil {
+		return nil, err
+	}
+	state := make(UserMediaState, len(result))
+	for _, u := range result {
+		entry := UserMediaEntry{Username: u.Username, Medias: map[string]UserMedia{}}
+		for _, m := range u.Medias {
+			to := append([]string(nil), m.SendTo...)
+			sort.Strings(to)
+			entry.Medias[m.MediaID] = UserMedia{MediaTypeID: m.MediaTypeID, SendTo: to, Active: m.Active}
+		}
+		state[u.UserID] = entry
+	}
+	return state, nil
+}
+
+// compareUserMedias возвращает описания изменений медиа пользователей
+func compareUserMedias(prev, curr UserMediaState, mediaName func(string) string) []string {
+	changes := []string{}
+	userIDs := make([]string, 0, len(curr))
+	for id := range curr {
+		userIDs = append(userIDs, id)
+	}
+	sort.Strings(userIDs)
+	for _, uid := range userIDs {
+		before, ok := prev[uid]
+		if !ok {
+			// новые пользователи — забота мониторинга пользователей
+			continue
+		}
+		after := curr[uid]
+		who := fmt.Sprintf("%s (id=%s)", after.Username, uid)
+
+		ids := make([]string, 0, len(before.Medias)+len(after.Medias))
+		for id := range before.Medias {
+			ids = append(ids, id)
+		}
+		for id := range after.Medias {
+			if _, ok := before.Medias[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			b, hadBefore := before.Medias[id]
+			a, hasNow := after.Medias[id]
+			switch {
+			case !hadBefore:
+				changes = append(changes, fmt.Sprintf("У пользователя %s добавлено медиа %s: %s", who, mediaName(a.MediaTypeID), strings.Join(a.SendTo, ", ")))
+			case !hasNow:
+				changes = append(changes, fmt.Sprintf("У пользователя %s удалено медиа %s: %s", who, mediaName(b.MediaTypeID), strings.Join(b.SendTo, ", ")))
+			default:
+				if b.Active != a.Active {
+					verb := "выключено"
+					if a.Active == "0" {
+						verb = "включено"
+					}
+					changes = append(changes, fmt.Sprintf("У пользователя %s %s медиа %s: %s", who, verb, mediaName(a.MediaTypeID), strings.Join(a.SendTo, ", ")))
+				}
+				if added, removed := diffUsers(b.SendTo, a.SendTo); len(added) > 0 || len(removed) > 0 {
+					changes = append(changes, fmt.Sprintf("У пользователя %s изменён адрес медиа %s: добавлены [%s], удалены [%s]",
+						who, mediaName(a.MediaTypeID), strings.Join(added, ","), strings.Join(removed, ",")))
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// sameUserIDs — одинаков ли набор пользователей (новые пользователи не дают изменений, но их нужно сохранить)
+func sameUserIDs(a, b UserMediaState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id := range a {
+		if _, ok := b[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// processUserMedias отслеживает медиа пользователей. Возвращает false, если данные получить не удалось.
+func processUserMedias(ctx context.Context, cfg *Config, prev UserMediaState, mediaTypes []MediaType, commit *cycleCommit, logger *logrus.Logger, sysLogger *syslog.Writer, baselineMode bool) bool {
+	current, err := getUserMedias(ctx, cfg, logger)
+	if err != nil {
+		logger.Errorf("Ошибка получения медиа пользователей: %v", err)
+		return false
+	}
+
+	if !baselineMode {
+		names := map[string]string{}
+		for _, m := range mediaTypes {
+			names[m.MediaTypeID] = m.Name
+		}
+		mediaName := func(id string) string {
+			if n, ok := names[id]; ok {
+				return n
+			}
+			return "mediatypeid=" + id
+		}
+		changes := compareUserMedias(prev, current, mediaName)
+		if len(changes) == 0 && sameUserIDs(prev, current) {
+			return true
+		}
+		for _, c := range changes {
+			if sysLogger != nil {
+				_ = sysLogger.Warning(fmt.Sprintf("User media change detected: %s", c))
+			}
+			notify(ctx, cfg, Event{Type: EventUserMediaChanged, Message: c}, logger)
+			logger.Warnf("User media change: %s", c)
+		}
+	}
+
+	data, err := marshalState(current, cfg.StateCompact)
+	if err != nil {
+		logger.Errorf("Ошибка сохранения состояния медиа пользователей: %v", err)
+		return true
+	}
+	commit.Stage(cfg.stateFile(userMediaStateFilename), data)
+	if baselineMode {
+		logger.Infof("Baseline медиа пользователей будет сохранён в %s — уведомлений не отправлено", cfg.stateFile(userMediaStateFilename))
+	}
+
+	for k := range prev {
+		delete(prev, k)
+	}
+	for k, v := range current {
+		prev[k] = v
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCompareUserMedias(t *testing.T) {
+	email := UserMedia{MediaTypeID: "1", SendTo: []string{"ops@example.com"}, Active: "0"}
+	sms := UserMedia{MediaTypeID: "2", SendTo: []string{"+70000000000"}, Active: "0"}
+	user := func(medias map[string]UserMedia) UserMediaState {
+		return UserMediaState{"5": {Username: "ivanov", Medias: medias}}
+	}
+	names := func(id string) string {
+		if id == "1" {
+			return "Email"
+		}
+		return "mediatypeid=" + id
+	}
+	tests := []struct {
+		name       string
+		prev, curr UserMediaState
+		want       []string
+	}{
+		{name: "без изменений", prev: user(map[string]UserMedia{"10": email}), curr: user(map[string]UserMedia{"10": email}), want: []string{}},
+		{
+			name: "медиа удалено",
+			prev: user(map[string]UserMedia{"10": email, "11": sms}), curr: user(map[string]UserMedia{"11": sms}),
+			want: []string{"У пользователя ivanov (id=5) удалено медиа Email: ops@example.com"},
+		},
+		{
+			name: "медиа добавлено",
+			prev: user(map[string]UserMedia{"10": email}), curr: user(map[string]UserMedia{"10": email, "11": sms}),
+			want: []string{"У пользователя ivanov (id=5) добавлено медиа mediatypeid=2: +70000000000"},
+		},
+		{
+			name: "медиа выключено",
+			prev: user(map[string]UserMedia{"10": email}),
+			curr: user(map[string]UserMedia{"10": {MediaTypeID: "1", SendTo: email.SendTo, Active: "1"}}),
+			want: []string{"У пользователя ivanov (id=5) выключено медиа Email: ops@example.com"},
+		},
+		{
+			name: "сменился адрес",
+			prev: user(map[string]UserMedia{"10": email}),
+			curr: user(map[string]UserMedia{"10": {MediaTypeID: "1", SendTo: []string{"noc@example.com"}, Active: "0"}}),
+			want: []string{"У пользователя ivanov (id=5) изменён адрес медиа Email: добавлены [noc@example.com], удалены [ops@example.com]"},
+		},
+		{
+			// новые и удалённые пользователи — забота мониторинга пользователей
+			name: "новый пользователь",
+			prev: UserMediaState{},
+			curr: user(map[string]UserMedia{"10": email}),
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareUserMedias(tt.prev, tt.curr, names); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("изменения %q, ожидалось %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeUsers — ответ user.get с selectMedias, который тест меняет между циклами
+type fakeUsers struct {
+	mu   sync.Mutex
+	json string
+}
+
+func (f *fakeUsers) Set(s string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.json = s
+}
+
+func (f *fakeUsers) handle(json.RawMessage) (interface{}, *fakeError) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return json.RawMessage(f.json), nil
+}
+
+func TestUserMediaRemoved(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	// у email-медиа sendto — массив, у SMS — строка
+	users := &fakeUsers{json: `[{"userid":"5","username":"ivanov","medias":[
+		{"mediaid":"10","mediatypeid":"1","sendto":["ops@example.com"],"active":"0"},
+		{"mediaid":"11","mediatypeid":"2","sendto":"+70000000000","active":"0"}]}]`}
+	zabbix.Handle("user.get", users.handle)
+	mm := newMattermostRecorder(t)
+	cfg, _ := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "MM_WEBHOOK_URL": mm.URL, "MONITOR_USER_MEDIA": "1"})
+	logger := testLogger(t)
+	mediaTypes := []MediaType{{MediaTypeID: "1", Name: "Email"}, {MediaTypeID: "2", Name: "SMS"}}
+
+	state, existed, err := loadUserMediaState(userMediaStateFilename)
+	if err != nil || existed {
+		t.Fatalf("начальное состояние: %v, %v", existed, err)
+	}
+	cycle := func(baseline bool) []string {
+		t.Helper()
+		commit := newCycleCommit()
+		if !processUserMedias(context.Background(), cfg, state, mediaTypes, commit, logger, nil, baseline) {
+			t.Fatal("не удалось получить медиа пользователей")
+		}
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+		return mm.Texts()
+	}
+
+	// первый запуск — baseline без уведомлений
+	if texts := cycle(true); len(texts) != 0 {
+		t.Fatalf("baseline: сообщения %q", texts)
+	}
+
+	// удалили адрес почты
+	users.Set(`[{"userid":"5","username":"ivanov","medias":[
+		{"mediaid":"11","mediatypeid":"2","sendto":"+70000000000","active":"0"}]}]`)
+	texts := cycle(false)
+	if want := "У пользователя ivanov (id=5) удалено медиа Email: ops@example.com"; len(texts) != 1 || !strings.Contains(texts[0], want) {
+		t.Fatalf("сообщения %q, ожидалось одно с %q", texts, want)
+	}
+
+	// о том же удалении повторно не сообщаем
+	if texts := cycle(false); len(texts) != 0 {
+		t.Errorf("повторный цикл: сообщения %q", texts)
+	}
+
+	// сохранённое состояние уже без удалённого медиа — после перезапуска сравнение продолжится с ним
+	saved, existed, err := loadUserMediaState(userMediaStateFilename)
+	if err != nil || !existed {
+		t.Fatalf("сохранённое состояние: %v, %v", existed, err)
+	}
+	if _, ok := saved["5"].Medias["10"]; ok || len(saved["5"].Medias) != 1 {
+		t.Errorf("сохранено %+v", saved)
+	}
+}
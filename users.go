@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Мониторинг пользователей ----------------
+
+// UserState — userid -> username
+type UserState map[string]string
+
+const userStateFilename = "user_state.json"
+
+func loadUserState(filename string) (UserState, bool, error) {
+	state := make(UserState)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+	if len(data) == 0 {
+		return state, true, nil
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, true, err
+	}
+	return state, true, nil
+}
+
+// getUsers вызывает user.get и возвращает карту userid -> username
+func getUsers(ctx context.Context, cfg *Config, logger *logrus.Logger) (map[string]string, error) {
+	req := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "user.get",
+		Params: map[string]interface{}{
+			"output": []string{"userid", "username"},
+		},
+		Auth: zabbixSession(cfg),
+		ID:   20,
+	}
+	var result []struct {
+		UserID   string `json:"userid"`
+		Username string `json:"username"`
+	}
+	if err := callZabbix(ctx, cfg, req, &result, logger); err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]string, len(result))
+	for _, u := range result {
+		users[u.UserID] = u.Username
+	}
+	logger.Infof("Получено %d пользователей", len(users))
+	return users, nil
+}
+
+// compareUserStates возвращает описания созданных и удалённых пользователей
+func compareUserStates(prev, curr UserState) []string {
+	changes := []string{}
+	ids := make([]string, 0, len(curr))
+	for id := range curr {
+		if _, ok := prev[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		changes = append(changes, fmt.Sprintf("Создан пользователь: %s (id=%s)", curr[id], id))
+	}
+
+	ids = ids[:0]
+	for id := range prev {
+		if _, ok := curr[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		changes = append(changes, fmt.Sprintf("Удалён пользователь: %s (id=%s)", prev[id], id))
+	}
+	return changes
+}
+
+// processUsers отслеживает создание и удаление учётных записей. Логика baseline как у групп.
+// Возвращает false, если список пользователей получить не удалось.
+func processUsers(ctx context.Context, cfg *Config, prev UserState, commit *cycleCommit, logger *logrus.Logger, sysLogger *syslog.Writer, baselineMode bool) bool {
+	users, err := getUsers(ctx, cfg, logger)
+	if err != nil {
+		logger.Errorf("Ошибка получения пользователей: %v", err)
+		return false
+	}
+	current := UserState(users)
+
+	if !baselineMode {
+		changes := compareUserStates(prev, current)
+		if len(changes) == 0 {
+			return true
+		}
+		for _, c := range changes {
+			if sysLogger != nil {
+				_ = sysLogger.Warning(fmt.Sprintf("User change detected: %s", c))
+			}
+			notify(ctx, cfg, Event{Type: EventUserChanged, Message: fmt.Sprintf("Изменения в пользователях: %s", c)}, logger)
+			logger.Warnf("User change: %s", c)
+		}
+	}
+
+	data, err := marshalState(current, cfg.StateCompact)
+	if err != nil {
+		logger.Errorf("Ошибка сохранения состояния пользователей: %v", err)
+		return true
+	}
+	commit.Stage(cfg.stateFile(userStateFilename), data)
+	if baselineMode {
+		logger.Infof("Baseline пользователей будет сохранён в %s — уведомлений не отправлено", cfg.stateFile(userStateFilename))
+	}
+
+	for k := range prev {
+		delete(prev, k)
+	}
+	for k, v := range current {
+		prev[k] = v
+	}
+	return true
+}
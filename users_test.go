@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCompareUserStates(t *testing.T) {
+	tests := []struct {
+		name       string
+		prev, curr UserState
+		want       []string
+	}{
+		{name: "без изменений", prev: UserState{"1": "alice"}, curr: UserState{"1": "alice"}, want: []string{}},
+		{name: "создан", prev: UserState{"1": "alice"}, curr: UserState{"1": "alice", "2": "bob"}, want: []string{"Создан пользователь: bob (id=2)"}},
+		{name: "удалён", prev: UserState{"1": "alice", "2": "bob"}, curr: UserState{"2": "bob"}, want: []string{"Удалён пользователь: alice (id=1)"}},
+		{
+			name: "создан и удалён",
+			prev: UserState{"1": "alice", "3": "carol"},
+			curr: UserState{"1": "alice", "4": "dave", "2": "bob"},
+			want: []string{"Создан пользователь: bob (id=2)", "Создан пользователь: dave (id=4)", "Удалён пользователь: carol (id=3)"},
+		},
+		// переименование учётной записи — не создание и не удаление
+		{name: "переименован", prev: UserState{"1": "alice"}, curr: UserState{"1": "alice.smith"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareUserStates(tt.prev, tt.curr); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%q, ожидалось %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessUsers(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	mm := newMattermostRecorder(t)
+	cfg, _ := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "MM_WEBHOOK_URL": mm.URL, "MONITOR_USERS": "1"})
+	logger := testLogger(t)
+	users := []map[string]string{{"userid": "1", "username": "alice"}, {"userid": "2", "username": "bob"}}
+	zabbix.Handle("user.get", func(json.RawMessage) (interface{}, *fakeError) { return users, nil })
+
+	// cycle — один цикл; состояние берётся с диска, как после перезапуска
+	cycle := func() bool {
+		prev, existed, err := loadUserState(userStateFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit := newCycleCommit()
+		ok := processUsers(context.Background(), cfg, prev, commit, logger, nil, !existed)
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	if !cycle() {
+		t.Fatal("processUsers вернул false")
+	}
+	if got := mm.Texts(); got != nil {
+		t.Fatalf("baseline отправил уведомления %q", got)
+	}
+
+	users = []map[string]string{{"userid": "2", "username": "bob"}, {"userid": "3", "username": "carol"}}
+	cycle()
+	messages := mm.Texts()
+	want := []string{"Изменения в пользователях: Создан пользователь: carol (id=3)", "Изменения в пользователях: Удалён пользователь: alice (id=1)"}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("уведомления %q, ожидалось %q", messages, want)
+	}
+
+	cycle()
+	if got := mm.Texts(); got != nil {
+		t.Errorf("повторные уведомления %q", got)
+	}
+
+	// без ответа user.get состояние не трогается
+	zabbix.Handle("user.get", func(json.RawMessage) (interface{}, *fakeError) {
+		return nil, &fakeError{Code: -32500, Message: "Application error.", Data: json.RawMessage(`"No permissions."`)}
+	})
+	if cycle() {
+		t.Error("ошибка user.get не отражена в результате")
+	}
+	if state, _, _ := loadUserState(userStateFilename); !reflect.DeepEqual(state, UserState{"2": "bob", "3": "carol"}) {
+		t.Errorf("состояние после ошибки: %v", state)
+	}
+}
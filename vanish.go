@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Пропавшие медиа ----------------
+// Медиа может на цикл выпасть из ответа mediatype.get (сбой запроса, одновременное
+// редактирование), поэтому о пропаже сообщается, только если медиа нет VANISH_GRACE циклов подряд.
+
+const vanishStateFilename = "media_vanish_state.json"
+
+// VanishState — отслеживаемые медиа по id: сколько циклов подряд их нет в ответе API
+type VanishState map[string]*VanishEntry
+
+type VanishEntry struct {
+	Name     string    `json:"name"`
+	LastSeen time.Time `json:"last_seen"`
+	Missing  int       `json:"missing,omitempty"`
+	Reported bool      `json:"reported,omitempty"`
+}
+
+func loadVanishState(filename string) (VanishState, error) {
+	state := make(VanishState)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	return state, json.Unmarshal(data, &state)
+}
+
+// processVanished сверяет полученный список медиа с прошлыми циклами. Вызывается только
+// с успешно полученным непустым списком: ошибка запроса целиком пропажей не считается.
+func processVanished(ctx context.Context, cfg *Config, mediaTypes []MediaType, state VanishState, commit *cycleCommit, logger *logrus.Logger, sysLogger *syslog.Writer) {
+	now := cfg.Clock.Now()
+	changed := false
+	seen := make(map[string]bool, len(mediaTypes))
+	for _, media := range mediaTypes {
+		seen[media.MediaTypeID] = true
+		entry, ok := state[media.MediaTypeID]
+		if !ok {
+			state[media.MediaTypeID] = &VanishEntry{Name: media.Name, LastSeen: now}
+			changed = true
+			continue
+		}
+		if entry.Reported {
+			msg := fmt.Sprintf("Медиа %s снова появилось в Zabbix (отсутствовало с %s)", media.Name, entry.LastSeen.Format(time.RFC3339))
+			logger.WithFields(logrus.Fields{"media_id": media.MediaTypeID, "media_name": media.Name}).Info(msg)
+			notify(ctx, cfg, Event{Type: EventMediaReappeared, MediaID: media.MediaTypeID, MediaName: media.Name, Channel: cfg.policyFor(media.Name).Channel, Message: msg}, logger)
+		}
+		if entry.Missing > 0 || entry.Reported || entry.Name != media.Name {
+			changed = true
+		}
+		*entry = VanishEntry{Name: media.Name, LastSeen: now}
+	}
+
+	for id, entry := range state {
+		if seen[id] {
+			continue
+		}
+		if !cfg.watchesMediaName(entry.Name) {
+			// медиа убрали из отслеживаемых — это не пропажа
+			delete(state, id)
+			changed = true
+			continue
+		}
+		if entry.Reported {
+			continue
+		}
+		entry.Missing++
+		changed = true
+		logEntry := logger.WithFields(logrus.Fields{"media_id": id, "media_name": entry.Name, "missing_cycles": entry.Missing})
+		if entry.Missing < cfg.VanishGrace {
+			logEntry.Warnf("Медиа %s нет в ответе Zabbix (%d из %d циклов до уведомления)", entry.Name, entry.Missing, cfg.VanishGrace)
+			continue
+		}
+		entry.Reported = true
+		msg := fmt.Sprintf("Медиа %s пропало из Zabbix: его нет %d циклов подряд (последний раз видели %s). Медиа удалено или больше не подходит под отбор",
+			entry.Name, entry.Missing, entry.LastSeen.Format(time.RFC3339))
+		logEntry.Error(msg)
+		if sysLogger != nil {
+			_ = sysLogger.Warning(msg)
+		}
+		notify(ctx, cfg, Event{Type: EventMediaVanished, MediaID: id, MediaName: entry.Name, Channel: cfg.policyFor(entry.Name).Channel, Message: msg}, logger)
+	}
+
+	if changed {
+		saveVanishState(cfg, state, commit, logger)
+	}
+}
+
+// saveVanishState добавляет состояние пропавших медиа в коммит цикла. Повторный вызов
+// за цикл заменяет прежнюю запись.
+func saveVanishState(cfg *Config, state VanishState, commit *cycleCommit, logger *logrus.Logger) {
+	data, err := marshalState(state, cfg.StateCompact)
+	if err != nil {
+		logger.Errorf("Ошибка сохранения состояния пропавших медиа: %v", err)
+		return
+	}
+	commit.Stage(cfg.stateFile(vanishStateFilename), data)
+}
+
+// pruneVanishedRecords удаляет из состояния медиа, которых больше нет в ответе Zabbix: иначе
+// их записи копились бы вечно. Без VANISH_GRACE о пропаже сообщается здесь же. С ним запись
+// удаляется, только когда processVanished отметил пропажу в vanished (медиа нет VANISH_GRACE
+// циклов подряд) — до этого медиа могло лишь на цикл выпасть из ответа; вместе с записью удаляется
+// и отметка о пропаже. pruned — изменилось состояние медиа, vanishChanged — состояние пропавших.
+func pruneVanishedRecords(ctx context.Context, cfg *Config, mediaTypes []MediaType, state MediaState, failures EnableFailures, vanished VanishState, logger *logrus.Logger) (pruned, vanishChanged bool) {
+	seen := make(map[string]bool, len(mediaTypes))
+	for _, media := range mediaTypes {
+		seen[media.MediaTypeID] = true
+	}
+	for id, rec := range state {
+		if seen[id] {
+			continue
+		}
+		unwatched := !cfg.watchesMediaName(rec.Name)
+		if cfg.VanishGrace > 0 && !unwatched {
+			entry, ok := vanished[id]
+			if !ok {
+				// медиа пропало раньше, чем его увидел processVanished: начинаем отсчёт с этого цикла
+				vanished[id] = &VanishEntry{Name: rec.Name, LastSeen: cfg.Clock.Now(), Missing: 1}
+				vanishChanged = true
+			}
+			if !ok || !entry.Reported {
+				continue
+			}
+			// о пропаже сообщено, записи состояния больше нет — отметка копилась бы вечно
+			delete(vanished, id)
+			vanishChanged = true
+		}
+		delete(state, id)
+		delete(failures, id)
+		pruned = true
+		logEntry := logger.WithFields(logrus.Fields{"media_id": id, "media_name": rec.Name})
+		if cfg.VanishGrace > 0 || unwatched {
+			logEntry.Warn("Отслеживаемого медиа нет в ответе Zabbix — запись состояния удалена")
+			continue
+		}
+		msg := fmt.Sprintf("Отслеживаемое медиа %s больше не существует в Zabbix — запись состояния удалена (отключено с %s)", rec.Name, rec.FirstSeen.Format(time.RFC3339))
+		logEntry.Error(msg)
+		notify(ctx, cfg, Event{Type: EventMediaVanished, MediaID: id, MediaName: rec.Name, Channel: cfg.policyFor(rec.Name).Channel, Message: msg}, logger)
+	}
+	return pruned, vanishChanged
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessVanishedAlerts(t *testing.T) {
+	const (
+		vanished   = "Медиа Email пропало из Zabbix"
+		reappeared = "Медиа Email снова появилось в Zabbix"
+	)
+	tests := []struct {
+		name  string
+		grace string
+		// присутствие Email в ответе по циклам и ожидаемые сообщения каждого цикла
+		present []bool
+		want    [][]string
+	}{
+		{
+			name:    "пропуск на один цикл",
+			grace:   "2",
+			present: []bool{true, false, true, false, true},
+			want:    [][]string{nil, nil, nil, nil, nil},
+		},
+		{
+			name:    "устойчивая пропажа и возвращение",
+			grace:   "2",
+			present: []bool{true, false, false, false, true},
+			want:    [][]string{nil, nil, {vanished}, nil, {reappeared}},
+		},
+		{
+			name:    "VANISH_GRACE=1",
+			grace:   "1",
+			present: []bool{true, false},
+			want:    [][]string{nil, {vanished}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mm := newMattermostRecorder(t)
+			cfg, clock := newTestConfig(t, map[string]string{"MM_WEBHOOK_URL": mm.URL, "VANISH_GRACE": tt.grace})
+			logger := testLogger(t)
+			email := MediaType{MediaTypeID: "1", Name: "Email", Status: "0"}
+			sms := MediaType{MediaTypeID: "2", Name: "SMS", Status: "0"}
+			state := make(VanishState)
+			missing := 0
+			for i, present := range tt.present {
+				media := []MediaType{sms}
+				if present {
+					media = append(media, email)
+					missing = 0
+				} else {
+					missing++
+				}
+				commit := newCycleCommit()
+				processVanished(context.Background(), cfg, media, state, commit, logger, nil)
+				if err := commit.Commit(logger); err != nil {
+					t.Fatal(err)
+				}
+				clock.Advance(time.Minute)
+
+				got := mm.Texts()
+				if len(got) != len(tt.want[i]) || (len(got) == 1 && !strings.HasPrefix(got[0], tt.want[i][0])) {
+					t.Errorf("цикл %d: сообщения %q, ожидалось %q", i+1, got, tt.want[i])
+				}
+				// счётчик пропусков переживает перезапуск
+				saved, err := loadVanishState(vanishStateFilename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				grace, _ := strconv.Atoi(tt.grace)
+				if want := min(missing, grace); saved["1"] == nil || saved["1"].Missing != want {
+					t.Errorf("цикл %d: сохранено %+v, ожидалось пропусков %d", i+1, saved["1"], want)
+				}
+			}
+		})
+	}
+}
+
+// TestPruneVanishedRecordsWithoutGrace: без VANISH_GRACE запись удаляется в первый же цикл
+// без медиа, а о пропаже сообщает сама очистка
+func TestPruneVanishedRecordsWithoutGrace(t *testing.T) {
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{"MM_WEBHOOK_URL": mm.URL, "MEDIA_NAMES": "Email,SMS", "VANISH_GRACE": "0"})
+	state := MediaState{
+		"1": {Name: "Email", FirstSeen: clock.Now()},
+		"2": {Name: "SMS", FirstSeen: clock.Now()},
+	}
+	failures := EnableFailures{"1": {LastNotified: clock.Now(), LastError: "timeout"}}
+
+	if pruned, _ := pruneVanishedRecords(context.Background(), cfg, []MediaType{{MediaTypeID: "2", Name: "SMS", Status: "1"}}, state, failures, nil, testLogger(t)); !pruned {
+		t.Fatal("состояние не изменилось")
+	}
+	if _, ok := state["1"]; ok {
+		t.Error("запись пропавшего медиа осталась в состоянии")
+	}
+	if _, ok := state["2"]; !ok {
+		t.Error("удалена запись медиа, которое есть в ответе")
+	}
+	if _, ok := failures["1"]; ok {
+		t.Error("ошибка включения пропавшего медиа осталась")
+	}
+	if texts := mm.Texts(); len(texts) != 1 || !strings.HasPrefix(texts[0], "Отслеживаемое медиа Email больше не существует") {
+		t.Errorf("уведомления %q, ожидалось одно о пропаже Email", texts)
+	}
+}
+
+func TestPruneVanishedRecordsWaitsForGrace(t *testing.T) {
+	clock := newFakeClock()
+	cfg := &Config{Clock: clock, VanishGrace: 2}
+	logger := testLogger(t)
+	email := MediaType{MediaTypeID: "1", Name: "Email", Status: "1"}
+	sms := MediaType{MediaTypeID: "2", Name: "SMS", Status: "0"}
+	state := MediaState{"1": {Name: "Email", FirstSeen: clock.Now()}}
+	failures := make(EnableFailures)
+	vanished := make(VanishState)
+
+	// cycle — один цикл в порядке processMediaTypes; true — запись "1" удалена
+	cycle := func(media ...MediaType) bool {
+		clock.Advance(time.Minute)
+		processVanished(context.Background(), cfg, media, vanished, newCycleCommit(), logger, nil)
+		pruneVanishedRecords(context.Background(), cfg, media, state, failures, vanished, logger)
+		_, ok := state["1"]
+		return !ok
+	}
+
+	if cycle(email, sms) {
+		t.Fatal("запись удалена, хотя медиа есть в ответе")
+	}
+	if cycle(sms) {
+		t.Fatal("запись удалена в первый же цикл без медиа")
+	}
+	if cycle(email, sms) {
+		t.Fatal("запись удалена после возвращения медиа")
+	}
+	if cycle(sms) {
+		t.Fatal("счётчик пропажи не сбросился после возвращения медиа")
+	}
+	if !cycle(sms) {
+		t.Fatal("запись не удалена после VANISH_GRACE циклов без медиа")
+	}
+	if _, ok := vanished["1"]; ok {
+		t.Error("отметка о пропаже осталась после удаления записи")
+	}
+}
+
+func TestPruneVanishedRecordsUntrackedMedia(t *testing.T) {
+	clock := newFakeClock()
+	cfg := &Config{Clock: clock, VanishGrace: 2}
+	logger := testLogger(t)
+	sms := []MediaType{{MediaTypeID: "2", Name: "SMS", Status: "0"}}
+	state := MediaState{"1": {Name: "Email", FirstSeen: clock.Now()}}
+	vanished := make(VanishState)
+
+	// медиа пропало до того, как его записал processVanished: отсчёт начинается с первого цикла
+	pruneVanishedRecords(context.Background(), cfg, sms, state, make(EnableFailures), vanished, logger)
+	if _, ok := state["1"]; !ok {
+		t.Fatal("запись удалена без отсчёта VANISH_GRACE")
+	}
+	processVanished(context.Background(), cfg, sms, vanished, newCycleCommit(), logger, nil)
+	pruneVanishedRecords(context.Background(), cfg, sms, state, make(EnableFailures), vanished, logger)
+	if _, ok := state["1"]; ok {
+		t.Fatal("запись не удалена после VANISH_GRACE циклов без медиа")
+	}
+}
+
+// TestVanishStateSavedAfterPrune: в файл пропавших медиа попадает и то, что добавила
+// или удалила очистка состояния, а не только processVanished
+func TestVanishStateSavedAfterPrune(t *testing.T) {
+	sms := MediaType{MediaTypeID: "2", Name: "SMS", Status: "0"}
+	zabbix := newFakeZabbix(t)
+	zabbix.Result("mediatype.get", []MediaType{sms})
+	cfg, clock := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "MEDIA_NAMES": "Email,SMS", "VANISH_GRACE": "2"})
+	logger := testLogger(t)
+	store := newMemoryStore()
+	state := MediaState{"1": {Name: "Email", FirstSeen: clock.Now()}}
+	vanished := make(VanishState)
+
+	// wantMissing — пропуски Email в сохранённом файле, 0 — Email в файле нет
+	for i, wantMissing := range []int{1, 0} {
+		clock.Advance(time.Minute)
+		commit := newCycleCommit()
+		processMediaTypes(context.Background(), cfg, []MediaType{sms}, nil, store, state, make(EnableFailures), vanished, commit, logger, nil)
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+		saved, err := loadVanishState(cfg.stateFile(vanishStateFilename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch entry := saved["1"]; {
+		case wantMissing == 0 && entry != nil:
+			t.Errorf("цикл %d: отметка о пропаже Email осталась в файле: %+v", i+1, entry)
+		case wantMissing > 0 && (entry == nil || entry.Missing != wantMissing):
+			t.Errorf("цикл %d: сохранено %+v, ожидалось пропусков %d", i+1, entry, wantMissing)
+		}
+	}
+	if _, ok := state["1"]; ok {
+		t.Error("запись Email не удалена после VANISH_GRACE циклов")
+	}
+}
+
+func TestEmptyMediaResultKeepsState(t *testing.T) {
+	// подсказки по ненайденным медиа запрашивают полный список — он тоже пуст
+	zabbix := newFakeZabbix(t)
+	zabbix.Result("mediatype.get", []MediaType{})
+	cfg, clock := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "MEDIA_NAMES": "Email,SMS", "VANISH_GRACE": "0"})
+	logger := testLogger(t)
+	store := newMemoryStore()
+	state := MediaState{
+		"1": {Name: "Email", FirstSeen: clock.Now()},
+		"2": {Name: "SMS", FirstSeen: clock.Now()},
+	}
+	failures := EnableFailures{"1": {LastNotified: clock.Now(), LastError: "timeout"}}
+	seed := newCycleCommit()
+	if err := store.SaveMedia(seed, state); err != nil {
+		t.Fatal(err)
+	}
+	if err := seed.Commit(logger); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, media := range [][]MediaType{nil, {}} {
+		commit := newCycleCommit()
+		processMediaTypes(context.Background(), cfg, media, nil, store, state, failures, make(VanishState), commit, logger, nil)
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+		if len(state) != 2 || len(failures) != 1 {
+			t.Fatalf("после пустого ответа API: состояние %v, ошибки включения %v", state, failures)
+		}
+	}
+	if saved, _, err := store.LoadMedia(); err != nil || len(saved) != 2 {
+		t.Errorf("сохранённое состояние %v (%v), ожидались обе записи", saved, err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Секреты из HashiCorp Vault (SECRETS_BACKEND=vault) ----------------
+// ZABBIX_API_TOKEN и MM_WEBHOOK_URL читаются из KV-секрета VAULT_SECRET_PATH (KV v1 или v2)
+// с ключами zabbix_api_token и mm_webhook_url. Авторизация — VAULT_TOKEN или Kubernetes auth
+// (VAULT_K8S_ROLE, токен service account). Секрет перечитывается по истечении аренды.
+
+const (
+	vaultKeyZabbixToken   = "zabbix_api_token"
+	vaultKeyWebhookURL    = "mm_webhook_url"
+	defaultK8sTokenPath   = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultVaultK8sMount  = "kubernetes"
+	vaultRefreshFloor     = time.Minute
+	vaultRefreshLeasePart = 2 // перечитываем, когда прошла половина аренды
+)
+
+type vaultClient struct {
+	addr       string
+	secretPath string
+	// статический токен либо параметры Kubernetes auth
+	staticToken  string
+	k8sRole      string
+	k8sMount     string
+	k8sTokenPath string
+
+	token     string
+	refreshAt time.Time
+}
+
+func newVaultClient() (*vaultClient, error) {
+	v := &vaultClient{
+		addr:         strings.TrimRight(strings.TrimSpace(os.Getenv("VAULT_ADDR")), "/"),
+		secretPath:   strings.Trim(strings.TrimSpace(os.Getenv("VAULT_SECRET_PATH")), "/"),
+		staticToken:  strings.TrimSpace(os.Getenv("VAULT_TOKEN")),
+		k8sRole:      strings.TrimSpace(os.Getenv("VAULT_K8S_ROLE")),
+		k8sMount:     envDefault("VAULT_K8S_MOUNT", defaultVaultK8sMount),
+		k8sTokenPath: envDefault("VAULT_K8S_TOKEN_PATH", defaultK8sTokenPath),
+	}
+	switch {
+	case v.addr == "":
+		return nil, fmt.Errorf("SECRETS_BACKEND=vault: не задан VAULT_ADDR")
+	case v.secretPath == "":
+		return nil, fmt.Errorf("SECRETS_BACKEND=vault: не задан VAULT_SECRET_PATH")
+	case v.staticToken == "" && v.k8sRole == "":
+		return nil, fmt.Errorf("SECRETS_BACKEND=vault: нужен VAULT_TOKEN или VAULT_K8S_ROLE")
+	}
+	return v, nil
+}
+
+type vaultResponse struct {
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func (v *vaultClient) request(ctx context.Context, cfg *Config, method, path string, in interface{}) (*vaultResponse, error) {
+	var body []byte
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = data
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	if v.token != "" {
+		header.Set("X-Vault-Token", v.token)
+	}
+	resp, err := doHTTP(ctx, cfg, method, v.addr+"/v1/"+path, header, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	var out vaultResponse
+	_ = json.Unmarshal(data, &out)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if len(out.Errors) > 0 {
+			return nil, fmt.Errorf("Vault %s: HTTP %d: %s", path, resp.StatusCode, strings.Join(out.Errors, "; "))
+		}
+		return nil, fmt.Errorf("Vault %s: HTTP %d", path, resp.StatusCode)
+	}
+	return &out, nil
+}
+
+// login получает токен Vault; возвращает срок его аренды (0 — бессрочно или неизвестно)
+func (v *vaultClient) login(ctx context.Context, cfg *Config) (time.Duration, error) {
+	if v.staticToken != "" {
+		v.token = v.staticToken
+		return 0, nil
+	}
+	jwt, err := os.ReadFile(v.k8sTokenPath)
+	if err != nil {
+		return 0, fmt.Errorf("чтение токена service account: %v", err)
+	}
+	v.token = ""
+	resp, err := v.request(ctx, cfg, http.MethodPost, "auth/"+v.k8sMount+"/login",
+		map[string]string{"role": v.k8sRole, "jwt": strings.TrimSpace(string(jwt))})
+	if err != nil {
+		return 0, err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return 0, fmt.Errorf("Vault не вернул токен при входе через Kubernetes auth")
+	}
+	v.token = resp.Auth.ClientToken
+	return time.Duration(resp.Auth.LeaseDuration) * time.Second, nil
+}
+
+// fetch читает секрет и применяет значения к конфигурации
+func (v *vaultClient) fetch(ctx context.Context, cfg *Config) error {
+	tokenLease, err := v.login(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	resp, err := v.request(ctx, cfg, http.MethodGet, v.secretPath, nil)
+	if err != nil {
+		return err
+	}
+	data := resp.Data
+	// KV v2 кладёт значения в data.data рядом с data.metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, v2 := data["metadata"]; v2 {
+			data = inner
+		}
+	}
+	apiToken, _ := data[vaultKeyZabbixToken].(string)
+	webhook, _ := data[vaultKeyWebhookURL].(string)
+	if apiToken == "" {
+		return fmt.Errorf("в секрете Vault %s нет ключа %s", v.secretPath, vaultKeyZabbixToken)
+	}
+	if webhook == "" {
+		return fmt.Errorf("в секрете Vault %s нет ключа %s", v.secretPath, vaultKeyWebhookURL)
+	}
+	setVaultSecrets(cfg, apiToken, strings.TrimSpace(webhook))
+
+	lease := time.Duration(resp.LeaseDuration) * time.Second
+	if tokenLease > 0 && (lease == 0 || tokenLease < lease) {
+		lease = tokenLease
+	}
+	v.refreshAt = time.Time{}
+	if lease > 0 {
+		wait := lease / vaultRefreshLeasePart
+		if wait < vaultRefreshFloor {
+			wait = vaultRefreshFloor
+		}
+		v.refreshAt = cfg.Clock.Now().Add(wait)
+	}
+	return nil
+}
+
+// MaybeRefresh перечитывает секрет, если подошёл срок аренды. При ошибке остаются прежние значения.
+func (v *vaultClient) MaybeRefresh(ctx context.Context, cfg *Config, logger *logrus.Logger) {
+	if v == nil || v.refreshAt.IsZero() || cfg.Clock.Now().Before(v.refreshAt) {
+		return
+	}
+	if err := v.fetch(ctx, cfg); err != nil {
+		logger.WithError(err).Error("Не удалось обновить секреты из Vault, используются прежние значения")
+		// повторим на следующем цикле
+		return
+	}
+	logger.Info("Секреты из Vault обновлены")
+}
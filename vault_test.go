@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVault — Vault с одним KV-секретом и входом через Kubernetes auth
+type fakeVault struct {
+	*httptest.Server
+	mu       sync.Mutex
+	token    string                 // токен, с которым разрешено чтение секрета
+	kv2      bool                   // отдавать секрет в формате KV v2
+	secret   map[string]interface{} // значения секрета
+	lease    int                    // lease_duration секрета, секунды
+	k8sLease int                    // срок аренды токена Kubernetes auth, секунды
+	fail     bool                   // отвечать 500 на чтение секрета
+	reads    int
+	logins   []map[string]string
+}
+
+func newFakeVault(t *testing.T, token string, secret map[string]interface{}) *fakeVault {
+	t.Helper()
+	v := &fakeVault{token: token, secret: secret}
+	v.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/kubernetes/login":
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			v.logins = append(v.logins, body)
+			if body["jwt"] != "sa-jwt" {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": v.token, "lease_duration": v.k8sLease},
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/data/zabbix":
+			v.reads++
+			if r.Header.Get("X-Vault-Token") != v.token {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			if v.fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			data := v.secret
+			if v.kv2 {
+				data = map[string]interface{}{"data": v.secret, "metadata": map[string]interface{}{"version": 3}}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"lease_duration": v.lease, "data": data})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	t.Cleanup(v.Close)
+	return v
+}
+
+func (v *fakeVault) Reads() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.reads
+}
+
+func vaultTestEnv(v *fakeVault, env map[string]string) map[string]string {
+	out := map[string]string{
+		"SECRETS_BACKEND":   "vault",
+		"VAULT_ADDR":        v.URL + "/",
+		"VAULT_SECRET_PATH": "/secret/data/zabbix",
+		"ZABBIX_API_TOKEN":  "",
+	}
+	for k, val := range env {
+		out[k] = val
+	}
+	return out
+}
+
+func TestVaultSecrets(t *testing.T) {
+	secret := map[string]interface{}{vaultKeyZabbixToken: "vault-api-token", vaultKeyWebhookURL: " https://mm.example/hooks/abc "}
+	tests := []struct {
+		name       string
+		kv2        bool
+		secret     map[string]interface{}
+		env        map[string]string
+		k8s        bool
+		wantErr    string
+		wantLogins int
+	}{
+		{name: "KV v1, статический токен", secret: secret, env: map[string]string{"VAULT_TOKEN": "root"}},
+		{name: "KV v2, статический токен", kv2: true, secret: secret, env: map[string]string{"VAULT_TOKEN": "root"}},
+		{name: "Kubernetes auth", kv2: true, secret: secret, k8s: true, env: map[string]string{"VAULT_K8S_ROLE": "watcher"}, wantLogins: 1},
+		{
+			name: "нет токена Zabbix", secret: map[string]interface{}{vaultKeyWebhookURL: "https://mm.example/hooks/abc"},
+			env: map[string]string{"VAULT_TOKEN": "root"}, wantErr: "нет ключа " + vaultKeyZabbixToken,
+		},
+		{
+			name: "нет webhook", kv2: true, secret: map[string]interface{}{vaultKeyZabbixToken: "vault-api-token"},
+			env: map[string]string{"VAULT_TOKEN": "root"}, wantErr: "нет ключа " + vaultKeyWebhookURL,
+		},
+		{name: "чужой токен", secret: secret, env: map[string]string{"VAULT_TOKEN": "guest"}, wantErr: "HTTP 403: permission denied"},
+		{name: "без авторизации", secret: secret, wantErr: "нужен VAULT_TOKEN или VAULT_K8S_ROLE"},
+		{name: "без пути", secret: secret, env: map[string]string{"VAULT_TOKEN": "root", "VAULT_SECRET_PATH": ""}, wantErr: "не задан VAULT_SECRET_PATH"},
+		{name: "несколько серверов", secret: secret, env: map[string]string{"VAULT_TOKEN": "root", "ZABBIX_SERVER_NAMES": "main"}, wantErr: "только один сервер"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault := newFakeVault(t, "root", tt.secret)
+			vault.kv2 = tt.kv2
+			env := vaultTestEnv(vault, tt.env)
+			if tt.k8s {
+				jwt := filepath.Join(t.TempDir(), "token")
+				if err := os.WriteFile(jwt, []byte("sa-jwt\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				env["VAULT_K8S_TOKEN_PATH"] = jwt
+			}
+			cfg, err := loadTestConfig(t, env)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.APIToken != "vault-api-token" || cfg.MattermostWebhook != "https://mm.example/hooks/abc" {
+				t.Errorf("токен %q, webhook %q", cfg.APIToken, cfg.MattermostWebhook)
+			}
+			if len(vault.logins) != tt.wantLogins {
+				t.Errorf("входов %d, ожидалось %d", len(vault.logins), tt.wantLogins)
+			}
+			if tt.k8s && vault.logins[0]["role"] != "watcher" {
+				t.Errorf("вход %v", vault.logins[0])
+			}
+		})
+	}
+}
+
+func TestVaultRefreshOnLease(t *testing.T) {
+	vault := newFakeVault(t, "root", map[string]interface{}{vaultKeyZabbixToken: "token-1", vaultKeyWebhookURL: "https://mm.example/1"})
+	vault.lease = 600
+	cfg, clock := newTestConfig(t, vaultTestEnv(vault, map[string]string{"VAULT_TOKEN": "root"}))
+	logger := testLogger(t)
+	// срок аренды отсчитывается по часам теста
+	if err := cfg.vault.fetch(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	reads := vault.Reads()
+
+	vault.mu.Lock()
+	vault.secret = map[string]interface{}{vaultKeyZabbixToken: "token-2", vaultKeyWebhookURL: "https://mm.example/2"}
+	vault.mu.Unlock()
+
+	steps := []struct {
+		advance   time.Duration
+		fail      bool
+		wantReads int
+		wantToken string
+	}{
+		// перечитываем, когда прошла половина аренды
+		{advance: 4 * time.Minute, wantReads: 0, wantToken: "token-1"},
+		{advance: time.Minute, wantReads: 1, wantToken: "token-2"},
+		{advance: time.Minute, wantReads: 0, wantToken: "token-2"},
+		// при ошибке остаются прежние значения, повтор — на следующем цикле
+		{advance: 5 * time.Minute, fail: true, wantReads: 1, wantToken: "token-2"},
+		{advance: time.Second, fail: true, wantReads: 1, wantToken: "token-2"},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		vault.mu.Lock()
+		vault.fail = step.fail
+		vault.mu.Unlock()
+		cfg.vault.MaybeRefresh(context.Background(), cfg, logger)
+		got := vault.Reads()
+		if got-reads != step.wantReads {
+			t.Errorf("шаг %d: чтений %d, ожидалось %d", i+1, got-reads, step.wantReads)
+		}
+		reads = got
+		if cfg.APIToken != step.wantToken {
+			t.Errorf("шаг %d: токен %q, ожидался %q", i+1, cfg.APIToken, step.wantToken)
+		}
+	}
+}
+
+func TestVaultRefreshUsesShorterTokenLease(t *testing.T) {
+	vault := newFakeVault(t, "root", map[string]interface{}{vaultKeyZabbixToken: "token-1", vaultKeyWebhookURL: "https://mm.example/1"})
+	vault.lease = 3600
+	vault.k8sLease = 60
+	jwt := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(jwt, []byte("sa-jwt"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, clock := newTestConfig(t, vaultTestEnv(vault, map[string]string{"VAULT_K8S_ROLE": "watcher", "VAULT_K8S_TOKEN_PATH": jwt}))
+	if err := cfg.vault.fetch(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	// половина аренды токена меньше минуты — перечитываем не чаще раза в минуту
+	if want := clock.Now().Add(vaultRefreshFloor); !cfg.vault.refreshAt.Equal(want) {
+		t.Errorf("следующее чтение %v, ожидалось %v", cfg.vault.refreshAt, want)
+	}
+}
+
+// TestVaultRefreshConcurrentReads: обновление секретов идёт параллельно с запросами и уведомлениями
+// других воркеров; под -race видно чтение токена или webhook мимо мьютекса
+func TestVaultRefreshConcurrentReads(t *testing.T) {
+	vault := newFakeVault(t, "root", map[string]interface{}{vaultKeyZabbixToken: "token-1", vaultKeyWebhookURL: "https://mm.example/1"})
+	cfg, _ := newTestConfig(t, vaultTestEnv(vault, map[string]string{"VAULT_TOKEN": "root"}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = zabbixSession(cfg)
+			_ = mattermostWebhook(cfg)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if err := cfg.vault.fetch(context.Background(), cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	if token, webhook := zabbixSession(cfg), mattermostWebhook(cfg); token != "token-1" || webhook != "https://mm.example/1" {
+		t.Errorf("токен %q, webhook %q", token, webhook)
+	}
+}
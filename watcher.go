@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Проверки одного сервера Zabbix ----------------
+// watcher хранит состояние проверок одного сервера между циклами. С несколькими серверами
+// (ZABBIX_API_URL списком) главный цикл по очереди вызывает runCycle у каждого.
+
+type watcher struct {
+	cfg     *Config
+	logger  *logrus.Logger
+	sysLogs *syslogRouter
+	store   StateStore
+
+	state                 MediaState
+	actionState           MediaState
+	actionFailures        EnableFailures
+	groupState            GroupState
+	groupStateExisted     bool
+	templateState         TemplateState
+	templateStateExisted  bool
+	mediaFields           MediaFieldsState
+	mediaFieldsExisted    bool
+	userState             UserState
+	userStateExisted      bool
+	userMediaState        UserMediaState
+	userMediaStateExisted bool
+	scheduleState         ScheduleState
+	vanishState           VanishState
+	report                *groupReport
+	audit                 *auditSummary
+
+	saveWatch        *persistWatch
+	api              apiWatch
+	beat             *heartbeat
+	commit           *cycleCommit
+	failures         EnableFailures
+	maintenanceWatch MaintenanceWatch
+	mediaConfig      mediaConfigWatch
+}
+
+// newWatcher загружает сохранённое состояние сервера
+func newWatcher(cfg *Config, store StateStore, logger *logrus.Logger, sysLogs *syslogRouter) *watcher {
+	w := &watcher{
+		cfg:              cfg,
+		logger:           logger,
+		sysLogs:          sysLogs,
+		store:            store,
+		saveWatch:        &persistWatch{},
+		beat:             &heartbeat{},
+		commit:           newCycleCommit(),
+		failures:         make(EnableFailures),
+		actionFailures:   make(EnableFailures),
+		maintenanceWatch: make(MaintenanceWatch),
+		mediaConfig:      make(mediaConfigWatch),
+	}
+	var err error
+
+	var migrated bool
+	w.state, migrated, err = store.LoadMedia()
+	if err != nil {
+		logger.Warnf("Ошибка загрузки состояния: %v", err)
+		w.state = make(MediaState)
+	} else {
+		logger.Infof("Состояние загружено: %d записей", len(w.state))
+	}
+	if migrated {
+		// файл будет переписан в новом формате в конце первого цикла
+		logger.Info("Файл состояния медиа в прежнем формате — переведён в записи с историей уведомлений")
+		if err := store.SaveMedia(w.commit, w.state); err != nil {
+			logger.Warnf("Ошибка подготовки состояния к сохранению: %v", err)
+		}
+	}
+
+	if len(cfg.ActionNames) > 0 {
+		if w.actionState, _, err = loadState(cfg.stateFile(actionStateFilename)); err != nil {
+			logger.Warnf("Ошибка загрузки состояния действий: %v", err)
+			w.actionState = make(MediaState)
+		}
+	}
+
+	w.groupState, w.groupStateExisted, err = store.LoadGroups()
+	if err != nil {
+		logger.Warnf("Ошибка загрузки состояния групп: %v", err)
+		w.groupState = make(GroupState)
+		w.groupStateExisted = false
+	} else {
+		if w.groupStateExisted {
+			logger.Infof("Состояние групп загружено: %d записей", len(w.groupState))
+		} else {
+			logger.Infof("Файл состояния групп не найден — при первой проверке будет создан baseline (уведомлений не будет)")
+		}
+	}
+
+	w.templateState, w.templateStateExisted, err = loadTemplateState(cfg.stateFile(templateStateFilename))
+	if err != nil {
+		logger.Warnf("Ошибка загрузки состояния шаблонов сообщений: %v", err)
+		w.templateState = make(TemplateState)
+		w.templateStateExisted = false
+	}
+
+	if len(cfg.MediaWatchFields) > 0 {
+		w.mediaFields, w.mediaFieldsExisted, err = loadMediaFieldsState(cfg.stateFile(mediaFieldsStateFilename))
+		if err != nil {
+			logger.Warnf("Ошибка загрузки состояния полей медиа: %v", err)
+			w.mediaFields = make(MediaFieldsState)
+			w.mediaFieldsExisted = false
+		}
+	}
+
+	if cfg.MonitorUsers {
+		w.userState, w.userStateExisted, err = loadUserState(cfg.stateFile(userStateFilename))
+		if err != nil {
+			logger.Warnf("Ошибка загрузки состояния пользователей: %v", err)
+			w.userState = make(UserState)
+			w.userStateExisted = false
+		} else if !w.userStateExisted {
+			logger.Infof("Файл состояния пользователей не найден — при первой проверке будет создан baseline (уведомлений не будет)")
+		}
+	}
+
+	if cfg.MonitorUserMedia {
+		w.userMediaState, w.userMediaStateExisted, err = loadUserMediaState(cfg.stateFile(userMediaStateFilename))
+		if err != nil {
+			logger.Warnf("Ошибка загрузки состояния медиа пользователей: %v", err)
+			w.userMediaState = make(UserMediaState)
+			w.userMediaStateExisted = false
+		} else if !w.userMediaStateExisted {
+			logger.Infof("Файл состояния медиа пользователей не найден — при первой проверке будет создан baseline (уведомлений не будет)")
+		}
+	}
+
+	if cfg.GroupReportFile != "" {
+		w.report, err = loadGroupReport(cfg.GroupReportFile, cfg.GroupReportRetention)
+		if err != nil {
+			logger.Warnf("Ошибка загрузки отчёта по группам: %v", err)
+		}
+		if cfg.HTTPAddr != "" {
+			path := "/report/groups"
+			if cfg.ServerName != "" {
+				path += "/" + cfg.ServerName
+			}
+			handleHTTP(cfg.HTTPAddr, path, w.report.ServeHTTP)
+		}
+	}
+
+	if len(cfg.DisableSchedule) > 0 {
+		if w.scheduleState, err = loadScheduleState(cfg.stateFile(scheduleStateFilename)); err != nil {
+			logger.Warnf("Ошибка загрузки состояния расписания: %v", err)
+			w.scheduleState = make(ScheduleState)
+		}
+	}
+
+	if cfg.VanishGrace > 0 {
+		if w.vanishState, err = loadVanishState(cfg.stateFile(vanishStateFilename)); err != nil {
+			logger.Warnf("Ошибка загрузки состояния пропавших медиа: %v", err)
+			w.vanishState = make(VanishState)
+		}
+	}
+
+	if err := cfg.throttle.load(cfg.stateFile(throttleStateFilename)); err != nil {
+		logger.Warnf("Ошибка загрузки времени последних уведомлений: %v", err)
+	}
+
+	if cfg.AuditSummaryInterval > 0 {
+		if w.audit, err = loadAuditSummary(cfg.stateFile(auditStateFilename)); err != nil {
+			logger.Warnf("Ошибка загрузки границы сводки изменений: %v", err)
+		}
+	}
+	return w
+}
+
+// runCycle выполняет все проверки сервера за один цикл и сохраняет состояние.
+// Возвращает ошибку, если медиа, группы или действия не получены из-за недоступности API Zabbix
+// (сетевая ошибка, таймаут, HTTP 5xx).
+func (w *watcher) runCycle(ctx context.Context) error {
+	cfg, logger, sysLogs := w.cfg, w.logger, w.sysLogs
+
+	if len(cfg.DisableSchedule) > 0 {
+		processDisableSchedule(ctx, cfg, w.scheduleState, w.commit, logger, sysLogs.For(syslogMedia))
+	}
+	// медиа и группы запрашиваются одним пакетом JSON-RPC
+	fetched := fetchMediaAndGroups(ctx, cfg, logger)
+	mediaResult := processMediaTypes(ctx, cfg, fetched.MediaTypes, fetched.MediaErr, w.store, w.state, w.failures, w.vanishState, w.commit, logger, sysLogs.For(syslogMedia))
+	mediaTypes := mediaResult.MediaTypes
+	var actionErr error
+	if len(cfg.ActionNames) > 0 {
+		actionErr = processActions(ctx, cfg, w.actionState, w.actionFailures, w.commit, logger, sysLogs.For(syslogMedia))
+	}
+	cfg.desired.Reconcile(ctx, cfg, logger, sysLogs.For(syslogMedia))
+	if cfg.ValidateEnabledMedia && mediaTypes != nil {
+		w.mediaConfig.Check(ctx, cfg, mediaTypes, logger, sysLogs.For(syslogMedia))
+	}
+	if cfg.WatchMessageTemplates && mediaTypes != nil {
+		processMessageTemplates(ctx, cfg, mediaTypes, w.templateState, w.commit, logger, sysLogs.For(syslogMedia), !w.templateStateExisted)
+		w.templateStateExisted = true
+	}
+	if len(cfg.MediaWatchFields) > 0 && mediaTypes != nil {
+		processMediaFields(ctx, cfg, mediaTypes, w.mediaFields, w.commit, logger, sysLogs.For(syslogMedia), !w.mediaFieldsExisted)
+		w.mediaFieldsExisted = true
+	}
+
+	baselineMode := !w.groupStateExisted
+	groupResult := processUserGroups(ctx, cfg, fetched.Groups, fetched.GroupErr, w.store, w.groupState, w.report, w.commit, logger, sysLogs.For(syslogGroups), baselineMode)
+
+	if cfg.MaintenanceMaxDuration > 0 || cfg.MaintenanceAutoCleanup {
+		processMaintenances(ctx, cfg, w.maintenanceWatch, logger, sysLogs.For(syslogMaintenance))
+	}
+
+	if cfg.MonitorUsers {
+		if processUsers(ctx, cfg, w.userState, w.commit, logger, sysLogs.For(syslogUsers), !w.userStateExisted) {
+			w.userStateExisted = true
+		}
+	}
+
+	if cfg.MonitorUserMedia {
+		if processUserMedias(ctx, cfg, w.userMediaState, mediaTypes, w.commit, logger, sysLogs.For(syslogUsers), !w.userMediaStateExisted) {
+			w.userMediaStateExisted = true
+		}
+	}
+
+	if w.audit != nil {
+		w.audit.MaybeSend(ctx, cfg, w.report, w.commit, logger)
+	}
+
+	if sent, changed := cfg.throttle.snapshot(cfg.NotifyCooldowns, cfg.Clock.Now()); changed {
+		if data, err := marshalState(sent, cfg.StateCompact); err == nil {
+			w.commit.Stage(cfg.stateFile(throttleStateFilename), data)
+		}
+	}
+
+	err := w.commit.Commit(logger)
+	if err != nil {
+		logger.Errorf("Ошибка сохранения состояния: %v", err)
+	}
+	w.saveWatch.Track(ctx, cfg, err, logger, sysLogs.For(syslogState))
+	w.beat.MaybeSend(ctx, cfg, w.state, logger)
+
+	if baselineMode {
+		w.groupStateExisted = true
+	}
+
+	summary := logger.WithFields(logrus.Fields{
+		"media_checked": mediaResult.Checked,
+		"disabled":      mediaResult.Disabled,
+		"auto_enabled":  mediaResult.AutoEnabled,
+		"enable_failed": mediaResult.EnableFailed,
+		"group_changes": len(groupResult.Changes),
+		"errors":        len(mediaResult.Errors) + len(groupResult.Errors),
+	})
+	if cfg.RunOnce {
+		summary.Info("Проверка завершена")
+	} else {
+		next := cfg.schedule.Next()
+		summary.WithField("next_run", next.Format(time.RFC3339)).Infof("Ожидание следующей проверки через %v", time.Until(next).Round(time.Second))
+	}
+
+	// ошибки JSON-RPC и HTTP 4xx уже учтены в итоге цикла: API ответил, значит, он доступен
+	var fetchErr error
+	switch {
+	case zabbixUnreachable(mediaResult.FetchError):
+		fetchErr = fmt.Errorf("получение медиа-типов: %w", mediaResult.FetchError)
+	case zabbixUnreachable(groupResult.FetchError):
+		fetchErr = fmt.Errorf("получение групп: %w", groupResult.FetchError)
+	case zabbixUnreachable(actionErr):
+		fetchErr = fmt.Errorf("получение действий: %w", actionErr)
+	}
+	w.api.Track(ctx, cfg, fetchErr, logger, sysLogs.For(syslogMedia))
+	return fetchErr
+}
+
+// serverHook добавляет имя сервера в каждую запись лога
+type serverHook struct{ name string }
+
+func (h serverHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h serverHook) Fire(e *logrus.Entry) error {
+	e.Data["server"] = h.name
+	return nil
+}
+
+// serverLogger — логгер сервера: тот же вывод и формат, плюс поле server. Для единственного
+// сервера без имени возвращается общий логгер.
+func serverLogger(base *logrus.Logger, name string) *logrus.Logger {
+	if name == "" {
+		return base
+	}
+	l := logrus.New()
+	l.SetOutput(base.Out)
+	l.SetFormatter(base.Formatter)
+	l.SetLevel(base.GetLevel())
+	l.AddHook(serverHook{name: name})
+	return l
+}
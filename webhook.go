@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Произвольный webhook (GENERIC_WEBHOOK_URL) ----------------
+// Тело запроса собирается шаблоном text/template из GENERIC_WEBHOOK_TEMPLATE по данным
+// события (webhookEvent) и отправляется POST с заголовком GENERIC_WEBHOOK_CONTENT_TYPE.
+// Доставка идёт через общую очередь с повторами, как у CloudEvents.
+
+const defaultWebhookContentType = "application/json"
+
+// defaultWebhookTemplate используется, когда GENERIC_WEBHOOK_TEMPLATE не задан
+const defaultWebhookTemplate = `{"type":{{json .Type}},"status":{{json .Status}},"action":{{json .Action}},` +
+	`"media_id":{{json .MediaID}},"media_name":{{json .MediaName}},"disabled_for_seconds":{{.DisabledForSeconds}},` +
+	`"message":{{json .Message}},"server":{{json .Server}},"time":{{json .Time}}}`
+
+// Статус события в webhookEvent.Status
+const (
+	webhookStatusProblem = "problem"
+	webhookStatusOK      = "ok"
+)
+
+// webhookOKEvents — события о восстановлении; остальные считаются проблемой
+var webhookOKEvents = map[string]bool{
+	EventMediaAutoEnabled:   true,
+	EventMediaRestored:      true,
+	EventMediaConfigFixed:   true,
+	EventMediaReconciled:    true,
+	EventMediaReappeared:    true,
+	EventMediaScheduledOn:   true,
+	EventStatePersistOK:     true,
+	EventMaintenanceDeleted: true,
+	EventActionAutoEnabled:  true,
+	EventActionRestored:     true,
+	EventZabbixRecovered:    true,
+}
+
+// webhookActions — что сделал вотчер по событию (пусто — только уведомил)
+var webhookActions = map[string]string{
+	EventMediaAutoEnabled:     "enabled",
+	EventMediaEnableFailed:    "enable_failed",
+	EventMediaReconciled:      "reconciled",
+	EventMediaReconcileFailed: "reconcile_failed",
+	EventMediaScheduledOff:    "disabled",
+	EventMediaScheduledOn:     "enabled",
+	EventMaintenanceDeleted:   "deleted",
+	EventActionAutoEnabled:    "enabled",
+	EventActionEnableFailed:   "enable_failed",
+}
+
+// webhookEvent — данные, доступные в GENERIC_WEBHOOK_TEMPLATE
+type webhookEvent struct {
+	Type               string
+	Status             string
+	Action             string
+	Message            string
+	MediaID            string
+	MediaName          string
+	DisabledFor        time.Duration
+	DisabledForSeconds float64
+	Threshold          time.Duration
+	ThresholdSeconds   float64
+	Error              string
+	Server             string
+	Environment        string
+	// Время события в RFC 3339 (UTC)
+	Time   string
+	DryRun bool
+}
+
+// webhookFuncs — функции шаблона: json экранирует значение для вставки в JSON
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// parseWebhookTemplate разбирает GENERIC_WEBHOOK_TEMPLATE; пустая строка — шаблон по умолчанию
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = defaultWebhookTemplate
+	}
+	return template.New("webhook").Funcs(webhookFuncs).Parse(text)
+}
+
+func buildWebhookEvent(cfg *Config, ev Event) webhookEvent {
+	status := webhookStatusProblem
+	if webhookOKEvents[ev.Type] {
+		status = webhookStatusOK
+	}
+	return webhookEvent{
+		Type:               ev.Type,
+		Status:             status,
+		Action:             webhookActions[ev.Type],
+		Message:            ev.Message,
+		MediaID:            ev.MediaID,
+		MediaName:          ev.MediaName,
+		DisabledFor:        ev.DisabledFor,
+		DisabledForSeconds: ev.DisabledFor.Seconds(),
+		Threshold:          ev.Threshold,
+		ThresholdSeconds:   ev.Threshold.Seconds(),
+		Error:              ev.Error,
+		Server:             cfg.ServerName,
+		Environment:        cfg.Environment,
+		Time:               ev.Time.UTC().Format(time.RFC3339),
+		DryRun:             cfg.DryRun || cfg.Simulate,
+	}
+}
+
+func sendGenericWebhook(ctx context.Context, cfg *Config, ev Event, logger *logrus.Logger) {
+	var buf bytes.Buffer
+	if err := cfg.webhookTemplate.Execute(&buf, buildWebhookEvent(cfg, ev)); err != nil {
+		logger.WithError(err).WithField("event", ev.Type).Error("Ошибка формирования тела webhook по GENERIC_WEBHOOK_TEMPLATE")
+		return
+	}
+	if _, err := cfg.delivery.Deliver(ctx, cfg, deliveryWebhook, ev.Type, buf.Bytes(), logger); err != nil && !errors.Is(err, errSpooled) {
+		logger.WithError(err).WithField("event", ev.Type).Error("Ошибка отправки уведомления на GENERIC_WEBHOOK_URL")
+	}
+}
+
+// checkWebhookTemplate пробно выполняет шаблон на примере события, чтобы ошибки в именах
+// полей обнаруживались при запуске, а не при первом уведомлении
+func checkWebhookTemplate(tmpl *template.Template) error {
+	sample := webhookEvent{Type: EventMediaDisabled, Status: webhookStatusProblem, Time: time.Now().UTC().Format(time.RFC3339)}
+	if err := tmpl.Execute(&bytes.Buffer{}, sample); err != nil {
+		return fmt.Errorf("GENERIC_WEBHOOK_TEMPLATE: %v", err)
+	}
+	return nil
+}
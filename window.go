@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// clockWindow — ежедневное окно времени "ЧЧ:ММ-ЧЧ:ММ" в заданном часовом поясе; может переходить через полночь
+type clockWindow struct {
+	start, end int // минуты от начала суток
+	loc        *time.Location
+}
+
+func parseClockWindow(spec string, loc *time.Location) (clockWindow, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return clockWindow{}, fmt.Errorf("неверное окно %q (ожидается ЧЧ:ММ-ЧЧ:ММ)", spec)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return clockWindow{}, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return clockWindow{}, err
+	}
+	if start == end {
+		return clockWindow{}, fmt.Errorf("окно %q: начало и конец совпадают", spec)
+	}
+	return clockWindow{start: start, end: end, loc: loc}, nil
+}
+
+// parseClock переводит "ЧЧ:ММ" в минуты от начала суток
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("время %q: ожидается ЧЧ:ММ", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// loadLocation — часовой пояс по имени, пустое имя — локальный
+func loadLocation(tz string) (*time.Location, error) {
+	if tz = strings.TrimSpace(tz); tz == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(tz)
+}
+
+// Contains — попадает ли момент в окно
+func (w clockWindow) Contains(t time.Time) bool {
+	local := t.In(w.loc)
+	m := local.Hour()*60 + local.Minute()
+	if w.start < w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Общий помощник JSON-RPC Zabbix ----------------
+
+type zabbixError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// Обычно строка, но некоторые методы отдают массив строк (например, ошибки валидации)
+	Data json.RawMessage `json:"data"`
+}
+
+func (e *zabbixError) Error() string {
+	return fmt.Sprintf("ошибка API (%d): %s - %s", e.Code, e.Message, e.DataText())
+}
+
+// DataText возвращает error.data текстом: строку как есть, массив — через "; ",
+// прочие значения — исходным JSON
+func (e *zabbixError) DataText() string {
+	if len(e.Data) == 0 || string(e.Data) == "null" {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(e.Data, &s); err == nil {
+		return s
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(e.Data, &items); err == nil {
+		parts := make([]string, 0, len(items))
+		for _, it := range items {
+			if err := json.Unmarshal(it, &s); err == nil {
+				parts = append(parts, s)
+			} else {
+				parts = append(parts, string(it))
+			}
+		}
+		return strings.Join(parts, "; ")
+	}
+	return string(e.Data)
+}
+
+// Способ передачи токена API (ZABBIX_AUTH_MODE). Zabbix 6.4+ принимает токен в заголовке
+// Authorization: Bearer, а поле auth считает устаревшим и в 7.2 перестал его принимать.
+const (
+	zabbixAuthField  = "field"
+	zabbixAuthHeader = "header"
+)
+
+// takeAuth возвращает токен для заголовка и убирает его из запроса, если выбран ZABBIX_AUTH_MODE=header
+func takeAuth(cfg *Config, req *ZabbixRequest) string {
+	if cfg.ZabbixAuthMode != zabbixAuthHeader {
+		return ""
+	}
+	token := req.Auth
+	req.Auth = ""
+	return token
+}
+
+// zabbixRetryBackoff — пауза перед первым повтором запроса к API, дальше удваивается
+const zabbixRetryBackoff = time.Second
+
+// doZabbixRequest отправляет JSON-RPC запрос в Zabbix и возвращает тело ответа.
+// Все обращения к API идут через него, чтобы соблюдать общий лимит API_RATE.
+// Сетевые ошибки и ответы 5xx и 429 повторяются до ZABBIX_MAX_RETRIES раз с экспоненциальной паузой;
+// прочие 4xx и ошибки JSON-RPC (приходят с кодом 200) не повторяются.
+func doZabbixRequest(ctx context.Context, cfg *Config, req ZabbixRequest, logger *logrus.Logger) ([]byte, error) {
+	token := takeAuth(cfg, &req)
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return sendWithRetries(ctx, cfg, req.Method, token, jsonData, logger)
+}
+
+func sendWithRetries(ctx context.Context, cfg *Config, method, token string, jsonData []byte, logger *logrus.Logger) ([]byte, error) {
+	delay := zabbixRetryBackoff
+	for attempt := 0; ; attempt++ {
+		body, err := sendZabbixRequest(ctx, cfg, method, token, jsonData, logger)
+		var perm permanentError
+		if err == nil || errors.As(err, &perm) || attempt >= cfg.ZabbixMaxRetries || ctx.Err() != nil {
+			return body, err
+		}
+		logger.WithError(err).WithFields(logrus.Fields{"method": method, "attempt": attempt + 1, "delay": delay.String()}).Warn("Запрос к Zabbix API не удался, повтор")
+		if sleepContext(ctx, delay) != nil {
+			return nil, err
+		}
+		delay *= 2
+	}
+}
+
+// zabbixServerError — ответ HTTP 5xx или 429: API (или прокси перед ним) сейчас не обслуживает запросы
+type zabbixServerError struct{ err error }
+
+func (e zabbixServerError) Error() string { return e.err.Error() }
+
+func sendZabbixRequest(ctx context.Context, cfg *Config, method, token string, jsonData []byte, logger *logrus.Logger) ([]byte, error) {
+	wait, err := cfg.apiLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		logger.WithField("method", method).Debugf("Лимит API_RATE: запрос отложен на %v", wait)
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := doHTTPWith(ctx, cfg, cfg.zabbixHTTPClient, http.MethodPost, cfg.ZabbixAPIURL+"/api_jsonrpc.php", header, jsonData)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		err := fmt.Errorf("HTTP %d от Zabbix API: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, permanentError{err}
+		}
+		return nil, zabbixServerError{err}
+	}
+	return body, nil
+}
+
+// callZabbix выполняет запрос и раскладывает result в out. Ошибка JSON-RPC возвращается как *zabbixError.
+// При входе по логину и паролю сессия открывается перед первым запросом и обновляется, если истекла.
+func callZabbix(ctx context.Context, cfg *Config, req ZabbixRequest, out interface{}, logger *logrus.Logger) error {
+	if !cfg.usesZabbixLogin() {
+		return callZabbixOnce(ctx, cfg, req, out, logger)
+	}
+	if zabbixSession(cfg) == "" {
+		if err := zabbixLogin(ctx, cfg, logger); err != nil {
+			return fmt.Errorf("вход в Zabbix API: %w", err)
+		}
+	}
+	// запрос собран с токеном на момент вызова, подставляем текущую сессию
+	req.Auth = zabbixSession(cfg)
+	err := callZabbixOnce(ctx, cfg, req, out, logger)
+	if !isSessionError(err) {
+		return err
+	}
+	logger.WithError(err).WithField("method", req.Method).Warn("Сессия Zabbix API истекла — повторный вход")
+	if lerr := zabbixLogin(ctx, cfg, logger); lerr != nil {
+		return fmt.Errorf("%w; повторный вход: %v", err, lerr)
+	}
+	req.Auth = zabbixSession(cfg)
+	return callZabbixOnce(ctx, cfg, req, out, logger)
+}
+
+func callZabbixOnce(ctx context.Context, cfg *Config, req ZabbixRequest, out interface{}, logger *logrus.Logger) error {
+	body, err := doZabbixRequest(ctx, cfg, req, logger)
+	if err != nil {
+		return err
+	}
+	return decodeZabbixResponse(body, out)
+}
+
+// decodeZabbixResponse раскладывает result одного ответа JSON-RPC в out
+func decodeZabbixResponse(body []byte, out interface{}) error {
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  zabbixError     `json:"error"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+	if response.Error.Code != 0 {
+		return &response.Error
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Result, out)
+}
+
+// errBatchUnsupported — на массив запросов Zabbix ответил одной ошибкой JSON-RPC вместо массива ответов
+var errBatchUnsupported = errors.New("Zabbix API не поддерживает пакетные запросы")
+
+// doBatch отправляет несколько запросов JSON-RPC одним POST и возвращает ответы в порядке reqs,
+// сопоставляя их по id; id запросов в пакете должны различаться
+func doBatch(ctx context.Context, cfg *Config, reqs []ZabbixRequest, logger *logrus.Logger) ([]json.RawMessage, error) {
+	methods := make([]string, 0, len(reqs))
+	index := make(map[int]int, len(reqs))
+	for i, r := range reqs {
+		if _, dup := index[r.ID]; dup {
+			return nil, fmt.Errorf("повторяющийся id %d в пакете запросов", r.ID)
+		}
+		index[r.ID] = i
+		methods = append(methods, r.Method)
+	}
+	// у всех запросов пакета один токен, в заголовке он передаётся один раз
+	var token string
+	if cfg.ZabbixAuthMode == zabbixAuthHeader {
+		reqs = append([]ZabbixRequest(nil), reqs...)
+		for i := range reqs {
+			if t := takeAuth(cfg, &reqs[i]); t != "" {
+				token = t
+			}
+		}
+	}
+	jsonData, err := json.Marshal(reqs)
+	if err != nil {
+		return nil, err
+	}
+	body, err := sendWithRetries(ctx, cfg, strings.Join(methods, "+"), token, jsonData, logger)
+	if err != nil {
+		return nil, err
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		// сервер без поддержки пакетов отвечает одной ошибкой JSON-RPC; всё прочее (обрезанный
+		// ответ, страница ошибки прокси) — обычный сбой запроса, после которого пакеты не отключаются
+		var single struct {
+			JSONRPC string       `json:"jsonrpc"`
+			Error   *zabbixError `json:"error"`
+		}
+		if json.Unmarshal(body, &single) == nil && single.JSONRPC != "" && single.Error != nil && single.Error.Code != 0 {
+			return nil, fmt.Errorf("%w: %v", errBatchUnsupported, single.Error)
+		}
+		return nil, fmt.Errorf("неверный ответ на пакетный запрос: %v", err)
+	}
+	out := make([]json.RawMessage, len(reqs))
+	for _, item := range items {
+		var head struct {
+			ID int `json:"id"`
+		}
+		if err := json.Unmarshal(item, &head); err != nil {
+			return nil, err
+		}
+		if i, ok := index[head.ID]; ok {
+			out[i] = item
+		}
+	}
+	for i, item := range out {
+		if item == nil {
+			return nil, fmt.Errorf("нет ответа на %s (id %d) в пакете", reqs[i].Method, reqs[i].ID)
+		}
+	}
+	return out, nil
+}
+
+// callZabbixBatch выполняет запросы одним пакетом и раскладывает результаты в outs.
+// Возвращает ошибку по каждому запросу; при истёкшей сессии пакет повторяется после входа.
+// Если сервер ответил на пакет ошибкой JSON-RPC, запросы до перезапуска идут по отдельности.
+func callZabbixBatch(ctx context.Context, cfg *Config, reqs []ZabbixRequest, outs []interface{}, logger *logrus.Logger) []error {
+	errs := make([]error, len(reqs))
+	if cfg.zabbixNoBatch {
+		for i, r := range reqs {
+			errs[i] = callZabbix(ctx, cfg, r, outs[i], logger)
+		}
+		return errs
+	}
+	if cfg.usesZabbixLogin() && zabbixSession(cfg) == "" {
+		if err := zabbixLogin(ctx, cfg, logger); err != nil {
+			err = fmt.Errorf("вход в Zabbix API: %w", err)
+			for i := range errs {
+				errs[i] = err
+			}
+			return errs
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		token := zabbixSession(cfg)
+		for i := range reqs {
+			reqs[i].Auth = token
+		}
+		items, err := doBatch(ctx, cfg, reqs, logger)
+		if errors.Is(err, errBatchUnsupported) {
+			logger.WithError(err).Warn("Zabbix API не принял пакетный запрос — запросы отправляются по отдельности")
+			cfg.zabbixNoBatch = true
+			return callZabbixBatch(ctx, cfg, reqs, outs, logger)
+		}
+		sessionExpired := false
+		for i := range reqs {
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			errs[i] = decodeZabbixResponse(items[i], outs[i])
+			if isSessionError(errs[i]) {
+				sessionExpired = true
+			}
+		}
+		if !sessionExpired || !cfg.usesZabbixLogin() || attempt > 0 {
+			return errs
+		}
+		logger.Warn("Сессия Zabbix API истекла — повторный вход")
+		if lerr := zabbixLogin(ctx, cfg, logger); lerr != nil {
+			return errs
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestZabbixErrorDataText(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "нет data"},
+		{name: "null", data: `null`},
+		{name: "строка", data: `"No permissions to referred object or it does not exist!"`, want: "No permissions to referred object or it does not exist!"},
+		{name: "массив строк", data: `["Invalid parameter \"/1/status\": value must be one of 0, 1.","Incorrect value for field \"name\"."]`,
+			want: `Invalid parameter "/1/status": value must be one of 0, 1.; Incorrect value for field "name".`},
+		{name: "массив со значениями других типов", data: `["bad",42,{"k":"v"}]`, want: `bad; 42; {"k":"v"}`},
+		{name: "объект", data: `{"field":"status"}`, want: `{"field":"status"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &zabbixError{Code: -32602, Message: "Invalid params.", Data: json.RawMessage(tt.data)}
+			if got := e.DataText(); got != tt.want {
+				t.Errorf("DataText() = %q, ожидалось %q", got, tt.want)
+			}
+			if want := "ошибка API (-32602): Invalid params. - " + tt.want; e.Error() != want {
+				t.Errorf("Error() = %q, ожидалось %q", e.Error(), want)
+			}
+		})
+	}
+}
+
+func TestZabbixErrorDataShapesInResponses(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "строка", data: `"No permissions to referred object or it does not exist!"`, want: "No permissions to referred object or it does not exist!"},
+		{name: "массив", data: `["Invalid parameter \"/1\": unexpected parameter \"x\".","Check the API version."]`,
+			want: `Invalid parameter "/1": unexpected parameter "x".; Check the API version.`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			apiErr := func(json.RawMessage) (interface{}, *fakeError) {
+				return nil, &fakeError{Code: -32602, Message: "Invalid params.", Data: json.RawMessage(tt.data)}
+			}
+			zabbix.Handle("mediatype.get", apiErr)
+			zabbix.Handle("mediatype.update", apiErr)
+			cfg, clock := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL})
+			logger := testLogger(t)
+
+			// ответ с ошибкой разбирается целиком, и текст data попадает в возвращаемую ошибку
+			if _, err := getMediaTypes(context.Background(), cfg, logger); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("mediatype.get: ошибка %v, ожидалась с %q", err, tt.want)
+			}
+			err := enableMediaType(context.Background(), cfg, MediaType{MediaTypeID: "1", Name: "Email", Status: "1"}, clock.Now(), logger)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("mediatype.update: ошибка %v, ожидалась с %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnableMediaTypeConfirmsID(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  string
+		wantErr string
+	}{
+		{name: "подтверждено", result: `{"mediatypeids":["1"]}`},
+		{name: "пустой ответ", result: `{"mediatypeids":[]}`, wantErr: "не затронул медиа Email (id 1): mediatypeids=[]"},
+		{name: "без поля", result: `{}`, wantErr: "не затронул медиа Email (id 1)"},
+		{name: "чужой id", result: `{"mediatypeids":["2"]}`, wantErr: "mediatypeids=[2]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			zabbix := newFakeZabbix(t)
+			zabbix.Result("mediatype.update", json.RawMessage(tt.result))
+			cfg, clock := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL})
+			err := enableMediaType(context.Background(), cfg, MediaType{MediaTypeID: "1", Name: "Email", Status: "1"}, clock.Now(), testLogger(t))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ошибка %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ошибка %v, ожидалась с %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEmptyUpdateResultKeepsStateAndAlerts(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	zabbix.Result("mediatype.update", json.RawMessage(`{"mediatypeids":[]}`))
+	mm := newMattermostRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{"ZABBIX_API_URL": zabbix.URL, "MM_WEBHOOK_URL": mm.URL})
+	logger := testLogger(t)
+	media := []MediaType{{MediaTypeID: "1", Name: "Email", Status: "1"}}
+	firstSeen := clock.Now().Add(-2 * time.Hour)
+	state := MediaState{"1": {Name: "Email", FirstSeen: firstSeen}}
+
+	commit := newCycleCommit()
+	result := processMediaTypes(context.Background(), cfg, media, nil, newMemoryStore(), state, make(EnableFailures), make(VanishState), commit, logger, nil)
+	if err := commit.Commit(logger); err != nil {
+		t.Fatal(err)
+	}
+
+	if result.AutoEnabled != 0 || result.EnableFailed != 1 {
+		t.Errorf("включено %d, ошибок включения %d", result.AutoEnabled, result.EnableFailed)
+	}
+	// медиа не включилось — отсчёт не сбрасывается, следующий цикл попробует снова
+	if rec := state["1"]; rec == nil || !rec.FirstSeen.Equal(firstSeen) {
+		t.Errorf("состояние %v, медиа должно остаться с прежним временем обнаружения", state)
+	}
+	texts := mm.Texts()
+	if len(texts) != 1 || !strings.Contains(texts[0], "не затронул медиа Email") {
+		t.Errorf("уведомления %q, ожидалось одно об ошибке включения", texts)
+	}
+}
+
+// fakeGroups — ответ usergroup.get; состав групп тест меняет между циклами
+type fakeGroups struct {
+	mu     sync.Mutex
+	groups []userGroupResult
+}
+
+func (f *fakeGroups) AddUser(groupID, userID, username string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.groups {
+		if f.groups[i].ID == groupID {
+			f.groups[i].Users = append(f.groups[i].Users, struct {
+				UserID   string `json:"userid"`
+				Username string `json:"username"`
+			}{userID, username})
+		}
+	}
+}
+
+func (f *fakeGroups) handle(json.RawMessage) (interface{}, *fakeError) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, err := json.Marshal(f.groups)
+	if err != nil {
+		return nil, &fakeError{Code: -32500, Message: err.Error()}
+	}
+	return json.RawMessage(data), nil
+}
+
+func TestZabbixCyclesWithFakeClock(t *testing.T) {
+	zabbix := newFakeZabbix(t)
+	live := serveLiveMedia(zabbix,
+		MediaType{MediaTypeID: "1", Name: "Email", Status: "0"},
+		MediaType{MediaTypeID: "2", Name: "SMS", Status: "0"},
+		MediaType{MediaTypeID: "3", Name: "Slack", Status: "1"},
+	)
+	groups := &fakeGroups{}
+	if err := json.Unmarshal([]byte(`[{"usrgrpid":"7","name":"Admins","users":[{"userid":"1","username":"admin"}]}]`), &groups.groups); err != nil {
+		t.Fatal(err)
+	}
+	zabbix.Handle("usergroup.get", groups.handle)
+	rec := newEventRecorder(t)
+	cfg, clock := newTestConfig(t, map[string]string{
+		"ZABBIX_API_URL":      zabbix.URL,
+		"GENERIC_WEBHOOK_URL": rec.URL,
+		"MEDIA_NAMES":         "Email,SMS",
+		"MEDIA_OFF_DURATION":  "60",
+		// напоминания в этом сценарии не нужны
+		"NOTIFY_REPEAT_INTERVAL": "1000",
+	})
+	logger := testLogger(t)
+	store := newMemoryStore()
+	state := make(MediaState)
+	prevGroups := make(GroupState)
+	failures := make(EnableFailures)
+	start := clock.Now()
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		change  func()
+		// ожидаемое время обнаружения медиа в сохранённом состоянии (от начала)
+		want     map[string]time.Duration
+		statuses map[string]string
+		events   []string
+	}{
+		{
+			// Slack не отслеживается — mediatype.get отбирает только MEDIA_NAMES
+			name:     "всё включено, baseline групп",
+			want:     map[string]time.Duration{},
+			statuses: map[string]string{"Email": "0", "SMS": "0", "Slack": "1"},
+		},
+		{
+			name:     "Email выключено",
+			advance:  5 * time.Minute,
+			change:   func() { live.Set("1", "1") },
+			want:     map[string]time.Duration{"1": 5 * time.Minute},
+			statuses: map[string]string{"Email": "1", "SMS": "0", "Slack": "1"},
+			events:   []string{EventMediaDisabled},
+		},
+		{
+			name:    "SMS выключено, в группе новый участник",
+			advance: 30 * time.Minute,
+			change: func() {
+				live.Set("2", "1")
+				groups.AddUser("7", "2", "intruder")
+			},
+			want:     map[string]time.Duration{"1": 5 * time.Minute, "2": 35 * time.Minute},
+			statuses: map[string]string{"Email": "1", "SMS": "1", "Slack": "1"},
+			events:   []string{EventGroupChanged, EventMediaDisabled},
+		},
+		{
+			name:     "порог Email ещё не прошёл",
+			advance:  29 * time.Minute,
+			want:     map[string]time.Duration{"1": 5 * time.Minute, "2": 35 * time.Minute},
+			statuses: map[string]string{"Email": "1", "SMS": "1", "Slack": "1"},
+		},
+		{
+			name:     "Email включено вотчером",
+			advance:  time.Minute,
+			want:     map[string]time.Duration{"2": 35 * time.Minute},
+			statuses: map[string]string{"Email": "0", "SMS": "1", "Slack": "1"},
+			events:   []string{EventMediaAutoEnabled},
+		},
+		{
+			name:     "SMS включили вручную",
+			advance:  10 * time.Minute,
+			change:   func() { live.Set("2", "0") },
+			want:     map[string]time.Duration{},
+			statuses: map[string]string{"Email": "0", "SMS": "0", "Slack": "1"},
+			events:   []string{EventMediaRestored},
+		},
+		{
+			// новое отключение отсчитывается заново, а не от прошлого
+			name:     "Email снова выключено",
+			advance:  10 * time.Minute,
+			change:   func() { live.Set("1", "1") },
+			want:     map[string]time.Duration{"1": 85 * time.Minute},
+			statuses: map[string]string{"Email": "1", "SMS": "0", "Slack": "1"},
+			events:   []string{EventMediaDisabled},
+		},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		if step.change != nil {
+			step.change()
+		}
+		commit := newCycleCommit()
+		fetched := fetchMediaAndGroups(context.Background(), cfg, logger)
+		processMediaTypes(context.Background(), cfg, fetched.MediaTypes, fetched.MediaErr, store, state, failures, make(VanishState), commit, logger, nil)
+		processUserGroups(context.Background(), cfg, fetched.Groups, fetched.GroupErr, store, prevGroups, nil, commit, logger, nil, i == 0)
+		if err := commit.Commit(logger); err != nil {
+			t.Fatal(err)
+		}
+
+		saved, _, err := store.LoadMedia()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]time.Duration{}
+		for id, r := range saved {
+			got[id] = r.FirstSeen.Sub(start)
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: в состоянии %v, ожидалось %v", step.name, got, step.want)
+		}
+		if got := live.Statuses(); !reflect.DeepEqual(got, step.statuses) {
+			t.Errorf("%s: статусы %v, ожидалось %v", step.name, got, step.statuses)
+		}
+		// медиа обрабатываются параллельно — порядок событий не задан
+		events := rec.Types()
+		sort.Strings(events)
+		if !reflect.DeepEqual(events, step.events) {
+			t.Errorf("%s: события %v, ожидалось %v", step.name, events, step.events)
+		}
+	}
+	if n := len(zabbix.Calls("mediatype.update")); n != 1 {
+		t.Errorf("mediatype.update вызван %d раз, ожидался один", n)
+	}
+}
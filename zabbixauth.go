@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ---------------- Вход в Zabbix по логину и паролю ----------------
+// Если ZABBIX_API_TOKEN не задан, а ZABBIX_USER и ZABBIX_PASSWORD заданы, вотчер получает
+// сессию через user.login и кладёт её в APIToken. Истёкшая сессия обновляется один раз
+// прямо в запросе, который на неё наткнулся.
+
+var zabbixLoginMu sync.Mutex
+
+// usesZabbixLogin — токен получается входом, а не задан статически
+func (cfg *Config) usesZabbixLogin() bool {
+	return cfg.zabbixLogin
+}
+
+// zabbixSession — текущий токен API или сессия. Запросы идут из нескольких воркеров MEDIA_CONCURRENCY
+// одновременно с повторным входом, поэтому токен для запроса читается только через неё.
+func zabbixSession(cfg *Config) string {
+	zabbixLoginMu.Lock()
+	defer zabbixLoginMu.Unlock()
+	return cfg.APIToken
+}
+
+// setVaultSecrets заменяет токен API и webhook Mattermost значениями из Vault. Оба меняются
+// под одним мьютексом, чтобы запросы и уведомления не читали их во время обновления.
+func setVaultSecrets(cfg *Config, token, webhook string) {
+	zabbixLoginMu.Lock()
+	defer zabbixLoginMu.Unlock()
+	cfg.APIToken = token
+	cfg.MattermostWebhook = webhook
+}
+
+// mattermostWebhook — текущий адрес webhook Mattermost; при VAULT_ADDR его заменяет обновление секретов
+func mattermostWebhook(cfg *Config) string {
+	zabbixLoginMu.Lock()
+	defer zabbixLoginMu.Unlock()
+	return cfg.MattermostWebhook
+}
+
+// zabbixLogin выполняет user.login и сохраняет сессию в cfg.APIToken
+func zabbixLogin(ctx context.Context, cfg *Config, logger *logrus.Logger) error {
+	zabbixLoginMu.Lock()
+	defer zabbixLoginMu.Unlock()
+	req := ZabbixRequest{
+		JSONRPC: "2.0",
+		Method:  "user.login",
+		Params: map[string]interface{}{
+			"username": cfg.ZabbixUser,
+			"password": cfg.ZabbixPassword,
+		},
+		ID: 50,
+	}
+	var session string
+	if err := callZabbixOnce(ctx, cfg, req, &session, logger); err != nil {
+		return err
+	}
+	cfg.APIToken = session
+	logger.WithField("user", cfg.ZabbixUser).Info("Выполнен вход в Zabbix API")
+	return nil
+}
+
+// isSessionError — Zabbix отклонил запрос из-за истёкшей или завершённой сессии
+func isSessionError(err error) bool {
+	var zerr *zabbixError
+	if !errors.As(err, &zerr) {
+		return false
+	}
+	text := strings.ToLower(zerr.Message + " " + zerr.DataText())
+	return strings.Contains(text, "re-login") || strings.Contains(text, "not authorised") ||
+		strings.Contains(text, "not authorized") || strings.Contains(text, "session terminated")
+}